@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+	"github.com/tebrizetayi/ledgerservice/internal/exporter"
+)
+
+// exportCommand exports the transactions and users to a directory with a manifest
+// An incremental export is made by passing the until of the previous manifest as --since
+func exportCommand(args []string) error {
+	flags := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	dir := flags.String("dir", "", "directory the files and the manifest are written to")
+	format := flags.String("format", string(exporter.FormatJSONL), "format of the files, csv or jsonl")
+	since := flags.String("since", "", "export the transactions created at or after this RFC 3339 timestamp")
+	until := flags.String("until", "", "export the transactions created before this RFC 3339 timestamp (default now)")
+
+	config, err := config.Load("export", args, flags)
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("export: --dir is required")
+	}
+
+	var exportRange exporter.Range
+	if exportRange.Since, err = parseTimeFlag("since", *since); err != nil {
+		return err
+	}
+	if exportRange.Until, err = parseTimeFlag("until", *until); err != nil {
+		return err
+	}
+
+	if err := requirePostgres(config.DB, "export"); err != nil {
+		return err
+	}
+
+	db, err := connectToDatabase(config.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer db.Close()
+
+	manifest, err := exporter.New(db).ExportDir(context.Background(), *dir, exporter.Format(*format), exportRange)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		fmt.Printf("exported %d rows of %s to %s\n", file.Rows, file.Table, file.Name)
+	}
+	fmt.Printf("next incremental export: --since %s\n", manifest.Until.Format(time.RFC3339Nano))
+	return nil
+}
+
+// parseTimeFlag parses an optional RFC 3339 timestamp flag
+func parseTimeFlag(name string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("export: --%s must be an RFC 3339 timestamp: %w", name, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/pflag"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+	"github.com/tebrizetayi/ledgerservice/internal/importer"
+	"github.com/tebrizetayi/ledgerservice/internal/migrations"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// importCommand imports historical transactions into the ledger
+// Rejected records are appended to the rejects file, so that an interrupted
+// import can be resumed with --offset without losing them
+func importCommand(args []string) error {
+	flags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	file := flags.String("file", "", "CSV or JSONL file of user_id, amount, currency, timestamp and external_ref")
+	format := flags.String("format", "", "format of the file, csv or jsonl (default from the file extension)")
+	offset := flags.Int("offset", 0, "number of records to skip, to resume an interrupted import")
+	batchSize := flags.Int("batch-size", 5000, "number of records added in a single database transaction")
+	rejectsFile := flags.String("rejects", "", "file the rejected records are appended to (default <file>.rejects.csv)")
+	createUsers := flags.Bool("create-users", false, "create the users that do not exist yet")
+
+	config, err := config.Load("import", args, flags)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("import: --file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	if *rejectsFile == "" {
+		*rejectsFile = *file + ".rejects.csv"
+	}
+
+	if err := requirePostgres(config.DB, "import"); err != nil {
+		return err
+	}
+
+	db, err := connectToDatabase(config.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	current, err := migrations.Current(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if current != migrations.Version() {
+		return fmt.Errorf("import: schema version is %d, expected %d, start the service to migrate the database", current, migrations.Version())
+	}
+
+	in, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	rejects, err := os.OpenFile(*rejectsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer rejects.Close()
+
+	// The imported transactions are audited as made by the OS user running the import
+	actor := "cli"
+	if current, err := user.Current(); err == nil {
+		actor = "cli:" + current.Username
+	}
+	ctx = audit.NewContext(ctx, audit.Metadata{Actor: actor, RequestID: uuid.NewString()})
+
+	result, err := importer.Import(ctx, storage.NewTransactionRepository(db), in, rejects, importer.Options{
+		Format:      importer.Format(*format),
+		Offset:      *offset,
+		BatchSize:   *batchSize,
+		CreateUsers: *createUsers,
+	})
+	fmt.Printf("imported %d records, rejected %d records to %s\n", result.Imported, result.Rejected, *rejectsFile)
+	if err != nil {
+		return fmt.Errorf("%w, resume with --offset %d", err, result.Offset)
+	}
+	return nil
+}
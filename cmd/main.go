@@ -1,107 +1,239 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 
-	"github.com/spf13/viper"
 	"github.com/tebrizetayi/ledgerservice/internal/api"
-	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+	"github.com/tebrizetayi/ledgerservice/internal/grpcapi"
 	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
 
 	_ "github.com/lib/pq"
 )
 
+const usage = `usage: ledgerservice [command] [flags]
+
+commands:
+  serve             start the HTTP API (default)
+  config print      print the effective config with secrets redacted
+  import            import historical transactions from a CSV or JSONL file
+  export            export the transactions and users to CSV or JSONL files
+  verify-chain      verify the hash chains of the users' transactions
+  snapshot rebuild  rebuild the daily balance snapshots from the transactions`
+
 func main() {
-	config := initConfig()
-	db, err := connectToDatabase(config.DB)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serve(args)
+	case "config":
+		err = configCommand(args)
+	case "import":
+		err = importCommand(args)
+	case "export":
+		err = exportCommand(args)
+	case "verify-chain":
+		err = verifyChainCommand(args)
+	case "snapshot":
+		err = snapshotCommand(args)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("main : %v", err)
+	}
+}
+
+func serve(args []string) error {
+	config, err := config.Load("serve", args)
 	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+		return err
+	}
+
+	ledgerStorage, err := openStorage(context.Background(), config.DB, config.Replica)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
 	}
 
 	// Use the db object for querying and other operations
 
-	defer db.Close()
+	defer ledgerStorage.close()
+
+	if err := ledgerStorage.migrate(context.Background()); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
 
 	// Make a channel to listen for an interrupt or terminate signal from the OS.
 	// Use a buffered channel because the signal package requires it.
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel with room for both the HTTP and the gRPC server so that
+	// their goroutines can exit if we don't collect their errors.
+	serverErrors := make(chan error, 2)
 
 	// Services
-	storageClient := storage.NewStorageClient(db)
-	transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
-	controller := api.NewController(transactionManager)
+	transactionManager := transactionmanager.NewTransactionManagerClient(ledgerStorage.transactions, ledgerStorage.users,
+		ledgerStorage.transactionManagerOptions(config.GroupCommit, config.Cache, config.Fees)...)
+	var cacheStats func() transactionmanager.CacheStats
+	if config.Cache.Enabled {
+		cacheStats = transactionManager.CacheStats
+	}
+	controller := api.NewController(transactionManager, api.WithMaxBatchSize(config.Batch.MaxSize))
+	grpcServer := grpcapi.NewGRPCServer(grpcapi.NewServer(transactionManager))
 
 	// Start the HTTP service listening for requests.
 	api := http.Server{
-		Addr:           fmt.Sprintf(":%s", config.App.Port),
-		Handler:        api.NewAPI(controller),
-		MaxHeaderBytes: 1 << 20,
+		Addr: fmt.Sprintf(":%s", config.HTTP.Port),
+		Handler: api.NewAPI(controller,
+			api.WithRateLimit(config.RateLimit.RequestsPerSecond, config.RateLimit.Burst),
+			api.WithMaxBodyBytes(config.HTTP.MaxBodyBytes),
+			api.WithAdmin(config.Admin.Token, ledgerStorage.exporter),
+			api.WithAuditLog(ledgerStorage.auditLog),
+			api.WithReadinessChecks(ledgerStorage.readinessChecks(config.Health)...),
+			api.WithCacheStats(cacheStats),
+		),
+		ReadTimeout:       config.HTTP.ReadTimeout,
+		ReadHeaderTimeout: config.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      config.HTTP.WriteTimeout,
+		IdleTimeout:       config.HTTP.IdleTimeout,
+		MaxHeaderBytes:    config.HTTP.MaxHeaderBytes,
 	}
 
 	go func() {
-		log.Printf("main : API Listening %s", config.App.Port)
+		log.Printf("main : API Listening %s", config.HTTP.Port)
 		serverErrors <- api.ListenAndServe()
 	}()
 
+	// Start the gRPC service listening for requests.
+	grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%s", config.GRPC.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+
+	go func() {
+		log.Printf("main : gRPC Listening %s", config.GRPC.Port)
+		serverErrors <- grpcServer.Serve(grpcListener)
+	}()
+
+	// Start the workers applying the transactions posted asynchronously.
+	// They are stopped once the servers are, the postings they did not apply stay queued.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	defer stopWorkers()
+	if ledgerStorage.postings != nil {
+		log.Printf("main : Starting %d posting workers", config.Queue.Workers)
+		for i := 0; i < config.Queue.Workers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				if err := transactionManager.RunPostingWorker(workerCtx, config.Queue.PollInterval); err != nil {
+					log.Printf("main : posting worker : %v", err)
+				}
+			}()
+		}
+	}
+
+	// Maintain the partitions of the transactions, the instances serialize their maintenance in the database.
+	if ledgerStorage.partitions != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			ledgerStorage.partitions.RunMaintenance(workerCtx, config.Partitions.MaintenanceInterval,
+				config.Partitions.MonthsAhead, config.Partitions.RetentionMonths)
+		}()
+	}
+
+	// Snapshot the daily balances, the instances serialize their snapshots in the database.
+	if ledgerStorage.snapshots != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			ledgerStorage.snapshots.RunSnapshots(workerCtx, config.Snapshots.Interval, config.Snapshots.SettleTime)
+		}()
+	}
+
+	// Post the scheduled transactions, a single instance leads the runners at a time.
+	if ledgerStorage.schedules != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := transactionManager.RunScheduler(workerCtx, config.Scheduler.PollInterval); err != nil {
+				log.Printf("main : scheduler : %v", err)
+			}
+		}()
+	}
+
 	// =========================================================================
 	// Shutdown
 	// Blocking main and waiting for shutdown.
 	select {
 	case err := <-serverErrors:
-		log.Fatalf("main : Error starting server: %+v", err)
+		return fmt.Errorf("error starting server: %w", err)
 
 	case sig := <-shutdown:
 		log.Printf("main : %v : Start shutdown..", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.HTTP.ShutdownTimeout)
+		defer cancel()
+
+		// Stop the gRPC server once outstanding calls are done or the deadline passes.
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		// Give outstanding requests a deadline for completion.
+		if err := api.Shutdown(ctx); err != nil {
+			api.Close()
+			grpcServer.Stop()
+			return fmt.Errorf("could not stop server gracefully: %w", err)
+		}
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+			return fmt.Errorf("could not stop gRPC server gracefully: %w", ctx.Err())
+		}
 	}
-}
 
-type Config struct {
-	DB  DBConfig
-	App AppConfig
-}
-type AppConfig struct {
-	Port string
+	return nil
 }
 
-type DBConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
-}
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("unknown config command, expected: config print")
+	}
 
-func initConfig() Config {
-	viper.AutomaticEnv()
-
-	return Config{
-		DB: DBConfig{
-			Host:     viper.GetString("POSTGRES_HOST"),
-			Port:     viper.GetInt("POSTGRES_PORT"),
-			User:     viper.GetString("POSTGRES_USER"),
-			Password: viper.GetString("POSTGRES_PASSWORD"),
-			DBName:   viper.GetString("POSTGRES_DB"),
-			SSLMode:  viper.GetString("PGSSLMODE"),
-		},
-		App: AppConfig{
-			Port: viper.GetString("PORT"),
-		},
+	config, err := config.Load("config print", args[1:])
+	if err != nil {
+		return err
 	}
+	return config.Print(os.Stdout)
 }
 
-func connectToDatabase(dBConfig DBConfig) (*sql.DB, error) {
+func connectToDatabase(dBConfig config.DBConfig) (*sql.DB, error) {
 	connectionString := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		dBConfig.Host,
@@ -117,6 +249,11 @@ func connectToDatabase(dBConfig DBConfig) (*sql.DB, error) {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(dBConfig.MaxOpenConns)
+	db.SetMaxIdleConns(dBConfig.MaxIdleConns)
+	db.SetConnMaxLifetime(dBConfig.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(dBConfig.ConnMaxIdleTime)
+
 	err = db.Ping()
 	if err != nil {
 		return nil, err
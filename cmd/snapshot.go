@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+)
+
+// snapshotCommand runs the snapshot subcommands
+// snapshot rebuild replaces the daily balance snapshots with the ones recomputed from the transactions,
+// e.g. after transactions were corrected by hand
+func snapshotCommand(args []string) error {
+	if len(args) == 0 || args[0] != "rebuild" {
+		return fmt.Errorf("unknown snapshot command, expected: snapshot rebuild")
+	}
+
+	config, err := config.Load("snapshot rebuild", args[1:])
+	if err != nil {
+		return err
+	}
+	if err := requirePostgres(config.DB, "snapshot rebuild"); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	ledgerStorage, err := openStorage(ctx, config.DB, config.Replica)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer ledgerStorage.close()
+
+	if err := ledgerStorage.checkSchema(ctx, "snapshot rebuild"); err != nil {
+		return err
+	}
+
+	written, err := ledgerStorage.snapshots.Rebuild(ctx, time.Now().UTC().Add(-config.Snapshots.SettleTime))
+	if err != nil {
+		return fmt.Errorf("snapshot rebuild: %w", err)
+	}
+	fmt.Printf("rebuilt %d balance snapshots\n", written)
+	return nil
+}
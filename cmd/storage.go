@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+	"github.com/tebrizetayi/ledgerservice/internal/exporter"
+	"github.com/tebrizetayi/ledgerservice/internal/migrations"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ledgerStorage is the storage backend selected with db.driver
+type ledgerStorage struct {
+	driver string
+	db     *sql.DB
+	// replica is nil unless replica.host is set
+	replica      *sql.DB
+	transactions transactionmanager.TransactionStore
+	users        transactionmanager.UserStore
+	auditLog     api.AuditLog
+	// postings is nil for SQLite, the queue of asynchronous postings needs Postgres
+	postings transactionmanager.PostingStore
+	// schedules is nil for SQLite, the runner of the schedules is elected with a Postgres advisory lock
+	schedules transactionmanager.ScheduleStore
+	// partitions is nil for SQLite, only the Postgres transactions table is partitioned
+	partitions *storage.PartitionRepository
+	// snapshots is nil for SQLite, the balances of SQLite are always summed from the transactions
+	snapshots *storage.SnapshotRepository
+	// exporter is nil for SQLite, the exporter only reads the Postgres schema
+	exporter    api.Exporter
+	listUserIDs func(ctx context.Context) ([]uuid.UUID, error)
+}
+
+// openStorage connects to the database selected with db.driver
+// The Postgres schema is not migrated, SQLite migrates its schema when it is opened
+func openStorage(ctx context.Context, dbConfig config.DBConfig, replicaConfig config.ReplicaConfig) (ledgerStorage, error) {
+	if dbConfig.Driver == config.DriverSQLite {
+		db, err := storage.OpenSQLite(ctx, dbConfig.Path)
+		if err != nil {
+			return ledgerStorage{}, err
+		}
+		db.SetMaxOpenConns(dbConfig.MaxOpenConns)
+		db.SetMaxIdleConns(dbConfig.MaxIdleConns)
+		db.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(dbConfig.ConnMaxIdleTime)
+
+		store := storage.NewSQLiteStore(db)
+		return ledgerStorage{
+			driver:       dbConfig.Driver,
+			db:           db,
+			transactions: store,
+			users:        store,
+			auditLog:     storage.NewSQLiteAuditRepository(db),
+			listUserIDs:  store.ListIDs,
+		}, nil
+	}
+
+	db, err := connectToDatabase(dbConfig)
+	if err != nil {
+		return ledgerStorage{}, err
+	}
+	var replica *sql.DB
+	var repositoryOpts []storage.RepositoryOption
+	if replicaConfig.Host != "" {
+		replicaDBConfig := dbConfig
+		replicaDBConfig.Host, replicaDBConfig.Port = replicaConfig.Host, replicaConfig.Port
+		replica, err = connectToDatabase(replicaDBConfig)
+		if err != nil {
+			db.Close()
+			return ledgerStorage{}, fmt.Errorf("failed to connect to the replica: %w", err)
+		}
+		router := storage.NewReplicaRouter(replica, replicaConfig.MaxLag, replicaConfig.LagCheckInterval)
+		repositoryOpts = append(repositoryOpts, storage.WithReplica(router))
+	}
+
+	storageClient := storage.NewStorageClient(db, repositoryOpts...)
+	return ledgerStorage{
+		driver:       dbConfig.Driver,
+		db:           db,
+		replica:      replica,
+		transactions: storageClient.TransactionRepository,
+		users:        storageClient.UserRepository,
+		auditLog:     storageClient.AuditRepository,
+		postings:     storageClient.PostingRepository,
+		schedules:    storageClient.ScheduleRepository,
+		partitions:   storageClient.PartitionRepository,
+		snapshots:    storageClient.SnapshotRepository,
+		exporter:     exporter.New(db),
+		listUserIDs:  storageClient.UserRepository.ListIDs,
+	}, nil
+}
+
+// close closes the connections to the database and its replica
+func (s ledgerStorage) close() {
+	s.db.Close()
+	if s.replica != nil {
+		s.replica.Close()
+	}
+}
+
+// transactionManagerOptions returns the options of the transaction manager supported by the backend
+func (s ledgerStorage) transactionManagerOptions(groupCommit config.GroupCommitConfig, cache config.CacheConfig, fees config.FeesConfig) []transactionmanager.Option {
+	opts := []transactionmanager.Option{}
+	if s.postings != nil {
+		opts = append(opts, transactionmanager.WithPostingStore(s.postings))
+	}
+	if s.schedules != nil {
+		opts = append(opts, transactionmanager.WithScheduleStore(s.schedules))
+	}
+	if groupCommit.Enabled {
+		opts = append(opts, transactionmanager.WithGroupCommit(groupCommit.MaxBatch, groupCommit.MaxLatency))
+	}
+	if cache.Enabled {
+		opts = append(opts, transactionmanager.WithCache(transactionmanager.NewLRUCache(cache.Size, cache.TTL, transactionmanager.SystemClock{})))
+	}
+	if len(fees.Rules) > 0 {
+		opts = append(opts, transactionmanager.WithFees(uuid.MustParse(fees.RevenueAccount), feeRules(fees.Rules)))
+	}
+	return opts
+}
+
+// feeRules returns the fee rules of the config, they are validated when the config is loaded
+func feeRules(rules []config.FeeRuleConfig) []transactionmanager.FeeRule {
+	feeRules := make([]transactionmanager.FeeRule, 0, len(rules))
+	for _, rule := range rules {
+		feeRules = append(feeRules, transactionmanager.FeeRule{
+			Name:       rule.Name,
+			Category:   rule.Category,
+			Currency:   rule.Currency,
+			Flat:       decimal.NewFromFloat(rule.Flat),
+			Percentage: decimal.NewFromFloat(rule.Percentage),
+			Min:        decimal.NewFromFloat(rule.Min),
+			Max:        decimal.NewFromFloat(rule.Max),
+		})
+	}
+	return feeRules
+}
+
+// migrate applies the pending Postgres migrations
+func (s ledgerStorage) migrate(ctx context.Context) error {
+	if s.driver != config.DriverPostgres {
+		return nil
+	}
+	return migrations.Up(ctx, s.db)
+}
+
+// checkSchema fails if the Postgres schema is not at the version expected by this binary
+func (s ledgerStorage) checkSchema(ctx context.Context, command string) error {
+	if s.driver != config.DriverPostgres {
+		return nil
+	}
+	current, err := migrations.Current(ctx, s.db)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if current != migrations.Version() {
+		return fmt.Errorf("%s: schema version is %d, expected %d, start the service to migrate the database", command, current, migrations.Version())
+	}
+	return nil
+}
+
+// readinessChecks returns the readiness checks of the backend
+func (s ledgerStorage) readinessChecks(healthConfig config.HealthConfig) []api.ReadinessCheck {
+	if s.driver != config.DriverPostgres {
+		return []api.ReadinessCheck{
+			api.SQLiteCheck(s.db, healthConfig.CheckTimeout),
+			api.PoolCheck(s.db),
+		}
+	}
+	return []api.ReadinessCheck{
+		api.PostgresCheck(s.db, healthConfig.CheckTimeout),
+		api.MigrationCheck(s.db, healthConfig.CheckTimeout),
+		api.PoolCheck(s.db),
+	}
+}
+
+// requirePostgres fails for the commands that only support Postgres
+func requirePostgres(dbConfig config.DBConfig, command string) error {
+	if dbConfig.Driver != config.DriverPostgres {
+		return fmt.Errorf("%s: only supported with db.driver %s, got %s", command, config.DriverPostgres, dbConfig.Driver)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/spf13/pflag"
+	"github.com/tebrizetayi/ledgerservice/internal/config"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+// verifyChainCommand recomputes the hash chains of the users and prints the first broken link of each
+// It fails if any chain is broken, so that it can be run as a scheduled audit
+func verifyChainCommand(args []string) error {
+	flags := pflag.NewFlagSet("verify-chain", pflag.ContinueOnError)
+	user := flags.String("user", "", "verify the chain of this user only (default every user)")
+
+	config, err := config.Load("verify-chain", args, flags)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	ledgerStorage, err := openStorage(ctx, config.DB, config.Replica)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer ledgerStorage.close()
+
+	if err := ledgerStorage.checkSchema(ctx, "verify-chain"); err != nil {
+		return err
+	}
+
+	var userIDs []uuid.UUID
+	if *user != "" {
+		userID, err := uuid.Parse(*user)
+		if err != nil {
+			return fmt.Errorf("verify-chain: --user must be a UUID: %w", err)
+		}
+		userIDs = []uuid.UUID{userID}
+	} else if userIDs, err = ledgerStorage.listUserIDs(ctx); err != nil {
+		return err
+	}
+
+	transactionManager := transactionmanager.NewTransactionManagerClient(ledgerStorage.transactions, ledgerStorage.users)
+	broken := 0
+	for _, userID := range userIDs {
+		report, err := transactionManager.VerifyUserChain(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to verify the chain of user %s: %w", userID, err)
+		}
+		if report.Intact {
+			continue
+		}
+
+		broken++
+		link := report.Break
+		transactionID := "-"
+		if link.TransactionID != nil {
+			transactionID = link.TransactionID.String()
+		}
+		fmt.Printf("user %s: %s at seq %d (transaction %s, expected hash %q, actual hash %q)\n",
+			userID, link.Reason, link.Seq, transactionID, link.ExpectedHash, link.ActualHash)
+	}
+
+	fmt.Printf("verified %d chains, %d broken\n", len(userIDs), broken)
+	if broken > 0 {
+		return fmt.Errorf("verify-chain: %d broken chains", broken)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/exporter"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+const (
+	adminPrefix = "/admin"
+	adminExport = "/export/{table}"
+	adminAudit  = "/audit"
+	adminCache  = "/cache/stats"
+)
+
+// Trailers of an export response, they are sent once every row was written
+const (
+	trailerRowCount = "Ledger-Row-Count"
+	trailerSHA256   = "Ledger-Sha256"
+	trailerError    = "Ledger-Error"
+)
+
+var contentTypes = map[exporter.Format]string{
+	exporter.FormatCSV:   "text/csv",
+	exporter.FormatJSONL: "application/x-ndjson",
+}
+
+// Exporter streams a table of the ledger
+type Exporter interface {
+	ExportTable(ctx context.Context, w io.Writer, table exporter.Table, format exporter.Format, r exporter.Range) (exporter.FileManifest, error)
+}
+
+// AuditLog finds the entries of the audit log
+type AuditLog interface {
+	Find(ctx context.Context, filter audit.Filter) ([]audit.Entry, error)
+}
+
+// AuditResponse is the response body of the audit log query
+// NextAfterID is passed as after_id to read the next page, it is omitted when the page is empty
+type AuditResponse struct {
+	Entries     []audit.Entry `json:"entries"`
+	NextAfterID int64         `json:"next_after_id,omitempty"`
+}
+
+// WithAuditLog sets the audit log queried by the admin audit endpoint
+func WithAuditLog(auditLog AuditLog) Option {
+	return func(o *options) {
+		o.auditLog = auditLog
+	}
+}
+
+// WithCacheStats sets the stats of the balance cache reported by the admin cache endpoint
+func WithCacheStats(stats func() transactionmanager.CacheStats) Option {
+	return func(o *options) {
+		o.cacheStats = stats
+	}
+}
+
+// WithAdmin enables the admin endpoints
+// Requests to them must carry the token as a bearer token
+// A nil exporter disables the export endpoint, it returns 501 Not Implemented
+func WithAdmin(token string, exporter Exporter) Option {
+	return func(o *options) {
+		o.adminToken = token
+		o.exporter = exporter
+	}
+}
+
+// adminMiddleware returns a middleware that only lets requests with the admin token through
+// If no token is configured, the admin endpoints are disabled and 403 Forbidden is returned
+func adminMiddleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				httpError(w, "admin API is disabled", http.StatusForbidden)
+				return
+			}
+
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				httpError(w, "invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Export returns a handler streaming a table as CSV or JSONL
+// The row count and the SHA-256 checksum of the body are sent as trailers. Since the
+// status is sent before the first row, a failure while streaming is reported in the
+// Ledger-Error trailer and the checksum is left out
+func Export(e Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e == nil {
+			httpError(w, "export is not supported by the storage backend", http.StatusNotImplemented)
+			return
+		}
+
+		table := exporter.Table(mux.Vars(r)["table"])
+		format := exporter.Format(r.URL.Query().Get("format"))
+		if format == "" {
+			format = exporter.FormatJSONL
+		}
+		if table != exporter.TableTransactions && table != exporter.TableUsers {
+			httpError(w, fmt.Sprintf("table must be %s or %s", exporter.TableTransactions, exporter.TableUsers), http.StatusNotFound)
+			return
+		}
+		if _, ok := contentTypes[format]; !ok {
+			httpError(w, fmt.Sprintf("format must be %s or %s", exporter.FormatCSV, exporter.FormatJSONL), http.StatusBadRequest)
+			return
+		}
+
+		var exportRange exporter.Range
+		for name, value := range map[string]*time.Time{"since": &exportRange.Since, "until": &exportRange.Until} {
+			param := r.URL.Query().Get(name)
+			if param == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, param)
+			if err != nil {
+				httpError(w, fmt.Sprintf("%s must be an RFC 3339 timestamp", name), http.StatusBadRequest)
+				return
+			}
+			*value = t
+		}
+
+		// An export can outlast the write timeout of the server
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("api : failed to clear the write deadline of the export: %v", err)
+		}
+
+		w.Header().Set("Content-Type", contentTypes[format])
+		w.Header().Set("Trailer", strings.Join([]string{trailerRowCount, trailerSHA256, trailerError}, ", "))
+		w.WriteHeader(http.StatusOK)
+
+		file, err := e.ExportTable(r.Context(), w, table, format, exportRange)
+		if err != nil {
+			log.Printf("api : export of %s failed: %v", table, err)
+			w.Header().Set(trailerError, err.Error())
+			return
+		}
+		w.Header().Set(trailerRowCount, strconv.FormatInt(file.Rows, 10))
+		w.Header().Set(trailerSHA256, file.SHA256)
+	}
+}
+
+// Audit returns a handler querying the audit log
+// The entries can be filtered by actor, user_id and a since/until range of RFC 3339 timestamps
+// and are paginated with after_id and limit
+func Audit(a AuditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := audit.Filter{Actor: query.Get("actor")}
+
+		if param := query.Get("user_id"); param != "" {
+			userID, err := uuid.Parse(param)
+			if err != nil {
+				httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+				return
+			}
+			filter.UserID = userID
+		}
+		for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			param := query.Get(name)
+			if param == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, param)
+			if err != nil {
+				httpError(w, fmt.Sprintf("%s must be an RFC 3339 timestamp", name), http.StatusBadRequest)
+				return
+			}
+			*value = t
+		}
+		if param := query.Get("after_id"); param != "" {
+			afterID, err := strconv.ParseInt(param, 10, 64)
+			if err != nil || afterID < 0 {
+				httpError(w, "after_id must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			filter.AfterID = afterID
+		}
+		if param := query.Get("limit"); param != "" {
+			limit, err := strconv.Atoi(param)
+			if err != nil || limit < 1 {
+				httpError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		entries, err := a.Find(r.Context(), filter)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := AuditResponse{Entries: entries}
+		if len(entries) > 0 {
+			response.NextAfterID = entries[len(entries)-1].ID
+		}
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// CacheStats returns a handler reporting the hits, misses and hit rate of the balance cache
+// Without stats the cache is disabled and 501 Not Implemented is returned
+func CacheStats(stats func() transactionmanager.CacheStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if stats == nil {
+			httpError(w, "balance cache is disabled", http.StatusNotImplemented)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, stats())
+	}
+}
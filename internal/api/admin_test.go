@@ -0,0 +1,428 @@
+package api_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/exporter"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+const adminToken = "admin-token"
+
+// stubExporter writes a line per table and records the requested range and whether the line could be flushed
+type stubExporter struct {
+	exportRange exporter.Range
+	err         error
+	panicAfter  bool
+	flushErr    error
+}
+
+func (s *stubExporter) ExportTable(ctx context.Context, w io.Writer, table exporter.Table, format exporter.Format, r exporter.Range) (exporter.FileManifest, error) {
+	s.exportRange = r
+	line := fmt.Sprintf("%s,%s\n", table, format)
+	if _, err := io.WriteString(w, line); err != nil {
+		return exporter.FileManifest{}, err
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		s.flushErr = http.NewResponseController(rw).Flush()
+	}
+	if s.panicAfter {
+		panic("boom")
+	}
+	if s.err != nil {
+		return exporter.FileManifest{}, s.err
+	}
+	sum := sha256.Sum256([]byte(line))
+	return exporter.FileManifest{Table: table, Rows: 1, SHA256: hex.EncodeToString(sum[:])}, nil
+}
+
+// stubAuditLog returns canned entries and records the filter
+type stubAuditLog struct {
+	entries []audit.Entry
+	filter  audit.Filter
+	err     error
+}
+
+func (s *stubAuditLog) Find(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	s.filter = filter
+	return s.entries, s.err
+}
+
+func TestExport_Authentication(t *testing.T) {
+	testCases := []struct {
+		name               string
+		configuredToken    string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Valid token",
+			configuredToken:    adminToken,
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Missing token",
+			configuredToken:    adminToken,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Wrong token",
+			configuredToken:    adminToken,
+			authorization:      "Bearer not-the-token",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Wrong scheme",
+			configuredToken:    adminToken,
+			authorization:      "Basic " + adminToken,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Admin API disabled",
+			authorization:      "Bearer ",
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(tc.configuredToken, &stubExporter{}))
+
+			req, _ := http.NewRequest(http.MethodGet, "/admin/export/users", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestExport_StreamsWithTrailers(t *testing.T) {
+	// Assign
+	stub := &stubExporter{}
+	server := httptest.NewServer(api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, stub)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/export/transactions?format=csv&since=2020-01-01T00:00:00Z", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	// Act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	// Assert
+	sum := sha256.Sum256(body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "transactions,csv\n", string(body))
+	assert.Equal(t, "1", resp.Trailer.Get("Ledger-Row-Count"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), resp.Trailer.Get("Ledger-Sha256"))
+	assert.Empty(t, resp.Trailer.Get("Ledger-Error"))
+	assert.True(t, stub.exportRange.Since.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, stub.exportRange.Until.IsZero())
+	assert.NoError(t, stub.flushErr, "the middlewares keep the response writer flushable")
+}
+
+func TestExport_PanicAfterHeader(t *testing.T) {
+	// Assign
+	newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, &stubExporter{panicAfter: true}))
+	req, _ := http.NewRequest(http.MethodGet, "/admin/export/users?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rr := httptest.NewRecorder()
+
+	// Act
+	newAPI.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "users,csv\n", rr.Body.String(), "no error is written after the header")
+}
+
+func TestExport_NotSupportedWithoutExporter(t *testing.T) {
+	// Assign
+	newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, nil))
+	req, _ := http.NewRequest(http.MethodGet, "/admin/export/users", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rr := httptest.NewRecorder()
+
+	// Act
+	newAPI.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestExport_FailureReportedInTrailer(t *testing.T) {
+	// Assign
+	stub := &stubExporter{err: errors.New("connection reset")}
+	server := httptest.NewServer(api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, stub)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/export/users", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	// Act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "connection reset", resp.Trailer.Get("Ledger-Error"))
+	assert.Empty(t, resp.Trailer.Get("Ledger-Sha256"))
+}
+
+func TestAudit_Filter(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedFilter     audit.Filter
+	}{
+		{
+			name:               "No filter",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Every filter",
+			query:              "?actor=admin&user_id=" + userID.String() + "&since=2020-01-01T00:00:00Z&until=2021-01-01T00:00:00Z&after_id=42&limit=10",
+			expectedStatusCode: http.StatusOK,
+			expectedFilter: audit.Filter{
+				Actor:   audit.ActorAdmin,
+				UserID:  userID,
+				Since:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Until:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				AfterID: 42,
+				Limit:   10,
+			},
+		},
+		{
+			name:               "Invalid user ID",
+			query:              "?user_id=invalid-user-id",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Invalid since",
+			query:              "?since=yesterday",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Negative after_id",
+			query:              "?after_id=-1",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			auditLog := &stubAuditLog{entries: []audit.Entry{{ID: 7, UserID: userID}}}
+			newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, &stubExporter{}), api.WithAuditLog(auditLog))
+
+			req, _ := http.NewRequest(http.MethodGet, "/admin/audit"+tc.query, nil)
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tc.expectedFilter, auditLog.filter)
+			var response api.AuditResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, int64(7), response.NextAfterID)
+		})
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	testCases := []struct {
+		name               string
+		stats              func() transactionmanager.CacheStats
+		expectedStatusCode int
+		expectedStats      transactionmanager.CacheStats
+	}{
+		{
+			name: "Cache enabled",
+			stats: func() transactionmanager.CacheStats {
+				return transactionmanager.CacheStats{Hits: 3, Misses: 1, HitRate: 0.75}
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedStats:      transactionmanager.CacheStats{Hits: 3, Misses: 1, HitRate: 0.75},
+		},
+		{
+			name:               "Cache disabled",
+			expectedStatusCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, &stubExporter{}), api.WithCacheStats(tc.stats))
+			req, _ := http.NewRequest(http.MethodGet, "/admin/cache/stats", nil)
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+			var stats transactionmanager.CacheStats
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+			assert.Equal(t, tc.expectedStats, stats)
+		})
+	}
+}
+
+func TestAuditMiddleware_Metadata(t *testing.T) {
+	testCases := []struct {
+		name              string
+		requestID         string
+		authorization     string
+		expectedActor     string
+		expectedRequestID string
+	}{
+		{
+			name:          "Generated request ID",
+			expectedActor: audit.ActorAnonymous,
+		},
+		{
+			name:              "Given request ID",
+			requestID:         "request-1",
+			expectedActor:     audit.ActorAnonymous,
+			expectedRequestID: "request-1",
+		},
+		{
+			name:              "Admin token",
+			requestID:         "request-2",
+			authorization:     "Bearer " + adminToken,
+			expectedActor:     audit.ActorAdmin,
+			expectedRequestID: "request-2",
+		},
+		{
+			name:          "Wrong admin token",
+			authorization: "Bearer not-the-token",
+			expectedActor: audit.ActorAnonymous,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			manager := &stubTransactionManager{}
+			newAPI := api.NewAPI(api.NewController(manager), api.WithAdmin(adminToken, &stubExporter{}))
+
+			body := `{"amount":100,"idempotency_key":"` + uuid.NewString() + `"}`
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, uuid.New()), strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Request-ID", tc.requestID)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			req.RemoteAddr = "192.0.2.1:1234"
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			sum := sha256.Sum256([]byte(body))
+			assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+			assert.Equal(t, tc.expectedActor, manager.metadata.Actor)
+			assert.Equal(t, "192.0.2.1", manager.metadata.ClientIP)
+			assert.Equal(t, sum[:], manager.metadata.PayloadHash)
+			assert.Equal(t, rr.Header().Get("X-Request-ID"), manager.metadata.RequestID)
+			if tc.expectedRequestID != "" {
+				assert.Equal(t, tc.expectedRequestID, manager.metadata.RequestID)
+			} else {
+				assert.NotEmpty(t, manager.metadata.RequestID)
+			}
+		})
+	}
+}
+
+// readRecorder records whether a request body was read
+type readRecorder struct {
+	io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func TestAuditMiddleware_UnmatchedRoute(t *testing.T) {
+	testCases := []struct {
+		name               string
+		method             string
+		path               string
+		expectedStatusCode int
+	}{
+		{"Unknown path", http.MethodPost, "/unknown", http.StatusNotFound},
+		{"Unknown method", http.MethodPut, fmt.Sprintf(AddTransactionTemplate, uuid.New()), http.StatusMethodNotAllowed},
+		{"Unknown admin method", http.MethodPost, "/admin/audit", http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.NewController(&stubTransactionManager{}), api.WithAdmin(adminToken, &stubExporter{}))
+			body := &readRecorder{Reader: strings.NewReader(`{"amount":100}`)}
+			req, _ := http.NewRequest(tc.method, tc.path, body)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			assert.False(t, body.read, "the body of an unmatched request is not read")
+		})
+	}
+}
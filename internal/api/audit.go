@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// errorReader returns err once the buffered part of a body was read
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
+
+// auditMiddleware returns a middleware attaching the audit metadata of the request to its context
+// The request ID is taken from the X-Request-ID header or generated, and is echoed in the response.
+// The actor is admin for requests carrying the admin token and anonymous otherwise, the API has no other caller identity.
+// The body of a mutating request is read upfront to hash it, a read error is returned to the handler. The router
+// applies its middlewares once a route matched, the bodies of the requests answered with 404 or 405 are not read
+func auditMiddleware(adminToken string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(requestIDHeader)
+			if requestID == "" || len(requestID) > maxRequestIDLength || strings.IndexFunc(requestID, isControl) >= 0 {
+				requestID = uuid.NewString()
+			}
+			w.Header().Set(requestIDHeader, requestID)
+
+			metadata := audit.Metadata{
+				Actor:     audit.ActorAnonymous,
+				RequestID: requestID,
+				ClientIP:  r.RemoteAddr,
+			}
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				metadata.ClientIP = host
+			}
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(given), []byte(adminToken)) == 1 {
+				metadata.Actor = audit.ActorAdmin
+			}
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Body != nil {
+				body, err := io.ReadAll(r.Body)
+				sum := sha256.Sum256(body)
+				metadata.PayloadHash = sum[:]
+
+				var rest io.Reader = bytes.NewReader(body)
+				if err != nil {
+					rest = io.MultiReader(rest, errorReader{err})
+				}
+				r.Body = io.NopCloser(rest)
+			}
+
+			next.ServeHTTP(w, r.WithContext(audit.NewContext(r.Context(), metadata)))
+		})
+	}
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
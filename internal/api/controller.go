@@ -3,12 +3,19 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
 	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
 
 	"github.com/google/uuid"
@@ -19,24 +26,142 @@ import (
 type TransactionManager interface {
 	AddTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Transaction, error)
 	GetUserBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
-	GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int) ([]transactionmanager.Transaction, error)
+	GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter transactionmanager.HistoryFilter) ([]transactionmanager.Transaction, error)
+	AddTransactions(ctx context.Context, transactions []transactionmanager.Transaction, mode transactionmanager.BatchMode) ([]transactionmanager.BatchResult, error)
+	VerifyUserChain(ctx context.Context, userID uuid.UUID) (transactionmanager.ChainReport, error)
+	GetTransaction(ctx context.Context, transactionID uuid.UUID, owner uuid.UUID) (transactionmanager.Transaction, error)
+	GetUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]transactionmanager.Transaction, error)
+	EnqueueTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Posting, error)
+	GetPosting(ctx context.Context, postingID uuid.UUID, owner uuid.UUID) (transactionmanager.Posting, error)
+	GetUserBalanceAsOf(ctx context.Context, userID uuid.UUID, asOf time.Time) (decimal.Decimal, error)
+	GetUserStatement(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) (transactionmanager.Statement, error)
+	CreateSchedule(ctx context.Context, schedule transactionmanager.Schedule) (transactionmanager.Schedule, error)
+	ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]transactionmanager.Schedule, error)
+	PauseSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error)
+	ResumeSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error)
+	ListScheduleRuns(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID, limit int) ([]transactionmanager.ScheduleRun, error)
 }
 
+const defaultMaxBatchSize = 1000
+
 // Controller is the API controller
 type Controller struct {
 	transactionmanager TransactionManager
+	maxBatchSize       int
+}
+
+// ControllerOption configures the API controller
+type ControllerOption func(*Controller)
+
+// WithMaxBatchSize sets the maximum number of postings of a batch
+func WithMaxBatchSize(maxBatchSize int) ControllerOption {
+	return func(c *Controller) {
+		c.maxBatchSize = maxBatchSize
+	}
 }
 
-func NewController(tm TransactionManager) Controller {
-	return Controller{
+// NewController returns a new API controller
+// By default a batch holds up to 1000 postings
+// The IDs of the added transactions are generated by the transaction manager
+func NewController(tm TransactionManager, opts ...ControllerOption) Controller {
+	c := Controller{
 		transactionmanager: tm,
+		maxBatchSize:       defaultMaxBatchSize,
 	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// TransactionDetails are the optional descriptive fields of a transaction
+// Currency is an ISO 4217 code, ExternalReference is unique per Source, both must be given together
+type TransactionDetails struct {
+	Currency          string                 `json:"currency,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Category          string                 `json:"category,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	ExternalReference string                 `json:"external_reference,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // AddTransactionRequest is the request body for adding a transaction
+// EffectiveDate backdates the transaction, it requires the admin token
 type AddTransactionRequest struct {
-	Amount         float64   `json:"amount"`
-	IdempotencyKey uuid.UUID `json:"idempotency_key"`
+	Amount         float64    `json:"amount"`
+	IdempotencyKey uuid.UUID  `json:"idempotency_key"`
+	EffectiveDate  *time.Time `json:"effective_date,omitempty"`
+	TransactionDetails
+}
+
+// AddTransactionResponse is the response body for adding a transaction
+type AddTransactionResponse struct {
+	Message     string                         `json:"message"`
+	Transaction transactionmanager.Transaction `json:"transaction"`
+}
+
+// AddTransactionAcceptedResponse is the response body for adding a transaction asynchronously
+type AddTransactionAcceptedResponse struct {
+	Message string                     `json:"message"`
+	Posting transactionmanager.Posting `json:"posting"`
+}
+
+// AddTransactionsRequest is the request body for adding a batch of transactions
+// Mode defaults to all_or_nothing
+type AddTransactionsRequest struct {
+	Mode     transactionmanager.BatchMode `json:"mode"`
+	Postings []PostingRequest             `json:"postings"`
+}
+
+// PostingRequest is a single transaction of a batch
+// EffectiveDate backdates the transaction, it requires the admin token
+type PostingRequest struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	Amount         float64    `json:"amount"`
+	IdempotencyKey uuid.UUID  `json:"idempotency_key"`
+	EffectiveDate  *time.Time `json:"effective_date,omitempty"`
+	TransactionDetails
+}
+
+// AddTransactionsResponse is the response body for adding a batch of transactions
+type AddTransactionsResponse struct {
+	Applied int             `json:"applied"`
+	Failed  int             `json:"failed"`
+	Results []PostingResult `json:"results"`
+}
+
+const (
+	postingStatusApplied = "applied"
+	postingStatusFailed  = "failed"
+	postingStatusAborted = "aborted"
+)
+
+// PostingResult is the outcome of a single posting of a batch
+// Aborted postings were valid but not added because another posting of an all_or_nothing batch failed
+type PostingResult struct {
+	Index       int                             `json:"index"`
+	Status      string                          `json:"status"`
+	Error       string                          `json:"error,omitempty"`
+	Transaction *transactionmanager.Transaction `json:"transaction,omitempty"`
+}
+
+// CreateScheduleRequest is the request body for scheduling a recurring transaction
+// Either Cron, a cron expression evaluated in UTC, or Interval, a duration such as "24h", must be given.
+// StartAt defaults to now, the schedule has no end without EndAt
+type CreateScheduleRequest struct {
+	Amount      float64                     `json:"amount"`
+	Cron        string                      `json:"cron,omitempty"`
+	Interval    transactionmanager.Duration `json:"interval,omitempty"`
+	StartAt     *time.Time                  `json:"start_at,omitempty"`
+	EndAt       *time.Time                  `json:"end_at,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Category    string                      `json:"category,omitempty"`
+}
+
+// CreateScheduleResponse is the response body for scheduling a recurring transaction
+type CreateScheduleResponse struct {
+	Message  string                      `json:"message"`
+	Schedule transactionmanager.Schedule `json:"schedule"`
 }
 
 // GetUserBalanceResponse is the response body for getting a user's balance
@@ -50,9 +175,35 @@ func (c *Controller) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A balance as of a past time is computed from the transactions, it is eventually consistent
+	if param := r.URL.Query().Get("as_of"); param != "" {
+		asOf, err := time.Parse(time.RFC3339Nano, param)
+		if err != nil {
+			httpError(w, "as_of must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		balance, err := c.transactionmanager.GetUserBalanceAsOf(ctx, userID, asOf)
+		if err != nil {
+			httpError(w, fmt.Sprintf("Error retrieving user balance %v", err), errorStatus(err))
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]decimal.Decimal{"balance": balance})
+		return
+	}
+
+	// The balance is read from the primary unless an eventually consistent balance is asked for
+	switch consistency := r.URL.Query().Get("consistency"); consistency {
+	case "", storage.ConsistencyStrong:
+	case storage.ConsistencyEventual:
+		ctx = storage.NewConsistencyContext(ctx, storage.ConsistencyEventual)
+	default:
+		httpError(w, fmt.Sprintf("Invalid consistency %q, must be %s or %s", consistency, storage.ConsistencyStrong, storage.ConsistencyEventual), http.StatusBadRequest)
+		return
+	}
+
 	balance, err := c.transactionmanager.GetUserBalance(ctx, userID)
 	if err != nil {
-		httpError(w, fmt.Sprintf("Error retrieving user balance %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("Error retrieving user balance %v", err), errorStatus(err))
 		return
 	}
 
@@ -63,6 +214,8 @@ func (c *Controller) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 }
 
 // AddTransaction adds a transaction to the ledger
+// With the async query parameter the transaction is queued and 202 is returned with the posting,
+// whose status is polled with GetPosting
 func (c *Controller) AddTransaction(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -73,34 +226,66 @@ func (c *Controller) AddTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	async := false
+	if value := r.URL.Query().Get("async"); value != "" {
+		if async, err = strconv.ParseBool(value); err != nil {
+			httpError(w, fmt.Sprintf("Invalid async %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	var addTransactionRequest AddTransactionRequest
 	if err := decodeJSON(r, &addTransactionRequest); err != nil {
-		httpError(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+
+	if addTransactionRequest.IdempotencyKey == uuid.Nil {
+		httpError(w, "idempotency_key is required", http.StatusBadRequest)
+		return
+	}
+	if addTransactionRequest.EffectiveDate != nil && !canBackdate(ctx) {
+		httpError(w, errBackdatingForbidden.Error(), http.StatusForbidden)
 		return
 	}
 
-	transaction := transactionmanager.Transaction{
+	transaction := withDetails(transactionmanager.Transaction{
 		UserID:         userID,
 		Amount:         decimal.NewFromFloat(addTransactionRequest.Amount),
-		ID:             uuid.New(),
-		CreatedAt:      time.Now(),
+		EffectiveDate:  timeOrZero(addTransactionRequest.EffectiveDate),
 		IdempotencyKey: addTransactionRequest.IdempotencyKey,
+	}, addTransactionRequest.TransactionDetails)
+
+	if async {
+		posting, err := c.transactionmanager.EnqueueTransaction(ctx, transaction)
+		if err != nil {
+			httpError(w, err.Error(), errorStatus(err))
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/postings/%s", posting.ID))
+		respondWithJSON(w, http.StatusAccepted, AddTransactionAcceptedResponse{
+			Message: "Transaction accepted",
+			Posting: posting,
+		})
+		return
 	}
 
-	if _, err := c.transactionmanager.AddTransaction(ctx, transaction); err != nil {
-		httpError(w, err.Error(), http.StatusInternalServerError)
+	added, err := c.transactionmanager.AddTransaction(ctx, transaction)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	response := struct {
-		Message string `json:"message"`
-	}{
-		Message: "Transaction successfully added",
+	response := AddTransactionResponse{
+		Message:     "Transaction successfully added",
+		Transaction: added,
 	}
 	respondWithJSON(w, http.StatusCreated, response)
 }
 
 // GetUserTransactionHistory returns a user's transaction history
+// The history is filtered by the reference query parameter and by metadata.<key> query parameters
 func (c *Controller) GetUserTransactionHistory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -121,17 +306,488 @@ func (c *Controller) GetUserTransactionHistory(w http.ResponseWriter, r *http.Re
 		pageSize = 10
 	}
 
-	transactions, err := c.transactionmanager.GetUserTransactionHistory(ctx, userID, page, pageSize)
+	transactions, err := c.transactionmanager.GetUserTransactionHistory(ctx, userID, page, pageSize, historyFilter(r.URL.Query()))
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, transactions)
+}
+
+// AddTransactions adds a batch of transactions to the ledger
+// 201 is returned if every posting was added, 207 if only some of them were
+// and 422 if none was
+func (c *Controller) AddTransactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var addTransactionsRequest AddTransactionsRequest
+	if err := decodeJSON(r, &addTransactionsRequest); err != nil {
+		httpError(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+
+	if len(addTransactionsRequest.Postings) == 0 {
+		httpError(w, "postings must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(addTransactionsRequest.Postings) > c.maxBatchSize {
+		httpError(w, fmt.Sprintf("a batch holds at most %d postings", c.maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	if addTransactionsRequest.Mode == "" {
+		addTransactionsRequest.Mode = transactionmanager.BatchModeAllOrNothing
+	}
+
+	transactions := make([]transactionmanager.Transaction, 0, len(addTransactionsRequest.Postings))
+	for i, posting := range addTransactionsRequest.Postings {
+		if posting.IdempotencyKey == uuid.Nil {
+			httpError(w, fmt.Sprintf("postings[%d]: idempotency_key is required", i), http.StatusBadRequest)
+			return
+		}
+		if posting.EffectiveDate != nil && !canBackdate(ctx) {
+			httpError(w, fmt.Sprintf("postings[%d]: %v", i, errBackdatingForbidden), http.StatusForbidden)
+			return
+		}
+		transactions = append(transactions, withDetails(transactionmanager.Transaction{
+			UserID:         posting.UserID,
+			Amount:         decimal.NewFromFloat(posting.Amount),
+			EffectiveDate:  timeOrZero(posting.EffectiveDate),
+			IdempotencyKey: posting.IdempotencyKey,
+		}, posting.TransactionDetails))
+	}
+
+	results, err := c.transactionmanager.AddTransactions(ctx, transactions, addTransactionsRequest.Mode)
+	if errors.Is(err, transactionmanager.ErrInvalidBatchMode) {
+		httpError(w, fmt.Sprintf("mode must be %s or %s", transactionmanager.BatchModeAllOrNothing, transactionmanager.BatchModeBestEffort), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	response := AddTransactionsResponse{
+		Results: make([]PostingResult, 0, len(results)),
+	}
+	for i, result := range results {
+		postingResult := PostingResult{Index: i}
+		switch {
+		case result.Err == nil:
+			transaction := result.Transaction
+			postingResult.Status = postingStatusApplied
+			postingResult.Transaction = &transaction
+			response.Applied++
+		case errors.Is(result.Err, transactionmanager.ErrBatchAborted):
+			postingResult.Status = postingStatusAborted
+			postingResult.Error = result.Err.Error()
+			response.Failed++
+		default:
+			postingResult.Status = postingStatusFailed
+			postingResult.Error = result.Err.Error()
+			response.Failed++
+		}
+		response.Results = append(response.Results, postingResult)
+	}
+
+	statusCode := http.StatusCreated
+	switch {
+	case response.Applied == 0:
+		statusCode = http.StatusUnprocessableEntity
+	case response.Failed > 0:
+		statusCode = http.StatusMultiStatus
+	}
+	respondWithJSON(w, statusCode, response)
+}
+
+// GetUserIntegrity recomputes the hash chain of a user's transactions
+// A broken chain is reported with 200 and intact set to false along with its first broken link
+func (c *Controller) GetUserIntegrity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	report, err := c.transactionmanager.VerifyUserChain(ctx, userID)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// GetUserStatement returns the statement of a user's transactions created from the from query parameter
+// until the to query parameter, both RFC 3339 timestamps, with the opening and closing balances
+func (c *Controller) GetUserStatement(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	for name, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		t, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get(name))
+		if err != nil {
+			httpError(w, fmt.Sprintf("%s must be an RFC 3339 timestamp", name), http.StatusBadRequest)
+			return
+		}
+		*value = t
+	}
+
+	statement, err := c.transactionmanager.GetUserStatement(ctx, userID, from, to)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, statement)
+}
+
+// GetTransaction returns a transaction by ID
+// Requests without the admin token must name the user of the transaction with the user_id query parameter,
+// the transactions of other users are not found. The user_id is not checked against the caller, it scopes
+// the lookup rather than authorizing it
+func (c *Controller) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	transactionID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid transaction ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	owner, err := requestOwner(r)
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	transaction, err := c.transactionmanager.GetTransaction(ctx, transactionID, owner)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, transaction)
+}
+
+// GetPosting returns a posting by ID, reporting whether its transaction is pending, applied or failed
+// Requests without the admin token must name the user of the posting with the user_id query parameter,
+// the postings of other users are not found. As for GetTransaction, the user_id is not checked against the caller
+func (c *Controller) GetPosting(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	postingID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid posting ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	owner, err := requestOwner(r)
 	if err != nil {
-		httpError(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	posting, err := c.transactionmanager.GetPosting(ctx, postingID, owner)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, posting)
+}
+
+// GetUserTransactions returns the transactions of a user added with the idempotency_key query parameter
+// It lets clients whose request timed out check whether their transaction was added
+func (c *Controller) GetUserTransactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	idempotencyKey, err := uuid.Parse(r.URL.Query().Get("idempotency_key"))
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid idempotency key %v", err), http.StatusBadRequest)
+		return
+	}
+
+	transactions, err := c.transactionmanager.GetUserTransactionsByIdempotencyKey(ctx, userID, idempotencyKey)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, transactions)
 }
 
+// CreateSchedule schedules a recurring transaction of a user, posted at every occurrence of its cron expression
+// or interval until its end
+func (c *Controller) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var request CreateScheduleRequest
+	if err := decodeJSON(r, &request); err != nil {
+		httpError(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+
+	schedule, err := c.transactionmanager.CreateSchedule(ctx, transactionmanager.Schedule{
+		UserID:      userID,
+		Amount:      decimal.NewFromFloat(request.Amount),
+		Cron:        request.Cron,
+		Interval:    request.Interval,
+		StartAt:     timeOrZero(request.StartAt),
+		EndAt:       request.EndAt,
+		Description: request.Description,
+		Category:    request.Category,
+	})
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, CreateScheduleResponse{
+		Message:  "Schedule successfully created",
+		Schedule: schedule,
+	})
+}
+
+// ListUserSchedules returns the schedules of a user, oldest first
+func (c *Controller) ListUserSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid user ID %v", err), http.StatusBadRequest)
+		return
+	}
+
+	schedules, err := c.transactionmanager.ListUserSchedules(ctx, userID)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schedules)
+}
+
+// PauseSchedule stops posting the occurrences of a schedule until it is resumed
+func (c *Controller) PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	c.setScheduleStatus(w, r, c.transactionmanager.PauseSchedule)
+}
+
+// ResumeSchedule posts the occurrences of a paused schedule again, the occurrences missed while it was paused are skipped
+func (c *Controller) ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	c.setScheduleStatus(w, r, c.transactionmanager.ResumeSchedule)
+}
+
+// setScheduleStatus pauses or resumes the schedule of the request with set and returns it
+func (c *Controller) setScheduleStatus(w http.ResponseWriter, r *http.Request,
+	set func(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error)) {
+	userID, scheduleID, err := scheduleVars(r)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := set(r.Context(), userID, scheduleID)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schedule)
+}
+
+// ListScheduleRuns returns the runs of a schedule, the latest occurrence first
+// The limit query parameter defaults to 100 and is capped at 1000
+func (c *Controller) ListScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, scheduleID, err := scheduleVars(r)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if param := r.URL.Query().Get("limit"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 {
+			httpError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := c.transactionmanager.ListScheduleRuns(ctx, userID, scheduleID, limit)
+	if err != nil {
+		httpError(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, runs)
+}
+
+// scheduleVars returns the user and schedule IDs of the path of a schedule request
+func scheduleVars(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["uid"])
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid user ID %v", err)
+	}
+	scheduleID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid schedule ID %v", err)
+	}
+	return userID, scheduleID, nil
+}
+
+var (
+	errUnsupportedMediaType = errors.New("content type must be application/json")
+	errTrailingData         = errors.New("request body must contain a single JSON object")
+	errBackdatingForbidden  = errors.New("effective_date requires the admin token")
+)
+
+// canBackdate reports whether the request may set the effective date of its transactions
+// Only requests carrying the admin token may backdate transactions
+func canBackdate(ctx context.Context) bool {
+	return isAdmin(ctx)
+}
+
+// requestOwner returns the user given with the user_id query parameter, which is required without the admin token
+// The caller is not authenticated as the user, the user only scopes the lookup
+// uuid.Nil is returned for admin requests without user_id
+func requestOwner(r *http.Request) (uuid.UUID, error) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" && isAdmin(r.Context()) {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(userID)
+}
+
+// isAdmin reports whether the request carries the admin token
+func isAdmin(ctx context.Context) bool {
+	return audit.FromContext(ctx).Actor == audit.ActorAdmin
+}
+
+// timeOrZero returns an optional time of a request, e.g. effective_date or start_at, the zero time if it is not given
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// withDetails sets the details of a transaction
+func withDetails(transaction transactionmanager.Transaction, details TransactionDetails) transactionmanager.Transaction {
+	transaction.Currency = details.Currency
+	transaction.Description = details.Description
+	transaction.Category = details.Category
+	transaction.Source = details.Source
+	transaction.ExternalReference = details.ExternalReference
+	transaction.Metadata = details.Metadata
+	return transaction
+}
+
+// metadataQueryPrefix prefixes the query parameters filtering the history by metadata
+const metadataQueryPrefix = "metadata."
+
+// historyFilter returns the history filter of the query parameters
+func historyFilter(query url.Values) transactionmanager.HistoryFilter {
+	filter := transactionmanager.HistoryFilter{Reference: query.Get("reference")}
+	for name, values := range query {
+		if !strings.HasPrefix(name, metadataQueryPrefix) {
+			continue
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = map[string]string{}
+		}
+		filter.Metadata[strings.TrimPrefix(name, metadataQueryPrefix)] = values[0]
+	}
+	return filter
+}
+
+// decodeJSON decodes the request body into v
+// The body must be a single JSON object of content type application/json without unknown fields
 func decodeJSON(r *http.Request, v interface{}) error {
-	return json.NewDecoder(r.Body).Decode(v)
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+// decodeErrorStatus returns the status code matching an error returned by decodeJSON
+func decodeErrorStatus(err error) int {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &maxBytesError):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// errorStatus returns the status code matching an error returned by the transaction manager
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, transactionmanager.ErrInvalidTransaction),
+		errors.Is(err, transactionmanager.ErrInvalidEffectiveDate),
+		errors.Is(err, transactionmanager.ErrInvalidHistoryFilter),
+		errors.Is(err, transactionmanager.ErrInvalidPeriod),
+		errors.Is(err, transactionmanager.ErrInvalidSchedule):
+		return http.StatusBadRequest
+	case errors.Is(err, transactionmanager.ErrUserNotFound),
+		errors.Is(err, transactionmanager.ErrTransactionNotFound),
+		errors.Is(err, transactionmanager.ErrPostingNotFound),
+		errors.Is(err, transactionmanager.ErrScheduleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, transactionmanager.ErrAsyncUnavailable),
+		errors.Is(err, transactionmanager.ErrSchedulesUnavailable):
+		return http.StatusNotImplemented
+	case errors.Is(err, transactionmanager.ErrTransactionAlreadyExist),
+		errors.Is(err, transactionmanager.ErrScheduleEnded):
+		return http.StatusConflict
+	case errors.Is(err, transactionmanager.ErrFeeAccountNotFound):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func respondWithJSON(w http.ResponseWriter, statusCode int, data interface{}) {
@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -16,14 +17,22 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tebrizetayi/ledgerservice/internal/api"
 	"github.com/tebrizetayi/ledgerservice/internal/storage"
-	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
 	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager/transactionmanagertest"
 )
 
 var (
 	GetUserBalanceTemplate            = "/users/%s/balance"
 	GetUserTransactionHistoryTemplate = "/users/%s/history%s"
 	AddTransactionTemplate            = "/users/%s/add"
+	GetUserIntegrityTemplate          = "/users/%s/integrity"
+	AddTransactionsPath               = "/transactions/batch"
+	GetTransactionTemplate            = "/transactions/%s%s"
+	GetUserTransactionsTemplate       = "/users/%s/transactions%s"
+	GetPostingTemplate                = "/postings/%s%s"
+	GetUserStatementTemplate          = "/users/%s/statement%s"
+	UserSchedulesTemplate             = "/users/%s/schedules"
+	ScheduleTemplate                  = "/users/%s/schedules/%s/%s"
 )
 
 func TestGetUserBalanceEndpoint(t *testing.T) {
@@ -52,15 +61,10 @@ func TestGetUserBalanceEndpoint(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		// Create a test environment
-		testEnv, err := utils.CreateTestEnv()
-		if err != nil {
-			t.Fatalf("failed to create test env: %v", err)
-		}
-		defer testEnv.Cleanup()
-
-		storageClient := storage.NewStorageClient(testEnv.DB)
-		transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
+		// Create an in-memory store
+		ctx := context.Background()
+		store := storage.NewMemoryStore()
+		transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
 
 		userId, _ := uuid.Parse(tc.userID)
 		user := storage.User{
@@ -68,16 +72,15 @@ func TestGetUserBalanceEndpoint(t *testing.T) {
 			Balance: decimal.NewFromFloat(0),
 		}
 
-		err = storageClient.UserRepository.Add(testEnv.Context, user)
+		err := store.Add(ctx, user)
 		if err != nil {
 			t.Fatalf("failed to add user: %v", err)
 		}
 
-		_, err = transactionManager.AddTransaction(testEnv.Context, transactionmanager.Transaction{
-			UserID:    userId,
-			Amount:    decimal.NewFromFloat(tc.mockBalance),
-			ID:        uuid.New(),
-			CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		_, err = transactionManager.AddTransaction(ctx, transactionmanager.Transaction{
+			UserID: userId,
+			Amount: decimal.NewFromFloat(tc.mockBalance),
+			ID:     uuid.New(),
 		})
 		if err != nil {
 			t.Fatalf("failed to add transaction: %v", err)
@@ -198,15 +201,11 @@ func TestGetUserTransactionHistoryEndpoint(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		// Create a test environment
-		testEnv, err := utils.CreateTestEnv()
-		if err != nil {
-			t.Fatalf("failed to create test env: %v", err)
-		}
-		defer testEnv.Cleanup()
-
-		storageClient := storage.NewStorageClient(testEnv.DB)
-		transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
+		// Create an in-memory store whose clock stands at the creation time of the transactions
+		ctx := context.Background()
+		clock := transactionmanagertest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+		transactionManager := transactionmanager.NewTransactionManagerClient(store, store, transactionmanager.WithClock(clock))
 
 		userId, _ := uuid.Parse(tc.userID)
 		user := storage.User{
@@ -214,17 +213,16 @@ func TestGetUserTransactionHistoryEndpoint(t *testing.T) {
 			Balance: decimal.NewFromFloat(0),
 		}
 
-		err = storageClient.UserRepository.Add(testEnv.Context, user)
+		err := store.Add(ctx, user)
 		if err != nil {
 			t.Fatalf("failed to add user: %v", err)
 		}
 
 		for i := range tc.mockTransactions {
-			_, err = transactionManager.AddTransaction(testEnv.Context, transactionmanager.Transaction{
+			_, err = transactionManager.AddTransaction(ctx, transactionmanager.Transaction{
 				UserID:         tc.mockTransactions[i].UserID,
 				Amount:         tc.mockTransactions[i].Amount,
 				ID:             tc.mockTransactions[i].ID,
-				CreatedAt:      tc.mockTransactions[i].CreatedAt,
 				IdempotencyKey: tc.mockTransactions[i].IdempotencyKey,
 			})
 			if err != nil {
@@ -276,35 +274,30 @@ func TestAddTransaction(t *testing.T) {
 	}{
 		{
 			name:               "Valid transaction",
-			requestBody:        []byte(`{"user_id":"` + testUserID.String() + `", "amount":100, "idempotency_key":"` + idempotency_key + `"}`),
+			requestBody:        []byte(`{"amount":100, "idempotency_key":"` + idempotency_key + `"}`),
 			expectedStatusCode: http.StatusCreated,
 			mockError:          nil,
 		},
 		{
 			name:               "Invalid JSON",
-			requestBody:        []byte(`{"user_id": , "amount":100}`),
+			requestBody:        []byte(`{"amount": , "idempotency_key":"` + idempotency_key + `"}`),
 			expectedStatusCode: http.StatusBadRequest,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Create a test environment
-			testEnv, err := utils.CreateTestEnv()
-			if err != nil {
-				t.Fatalf("failed to create test env: %v", err)
-			}
-			defer testEnv.Cleanup()
-
-			storageClient := storage.NewStorageClient(testEnv.DB)
-			transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
+			// Create an in-memory store
+			ctx := context.Background()
+			store := storage.NewMemoryStore()
+			transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
 
 			user := storage.User{
 				ID:      testUserID,
 				Balance: decimal.NewFromFloat(0),
 			}
 
-			err = storageClient.UserRepository.Add(testEnv.Context, user)
+			err := store.Add(ctx, user)
 			if err != nil {
 				t.Fatalf("failed to add user: %v", err)
 			}
@@ -314,6 +307,7 @@ func TestAddTransaction(t *testing.T) {
 			newAPI := api.NewAPI(controller)
 
 			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, testUserID.String()), bytes.NewBuffer(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
 			rr := httptest.NewRecorder()
 			newAPI.ServeHTTP(rr, req)
 
@@ -331,7 +325,7 @@ func TestAddTransaction(t *testing.T) {
 				}
 				assert.Equal(t, "Transaction successfully added", response.Message)
 
-				transactions, err := transactionManager.GetUserTransactionHistory(testEnv.Context, testUserID, 1, 10)
+				transactions, err := transactionManager.GetUserTransactionHistory(ctx, testUserID, 1, 10, transactionmanager.HistoryFilter{})
 				if err != nil {
 					t.Fatalf("failed to get transactions: %v", err)
 				}
@@ -344,6 +338,418 @@ func TestAddTransaction(t *testing.T) {
 	}
 }
 
+func TestAddTransaction_EffectiveDate(t *testing.T) {
+	const adminToken = "admin-token"
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	testUserID := uuid.New()
+	testCases := []struct {
+		name                  string
+		authorization         string
+		effectiveDate         string
+		expectedStatusCode    int
+		expectedEffectiveDate time.Time
+	}{
+		{
+			name:                  "No effective date",
+			expectedStatusCode:    http.StatusCreated,
+			expectedEffectiveDate: now,
+		},
+		{
+			name:                  "Backdated with the admin token",
+			authorization:         "Bearer " + adminToken,
+			effectiveDate:         "2020-01-01T00:00:00Z",
+			expectedStatusCode:    http.StatusCreated,
+			expectedEffectiveDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:               "Backdated without the admin token",
+			effectiveDate:      "2020-01-01T00:00:00Z",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "In the future",
+			authorization:      "Bearer " + adminToken,
+			effectiveDate:      "2020-01-03T00:00:00Z",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			clock := transactionmanagertest.NewClock(now)
+			store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+			transactionManager := transactionmanager.NewTransactionManagerClient(store, store,
+				transactionmanager.WithClock(clock), transactionmanager.WithIDGenerator(transactionmanagertest.NewIDGenerator()))
+			err := store.Add(ctx, storage.User{ID: testUserID, Balance: decimal.NewFromFloat(0)})
+			if err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+
+			controller := api.NewController(transactionManager)
+			newAPI := api.NewAPI(controller, api.WithAdmin(adminToken, nil))
+
+			body := `{"amount":100, "idempotency_key":"` + uuid.NewString() + `"`
+			if tc.effectiveDate != "" {
+				body += `, "effective_date":"` + tc.effectiveDate + `"`
+			}
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, testUserID), bytes.NewBufferString(body+"}"))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if rr.Code == http.StatusCreated {
+				var response api.AddTransactionResponse
+				err = json.Unmarshal(rr.Body.Bytes(), &response)
+				if err != nil {
+					t.Fatalf("failed to unmarshal response: %v", err)
+				}
+				assert.Equal(t, transactionmanagertest.ID(1), response.Transaction.ID)
+				assert.True(t, now.Equal(response.Transaction.CreatedAt), "created_at is %s", response.Transaction.CreatedAt)
+				assert.True(t, tc.expectedEffectiveDate.Equal(response.Transaction.EffectiveDate), "effective_date is %s", response.Transaction.EffectiveDate)
+			}
+		})
+	}
+}
+
+func TestAddTransaction_DetailsAndHistoryFilter(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	testUserID := uuid.New()
+	store := storage.NewMemoryStore()
+	transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
+	err := store.Add(ctx, storage.User{ID: testUserID, Balance: decimal.NewFromFloat(0)})
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	newAPI := api.NewAPI(api.NewController(transactionManager))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, testUserID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		newAPI.ServeHTTP(rr, req)
+		return rr
+	}
+	history := func(query string) ([]transactionmanager.Transaction, int) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserTransactionHistoryTemplate, testUserID, query), nil)
+		rr := httptest.NewRecorder()
+		newAPI.ServeHTTP(rr, req)
+		var transactions []transactionmanager.Transaction
+		json.Unmarshal(rr.Body.Bytes(), &transactions)
+		return transactions, rr.Code
+	}
+
+	// Act
+	first := post(`{"amount":10, "idempotency_key":"` + uuid.NewString() + `", "description":"Invoice 1", "category":"payments", "currency":"EUR",
+		"source":"stripe", "external_reference":"ch_1", "metadata":{"order_id":"1", "attempt":2}}`)
+	second := post(`{"amount":20, "idempotency_key":"` + uuid.NewString() + `", "source":"stripe", "external_reference":"ch_2", "metadata":{"order_id":"2"}}`)
+	duplicateReference := post(`{"amount":30, "idempotency_key":"` + uuid.NewString() + `", "source":"stripe", "external_reference":"ch_1"}`)
+	referenceWithoutSource := post(`{"amount":30, "idempotency_key":"` + uuid.NewString() + `", "external_reference":"ch_3"}`)
+	byReference, byReferenceStatus := history("?reference=ch_2")
+	byMetadata, byMetadataStatus := history("?metadata.order_id=1&metadata.attempt=2")
+	_, invalidKeyStatus := history("?metadata.order%20id=1")
+
+	// Assert
+	assert.Equal(t, http.StatusCreated, first.Code)
+	var response api.AddTransactionResponse
+	err = json.Unmarshal(first.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, "Invoice 1", response.Transaction.Description)
+	assert.Equal(t, "payments", response.Transaction.Category)
+	assert.Equal(t, "EUR", response.Transaction.Currency)
+	assert.Equal(t, "stripe", response.Transaction.Source)
+	assert.Equal(t, "ch_1", response.Transaction.ExternalReference)
+	assert.Equal(t, map[string]interface{}{"order_id": "1", "attempt": float64(2)}, response.Transaction.Metadata)
+
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, http.StatusConflict, duplicateReference.Code)
+	assert.Equal(t, http.StatusBadRequest, referenceWithoutSource.Code)
+
+	assert.Equal(t, http.StatusOK, byReferenceStatus)
+	if assert.Len(t, byReference, 1) {
+		assert.Equal(t, "ch_2", byReference[0].ExternalReference)
+	}
+	assert.Equal(t, http.StatusOK, byMetadataStatus)
+	if assert.Len(t, byMetadata, 1) {
+		assert.Equal(t, "ch_1", byMetadata[0].ExternalReference)
+	}
+	assert.Equal(t, http.StatusBadRequest, invalidKeyStatus)
+}
+
+func TestGetTransaction(t *testing.T) {
+	const adminToken = "admin-token"
+	ctx := context.Background()
+	ownerID := uuid.New()
+	otherUserID := uuid.New()
+	store := storage.NewMemoryStore()
+	transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
+	for _, userID := range []uuid.UUID{ownerID, otherUserID} {
+		err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.NewFromFloat(0)})
+		if err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	added, err := transactionManager.AddTransaction(ctx, transactionmanager.Transaction{
+		UserID:         ownerID,
+		Amount:         decimal.NewFromFloat(10),
+		IdempotencyKey: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	newAPI := api.NewAPI(api.NewController(transactionManager), api.WithAdmin(adminToken, nil))
+
+	testCases := []struct {
+		name               string
+		transactionID      string
+		query              string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Owner",
+			transactionID:      added.ID.String(),
+			query:              "?user_id=" + ownerID.String(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Other user",
+			transactionID:      added.ID.String(),
+			query:              "?user_id=" + otherUserID.String(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Without user",
+			transactionID:      added.ID.String(),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Admin without user",
+			transactionID:      added.ID.String(),
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Unknown transaction",
+			transactionID:      uuid.NewString(),
+			query:              "?user_id=" + ownerID.String(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Invalid transaction ID",
+			transactionID:      "invalid-transaction-id",
+			query:              "?user_id=" + ownerID.String(),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetTransactionTemplate, tc.transactionID, tc.query), nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if rr.Code == http.StatusOK {
+				var transaction transactionmanager.Transaction
+				err := json.Unmarshal(rr.Body.Bytes(), &transaction)
+				if err != nil {
+					t.Fatalf("failed to unmarshal response: %v", err)
+				}
+				assert.Equal(t, added.ID, transaction.ID)
+				assert.Equal(t, ownerID, transaction.UserID)
+			}
+		})
+	}
+}
+
+func TestAddTransaction_Async(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	testUserID := uuid.New()
+	otherUserID := uuid.New()
+	store := storage.NewMemoryStore()
+	transactionManager := transactionmanager.NewTransactionManagerClient(store, store, transactionmanager.WithPostingStore(store))
+	for _, userID := range []uuid.UUID{testUserID, otherUserID} {
+		err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.NewFromFloat(0)})
+		if err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	newAPI := api.NewAPI(api.NewController(transactionManager))
+	synchronousAPI := api.NewAPI(api.NewController(transactionmanager.NewTransactionManagerClient(store, store)))
+
+	post := func(handler http.Handler, query string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, testUserID)+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	getPosting := func(postingID uuid.UUID, query string) (transactionmanager.Posting, int) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetPostingTemplate, postingID, query), nil)
+		rr := httptest.NewRecorder()
+		newAPI.ServeHTTP(rr, req)
+		var posting transactionmanager.Posting
+		json.Unmarshal(rr.Body.Bytes(), &posting)
+		return posting, rr.Code
+	}
+	body := `{"amount":10, "idempotency_key":"` + uuid.NewString() + `", "description":"Invoice 1"}`
+
+	// Act
+	accepted := post(newAPI, "?async=true", body)
+	duplicate := post(newAPI, "?async=true", body)
+	invalidAsync := post(newAPI, "?async=maybe", body)
+	unavailable := post(synchronousAPI, "?async=true", `{"amount":10, "idempotency_key":"`+uuid.NewString()+`"}`)
+	var response api.AddTransactionAcceptedResponse
+	err := json.Unmarshal(accepted.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	pending, pendingStatus := getPosting(response.Posting.ID, "?user_id="+testUserID.String())
+	_, otherUserStatus := getPosting(response.Posting.ID, "?user_id="+otherUserID.String())
+	_, withoutUserStatus := getPosting(response.Posting.ID, "")
+	_, unknownStatus := getPosting(uuid.New(), "?user_id="+testUserID.String())
+	_, _, err = store.ApplyNextPosting(ctx)
+	if err != nil {
+		t.Fatalf("failed to apply posting: %v", err)
+	}
+	applied, appliedStatus := getPosting(response.Posting.ID, "?user_id="+testUserID.String())
+
+	// Assert
+	assert.Equal(t, http.StatusAccepted, accepted.Code)
+	assert.Equal(t, fmt.Sprintf("/postings/%s", response.Posting.ID), accepted.Header().Get("Location"))
+	assert.Equal(t, transactionmanager.PostingPending, response.Posting.Status)
+	assert.Equal(t, testUserID, response.Posting.UserID)
+	assert.Equal(t, http.StatusConflict, duplicate.Code)
+	assert.Equal(t, http.StatusBadRequest, invalidAsync.Code)
+	assert.Equal(t, http.StatusNotImplemented, unavailable.Code)
+
+	assert.Equal(t, http.StatusOK, pendingStatus)
+	assert.Equal(t, transactionmanager.PostingPending, pending.Status)
+	assert.Nil(t, pending.Transaction)
+	assert.Equal(t, http.StatusNotFound, otherUserStatus)
+	assert.Equal(t, http.StatusBadRequest, withoutUserStatus)
+	assert.Equal(t, http.StatusNotFound, unknownStatus)
+
+	assert.Equal(t, http.StatusOK, appliedStatus)
+	assert.Equal(t, transactionmanager.PostingApplied, applied.Status)
+	if assert.NotNil(t, applied.Transaction) {
+		assert.Equal(t, "Invoice 1", applied.Transaction.Description)
+		assert.True(t, applied.Transaction.Amount.Equal(decimal.NewFromFloat(10)), "got %s", applied.Transaction.Amount)
+	}
+}
+
+func TestGetUserTransactions_IdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	ownerID := uuid.New()
+	otherUserID := uuid.New()
+	idempotencyKey := uuid.New()
+	store := storage.NewMemoryStore()
+	transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
+	for _, userID := range []uuid.UUID{ownerID, otherUserID} {
+		err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.NewFromFloat(0)})
+		if err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	// A key only identifies a transaction together with its amount
+	for _, amount := range []float64{10, 20} {
+		_, err := transactionManager.AddTransaction(ctx, transactionmanager.Transaction{
+			UserID:         ownerID,
+			Amount:         decimal.NewFromFloat(amount),
+			IdempotencyKey: idempotencyKey,
+		})
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	newAPI := api.NewAPI(api.NewController(transactionManager))
+
+	testCases := []struct {
+		name                 string
+		userID               uuid.UUID
+		query                string
+		expectedStatusCode   int
+		expectedTransactions int
+	}{
+		{
+			name:                 "Added transactions",
+			userID:               ownerID,
+			query:                "?idempotency_key=" + idempotencyKey.String(),
+			expectedStatusCode:   http.StatusOK,
+			expectedTransactions: 2,
+		},
+		{
+			name:               "Key of another user",
+			userID:             otherUserID,
+			query:              "?idempotency_key=" + idempotencyKey.String(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Unknown key",
+			userID:             ownerID,
+			query:              "?idempotency_key=" + uuid.NewString(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Unknown user",
+			userID:             uuid.New(),
+			query:              "?idempotency_key=" + idempotencyKey.String(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Invalid key",
+			userID:             ownerID,
+			query:              "?idempotency_key=invalid-key",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserTransactionsTemplate, tc.userID, tc.query), nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if rr.Code == http.StatusOK {
+				var transactions []transactionmanager.Transaction
+				err := json.Unmarshal(rr.Body.Bytes(), &transactions)
+				if err != nil {
+					t.Fatalf("failed to unmarshal response: %v", err)
+				}
+				assert.Len(t, transactions, tc.expectedTransactions)
+				for _, transaction := range transactions {
+					assert.Equal(t, idempotencyKey, transaction.IdempotencyKey)
+				}
+			}
+		})
+	}
+}
+
 func TestAddTransaction_MultipleRequestWithSameAmount(t *testing.T) {
 	testUserID := uuid.New()
 	idempotencyKey := uuid.New().String()
@@ -355,7 +761,7 @@ func TestAddTransaction_MultipleRequestWithSameAmount(t *testing.T) {
 	}{
 		{
 			name:               "Valid transaction",
-			requestBody:        []byte(fmt.Sprintf(`{"user_id":"%s", "amount":100, "idempotency_key":"%s"}`, testUserID.String(), idempotencyKey)),
+			requestBody:        []byte(fmt.Sprintf(`{"amount":100, "idempotency_key":"%s"}`, idempotencyKey)),
 			expectedStatusCode: http.StatusCreated,
 			mockError:          nil,
 		},
@@ -363,22 +769,17 @@ func TestAddTransaction_MultipleRequestWithSameAmount(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Create a test environment
-			testEnv, err := utils.CreateTestEnv()
-			if err != nil {
-				t.Fatalf("failed to create test env: %v", err)
-			}
-			defer testEnv.Cleanup()
-
-			storageClient := storage.NewStorageClient(testEnv.DB)
-			transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
+			// Create an in-memory store
+			ctx := context.Background()
+			store := storage.NewMemoryStore()
+			transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
 
 			user := storage.User{
 				ID:      testUserID,
 				Balance: decimal.NewFromFloat(0),
 			}
 
-			err = storageClient.UserRepository.Add(testEnv.Context, user)
+			err := store.Add(ctx, user)
 			if err != nil {
 				t.Fatalf("failed to add user: %v", err)
 			}
@@ -397,6 +798,7 @@ func TestAddTransaction_MultipleRequestWithSameAmount(t *testing.T) {
 
 			for i := 0; i < concurrentRequests; i++ {
 				req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, testUserID.String()), bytes.NewBuffer(tc.requestBody))
+				req.Header.Set("Content-Type", "application/json")
 				rr := httptest.NewRecorder()
 
 				go func() {
@@ -425,15 +827,10 @@ func TestAddTransaction_MultipleRequestWithSameAmount(t *testing.T) {
 
 func TestAddTransaction_MultipleRequestWithDifferentAmount(t *testing.T) {
 
-	// Create a test environment
-	testEnv, err := utils.CreateTestEnv()
-	if err != nil {
-		t.Fatalf("failed to create test env: %v", err)
-	}
-	defer testEnv.Cleanup()
-
-	storageClient := storage.NewStorageClient(testEnv.DB)
-	transactionManager := transactionmanager.NewTransactionManagerClient(storageClient)
+	// Create an in-memory store
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := transactionmanager.NewTransactionManagerClient(store, store)
 
 	idempotencyKey := uuid.New().String()
 	user := storage.User{
@@ -441,7 +838,7 @@ func TestAddTransaction_MultipleRequestWithDifferentAmount(t *testing.T) {
 		Balance: decimal.NewFromFloat(0),
 	}
 
-	err = storageClient.UserRepository.Add(testEnv.Context, user)
+	err := store.Add(ctx, user)
 	if err != nil {
 		t.Fatalf("failed to add user: %v", err)
 	}
@@ -461,8 +858,9 @@ func TestAddTransaction_MultipleRequestWithDifferentAmount(t *testing.T) {
 	for i := 0; i < int(concurrentRequests); i++ {
 		go func(i float64) {
 
-			requestBody := []byte(fmt.Sprintf(`{"user_id":"%s", "amount":%f, "idempotency_key":"%s"}`, user.ID.String(), i, idempotencyKey))
+			requestBody := []byte(fmt.Sprintf(`{"amount":%f, "idempotency_key":"%s"}`, i, idempotencyKey))
 			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, user.ID.String()), bytes.NewBuffer(requestBody))
+			req.Header.Set("Content-Type", "application/json")
 			rr := httptest.NewRecorder()
 			<-startCh
 			newAPI.ServeHTTP(rr, req)
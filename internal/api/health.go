@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tebrizetayi/ledgerservice/internal/migrations"
+)
+
+const (
+	healthz = "/healthz"
+	readyz  = "/readyz"
+)
+
+const (
+	checkStatusOK   = "ok"
+	checkStatusFail = "fail"
+)
+
+// ReadinessCheck is a named dependency check run by the readiness endpoint
+// Check returns optional details that are reported in the response
+type ReadinessCheck struct {
+	Name    string
+	Timeout time.Duration
+	Check   func(ctx context.Context) (interface{}, error)
+}
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	Status   string      `json:"status"`
+	Duration string      `json:"duration"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// ReadinessResponse is the response body of the readiness endpoint
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthz reports that the process is alive
+// It does not touch any dependency
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": checkStatusOK})
+}
+
+// Readyz returns a handler running every check and reporting a breakdown per check
+// If any check fails, 503 Service Unavailable is returned
+func Readyz(checks []ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := ReadinessResponse{
+			Status: checkStatusOK,
+			Checks: map[string]CheckResult{},
+		}
+
+		for _, check := range checks {
+			result := runCheck(r.Context(), check)
+			if result.Status != checkStatusOK {
+				response.Status = checkStatusFail
+			}
+			response.Checks[check.Name] = result
+		}
+
+		statusCode := http.StatusOK
+		if response.Status != checkStatusOK {
+			statusCode = http.StatusServiceUnavailable
+		}
+		respondWithJSON(w, statusCode, response)
+	}
+}
+
+func runCheck(ctx context.Context, check ReadinessCheck) CheckResult {
+	if check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, check.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	details, err := check.Check(ctx)
+	result := CheckResult{
+		Status:   checkStatusOK,
+		Duration: time.Since(start).String(),
+		Details:  details,
+	}
+	if err != nil {
+		result.Status = checkStatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// PostgresCheck pings the database within the given timeout
+func PostgresCheck(db *sql.DB, timeout time.Duration) ReadinessCheck {
+	return ReadinessCheck{
+		Name:    "postgres",
+		Timeout: timeout,
+		Check: func(ctx context.Context) (interface{}, error) {
+			return nil, db.PingContext(ctx)
+		},
+	}
+}
+
+// SQLiteCheck pings the SQLite database within the given timeout
+func SQLiteCheck(db *sql.DB, timeout time.Duration) ReadinessCheck {
+	return ReadinessCheck{
+		Name:    "sqlite",
+		Timeout: timeout,
+		Check: func(ctx context.Context) (interface{}, error) {
+			return nil, db.PingContext(ctx)
+		},
+	}
+}
+
+// MigrationCheck verifies that the schema version in the database
+// matches the version expected by this binary
+func MigrationCheck(db *sql.DB, timeout time.Duration) ReadinessCheck {
+	return ReadinessCheck{
+		Name:    "migrations",
+		Timeout: timeout,
+		Check: func(ctx context.Context) (interface{}, error) {
+			current, err := migrations.Current(ctx, db)
+			if err != nil {
+				return nil, err
+			}
+
+			details := map[string]int{
+				"expected": migrations.Version(),
+				"current":  current,
+			}
+			if current != migrations.Version() {
+				return details, fmt.Errorf("schema version %d does not match expected version %d", current, migrations.Version())
+			}
+			return details, nil
+		},
+	}
+}
+
+// PoolCheck reports the saturation of the database connection pool
+// It never fails, saturation is reported for information only
+func PoolCheck(db *sql.DB) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "pool",
+		Check: func(ctx context.Context) (interface{}, error) {
+			stats := db.Stats()
+
+			saturation := 0.0
+			if stats.MaxOpenConnections > 0 {
+				saturation = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+			}
+
+			return map[string]interface{}{
+				"open":       stats.OpenConnections,
+				"in_use":     stats.InUse,
+				"idle":       stats.Idle,
+				"max_open":   stats.MaxOpenConnections,
+				"wait_count": stats.WaitCount,
+				"saturation": saturation,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+)
+
+func TestHealthzEndpoint(t *testing.T) {
+	// Assign
+	newAPI := api.NewAPI(api.Controller{})
+
+	req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	newAPI.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	passing := api.ReadinessCheck{
+		Name: "passing",
+		Check: func(ctx context.Context) (interface{}, error) {
+			return map[string]int{"value": 1}, nil
+		},
+	}
+	failing := api.ReadinessCheck{
+		Name: "failing",
+		Check: func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("dependency down")
+		},
+	}
+	slow := api.ReadinessCheck{
+		Name:    "slow",
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	testCases := []struct {
+		name               string
+		checks             []api.ReadinessCheck
+		expectedStatusCode int
+		expectedStatus     string
+		expectedChecks     map[string]string
+	}{
+		{
+			name:               "All checks pass",
+			checks:             []api.ReadinessCheck{passing},
+			expectedStatusCode: http.StatusOK,
+			expectedStatus:     "ok",
+			expectedChecks:     map[string]string{"passing": "ok"},
+		},
+		{
+			name:               "One check fails",
+			checks:             []api.ReadinessCheck{passing, failing},
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedStatus:     "fail",
+			expectedChecks:     map[string]string{"passing": "ok", "failing": "fail"},
+		},
+		{
+			name:               "Check times out",
+			checks:             []api.ReadinessCheck{slow},
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedStatus:     "fail",
+			expectedChecks:     map[string]string{"slow": "fail"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.Controller{}, api.WithReadinessChecks(tc.checks...))
+
+			req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+
+			var response api.ReadinessResponse
+			err := json.Unmarshal(rr.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, response.Status)
+			for name, status := range tc.expectedChecks {
+				assert.Equal(t, status, response.Checks[name].Status, "check %s", name)
+			}
+		})
+	}
+}
+
+func TestHealthEndpoints_NotRateLimited(t *testing.T) {
+	// Assign
+	newAPI := api.NewAPI(api.Controller{})
+
+	// Act & Assert
+	// The shared limiter allows a burst of 100 requests
+	for i := 0; i < 200; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+		rr := httptest.NewRecorder()
+		newAPI.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+const openAPI = "/openapi.json"
+
+// OpenAPISpec is the OpenAPI 3 document describing every route of the API
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// OpenAPI serves the OpenAPI document
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(OpenAPISpec)
+}
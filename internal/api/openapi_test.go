@@ -0,0 +1,626 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+func loadOpenAPISpec(t *testing.T) (*openapi3.T, routers.Router) {
+	t.Helper()
+
+	doc, err := openapi3.NewLoader().LoadFromData(api.OpenAPISpec)
+	if err != nil {
+		t.Fatalf("failed to load OpenAPI spec: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %v", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("failed to create OpenAPI router: %v", err)
+	}
+	return doc, router
+}
+
+func TestOpenAPISpec_DocumentsEveryRoute(t *testing.T) {
+	// Assign
+	doc, _ := loadOpenAPISpec(t)
+	router := api.NewAPI(api.NewController(&stubTransactionManager{})).(*mux.Router)
+
+	// Act
+	routes := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Routes without methods only group other routes
+			return nil
+		}
+		for _, method := range methods {
+			routes[method+" "+template] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+
+	// Assert
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+	assert.Equal(t, documented, routes)
+}
+
+func TestOpenAPISpec_ResponsesMatchSpec(t *testing.T) {
+	userID := uuid.New()
+	transactions := []transactionmanager.Transaction{
+		{
+			ID:             uuid.New(),
+			UserID:         userID,
+			Amount:         decimal.NewFromFloat(100.5),
+			CreatedAt:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			IdempotencyKey: uuid.New(),
+		},
+	}
+	transactionID := transactions[0].ID
+	processedAt := time.Date(2020, 1, 1, 0, 0, 1, 0, time.UTC)
+	postings := []transactionmanager.Posting{
+		{
+			ID:          uuid.New(),
+			UserID:      userID,
+			Status:      transactionmanager.PostingApplied,
+			CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			ProcessedAt: &processedAt,
+			Transaction: &transactions[0],
+		},
+	}
+	auditEntries := []audit.Entry{
+		{
+			ID:            1,
+			CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Action:        audit.ActionAddTransaction,
+			Actor:         audit.ActorAnonymous,
+			RequestID:     uuid.NewString(),
+			ClientIP:      "127.0.0.1",
+			UserID:        userID,
+			TransactionID: &transactionID,
+			BalanceBefore: decimal.Zero,
+			BalanceAfter:  decimal.NewFromFloat(100.5),
+			PayloadHash:   "00ff",
+		},
+	}
+	validBody := `{"amount":100, "idempotency_key":"` + uuid.New().String() + `"}`
+	feeBody := `{"amount":100, "idempotency_key":"` + uuid.New().String() + `", "category":"card", "currency":"EUR"}`
+	fee := &transactionmanager.Fee{
+		Rule:                 "card-eur",
+		Flat:                 decimal.NewFromFloat(0.25),
+		Percentage:           decimal.NewFromFloat(1.5),
+		PercentageAmount:     decimal.NewFromFloat(1.5),
+		Amount:               decimal.NewFromFloat(1.75),
+		NetAmount:            decimal.NewFromFloat(98.25),
+		TransactionID:        uuid.New(),
+		RevenueTransactionID: uuid.New(),
+		RevenueAccount:       uuid.New(),
+	}
+	batchBody := `{"mode":"best_effort","postings":[` +
+		`{"user_id":"` + userID.String() + `","amount":100,"idempotency_key":"` + uuid.New().String() + `"},` +
+		`{"user_id":"` + userID.String() + `","amount":50,"idempotency_key":"` + uuid.New().String() + `"}]}`
+
+	testCases := []struct {
+		name               string
+		manager            *stubTransactionManager
+		options            []api.Option
+		method             string
+		path               string
+		contentType        string
+		authorization      string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Add transaction",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Add transaction with a fee",
+			manager:            &stubTransactionManager{fee: fee},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        feeBody,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Add transaction without a fee revenue account",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrFeeAccountNotFound},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        feeBody,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:               "Add transaction asynchronously",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID) + "?async=true",
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			name:               "Add transaction asynchronously without a queue",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrAsyncUnavailable},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID) + "?async=true",
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusNotImplemented,
+		},
+		{
+			name:               "Add transaction with invalid JSON",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        `{"amount":`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Add transaction with wrong content type",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "text/plain",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "Add transaction with body too large",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithMaxBodyBytes(16)},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:               "Add transaction failing",
+			manager:            &stubTransactionManager{err: errors.New("database down")},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "Add duplicate transaction",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrTransactionAlreadyExist},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(AddTransactionTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        validBody,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "Add batch",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               AddTransactionsPath,
+			contentType:        "application/json",
+			requestBody:        batchBody,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Add batch partially",
+			manager:            &stubTransactionManager{batchErrs: []error{nil, transactionmanager.ErrUserNotFound}},
+			method:             http.MethodPost,
+			path:               AddTransactionsPath,
+			contentType:        "application/json",
+			requestBody:        batchBody,
+			expectedStatusCode: http.StatusMultiStatus,
+		},
+		{
+			name:               "Add batch aborted",
+			manager:            &stubTransactionManager{batchErrs: []error{transactionmanager.ErrBatchAborted, transactionmanager.ErrInvalidTransaction}},
+			method:             http.MethodPost,
+			path:               AddTransactionsPath,
+			contentType:        "application/json",
+			requestBody:        batchBody,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:               "Add empty batch",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               AddTransactionsPath,
+			contentType:        "application/json",
+			requestBody:        `{"postings":[]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Get balance of unknown user",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrUserNotFound},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserBalanceTemplate, userID),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Get balance",
+			manager:            &stubTransactionManager{balance: decimal.NewFromFloat(100.5)},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserBalanceTemplate, userID),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get balance as of",
+			manager:            &stubTransactionManager{balance: decimal.NewFromFloat(100.5)},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserBalanceTemplate, userID) + "?as_of=2020-01-01T00:00:00Z",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get balance with invalid user ID",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserBalanceTemplate, "invalid-user-id"),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Get history",
+			manager:            &stubTransactionManager{transactions: transactions},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserTransactionHistoryTemplate, userID, "?page=1&pageSize=10"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get empty history",
+			manager:            &stubTransactionManager{transactions: []transactionmanager.Transaction{}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserTransactionHistoryTemplate, userID, ""),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get history failing",
+			manager:            &stubTransactionManager{err: errors.New("database down")},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserTransactionHistoryTemplate, userID, ""),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name: "Get statement",
+			manager: &stubTransactionManager{statement: transactionmanager.Statement{
+				OpeningBalance: decimal.NewFromInt(10),
+				ClosingBalance: transactions[0].Amount.Add(decimal.NewFromInt(10)),
+				Lines:          []transactionmanager.StatementLine{{Transaction: transactions[0], Balance: transactions[0].Amount.Add(decimal.NewFromInt(10))}},
+			}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserStatementTemplate, userID, "?from=2020-01-01T00:00:00Z&to=2020-02-01T00:00:00Z"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get statement of invalid period",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrInvalidPeriod},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserStatementTemplate, userID, "?from=2020-02-01T00:00:00Z&to=2020-01-01T00:00:00Z"),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Create schedule",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(UserSchedulesTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        `{"amount":10, "interval":"24h", "end_at":"2021-01-01T00:00:00Z"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Create invalid schedule",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrInvalidSchedule},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(UserSchedulesTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        `{"amount":10}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Create schedule without schedules",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrSchedulesUnavailable},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(UserSchedulesTemplate, userID),
+			contentType:        "application/json",
+			requestBody:        `{"amount":10, "cron":"@daily"}`,
+			expectedStatusCode: http.StatusNotImplemented,
+		},
+		{
+			name: "List schedules",
+			manager: &stubTransactionManager{schedules: []transactionmanager.Schedule{{
+				ID:        uuid.New(),
+				UserID:    userID,
+				Amount:    decimal.NewFromInt(10),
+				Cron:      "@daily",
+				StartAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				NextRunAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+				Status:    transactionmanager.ScheduleActive,
+				CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			}}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(UserSchedulesTemplate, userID),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Pause schedule",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(ScheduleTemplate, userID, uuid.New(), "pause"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Resume ended schedule",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrScheduleEnded},
+			method:             http.MethodPost,
+			path:               fmt.Sprintf(ScheduleTemplate, userID, uuid.New(), "resume"),
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name: "List schedule runs",
+			manager: &stubTransactionManager{runs: []transactionmanager.ScheduleRun{{
+				ScheduledAt:   time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+				Status:        transactionmanager.RunPosted,
+				TransactionID: &transactionID,
+				RanAt:         processedAt,
+			}}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(ScheduleTemplate, userID, uuid.New(), "runs?limit=10"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "List runs of unknown schedule",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrScheduleNotFound},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(ScheduleTemplate, userID, uuid.New(), "runs"),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Get integrity",
+			manager:            &stubTransactionManager{chainReport: transactionmanager.ChainReport{UserID: userID, Intact: true, Transactions: 1}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserIntegrityTemplate, userID),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Get integrity of broken chain",
+			manager: &stubTransactionManager{chainReport: transactionmanager.ChainReport{
+				UserID:       userID,
+				Transactions: 1,
+				Break:        &transactionmanager.ChainBreak{Seq: 1, TransactionID: &transactions[0].ID, Reason: "hash mismatch", ExpectedHash: "00", ActualHash: "01"},
+			}},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserIntegrityTemplate, userID),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get transaction",
+			manager:            &stubTransactionManager{transactions: transactions},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetTransactionTemplate, transactions[0].ID, "?user_id="+userID.String()),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get unknown transaction",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetTransactionTemplate, uuid.New(), "?user_id="+userID.String()),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Get transactions by idempotency key",
+			manager:            &stubTransactionManager{transactions: transactions},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserTransactionsTemplate, userID, "?idempotency_key="+transactions[0].IdempotencyKey.String()),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get transactions without idempotency key",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserTransactionsTemplate, userID, ""),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Get posting",
+			manager:            &stubTransactionManager{postings: postings},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetPostingTemplate, postings[0].ID, "?user_id="+userID.String()),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Get unknown posting",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetPostingTemplate, uuid.New(), "?user_id="+userID.String()),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Rate limited",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithRateLimit(0, 0)},
+			method:             http.MethodGet,
+			path:               fmt.Sprintf(GetUserBalanceTemplate, userID),
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name:               "Liveness",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               "/healthz",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:    "Readiness failing",
+			manager: &stubTransactionManager{},
+			options: []api.Option{api.WithReadinessChecks(api.ReadinessCheck{
+				Name: "failing",
+				Check: func(ctx context.Context) (interface{}, error) {
+					return nil, errors.New("dependency down")
+				},
+			})},
+			method:             http.MethodGet,
+			path:               "/readyz",
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:               "Export",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{})},
+			method:             http.MethodGet,
+			path:               "/admin/export/transactions?format=csv&since=2020-01-01T00:00:00Z",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Export with invalid format",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{})},
+			method:             http.MethodGet,
+			path:               "/admin/export/transactions?format=xml",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Export without token",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{})},
+			method:             http.MethodGet,
+			path:               "/admin/export/users",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "Export disabled",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               "/admin/export/users",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "Audit log",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{}), api.WithAuditLog(&stubAuditLog{entries: auditEntries})},
+			method:             http.MethodGet,
+			path:               "/admin/audit?actor=anonymous&user_id=" + userID.String() + "&since=2020-01-01T00:00:00Z&limit=10",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Audit log with invalid limit",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{}), api.WithAuditLog(&stubAuditLog{})},
+			method:             http.MethodGet,
+			path:               "/admin/audit?limit=0",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Cache stats",
+			manager: &stubTransactionManager{},
+			options: []api.Option{api.WithAdmin(adminToken, &stubExporter{}), api.WithCacheStats(func() transactionmanager.CacheStats {
+				return transactionmanager.CacheStats{Hits: 3, Misses: 1, HitRate: 0.75}
+			})},
+			method:             http.MethodGet,
+			path:               "/admin/cache/stats",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Cache stats without a cache",
+			manager:            &stubTransactionManager{},
+			options:            []api.Option{api.WithAdmin(adminToken, &stubExporter{})},
+			method:             http.MethodGet,
+			path:               "/admin/cache/stats",
+			authorization:      "Bearer " + adminToken,
+			expectedStatusCode: http.StatusNotImplemented,
+		},
+		{
+			name:               "OpenAPI document",
+			manager:            &stubTransactionManager{},
+			method:             http.MethodGet,
+			path:               "/openapi.json",
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	_, specRouter := loadOpenAPISpec(t)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.NewController(tc.manager), tc.options...)
+
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.requestBody))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+
+			route, pathParams, err := specRouter.FindRoute(req)
+			if err != nil {
+				t.Fatalf("route not found in OpenAPI spec: %v", err)
+			}
+			err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    req,
+					PathParams: pathParams,
+					Route:      route,
+				},
+				Status:  rr.Code,
+				Header:  rr.Header(),
+				Body:    io.NopCloser(strings.NewReader(rr.Body.String())),
+				Options: &openapi3filter.Options{IncludeResponseStatus: true},
+			})
+			assert.NoError(t, err, "response does not match the OpenAPI spec")
+		})
+	}
+}
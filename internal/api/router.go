@@ -1,45 +1,192 @@
 package api
 
 import (
+	"log"
 	"net/http"
+	"runtime/debug"
 
 	"github.com/gorilla/mux"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
 	"golang.org/x/time/rate"
 )
 
 const (
-	addTransaction = "/users/{uid}/add"
-	getUserBalance = "/users/{uid}/balance"
-	userHistory    = "/users/{uid}/history"
+	addTransaction   = "/users/{uid}/add"
+	getUserBalance   = "/users/{uid}/balance"
+	userHistory      = "/users/{uid}/history"
+	batch            = "/transactions/batch"
+	userIntegrity    = "/users/{uid}/integrity"
+	transaction      = "/transactions/{id}"
+	userTransactions = "/users/{uid}/transactions"
+	posting          = "/postings/{id}"
+	userStatement    = "/users/{uid}/statement"
+	userSchedules    = "/users/{uid}/schedules"
+	pauseSchedule    = "/users/{uid}/schedules/{id}/pause"
+	resumeSchedule   = "/users/{uid}/schedules/{id}/resume"
+	scheduleRuns     = "/users/{uid}/schedules/{id}/runs"
 )
 
-var limiter = rate.NewLimiter(10, 100)
+const (
+	defaultRequestsPerSecond = 10
+	defaultBurst             = 100
+	defaultMaxBodyBytes      = 1 << 20
+)
 
-// limitMiddleware is a middleware that limits the number of requests per second
-// to 10 requests per second
-// If the limit is exceeded, a 429 Too Many Requests response is returned
-func limitMiddleware(next http.Handler) http.Handler {
+// Option configures the API router
+type Option func(*options)
+
+type options struct {
+	checks            []ReadinessCheck
+	requestsPerSecond float64
+	burst             int
+	maxBodyBytes      int64
+	adminToken        string
+	exporter          Exporter
+	auditLog          AuditLog
+	cacheStats        func() transactionmanager.CacheStats
+}
+
+// WithReadinessChecks sets the checks run by the readiness endpoint
+func WithReadinessChecks(checks ...ReadinessCheck) Option {
+	return func(o *options) {
+		o.checks = append(o.checks, checks...)
+	}
+}
+
+// WithRateLimit sets the number of requests per second and the burst
+// allowed by the rate limiting middleware
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(o *options) {
+		o.requestsPerSecond = requestsPerSecond
+		o.burst = burst
+	}
+}
+
+// WithMaxBodyBytes sets the maximum size of a request body
+func WithMaxBodyBytes(maxBodyBytes int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = maxBodyBytes
+	}
+}
+
+// headerTrackingWriter records whether the response header was written
+// Unwrap exposes the underlying writer to http.ResponseController, e.g. to flush or to set deadlines
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTrackingWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerTrackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverMiddleware is a middleware that recovers from panics in the handlers
+// The panic is logged and a 500 Internal Server Error response is returned, unless the handler already wrote
+// the header of its response, which is then left as is
+func recoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-			return
-		}
-		next.ServeHTTP(w, r)
+		tracking := &headerTrackingWriter{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("api : panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				if !tracking.wroteHeader {
+					httpError(w, "internal server error", http.StatusInternalServerError)
+				}
+			}
+		}()
+		next.ServeHTTP(tracking, r)
 	})
 }
 
+// bodyLimitMiddleware returns a middleware that limits the size of request bodies
+// Reading beyond the limit fails with an *http.MaxBytesError
+func bodyLimitMiddleware(maxBodyBytes int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitMiddleware returns a middleware that limits the number of requests per second
+// If the limit is exceeded, a 429 Too Many Requests response is returned
+// asking the client to retry after one second
+func limitMiddleware(limiter *rate.Limiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.Allow() {
+				w.Header().Set("Retry-After", "1")
+				httpError(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // NewAPI returns a new API router
 // The router is configured with the API controller
 // and the rate limiting middleware
-func NewAPI(apiController Controller) http.Handler {
+// By default 10 requests per second with a burst of 100 are allowed
+// and request bodies are limited to 1 MiB
+func NewAPI(apiController Controller, opts ...Option) http.Handler {
+	o := options{
+		requestsPerSecond: defaultRequestsPerSecond,
+		burst:             defaultBurst,
+		maxBodyBytes:      defaultMaxBodyBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	router := mux.NewRouter()
+	router.Use(recoverMiddleware, bodyLimitMiddleware(o.maxBodyBytes), auditMiddleware(o.adminToken))
+
+	// Health endpoints are probed by the orchestrator and are not rate limited
+	// neither is the OpenAPI document
+	router.HandleFunc(healthz, Healthz).Methods(http.MethodGet)
+	router.HandleFunc(readyz, Readyz(o.checks)).Methods(http.MethodGet)
+	router.HandleFunc(openAPI, OpenAPI).Methods(http.MethodGet)
+
+	// Admin endpoints are authenticated with the admin token and are not rate limited
+	admin := router.PathPrefix(adminPrefix).Subrouter()
+	admin.Use(adminMiddleware(o.adminToken))
+	admin.HandleFunc(adminExport, Export(o.exporter)).Methods(http.MethodGet)
+	admin.HandleFunc(adminAudit, Audit(o.auditLog)).Methods(http.MethodGet)
+	admin.HandleFunc(adminCache, CacheStats(o.cacheStats)).Methods(http.MethodGet)
 
-	// Add rate limiting middleware to all endpoints
-	router.Use(limitMiddleware)
+	// Add rate limiting middleware to all other endpoints
+	limited := router.PathPrefix("/").Subrouter()
+	limited.Use(limitMiddleware(rate.NewLimiter(rate.Limit(o.requestsPerSecond), o.burst)))
 
-	router.HandleFunc(addTransaction, apiController.AddTransaction).Methods(http.MethodPost)
-	router.HandleFunc(getUserBalance, apiController.GetUserBalance).Methods(http.MethodGet)
-	router.HandleFunc(userHistory, apiController.GetUserTransactionHistory).Methods(http.MethodGet)
+	limited.HandleFunc(addTransaction, apiController.AddTransaction).Methods(http.MethodPost)
+	limited.HandleFunc(getUserBalance, apiController.GetUserBalance).Methods(http.MethodGet)
+	limited.HandleFunc(userHistory, apiController.GetUserTransactionHistory).Methods(http.MethodGet)
+	limited.HandleFunc(batch, apiController.AddTransactions).Methods(http.MethodPost)
+	limited.HandleFunc(userIntegrity, apiController.GetUserIntegrity).Methods(http.MethodGet)
+	limited.HandleFunc(transaction, apiController.GetTransaction).Methods(http.MethodGet)
+	limited.HandleFunc(userTransactions, apiController.GetUserTransactions).Methods(http.MethodGet)
+	limited.HandleFunc(posting, apiController.GetPosting).Methods(http.MethodGet)
+	limited.HandleFunc(userStatement, apiController.GetUserStatement).Methods(http.MethodGet)
+	limited.HandleFunc(userSchedules, apiController.CreateSchedule).Methods(http.MethodPost)
+	limited.HandleFunc(userSchedules, apiController.ListUserSchedules).Methods(http.MethodGet)
+	limited.HandleFunc(pauseSchedule, apiController.PauseSchedule).Methods(http.MethodPost)
+	limited.HandleFunc(resumeSchedule, apiController.ResumeSchedule).Methods(http.MethodPost)
+	limited.HandleFunc(scheduleRuns, apiController.ListScheduleRuns).Methods(http.MethodGet)
 
 	return router
 }
@@ -0,0 +1,708 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+// stubTransactionManager is a TransactionManager returning canned results
+type stubTransactionManager struct {
+	transactions []transactionmanager.Transaction
+	postings     []transactionmanager.Posting
+	balance      decimal.Decimal
+	err          error
+	batchErrs    []error
+	chainReport  transactionmanager.ChainReport
+	metadata     audit.Metadata
+	consistency  string
+	asOf         time.Time
+	statement    transactionmanager.Statement
+	schedules    []transactionmanager.Schedule
+	runs         []transactionmanager.ScheduleRun
+	runsLimit    int
+	fee          *transactionmanager.Fee
+	panicMessage string
+}
+
+func (s *stubTransactionManager) AddTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Transaction, error) {
+	if s.panicMessage != "" {
+		panic(s.panicMessage)
+	}
+	s.metadata = audit.FromContext(ctx)
+	if s.err != nil {
+		return transactionmanager.Transaction{}, s.err
+	}
+	s.transactions = append(s.transactions, transaction)
+	transaction.Fee = s.fee
+	return transaction, nil
+}
+
+func (s *stubTransactionManager) AddTransactions(ctx context.Context, transactions []transactionmanager.Transaction, mode transactionmanager.BatchMode) ([]transactionmanager.BatchResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	results := make([]transactionmanager.BatchResult, len(transactions))
+	for i, transaction := range transactions {
+		results[i].Transaction = transaction
+		if i < len(s.batchErrs) {
+			results[i].Err = s.batchErrs[i]
+		}
+	}
+	s.transactions = append(s.transactions, transactions...)
+	return results, nil
+}
+
+func (s *stubTransactionManager) VerifyUserChain(ctx context.Context, userID uuid.UUID) (transactionmanager.ChainReport, error) {
+	return s.chainReport, s.err
+}
+
+func (s *stubTransactionManager) GetTransaction(ctx context.Context, transactionID uuid.UUID, owner uuid.UUID) (transactionmanager.Transaction, error) {
+	if s.err != nil {
+		return transactionmanager.Transaction{}, s.err
+	}
+	if len(s.transactions) == 0 {
+		return transactionmanager.Transaction{}, transactionmanager.ErrTransactionNotFound
+	}
+	return s.transactions[0], nil
+}
+
+func (s *stubTransactionManager) GetUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]transactionmanager.Transaction, error) {
+	return s.transactions, s.err
+}
+
+func (s *stubTransactionManager) EnqueueTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Posting, error) {
+	if s.err != nil {
+		return transactionmanager.Posting{}, s.err
+	}
+	posting := transactionmanager.Posting{ID: uuid.New(), UserID: transaction.UserID, Status: transactionmanager.PostingPending}
+	s.postings = append(s.postings, posting)
+	return posting, nil
+}
+
+func (s *stubTransactionManager) GetPosting(ctx context.Context, postingID uuid.UUID, owner uuid.UUID) (transactionmanager.Posting, error) {
+	if s.err != nil {
+		return transactionmanager.Posting{}, s.err
+	}
+	if len(s.postings) == 0 {
+		return transactionmanager.Posting{}, transactionmanager.ErrPostingNotFound
+	}
+	return s.postings[0], nil
+}
+
+func (s *stubTransactionManager) GetUserBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	s.consistency = storage.ConsistencyFromContext(ctx)
+	return s.balance, s.err
+}
+
+func (s *stubTransactionManager) GetUserBalanceAsOf(ctx context.Context, userID uuid.UUID, asOf time.Time) (decimal.Decimal, error) {
+	s.asOf = asOf
+	return s.balance, s.err
+}
+
+func (s *stubTransactionManager) GetUserStatement(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) (transactionmanager.Statement, error) {
+	if s.err != nil {
+		return transactionmanager.Statement{}, s.err
+	}
+	statement := s.statement
+	statement.UserID, statement.From, statement.To = userID, from, to
+	return statement, nil
+}
+
+func (s *stubTransactionManager) CreateSchedule(ctx context.Context, schedule transactionmanager.Schedule) (transactionmanager.Schedule, error) {
+	if s.err != nil {
+		return transactionmanager.Schedule{}, s.err
+	}
+	schedule.Status = transactionmanager.ScheduleActive
+	s.schedules = append(s.schedules, schedule)
+	return schedule, nil
+}
+
+func (s *stubTransactionManager) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]transactionmanager.Schedule, error) {
+	return s.schedules, s.err
+}
+
+func (s *stubTransactionManager) PauseSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	if s.err != nil {
+		return transactionmanager.Schedule{}, s.err
+	}
+	return transactionmanager.Schedule{ID: scheduleID, UserID: userID, Status: transactionmanager.SchedulePaused}, nil
+}
+
+func (s *stubTransactionManager) ResumeSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	if s.err != nil {
+		return transactionmanager.Schedule{}, s.err
+	}
+	return transactionmanager.Schedule{ID: scheduleID, UserID: userID, Status: transactionmanager.ScheduleActive}, nil
+}
+
+func (s *stubTransactionManager) ListScheduleRuns(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID, limit int) ([]transactionmanager.ScheduleRun, error) {
+	s.runsLimit = limit
+	return s.runs, s.err
+}
+
+func (s *stubTransactionManager) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter transactionmanager.HistoryFilter) ([]transactionmanager.Transaction, error) {
+	return s.transactions, s.err
+}
+
+func TestAddTransaction_RequestValidation(t *testing.T) {
+	idempotencyKey := uuid.New().String()
+	testCases := []struct {
+		name               string
+		contentType        string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "Valid request",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Content type with charset",
+			contentType:        "application/json; charset=utf-8",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Missing content type",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"}`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "Wrong content type",
+			contentType:        "text/plain",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"}`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "Unknown field",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `", "user_id":"x"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Trailing data",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"} {}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Missing idempotency key",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Zero idempotency key",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100, "idempotency_key":"` + uuid.Nil.String() + `"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Body too large",
+			contentType:        "application/json",
+			requestBody:        `{"amount":100, "idempotency_key":"` + idempotencyKey + `"` + strings.Repeat(" ", 2048) + `}`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			controller := api.NewController(&stubTransactionManager{})
+			newAPI := api.NewAPI(controller, api.WithMaxBodyBytes(1024))
+
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, uuid.New().String()), bytes.NewBufferString(tc.requestBody))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+		})
+	}
+}
+
+func TestAddTransactions_Batch(t *testing.T) {
+	userID := uuid.New().String()
+	posting := func(amount string) string {
+		return `{"user_id":"` + userID + `","amount":` + amount + `,"idempotency_key":"` + uuid.New().String() + `"}`
+	}
+
+	testCases := []struct {
+		name               string
+		manager            *stubTransactionManager
+		requestBody        string
+		expectedStatusCode int
+		expectedStatuses   []string
+	}{
+		{
+			name:               "Every posting applied",
+			manager:            &stubTransactionManager{},
+			requestBody:        `{"postings":[` + posting("1") + `,` + posting("2") + `]}`,
+			expectedStatusCode: http.StatusCreated,
+			expectedStatuses:   []string{"applied", "applied"},
+		},
+		{
+			name:               "Some postings failed",
+			manager:            &stubTransactionManager{batchErrs: []error{transactionmanager.ErrTransactionAlreadyExist}},
+			requestBody:        `{"mode":"best_effort","postings":[` + posting("1") + `,` + posting("2") + `]}`,
+			expectedStatusCode: http.StatusMultiStatus,
+			expectedStatuses:   []string{"failed", "applied"},
+		},
+		{
+			name:               "Batch aborted",
+			manager:            &stubTransactionManager{batchErrs: []error{transactionmanager.ErrUserNotFound, transactionmanager.ErrBatchAborted}},
+			requestBody:        `{"postings":[` + posting("1") + `,` + posting("2") + `]}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedStatuses:   []string{"failed", "aborted"},
+		},
+		{
+			name:               "Empty batch",
+			manager:            &stubTransactionManager{},
+			requestBody:        `{"postings":[]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Too many postings",
+			manager:            &stubTransactionManager{},
+			requestBody:        `{"postings":[` + posting("1") + `,` + posting("2") + `,` + posting("3") + `]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Missing idempotency key",
+			manager:            &stubTransactionManager{},
+			requestBody:        `{"postings":[{"user_id":"` + userID + `","amount":1}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Invalid mode",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrInvalidBatchMode},
+			requestBody:        `{"mode":"sometimes","postings":[` + posting("1") + `]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			controller := api.NewController(tc.manager, api.WithMaxBatchSize(2))
+			newAPI := api.NewAPI(controller)
+
+			req, _ := http.NewRequest(http.MethodPost, AddTransactionsPath, bytes.NewBufferString(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code, rr.Body.String())
+			if tc.expectedStatuses == nil {
+				return
+			}
+
+			var response api.AddTransactionsResponse
+			err := json.Unmarshal(rr.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			statuses := []string{}
+			for i, result := range response.Results {
+				assert.Equal(t, i, result.Index)
+				statuses = append(statuses, result.Status)
+			}
+			assert.Equal(t, tc.expectedStatuses, statuses)
+		})
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	// Assign
+	controller := api.NewController(&stubTransactionManager{panicMessage: "boom"})
+	newAPI := api.NewAPI(controller)
+
+	requestBody := `{"amount":100, "idempotency_key":"` + uuid.New().String() + `"}`
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(AddTransactionTemplate, uuid.New().String()), bytes.NewBufferString(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	// Act
+	newAPI.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), response.Error)
+	assert.NotContains(t, response.Message, "boom")
+}
+
+func TestGetUserIntegrity(t *testing.T) {
+	userID := uuid.New()
+	transactionID := uuid.New()
+
+	testCases := []struct {
+		name               string
+		manager            *stubTransactionManager
+		userID             string
+		expectedStatusCode int
+		expectedIntact     bool
+	}{
+		{
+			name:               "Intact chain",
+			manager:            &stubTransactionManager{chainReport: transactionmanager.ChainReport{UserID: userID, Intact: true, Transactions: 2}},
+			userID:             userID.String(),
+			expectedStatusCode: http.StatusOK,
+			expectedIntact:     true,
+		},
+		{
+			name: "Broken chain",
+			manager: &stubTransactionManager{chainReport: transactionmanager.ChainReport{
+				UserID:       userID,
+				Transactions: 2,
+				Break:        &transactionmanager.ChainBreak{Seq: 2, TransactionID: &transactionID, Reason: "hash mismatch"},
+			}},
+			userID:             userID.String(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Unknown user",
+			manager:            &stubTransactionManager{err: transactionmanager.ErrUserNotFound},
+			userID:             userID.String(),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Invalid user ID",
+			manager:            &stubTransactionManager{},
+			userID:             "invalid-user-id",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			newAPI := api.NewAPI(api.NewController(tc.manager))
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserIntegrityTemplate, tc.userID), nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+			var report transactionmanager.ChainReport
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+			assert.Equal(t, tc.expectedIntact, report.Intact)
+			assert.Equal(t, tc.manager.chainReport, report)
+		})
+	}
+}
+
+func TestGetUserBalance_Consistency(t *testing.T) {
+	testCases := []struct {
+		name                string
+		query               string
+		expectedStatusCode  int
+		expectedConsistency string
+	}{
+		{
+			name:                "Default",
+			expectedStatusCode:  http.StatusOK,
+			expectedConsistency: storage.ConsistencyStrong,
+		},
+		{
+			name:                "Strong",
+			query:               "?consistency=strong",
+			expectedStatusCode:  http.StatusOK,
+			expectedConsistency: storage.ConsistencyStrong,
+		},
+		{
+			name:                "Eventual",
+			query:               "?consistency=eventual",
+			expectedStatusCode:  http.StatusOK,
+			expectedConsistency: storage.ConsistencyEventual,
+		},
+		{
+			name:               "Invalid consistency",
+			query:              "?consistency=stale",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			manager := &stubTransactionManager{balance: decimal.NewFromInt(10)}
+			newAPI := api.NewAPI(api.NewController(manager))
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserBalanceTemplate, uuid.New())+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			assert.Equal(t, tc.expectedConsistency, manager.consistency)
+		})
+	}
+}
+
+func TestGetUserBalance_AsOf(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedAsOf       time.Time
+	}{
+		{
+			name:               "As of",
+			query:              "?as_of=2020-01-02T03:04:05Z",
+			expectedStatusCode: http.StatusOK,
+			expectedAsOf:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name:               "Invalid as of",
+			query:              "?as_of=yesterday",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			manager := &stubTransactionManager{balance: decimal.NewFromInt(10)}
+			newAPI := api.NewAPI(api.NewController(manager))
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserBalanceTemplate, uuid.New())+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			assert.True(t, tc.expectedAsOf.Equal(manager.asOf), "expected as of %s, got %s", tc.expectedAsOf, manager.asOf)
+		})
+	}
+}
+
+func TestGetUserStatement(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		err                error
+		expectedStatusCode int
+	}{
+		{
+			name:               "Statement",
+			query:              "?from=2020-01-01T00:00:00Z&to=2020-02-01T00:00:00Z",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "Missing to",
+			query:              "?from=2020-01-01T00:00:00Z",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Invalid from",
+			query:              "?from=2020-01-01&to=2020-02-01T00:00:00Z",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Invalid period",
+			query:              "?from=2020-02-01T00:00:00Z&to=2020-01-01T00:00:00Z",
+			err:                transactionmanager.ErrInvalidPeriod,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Unknown user",
+			query:              "?from=2020-01-01T00:00:00Z&to=2020-02-01T00:00:00Z",
+			err:                transactionmanager.ErrUserNotFound,
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			userID := uuid.New()
+			manager := &stubTransactionManager{
+				err: tc.err,
+				statement: transactionmanager.Statement{
+					OpeningBalance: decimal.NewFromInt(10),
+					ClosingBalance: decimal.NewFromInt(15),
+					Lines: []transactionmanager.StatementLine{
+						{Transaction: transactionmanager.Transaction{UserID: userID, Amount: decimal.NewFromInt(5)}, Balance: decimal.NewFromInt(15)},
+					},
+				},
+			}
+			newAPI := api.NewAPI(api.NewController(manager))
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(GetUserStatementTemplate, userID, tc.query), nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode == http.StatusOK {
+				var statement transactionmanager.Statement
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&statement))
+				assert.Equal(t, userID, statement.UserID)
+				assert.True(t, statement.OpeningBalance.Equal(decimal.NewFromInt(10)))
+				assert.True(t, statement.ClosingBalance.Equal(decimal.NewFromInt(15)))
+				assert.Len(t, statement.Lines, 1)
+			}
+		})
+	}
+}
+
+func TestCreateSchedule(t *testing.T) {
+	testCases := []struct {
+		name               string
+		requestBody        string
+		err                error
+		expectedStatusCode int
+	}{
+		{
+			name:               "Cron",
+			requestBody:        `{"amount":10, "cron":"0 9 1 * *", "end_at":"2021-01-01T00:00:00Z", "description":"subscription"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Interval",
+			requestBody:        `{"amount":10, "interval":"24h"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "Invalid interval",
+			requestBody:        `{"amount":10, "interval":"daily"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Invalid schedule",
+			requestBody:        `{"amount":10}`,
+			err:                transactionmanager.ErrInvalidSchedule,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "Unknown user",
+			requestBody:        `{"amount":10, "interval":"24h"}`,
+			err:                transactionmanager.ErrUserNotFound,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "Unavailable",
+			requestBody:        `{"amount":10, "interval":"24h"}`,
+			err:                transactionmanager.ErrSchedulesUnavailable,
+			expectedStatusCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			userID := uuid.New()
+			manager := &stubTransactionManager{err: tc.err}
+			newAPI := api.NewAPI(api.NewController(manager))
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf(UserSchedulesTemplate, userID), bytes.NewBufferString(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			// Act
+			newAPI.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode == http.StatusCreated {
+				var response api.CreateScheduleResponse
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+				assert.Equal(t, userID, response.Schedule.UserID)
+				assert.True(t, response.Schedule.Amount.Equal(decimal.NewFromInt(10)))
+				assert.Equal(t, manager.schedules[0].Cron, response.Schedule.Cron)
+				assert.Equal(t, manager.schedules[0].Interval, response.Schedule.Interval)
+			}
+		})
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	// Assign
+	userID, scheduleID := uuid.New(), uuid.New()
+	transactionID := uuid.New()
+	manager := &stubTransactionManager{
+		schedules: []transactionmanager.Schedule{{ID: scheduleID, UserID: userID, Interval: transactionmanager.Duration(time.Hour)}},
+		runs:      []transactionmanager.ScheduleRun{{Status: transactionmanager.RunPosted, TransactionID: &transactionID}},
+	}
+	newAPI := api.NewAPI(api.NewController(manager))
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, http.NoBody)
+		rr := httptest.NewRecorder()
+		newAPI.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Act
+	list := serve(http.MethodGet, fmt.Sprintf(UserSchedulesTemplate, userID))
+	pause := serve(http.MethodPost, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "pause"))
+	resume := serve(http.MethodPost, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "resume"))
+	runs := serve(http.MethodGet, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "runs?limit=5"))
+	runsLimit := manager.runsLimit
+	invalidLimit := serve(http.MethodGet, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "runs?limit=0"))
+	invalidID := serve(http.MethodPost, fmt.Sprintf(ScheduleTemplate, userID, "x", "pause"))
+	manager.err = transactionmanager.ErrScheduleEnded
+	ended := serve(http.MethodPost, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "resume"))
+	manager.err = transactionmanager.ErrScheduleNotFound
+	notFound := serve(http.MethodGet, fmt.Sprintf(ScheduleTemplate, userID, scheduleID, "runs"))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, list.Code)
+	var schedules []transactionmanager.Schedule
+	assert.NoError(t, json.NewDecoder(list.Body).Decode(&schedules))
+	if assert.Len(t, schedules, 1) {
+		assert.Equal(t, transactionmanager.Duration(time.Hour), schedules[0].Interval)
+	}
+	assert.Equal(t, http.StatusOK, pause.Code)
+	assert.Contains(t, pause.Body.String(), `"status":"paused"`)
+	assert.Equal(t, http.StatusOK, resume.Code)
+	assert.Contains(t, resume.Body.String(), `"status":"active"`)
+	assert.Equal(t, http.StatusOK, runs.Code)
+	assert.Contains(t, runs.Body.String(), transactionID.String())
+	assert.Equal(t, 5, runsLimit)
+	assert.Equal(t, http.StatusBadRequest, invalidLimit.Code)
+	assert.Equal(t, http.StatusBadRequest, invalidID.Code)
+	assert.Equal(t, http.StatusConflict, ended.Code)
+	assert.Equal(t, http.StatusNotFound, notFound.Code)
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionAddTransaction    = "transaction.add"
+	ActionImportTransaction = "transaction.import"
+	ActionCreateSchedule    = "schedule.create"
+	ActionPauseSchedule     = "schedule.pause"
+	ActionResumeSchedule    = "schedule.resume"
+)
+
+// Actors of mutations that were not made by an authenticated caller
+const (
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+	ActorSystem    = "system"
+)
+
+// Metadata describes the origin of a mutation
+// It is carried by the context down to the storage, which writes it with the mutation
+type Metadata struct {
+	Actor       string
+	RequestID   string
+	ClientIP    string
+	PayloadHash []byte
+}
+
+// Entry is a row of the audit log
+// TransactionID is nil for mutations not bound to a transaction, ScheduleID for mutations not bound to a schedule
+type Entry struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Action        string          `json:"action"`
+	Actor         string          `json:"actor"`
+	RequestID     string          `json:"request_id,omitempty"`
+	ClientIP      string          `json:"client_ip,omitempty"`
+	UserID        uuid.UUID       `json:"user_id"`
+	TransactionID *uuid.UUID      `json:"transaction_id,omitempty"`
+	ScheduleID    *uuid.UUID      `json:"schedule_id,omitempty"`
+	BalanceBefore decimal.Decimal `json:"balance_before"`
+	BalanceAfter  decimal.Decimal `json:"balance_after"`
+	PayloadHash   string          `json:"payload_hash,omitempty"`
+}
+
+// Filter selects entries of the audit log, zero fields do not filter
+// Entries are returned in ascending ID order starting after AfterID
+type Filter struct {
+	Actor   string
+	UserID  uuid.UUID
+	Since   time.Time
+	Until   time.Time
+	AfterID int64
+	Limit   int
+}
+
+type contextKey struct{}
+
+type batchContextKey struct{}
+
+// NewContext returns a context carrying the metadata of a mutation
+func NewContext(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, metadata)
+}
+
+// FromContext returns the metadata carried by the context
+// Mutations without metadata are attributed to ActorSystem
+func FromContext(ctx context.Context) Metadata {
+	metadata, ok := ctx.Value(contextKey{}).(Metadata)
+	if !ok || metadata.Actor == "" {
+		metadata.Actor = ActorSystem
+	}
+	return metadata
+}
+
+// NewBatchContext returns a context carrying the metadata of the transactions of a batch by transaction ID
+// It is used when the requests of several callers are added in a single database transaction
+func NewBatchContext(ctx context.Context, metadata map[uuid.UUID]Metadata) context.Context {
+	return context.WithValue(ctx, batchContextKey{}, metadata)
+}
+
+// TransactionFromContext returns the metadata of a transaction
+// The metadata of the batch carried by the context wins over the metadata of the context
+func TransactionFromContext(ctx context.Context, transactionID uuid.UUID) Metadata {
+	batch, _ := ctx.Value(batchContextKey{}).(map[uuid.UUID]Metadata)
+	metadata, ok := batch[transactionID]
+	if !ok {
+		return FromContext(ctx)
+	}
+	if metadata.Actor == "" {
+		metadata.Actor = ActorSystem
+	}
+	return metadata
+}
@@ -0,0 +1,572 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// redacted replaces the value of secret settings when the config is printed
+const redacted = "******"
+
+// Config is the effective configuration of the service
+// Values are resolved in the following order, the first one wins:
+// flags, environment variables, config file, defaults
+type Config struct {
+	HTTP        HTTPConfig        `mapstructure:"http"`
+	GRPC        GRPCConfig        `mapstructure:"grpc"`
+	DB          DBConfig          `mapstructure:"db"`
+	RateLimit   RateLimitConfig   `mapstructure:"rate_limit"`
+	Health      HealthConfig      `mapstructure:"health"`
+	Batch       BatchConfig       `mapstructure:"batch"`
+	Admin       AdminConfig       `mapstructure:"admin"`
+	Queue       QueueConfig       `mapstructure:"queue"`
+	GroupCommit GroupCommitConfig `mapstructure:"group_commit"`
+	Cache       CacheConfig       `mapstructure:"cache"`
+	Replica     ReplicaConfig     `mapstructure:"replica"`
+	Partitions  PartitionsConfig  `mapstructure:"partitions"`
+	Snapshots   SnapshotsConfig   `mapstructure:"snapshots"`
+	Scheduler   SchedulerConfig   `mapstructure:"scheduler"`
+	Fees        FeesConfig        `mapstructure:"fees"`
+}
+
+// HTTPConfig configures the HTTP server
+type HTTPConfig struct {
+	Port              string        `mapstructure:"port"`
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout"`
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"`
+	ShutdownTimeout   time.Duration `mapstructure:"shutdown_timeout"`
+	MaxHeaderBytes    int           `mapstructure:"max_header_bytes"`
+	MaxBodyBytes      int64         `mapstructure:"max_body_bytes"`
+}
+
+// GRPCConfig configures the gRPC server
+type GRPCConfig struct {
+	Port string `mapstructure:"port"`
+}
+
+// DBConfig configures the database connection and its pool
+// Driver selects the backend, Postgres with the connection settings or SQLite with the file at Path
+type DBConfig struct {
+	Driver          string        `mapstructure:"driver"`
+	Path            string        `mapstructure:"path"`
+	Host            string        `mapstructure:"host"`
+	Port            int           `mapstructure:"port"`
+	User            string        `mapstructure:"user"`
+	Password        string        `mapstructure:"password" secret:"true"`
+	DBName          string        `mapstructure:"name"`
+	SSLMode         string        `mapstructure:"sslmode"`
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
+}
+
+// RateLimitConfig configures the request rate limiter of the API
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+// HealthConfig configures the readiness checks
+type HealthConfig struct {
+	CheckTimeout time.Duration `mapstructure:"check_timeout"`
+}
+
+// BatchConfig configures the batch posting endpoint
+type BatchConfig struct {
+	MaxSize int `mapstructure:"max_size"`
+}
+
+// AdminConfig configures the admin endpoints
+// The admin endpoints are disabled when no token is set
+type AdminConfig struct {
+	Token string `mapstructure:"token" secret:"true"`
+}
+
+// QueueConfig configures the workers applying the transactions posted asynchronously
+// The queue needs Postgres, no worker is started with SQLite
+type QueueConfig struct {
+	Workers      int           `mapstructure:"workers"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// GroupCommitConfig configures the coalescing of concurrent transactions into batches
+// A batch is added once it holds MaxBatch transactions or MaxLatency passed since its first transaction
+type GroupCommitConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	MaxBatch   int           `mapstructure:"max_batch"`
+	MaxLatency time.Duration `mapstructure:"max_latency"`
+}
+
+// CacheConfig configures the in-process cache of the balances
+// Size is the number of balances kept, each is kept for TTL at most
+type CacheConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Size    int           `mapstructure:"size"`
+	TTL     time.Duration `mapstructure:"ttl"`
+}
+
+// ReplicaConfig configures the read-only replica serving the eventually consistent reads
+// The replica is disabled when no host is set, its other connection settings are the ones of db.
+// Reads fall back to the primary while the replica lags more than MaxLag, checked every LagCheckInterval
+type ReplicaConfig struct {
+	Host             string        `mapstructure:"host"`
+	Port             int           `mapstructure:"port"`
+	MaxLag           time.Duration `mapstructure:"max_lag"`
+	LagCheckInterval time.Duration `mapstructure:"lag_check_interval"`
+}
+
+// PartitionsConfig configures the maintenance of the monthly partitions of the transactions
+// The partitions of the next MonthsAhead months are created ahead of time, the partitions older than
+// RetentionMonths months are moved to the archive schema, 0 keeps them. The maintenance needs Postgres
+type PartitionsConfig struct {
+	MonthsAhead         int           `mapstructure:"months_ahead"`
+	RetentionMonths     int           `mapstructure:"retention_months"`
+	MaintenanceInterval time.Duration `mapstructure:"maintenance_interval"`
+}
+
+// SnapshotsConfig configures the daily balance snapshots of the users
+// The snapshots are written every Interval, a day is snapshotted once it ended SettleTime ago. The snapshots need Postgres
+type SnapshotsConfig struct {
+	Interval   time.Duration `mapstructure:"interval"`
+	SettleTime time.Duration `mapstructure:"settle_time"`
+}
+
+// SchedulerConfig configures the runner posting the scheduled transactions
+// The due occurrences are posted every PollInterval by the instance leading the runners. The schedules need Postgres
+type SchedulerConfig struct {
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// FeesConfig configures the fees charged on the added transactions, in batches and asynchronous postings as well,
+// imports are not charged
+// The fee of the first rule matching a transaction is credited to RevenueAccount, the ID of a user of the ledger.
+// The rules can only be set in the config file
+type FeesConfig struct {
+	RevenueAccount string          `mapstructure:"revenue_account"`
+	Rules          []FeeRuleConfig `mapstructure:"rules"`
+}
+
+// FeeRuleConfig is a fee rule, an empty Category or Currency matches every transaction
+// The fee is Flat plus Percentage percent of the amount, at least Min and at most Max unless Max is zero
+type FeeRuleConfig struct {
+	Name       string  `mapstructure:"name"`
+	Category   string  `mapstructure:"category"`
+	Currency   string  `mapstructure:"currency"`
+	Flat       float64 `mapstructure:"flat"`
+	Percentage float64 `mapstructure:"percentage"`
+	Min        float64 `mapstructure:"min"`
+	Max        float64 `mapstructure:"max"`
+}
+
+// defaults lists every setting with its default value
+// The type of the default value is the type of the flag registered for the setting
+var defaults = map[string]interface{}{
+	"http.port":                       "8080",
+	"http.read_timeout":               5 * time.Second,
+	"http.read_header_timeout":        2 * time.Second,
+	"http.write_timeout":              10 * time.Second,
+	"http.idle_timeout":               60 * time.Second,
+	"http.shutdown_timeout":           15 * time.Second,
+	"http.max_header_bytes":           1 << 20,
+	"http.max_body_bytes":             1 << 20,
+	"grpc.port":                       "9090",
+	"db.driver":                       DriverPostgres,
+	"db.path":                         "ledger.db",
+	"db.host":                         "localhost",
+	"db.port":                         5432,
+	"db.user":                         "postgres",
+	"db.password":                     "",
+	"db.name":                         "ledger",
+	"db.sslmode":                      "disable",
+	"db.max_open_conns":               25,
+	"db.max_idle_conns":               10,
+	"db.conn_max_lifetime":            30 * time.Minute,
+	"db.conn_max_idle_time":           5 * time.Minute,
+	"rate_limit.requests_per_second":  10.0,
+	"rate_limit.burst":                100,
+	"health.check_timeout":            2 * time.Second,
+	"batch.max_size":                  1000,
+	"admin.token":                     "",
+	"queue.workers":                   4,
+	"queue.poll_interval":             100 * time.Millisecond,
+	"group_commit.enabled":            false,
+	"group_commit.max_batch":          100,
+	"group_commit.max_latency":        2 * time.Millisecond,
+	"cache.enabled":                   false,
+	"cache.size":                      10000,
+	"cache.ttl":                       5 * time.Second,
+	"replica.host":                    "",
+	"replica.port":                    5432,
+	"replica.max_lag":                 5 * time.Second,
+	"replica.lag_check_interval":      time.Second,
+	"partitions.months_ahead":         3,
+	"partitions.retention_months":     0,
+	"partitions.maintenance_interval": time.Hour,
+	"snapshots.interval":              time.Hour,
+	"snapshots.settle_time":           time.Hour,
+	"scheduler.poll_interval":         10 * time.Second,
+	"fees.revenue_account":            "",
+}
+
+// envAliases maps settings to the environment variables used by the deployment
+// Every setting can also be set with LEDGER_ followed by the upper cased key,
+// e.g. LEDGER_DB_MAX_OPEN_CONNS
+var envAliases = map[string]string{
+	"http.port":   "PORT",
+	"grpc.port":   "GRPC_PORT",
+	"db.host":     "POSTGRES_HOST",
+	"db.port":     "POSTGRES_PORT",
+	"db.user":     "POSTGRES_USER",
+	"db.password": "POSTGRES_PASSWORD",
+	"db.name":     "POSTGRES_DB",
+	"db.sslmode":  "PGSSLMODE",
+}
+
+// Database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+var drivers = []string{DriverPostgres, DriverSQLite}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// currencyPattern is the pattern of the ISO 4217 currency codes
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// listIndex matches the index of an element of a list in the key of a setting
+var listIndex = regexp.MustCompile(`\[\d+\]`)
+
+// Load resolves the configuration from the command line arguments,
+// the environment and the config file given with --config or LEDGER_CONFIG
+// The flags of commandFlags are parsed from the same arguments, for the flags
+// that belong to a command rather than to the config
+// The returned config is validated
+func Load(name string, args []string, commandFlags ...*pflag.FlagSet) (Config, error) {
+	v := viper.New()
+
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML or TOML config file")
+	for _, key := range keys() {
+		registerFlag(flags, key, defaults[key])
+	}
+	for _, commandFlagSet := range commandFlags {
+		flags.AddFlagSet(commandFlagSet)
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	for _, key := range keys() {
+		v.SetDefault(key, defaults[key])
+		if err := v.BindPFlag(key, flags.Lookup(key)); err != nil {
+			return Config{}, err
+		}
+	}
+
+	v.SetEnvPrefix("LEDGER")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for key, env := range envAliases {
+		if err := v.BindEnv(key, env); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if *configFile == "" {
+		*configFile = v.GetString("config")
+	}
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("read config file: %w", err)
+		}
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return Config{}, fmt.Errorf("decode config: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate checks every setting and returns all problems found
+func (c Config) Validate() error {
+	var errs []error
+
+	port, err := strconv.Atoi(c.HTTP.Port)
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("http.port: must be a port number, got %q", c.HTTP.Port))
+	}
+	grpcPort, err := strconv.Atoi(c.GRPC.Port)
+	if err != nil || grpcPort < 1 || grpcPort > 65535 {
+		errs = append(errs, fmt.Errorf("grpc.port: must be a port number, got %q", c.GRPC.Port))
+	} else if c.GRPC.Port == c.HTTP.Port {
+		errs = append(errs, fmt.Errorf("grpc.port: must differ from http.port, got %q", c.GRPC.Port))
+	}
+	for key, timeout := range map[string]time.Duration{
+		"http.read_timeout":               c.HTTP.ReadTimeout,
+		"http.read_header_timeout":        c.HTTP.ReadHeaderTimeout,
+		"http.write_timeout":              c.HTTP.WriteTimeout,
+		"http.idle_timeout":               c.HTTP.IdleTimeout,
+		"http.shutdown_timeout":           c.HTTP.ShutdownTimeout,
+		"health.check_timeout":            c.Health.CheckTimeout,
+		"queue.poll_interval":             c.Queue.PollInterval,
+		"group_commit.max_latency":        c.GroupCommit.MaxLatency,
+		"cache.ttl":                       c.Cache.TTL,
+		"replica.max_lag":                 c.Replica.MaxLag,
+		"replica.lag_check_interval":      c.Replica.LagCheckInterval,
+		"partitions.maintenance_interval": c.Partitions.MaintenanceInterval,
+		"snapshots.interval":              c.Snapshots.Interval,
+		"scheduler.poll_interval":         c.Scheduler.PollInterval,
+	} {
+		if timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %s", key, timeout))
+		}
+	}
+	if c.HTTP.MaxHeaderBytes <= 0 {
+		errs = append(errs, fmt.Errorf("http.max_header_bytes: must be positive, got %d", c.HTTP.MaxHeaderBytes))
+	}
+	if c.HTTP.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("http.max_body_bytes: must be positive, got %d", c.HTTP.MaxBodyBytes))
+	}
+
+	switch c.DB.Driver {
+	case DriverPostgres:
+		if c.DB.Host == "" {
+			errs = append(errs, errors.New("db.host: must not be empty"))
+		}
+		if c.DB.Port < 1 || c.DB.Port > 65535 {
+			errs = append(errs, fmt.Errorf("db.port: must be a port number, got %d", c.DB.Port))
+		}
+		if c.DB.User == "" {
+			errs = append(errs, errors.New("db.user: must not be empty"))
+		}
+		if c.DB.DBName == "" {
+			errs = append(errs, errors.New("db.name: must not be empty"))
+		}
+		if !contains(sslModes, c.DB.SSLMode) {
+			errs = append(errs, fmt.Errorf("db.sslmode: must be one of %s, got %q", strings.Join(sslModes, ", "), c.DB.SSLMode))
+		}
+	case DriverSQLite:
+		if c.DB.Path == "" {
+			errs = append(errs, errors.New("db.path: must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("db.driver: must be one of %s, got %q", strings.Join(drivers, ", "), c.DB.Driver))
+	}
+	if c.DB.MaxOpenConns < 1 {
+		errs = append(errs, fmt.Errorf("db.max_open_conns: must be at least 1, got %d", c.DB.MaxOpenConns))
+	}
+	if c.DB.MaxIdleConns < 0 || c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("db.max_idle_conns: must be between 0 and db.max_open_conns, got %d", c.DB.MaxIdleConns))
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("db.conn_max_lifetime: must not be negative, got %s", c.DB.ConnMaxLifetime))
+	}
+	if c.DB.ConnMaxIdleTime < 0 {
+		errs = append(errs, fmt.Errorf("db.conn_max_idle_time: must not be negative, got %s", c.DB.ConnMaxIdleTime))
+	}
+
+	if c.RateLimit.RequestsPerSecond <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.requests_per_second: must be positive, got %v", c.RateLimit.RequestsPerSecond))
+	}
+	if c.RateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("rate_limit.burst: must be at least 1, got %d", c.RateLimit.Burst))
+	}
+
+	if c.Batch.MaxSize < 1 {
+		errs = append(errs, fmt.Errorf("batch.max_size: must be at least 1, got %d", c.Batch.MaxSize))
+	}
+
+	if c.Queue.Workers < 0 {
+		errs = append(errs, fmt.Errorf("queue.workers: must not be negative, got %d", c.Queue.Workers))
+	}
+
+	if c.GroupCommit.MaxBatch < 1 {
+		errs = append(errs, fmt.Errorf("group_commit.max_batch: must be at least 1, got %d", c.GroupCommit.MaxBatch))
+	}
+
+	if c.Cache.Size < 1 {
+		errs = append(errs, fmt.Errorf("cache.size: must be at least 1, got %d", c.Cache.Size))
+	}
+
+	if c.Replica.Host != "" {
+		if c.DB.Driver != DriverPostgres {
+			errs = append(errs, fmt.Errorf("replica.host: needs the %s driver, got %q", DriverPostgres, c.DB.Driver))
+		}
+		if c.Replica.Port < 1 || c.Replica.Port > 65535 {
+			errs = append(errs, fmt.Errorf("replica.port: must be a port number, got %d", c.Replica.Port))
+		}
+	}
+
+	if c.Partitions.MonthsAhead < 1 {
+		errs = append(errs, fmt.Errorf("partitions.months_ahead: must be at least 1, got %d", c.Partitions.MonthsAhead))
+	}
+	if c.Partitions.RetentionMonths < 0 {
+		errs = append(errs, fmt.Errorf("partitions.retention_months: must not be negative, got %d", c.Partitions.RetentionMonths))
+	}
+	if c.Snapshots.SettleTime <= 0 {
+		errs = append(errs, fmt.Errorf("snapshots.settle_time: must be positive, got %s", c.Snapshots.SettleTime))
+	}
+
+	errs = append(errs, c.Fees.validate()...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+}
+
+// validate checks the revenue account and the fee rules
+func (f FeesConfig) validate() []error {
+	var errs []error
+	if f.RevenueAccount != "" {
+		if _, err := uuid.Parse(f.RevenueAccount); err != nil {
+			errs = append(errs, fmt.Errorf("fees.revenue_account: must be a user ID, got %q", f.RevenueAccount))
+		}
+	} else if len(f.Rules) > 0 {
+		errs = append(errs, errors.New("fees.revenue_account: must be set with fees.rules"))
+	}
+
+	names := map[string]bool{}
+	for i, rule := range f.Rules {
+		key := fmt.Sprintf("fees.rules[%d]", i)
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", key))
+		} else if names[rule.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: must be unique, got %q", key, rule.Name))
+		}
+		names[rule.Name] = true
+		if rule.Currency != "" && !currencyPattern.MatchString(rule.Currency) {
+			errs = append(errs, fmt.Errorf("%s.currency: must be an ISO 4217 code of 3 upper case letters, got %q", key, rule.Currency))
+		}
+		if rule.Flat < 0 || rule.Percentage < 0 || rule.Min < 0 || rule.Max < 0 {
+			errs = append(errs, fmt.Errorf("%s: flat, percentage, min and max must not be negative", key))
+		}
+		if rule.Percentage > 100 {
+			errs = append(errs, fmt.Errorf("%s.percentage: must be at most 100, got %v", key, rule.Percentage))
+		}
+		if rule.Flat <= 0 && rule.Percentage <= 0 && rule.Min <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must charge a flat fee, a percentage or a minimum", key))
+		}
+		if rule.Max > 0 && rule.Max < rule.Min {
+			errs = append(errs, fmt.Errorf("%s.max: must be at least min, got %v", key, rule.Max))
+		}
+	}
+	return errs
+}
+
+// Print writes the effective config to w, one setting per line
+// Secret settings are redacted, the fields of the elements of a list are printed by their index, e.g. fees.rules[0].name
+func (c Config) Print(w io.Writer) error {
+	settings := map[string]string{}
+	flatten("", reflect.ValueOf(c), settings)
+
+	printed := make([]string, 0, len(settings))
+	for key := range settings {
+		printed = append(printed, key)
+	}
+	sort.Slice(printed, func(i, j int) bool {
+		return sortKey(printed[i]) < sortKey(printed[j])
+	})
+	for _, key := range printed {
+		if _, err := fmt.Fprintf(w, "%s = %s\n", key, settings[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatten collects the settings of a config struct by their dotted key
+func flatten(prefix string, value reflect.Value, settings map[string]string) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+
+		if field.Type.Kind() == reflect.Struct {
+			flatten(key+".", value.Field(i), settings)
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			if value.Field(i).Len() == 0 {
+				settings[key] = "[]"
+			}
+			for j := 0; j < value.Field(i).Len(); j++ {
+				flatten(fmt.Sprintf("%s[%d].", key, j), value.Field(i).Index(j), settings)
+			}
+			continue
+		}
+
+		setting := fmt.Sprint(value.Field(i).Interface())
+		if field.Tag.Get("secret") == "true" && setting != "" {
+			setting = redacted
+		}
+		settings[key] = setting
+	}
+}
+
+func registerFlag(flags *pflag.FlagSet, key string, value interface{}) {
+	usage := fmt.Sprintf("sets %s", key)
+	switch value := value.(type) {
+	case string:
+		flags.String(key, value, usage)
+	case int:
+		flags.Int(key, value, usage)
+	case float64:
+		flags.Float64(key, value, usage)
+	case bool:
+		flags.Bool(key, value, usage)
+	case time.Duration:
+		flags.Duration(key, value, usage)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for %s", value, key))
+	}
+}
+
+// sortKey orders the settings by key and the elements of a list by their index
+func sortKey(key string) string {
+	return listIndex.ReplaceAllStringFunc(key, func(index string) string {
+		n, _ := strconv.Atoi(index[1 : len(index)-1])
+		return fmt.Sprintf("[%09d]", n)
+	})
+}
+
+// keys returns the keys of every setting in a stable order
+func keys() []string {
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	// Act
+	config, err := Load("test", nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Assert
+	assert.Equal(t, "8080", config.HTTP.Port)
+	assert.Equal(t, 5432, config.DB.Port)
+	assert.Equal(t, 10*time.Second, config.HTTP.WriteTimeout)
+	assert.Equal(t, 25, config.DB.MaxOpenConns)
+	assert.Equal(t, 10.0, config.RateLimit.RequestsPerSecond)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	// Assign
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+http:
+  port: "9000"
+  write_timeout: 30s
+db:
+  host: file-host
+  max_open_conns: 50
+`), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("POSTGRES_HOST", "env-host")
+	t.Setenv("LEDGER_DB_MAX_IDLE_CONNS", "20")
+
+	// Act
+	config, err := Load("test", []string{"--config", file, "--http.port", "9100"})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Assert
+	assert.Equal(t, "9100", config.HTTP.Port, "flag should win over file")
+	assert.Equal(t, "env-host", config.DB.Host, "env should win over file")
+	assert.Equal(t, 30*time.Second, config.HTTP.WriteTimeout, "file should win over default")
+	assert.Equal(t, 50, config.DB.MaxOpenConns)
+	assert.Equal(t, 20, config.DB.MaxIdleConns)
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	// Assign
+	file := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(file, []byte("[db]\nname = \"toml-db\"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("LEDGER_CONFIG", file)
+
+	// Act
+	config, err := Load("test", nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Assert
+	assert.Equal(t, "toml-db", config.DB.DBName)
+}
+
+func TestLoad_ValidationErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		env           map[string]string
+		args          []string
+		expectedError string
+	}{
+		{
+			name:          "Empty port",
+			args:          []string{"--http.port", ""},
+			expectedError: "http.port",
+		},
+		{
+			name:          "Non numeric database port",
+			env:           map[string]string{"POSTGRES_PORT": "abc"},
+			expectedError: "decode config",
+		},
+		{
+			name:          "Unknown ssl mode",
+			env:           map[string]string{"PGSSLMODE": "sometimes"},
+			expectedError: "db.sslmode",
+		},
+		{
+			name:          "Idle connections above open connections",
+			args:          []string{"--db.max_open_conns", "5", "--db.max_idle_conns", "10"},
+			expectedError: "db.max_idle_conns",
+		},
+		{
+			name:          "Unknown driver",
+			args:          []string{"--db.driver", "mysql"},
+			expectedError: "db.driver",
+		},
+		{
+			name:          "SQLite without a path",
+			args:          []string{"--db.driver", "sqlite", "--db.path", ""},
+			expectedError: "db.path",
+		},
+		{
+			name:          "Zero write timeout",
+			args:          []string{"--http.write_timeout", "0s"},
+			expectedError: "http.write_timeout",
+		},
+		{
+			name:          "Replica with SQLite",
+			args:          []string{"--db.driver", "sqlite", "--replica.host", "replica"},
+			expectedError: "replica.host",
+		},
+		{
+			name:          "Negative snapshot settle time",
+			args:          []string{"--snapshots.settle_time", "-1h"},
+			expectedError: "snapshots.settle_time",
+		},
+		{
+			name:          "Zero snapshot settle time",
+			args:          []string{"--snapshots.settle_time", "0s"},
+			expectedError: "snapshots.settle_time",
+		},
+		{
+			name:          "Zero scheduler poll interval",
+			args:          []string{"--scheduler.poll_interval", "0s"},
+			expectedError: "scheduler.poll_interval",
+		},
+		{
+			name:          "Invalid fee revenue account",
+			args:          []string{"--fees.revenue_account", "fees"},
+			expectedError: "fees.revenue_account",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			// Act
+			_, err := Load("test", tc.args)
+
+			// Assert
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestLoad_FeeRules(t *testing.T) {
+	testCases := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name: "Valid rules",
+			config: `
+fees:
+  revenue_account: 123e4567-e89b-12d3-a456-426614174000
+  rules:
+    - name: card-eur
+      category: card
+      currency: EUR
+      flat: 0.25
+      percentage: 1.5
+      max: 10
+    - name: wire
+      category: wire
+      flat: 5
+`,
+		},
+		{
+			name: "Rules without revenue account",
+			config: `
+fees:
+  rules:
+    - name: wire
+      flat: 5
+`,
+			expectedError: "fees.revenue_account",
+		},
+		{
+			name: "Duplicate rule name",
+			config: `
+fees:
+  revenue_account: 123e4567-e89b-12d3-a456-426614174000
+  rules:
+    - name: wire
+      flat: 5
+    - name: wire
+      flat: 1
+`,
+			expectedError: "fees.rules[1].name",
+		},
+		{
+			name: "Maximum below minimum",
+			config: `
+fees:
+  revenue_account: 123e4567-e89b-12d3-a456-426614174000
+  rules:
+    - name: card
+      percentage: 2
+      min: 5
+      max: 1
+`,
+			expectedError: "fees.rules[0].max",
+		},
+		{
+			name: "No fee",
+			config: `
+fees:
+  revenue_account: 123e4567-e89b-12d3-a456-426614174000
+  rules:
+    - name: card
+      currency: eur
+`,
+			expectedError: "fees.rules[0]: must charge",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(file, []byte(tc.config), 0o600); err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+
+			// Act
+			config, err := Load("test", []string{"--config", file})
+
+			// Assert
+			if tc.expectedError != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedError)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, config.Fees.Rules, 2) {
+				assert.Equal(t, FeeRuleConfig{Name: "card-eur", Category: "card", Currency: "EUR", Flat: 0.25, Percentage: 1.5, Max: 10}, config.Fees.Rules[0])
+				assert.Equal(t, 5.0, config.Fees.Rules[1].Flat)
+			}
+		})
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	// Assign
+	t.Setenv("POSTGRES_PASSWORD", "super-secret")
+	t.Setenv("LEDGER_ADMIN_TOKEN", "admin-secret")
+	config, err := Load("test", nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Act
+	var out bytes.Buffer
+	err = config.Print(&out)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "super-secret")
+	assert.Contains(t, out.String(), "db.password = ******")
+	assert.NotContains(t, out.String(), "admin-secret")
+	assert.Contains(t, out.String(), "admin.token = ******")
+	assert.Contains(t, out.String(), "http.write_timeout = 10s")
+	assert.Contains(t, out.String(), "fees.rules = []")
+}
+
+func TestPrint_FeeRules(t *testing.T) {
+	// Assign
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+fees:
+  revenue_account: 123e4567-e89b-12d3-a456-426614174000
+  rules:
+    - name: card-eur
+      category: card
+      currency: EUR
+      percentage: 1.5
+    - name: wire
+      flat: 5
+`
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	loaded, err := Load("test", []string{"--config", file})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Act
+	var out bytes.Buffer
+	err = loaded.Print(&out)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "fees.revenue_account = 123e4567-e89b-12d3-a456-426614174000\n"+
+		"fees.rules[0].category = card\n"+
+		"fees.rules[0].currency = EUR\n"+
+		"fees.rules[0].flat = 0\n"+
+		"fees.rules[0].max = 0\n"+
+		"fees.rules[0].min = 0\n"+
+		"fees.rules[0].name = card-eur\n"+
+		"fees.rules[0].percentage = 1.5\n"+
+		"fees.rules[1].category = \n")
+	assert.Contains(t, out.String(), "fees.rules[1].name = wire\n")
+}
+
+func TestLoad_CommandFlags(t *testing.T) {
+	// Assign
+	commandFlags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	file := commandFlags.String("file", "", "file to import")
+
+	// Act
+	config, err := Load("import", []string{"--file", "ledger.csv", "--db.host", "db"}, commandFlags)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "ledger.csv", *file)
+	assert.Equal(t, "db", config.DB.Host)
+}
@@ -0,0 +1,274 @@
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Format is the format of an exported file
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// Table is an exported table
+type Table string
+
+const (
+	TableTransactions Table = "transactions"
+	TableUsers        Table = "users"
+)
+
+// Tables lists every exported table
+var Tables = []Table{TableTransactions, TableUsers}
+
+// defaultFetchSize is the number of rows fetched from the cursor at once
+const defaultFetchSize = 1000
+
+// ManifestFile is the name of the manifest written next to the exported files
+const ManifestFile = "manifest.json"
+
+var (
+	ErrInvalidFormat = errors.New("invalid format")
+	ErrInvalidTable  = errors.New("invalid table")
+)
+
+// Range selects the transactions created in [Since, Until)
+// A zero Since exports every transaction, a zero Until is replaced by the start of the export
+// Users are always exported in full since their balances change
+type Range struct {
+	Since time.Time
+	Until time.Time
+}
+
+// FileManifest describes an exported file
+type FileManifest struct {
+	Name   string `json:"name"`
+	Table  Table  `json:"table"`
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes an export
+// Until is the watermark to pass as the Since of the next incremental export
+type Manifest struct {
+	Format    Format         `json:"format"`
+	Since     *time.Time     `json:"since,omitempty"`
+	Until     time.Time      `json:"until"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []FileManifest `json:"files"`
+}
+
+// Exporter streams the ledger tables out of the database
+// The rows are read through a server side cursor, so memory stays constant
+// whatever the size of the tables
+type Exporter struct {
+	db        *sql.DB
+	fetchSize int
+}
+
+func New(db *sql.DB) *Exporter {
+	return &Exporter{
+		db:        db,
+		fetchSize: defaultFetchSize,
+	}
+}
+
+// ExportTable writes the rows of a table to w
+func (e *Exporter) ExportTable(ctx context.Context, w io.Writer, table Table, format Format, r Range) (FileManifest, error) {
+	if err := validate(table, format); err != nil {
+		return FileManifest{}, err
+	}
+
+	tx, r, err := e.begin(ctx, r)
+	if err != nil {
+		return FileManifest{}, err
+	}
+	defer tx.Rollback()
+
+	return e.exportTable(ctx, tx, w, table, format, r)
+}
+
+// ExportDir writes every table to a file of dir followed by the manifest
+// All tables are read from the same snapshot of the database
+func (e *Exporter) ExportDir(ctx context.Context, dir string, format Format, r Range) (Manifest, error) {
+	if err := validate(TableTransactions, format); err != nil {
+		return Manifest{}, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Manifest{}, err
+	}
+
+	tx, r, err := e.begin(ctx, r)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer tx.Rollback()
+
+	manifest := Manifest{
+		Format:    format,
+		Until:     r.Until,
+		CreatedAt: time.Now().UTC(),
+		Files:     []FileManifest{},
+	}
+	if !r.Since.IsZero() {
+		manifest.Since = &r.Since
+	}
+
+	for _, table := range Tables {
+		file, err := e.exportFile(ctx, tx, dir, table, format, r)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("export %s: %w", table, err)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// The manifest is written last, its presence marks a complete export
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0o644); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+func (e *Exporter) exportFile(ctx context.Context, tx *sql.Tx, dir string, table Table, format Format, r Range) (FileManifest, error) {
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s.%s", table, format)))
+	if err != nil {
+		return FileManifest{}, err
+	}
+	defer f.Close()
+
+	file, err := e.exportTable(ctx, tx, f, table, format, r)
+	if err != nil {
+		return FileManifest{}, err
+	}
+	return file, f.Close()
+}
+
+// begin starts a read only snapshot and resolves the end of the range
+func (e *Exporter) begin(ctx context.Context, r Range) (*sql.Tx, Range, error) {
+	tx, err := e.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, Range{}, err
+	}
+
+	if r.Until.IsZero() {
+		// created_at is stored without time zone in UTC
+		err = tx.QueryRowContext(ctx, "SELECT now() AT TIME ZONE 'UTC'").Scan(&r.Until)
+		if err != nil {
+			tx.Rollback()
+			return nil, Range{}, err
+		}
+	}
+	r.Since, r.Until = r.Since.UTC(), r.Until.UTC()
+	return tx, r, nil
+}
+
+// exportTable writes the rows of a table read through a cursor to w
+func (e *Exporter) exportTable(ctx context.Context, tx *sql.Tx, w io.Writer, table Table, format Format, r Range) (FileManifest, error) {
+	var query string
+	var args []interface{}
+	switch table {
+	case TableTransactions:
+		query = `SELECT id, user_id, amount, created_at, effective_date, idempotency_key, COALESCE(currency, ''), COALESCE(external_reference, '')
+			FROM transactions WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`
+		args = []interface{}{r.Since, r.Until}
+	case TableUsers:
+		query = `SELECT id, balance FROM users ORDER BY id`
+	}
+
+	hash := sha256.New()
+	out := newRowWriter(io.MultiWriter(w, hash), format, table)
+	if err := out.writeHeader(); err != nil {
+		return FileManifest{}, err
+	}
+
+	cursor := fmt.Sprintf("export_%s", table)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", cursor, query), args...)
+	if err != nil {
+		return FileManifest{}, err
+	}
+
+	var count int64
+	for {
+		n, err := e.fetch(ctx, tx, cursor, table, out)
+		if err != nil {
+			return FileManifest{}, err
+		}
+		count += int64(n)
+		if n < e.fetchSize {
+			break
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "CLOSE "+cursor); err != nil {
+		return FileManifest{}, err
+	}
+	if err := out.flush(); err != nil {
+		return FileManifest{}, err
+	}
+
+	return FileManifest{
+		Name:   fmt.Sprintf("%s.%s", table, format),
+		Table:  table,
+		Rows:   count,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// fetch writes the next rows of the cursor and returns how many were written
+func (e *Exporter) fetch(ctx context.Context, tx *sql.Tx, cursor string, table Table, out *rowWriter) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM %s", e.fetchSize, cursor))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var err error
+		switch table {
+		case TableTransactions:
+			var row transactionRow
+			if err = rows.Scan(&row.ID, &row.UserID, &row.Amount, &row.CreatedAt, &row.EffectiveDate, &row.IdempotencyKey, &row.Currency, &row.ExternalReference); err == nil {
+				row.CreatedAt = row.CreatedAt.UTC()
+				row.EffectiveDate = row.EffectiveDate.UTC()
+				err = out.writeTransaction(row)
+			}
+		case TableUsers:
+			var row userRow
+			if err = rows.Scan(&row.ID, &row.Balance); err == nil {
+				err = out.writeUser(row)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func validate(table Table, format Format) error {
+	if table != TableTransactions && table != TableUsers {
+		return fmt.Errorf("%w %q, must be %s or %s", ErrInvalidTable, table, TableTransactions, TableUsers)
+	}
+	if format != FormatCSV && format != FormatJSONL {
+		return fmt.Errorf("%w %q, must be %s or %s", ErrInvalidFormat, format, FormatCSV, FormatJSONL)
+	}
+	return nil
+}
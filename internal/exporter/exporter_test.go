@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestRowWriter_Formats(t *testing.T) {
+	row := transactionRow{
+		ID:             uuid.MustParse("223e4567-e89b-12d3-a456-426614174000"),
+		UserID:         uuid.MustParse("123e4567-e89b-12d3-a456-426614174000"),
+		Amount:         decimal.RequireFromString("100.10"),
+		CreatedAt:      time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		EffectiveDate:  time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
+		IdempotencyKey: uuid.MustParse("323e4567-e89b-12d3-a456-426614174000"),
+	}
+
+	testCases := []struct {
+		name     string
+		format   Format
+		expected string
+	}{
+		{
+			name:   "CSV",
+			format: FormatCSV,
+			expected: "id,user_id,amount,created_at,effective_date,idempotency_key,currency,external_reference\n" +
+				"223e4567-e89b-12d3-a456-426614174000,123e4567-e89b-12d3-a456-426614174000,100.1,2022-01-01T00:00:00Z,2021-12-31T00:00:00Z,323e4567-e89b-12d3-a456-426614174000,,\n",
+		},
+		{
+			name:   "JSONL",
+			format: FormatJSONL,
+			expected: `{"id":"223e4567-e89b-12d3-a456-426614174000","user_id":"123e4567-e89b-12d3-a456-426614174000",` +
+				`"amount":"100.1","created_at":"2022-01-01T00:00:00Z","effective_date":"2021-12-31T00:00:00Z","idempotency_key":"323e4567-e89b-12d3-a456-426614174000"}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			var out bytes.Buffer
+			w := newRowWriter(&out, tc.format, TableTransactions)
+
+			// Act
+			err := w.writeHeader()
+			if err == nil {
+				err = w.writeTransaction(row)
+			}
+			if err == nil {
+				err = w.flush()
+			}
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestExportDir_WritesManifest(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := storage.NewStorageClient(testEnv.DB)
+	user := storage.User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Imported transactions keep their created_at
+	watermark := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	transactions := []storage.Transaction{}
+	for i, createdAt := range []time.Time{watermark.AddDate(0, 0, -1), watermark, watermark.AddDate(0, 0, 1)} {
+		transactions = append(transactions, storage.Transaction{
+			ID:             uuid.New(),
+			UserID:         user.ID,
+			Amount:         decimal.NewFromInt(int64(i + 1)),
+			CreatedAt:      createdAt,
+			IdempotencyKey: uuid.New(),
+		})
+	}
+	results, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, transactions, false)
+	if err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+	for _, err := range results {
+		if err != nil {
+			t.Fatalf("failed to import transaction: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	e := New(testEnv.DB)
+	// Fetch a row at a time to read the cursor more than once
+	e.fetchSize = 1
+
+	// Act
+	manifest, err := e.ExportDir(testEnv.Context, dir, FormatCSV, Range{Since: watermark})
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	// Assert
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	var written Manifest
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	assert.Equal(t, len(Tables), len(written.Files))
+	assert.True(t, written.Since.Equal(watermark))
+	assert.True(t, written.Until.After(watermark))
+
+	rows := map[Table]int64{}
+	for _, file := range manifest.Files {
+		content, err := os.ReadFile(filepath.Join(dir, file.Name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file.Name, err)
+		}
+		sum := sha256.Sum256(content)
+		assert.Equal(t, hex.EncodeToString(sum[:]), file.SHA256, "checksum of %s", file.Name)
+		assert.Equal(t, file.Rows+1, int64(strings.Count(string(content), "\n")), "lines of %s", file.Name)
+		rows[file.Table] = file.Rows
+	}
+	assert.Equal(t, int64(2), rows[TableTransactions], "transactions before the watermark should be skipped")
+	assert.Equal(t, int64(1), rows[TableUsers])
+}
@@ -0,0 +1,12 @@
+package exporter
+
+import (
+	"os"
+	"testing"
+
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(utils.Main(m))
+}
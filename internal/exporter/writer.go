@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type transactionRow struct {
+	ID                uuid.UUID       `json:"id"`
+	UserID            uuid.UUID       `json:"user_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	CreatedAt         time.Time       `json:"created_at"`
+	EffectiveDate     time.Time       `json:"effective_date"`
+	IdempotencyKey    uuid.UUID       `json:"idempotency_key"`
+	Currency          string          `json:"currency,omitempty"`
+	ExternalReference string          `json:"external_reference,omitempty"`
+}
+
+type userRow struct {
+	ID      uuid.UUID       `json:"id"`
+	Balance decimal.Decimal `json:"balance"`
+}
+
+var headers = map[Table][]string{
+	TableTransactions: {"id", "user_id", "amount", "created_at", "effective_date", "idempotency_key", "currency", "external_reference"},
+	TableUsers:        {"id", "balance"},
+}
+
+// rowWriter encodes the rows of a table as CSV with a header or as JSONL
+// Amounts are written as exact decimals
+type rowWriter struct {
+	format Format
+	table  Table
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+func newRowWriter(w io.Writer, format Format, table Table) *rowWriter {
+	buf := bufio.NewWriter(w)
+	return &rowWriter{
+		format: format,
+		table:  table,
+		buf:    buf,
+		csv:    csv.NewWriter(buf),
+		json:   json.NewEncoder(buf),
+	}
+}
+
+func (w *rowWriter) writeHeader() error {
+	if w.format != FormatCSV {
+		return nil
+	}
+	return w.csv.Write(headers[w.table])
+}
+
+func (w *rowWriter) writeTransaction(row transactionRow) error {
+	if w.format == FormatJSONL {
+		return w.json.Encode(row)
+	}
+	return w.csv.Write([]string{
+		row.ID.String(),
+		row.UserID.String(),
+		row.Amount.String(),
+		row.CreatedAt.Format(time.RFC3339Nano),
+		row.EffectiveDate.Format(time.RFC3339Nano),
+		row.IdempotencyKey.String(),
+		row.Currency,
+		row.ExternalReference,
+	})
+}
+
+func (w *rowWriter) writeUser(row userRow) error {
+	if w.format == FormatJSONL {
+		return w.json.Encode(row)
+	}
+	return w.csv.Write([]string{row.ID.String(), row.Balance.String()})
+}
+
+func (w *rowWriter) flush() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.buf.Flush()
+}
@@ -0,0 +1,50 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+
+	"github.com/google/uuid"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const requestIDKey = "x-request-id"
+
+// auditInterceptor attaches the audit metadata of a unary call to its context
+// The request ID is taken from the x-request-id metadata or generated, and is sent back as a header.
+// The gRPC API does not authenticate its callers, so the actor is anonymous
+func auditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(requestIDKey)) > 0 {
+		requestID = md.Get(requestIDKey)[0]
+	}
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+
+	auditMetadata := audit.Metadata{
+		Actor:     audit.ActorAnonymous,
+		RequestID: requestID,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		auditMetadata.ClientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(auditMetadata.ClientIP); err == nil {
+			auditMetadata.ClientIP = host
+		}
+	}
+	if message, ok := req.(proto.Message); ok {
+		if payload, err := (proto.MarshalOptions{Deterministic: true}).Marshal(message); err == nil {
+			sum := sha256.Sum256(payload)
+			auditMetadata.PayloadHash = sum[:]
+		}
+	}
+
+	return handler(audit.NewContext(ctx, auditMetadata), req)
+}
@@ -0,0 +1,144 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+	"github.com/tebrizetayi/ledgerservice/pkg/ledgerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultHistoryPageSize = 100
+
+// Server is the gRPC ledger service
+// It shares the transaction manager with the REST controller
+type Server struct {
+	ledgerpb.UnimplementedLedgerServiceServer
+	transactionmanager api.TransactionManager
+}
+
+func NewServer(tm api.TransactionManager) *Server {
+	return &Server{
+		transactionmanager: tm,
+	}
+}
+
+// NewGRPCServer returns a gRPC server with the ledger service registered
+// Unary calls carry the audit metadata of the caller
+func NewGRPCServer(server *Server, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(auditInterceptor)}, opts...)
+	grpcServer := grpc.NewServer(opts...)
+	ledgerpb.RegisterLedgerServiceServer(grpcServer, server)
+	return grpcServer
+}
+
+// AddTransaction adds a transaction to the ledger
+func (s *Server) AddTransaction(ctx context.Context, req *ledgerpb.AddTransactionRequest) (*ledgerpb.AddTransactionResponse, error) {
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid user ID %v", err)
+	}
+
+	amount, err := decimal.NewFromString(req.GetAmount())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid amount %v", err)
+	}
+
+	idempotencyKey, err := uuid.Parse(req.GetIdempotencyKey())
+	if err != nil || idempotencyKey == uuid.Nil {
+		return nil, status.Error(codes.InvalidArgument, "idempotency_key is required")
+	}
+
+	transaction, err := s.transactionmanager.AddTransaction(ctx, transactionmanager.Transaction{
+		UserID:         userID,
+		Amount:         amount,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &ledgerpb.AddTransactionResponse{Transaction: toProto(transaction)}, nil
+}
+
+// GetBalance returns the balance of a user
+func (s *Server) GetBalance(ctx context.Context, req *ledgerpb.GetBalanceRequest) (*ledgerpb.GetBalanceResponse, error) {
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid user ID %v", err)
+	}
+
+	balance, err := s.transactionmanager.GetUserBalance(ctx, userID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &ledgerpb.GetBalanceResponse{Balance: balance.String()}, nil
+}
+
+// GetHistory streams the whole transaction history of a user
+// The history is read from the transaction manager one page at a time
+func (s *Server) GetHistory(req *ledgerpb.GetHistoryRequest, stream ledgerpb.LedgerService_GetHistoryServer) error {
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid user ID %v", err)
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultHistoryPageSize
+	}
+
+	for page := 1; ; page++ {
+		transactions, err := s.transactionmanager.GetUserTransactionHistory(stream.Context(), userID, page, pageSize, transactionmanager.HistoryFilter{})
+		if err != nil {
+			return toStatus(err)
+		}
+
+		for _, transaction := range transactions {
+			if err := stream.Send(&ledgerpb.GetHistoryResponse{Transaction: toProto(transaction)}); err != nil {
+				return err
+			}
+		}
+
+		if len(transactions) < pageSize {
+			return nil
+		}
+	}
+}
+
+func toProto(transaction transactionmanager.Transaction) *ledgerpb.Transaction {
+	return &ledgerpb.Transaction{
+		Id:             transaction.ID.String(),
+		UserId:         transaction.UserID.String(),
+		Amount:         transaction.Amount.String(),
+		CreatedAt:      timestamppb.New(transaction.CreatedAt),
+		IdempotencyKey: transaction.IdempotencyKey.String(),
+	}
+}
+
+// toStatus maps an error returned by the transaction manager to a gRPC status
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, transactionmanager.ErrInvalidTransaction):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, transactionmanager.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, transactionmanager.ErrTransactionAlreadyExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, transactionmanager.ErrFeeAccountNotFound):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,384 @@
+package grpcapi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/grpcapi"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+	"github.com/tebrizetayi/ledgerservice/pkg/ledgerpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeTransactionManager keeps the ledger of a set of users in memory
+type fakeTransactionManager struct {
+	mu    sync.Mutex
+	users map[uuid.UUID][]transactionmanager.Transaction
+	err   error
+}
+
+func newFakeTransactionManager(userIDs ...uuid.UUID) *fakeTransactionManager {
+	users := map[uuid.UUID][]transactionmanager.Transaction{}
+	for _, userID := range userIDs {
+		users[userID] = []transactionmanager.Transaction{}
+	}
+	return &fakeTransactionManager{users: users}
+}
+
+func (f *fakeTransactionManager) AddTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return transactionmanager.Transaction{}, f.err
+	}
+	if !transaction.Amount.IsPositive() {
+		return transactionmanager.Transaction{}, transactionmanager.ErrInvalidTransaction
+	}
+	transactions, ok := f.users[transaction.UserID]
+	if !ok {
+		return transactionmanager.Transaction{}, transactionmanager.ErrUserNotFound
+	}
+	for _, existing := range transactions {
+		if existing.IdempotencyKey == transaction.IdempotencyKey && existing.Amount.Equal(transaction.Amount) {
+			return transactionmanager.Transaction{}, transactionmanager.ErrTransactionAlreadyExist
+		}
+	}
+	// Prepend so that the history is newest first
+	f.users[transaction.UserID] = append([]transactionmanager.Transaction{transaction}, transactions...)
+	return transaction, nil
+}
+
+func (f *fakeTransactionManager) AddTransactions(ctx context.Context, transactions []transactionmanager.Transaction, mode transactionmanager.BatchMode) ([]transactionmanager.BatchResult, error) {
+	results := make([]transactionmanager.BatchResult, len(transactions))
+	for i, transaction := range transactions {
+		results[i].Transaction, results[i].Err = f.AddTransaction(ctx, transaction)
+	}
+	return results, nil
+}
+
+func (f *fakeTransactionManager) GetUserBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return decimal.Zero, transactionmanager.ErrUserNotFound
+	}
+	balance := decimal.Zero
+	for _, transaction := range transactions {
+		balance = balance.Add(transaction.Amount)
+	}
+	return balance, nil
+}
+
+func (f *fakeTransactionManager) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter transactionmanager.HistoryFilter) ([]transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return nil, transactionmanager.ErrUserNotFound
+	}
+	start := (page - 1) * pageSize
+	if start >= len(transactions) {
+		return []transactionmanager.Transaction{}, nil
+	}
+	end := start + pageSize
+	if end > len(transactions) {
+		end = len(transactions)
+	}
+	return transactions[start:end], nil
+}
+
+func (f *fakeTransactionManager) GetTransaction(ctx context.Context, transactionID uuid.UUID, owner uuid.UUID) (transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for userID, transactions := range f.users {
+		for _, transaction := range transactions {
+			if transaction.ID == transactionID && (owner == uuid.Nil || owner == userID) {
+				return transaction, nil
+			}
+		}
+	}
+	return transactionmanager.Transaction{}, transactionmanager.ErrTransactionNotFound
+}
+
+func (f *fakeTransactionManager) GetUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return nil, transactionmanager.ErrUserNotFound
+	}
+	found := []transactionmanager.Transaction{}
+	for _, transaction := range transactions {
+		if transaction.IdempotencyKey == idempotencyKey {
+			found = append(found, transaction)
+		}
+	}
+	if len(found) == 0 {
+		return nil, transactionmanager.ErrTransactionNotFound
+	}
+	return found, nil
+}
+
+func (f *fakeTransactionManager) EnqueueTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Posting, error) {
+	return transactionmanager.Posting{}, transactionmanager.ErrAsyncUnavailable
+}
+
+func (f *fakeTransactionManager) GetPosting(ctx context.Context, postingID uuid.UUID, owner uuid.UUID) (transactionmanager.Posting, error) {
+	return transactionmanager.Posting{}, transactionmanager.ErrAsyncUnavailable
+}
+
+func (f *fakeTransactionManager) GetUserBalanceAsOf(ctx context.Context, userID uuid.UUID, asOf time.Time) (decimal.Decimal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return decimal.Zero, transactionmanager.ErrUserNotFound
+	}
+	balance := decimal.Zero
+	for _, transaction := range transactions {
+		if transaction.CreatedAt.Before(asOf) {
+			balance = balance.Add(transaction.Amount)
+		}
+	}
+	return balance, nil
+}
+
+func (f *fakeTransactionManager) GetUserStatement(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) (transactionmanager.Statement, error) {
+	return transactionmanager.Statement{}, transactionmanager.ErrInvalidPeriod
+}
+
+func (f *fakeTransactionManager) CreateSchedule(ctx context.Context, schedule transactionmanager.Schedule) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]transactionmanager.Schedule, error) {
+	return nil, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) PauseSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ResumeSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ListScheduleRuns(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID, limit int) ([]transactionmanager.ScheduleRun, error) {
+	return nil, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) VerifyUserChain(ctx context.Context, userID uuid.UUID) (transactionmanager.ChainReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return transactionmanager.ChainReport{}, transactionmanager.ErrUserNotFound
+	}
+	return transactionmanager.ChainReport{UserID: userID, Intact: true, Transactions: int64(len(transactions))}, nil
+}
+
+// newTestClient serves the ledger service on an in-process listener
+func newTestClient(t *testing.T, manager *fakeTransactionManager) ledgerpb.LedgerServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpcapi.NewGRPCServer(grpcapi.NewServer(manager))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return ledgerpb.NewLedgerServiceClient(conn)
+}
+
+func TestAddTransactionAndGetBalance(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	client := newTestClient(t, newFakeTransactionManager(userID))
+
+	// Act
+	response, err := client.AddTransaction(context.Background(), &ledgerpb.AddTransactionRequest{
+		UserId:         userID.String(),
+		Amount:         "100.25",
+		IdempotencyKey: uuid.New().String(),
+	})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	balance, err := client.GetBalance(context.Background(), &ledgerpb.GetBalanceRequest{UserId: userID.String()})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "100.25", balance.GetBalance())
+	assert.Equal(t, userID.String(), response.GetTransaction().GetUserId())
+	assert.Equal(t, "100.25", response.GetTransaction().GetAmount())
+	assert.NotEmpty(t, response.GetTransaction().GetId())
+}
+
+func TestAddTransaction_ErrorCodes(t *testing.T) {
+	userID := uuid.New()
+	duplicateKey := uuid.New().String()
+
+	testCases := []struct {
+		name         string
+		request      *ledgerpb.AddTransactionRequest
+		managerErr   error
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Invalid user ID",
+			request:      &ledgerpb.AddTransactionRequest{UserId: "invalid-user-id", Amount: "1", IdempotencyKey: uuid.New().String()},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Invalid amount",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "one", IdempotencyKey: uuid.New().String()},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Missing idempotency key",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Zero idempotency key",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "1", IdempotencyKey: uuid.Nil.String()},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Negative amount",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "-1", IdempotencyKey: uuid.New().String()},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "Unknown user",
+			request:      &ledgerpb.AddTransactionRequest{UserId: uuid.New().String(), Amount: "1", IdempotencyKey: uuid.New().String()},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "Duplicate transaction",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "1", IdempotencyKey: duplicateKey},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name:         "Missing fee revenue account",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "1", IdempotencyKey: uuid.New().String()},
+			managerErr:   transactionmanager.ErrFeeAccountNotFound,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name:         "Storage failure",
+			request:      &ledgerpb.AddTransactionRequest{UserId: userID.String(), Amount: "1", IdempotencyKey: uuid.New().String()},
+			managerErr:   errors.New("database down"),
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			manager := newFakeTransactionManager(userID)
+			client := newTestClient(t, manager)
+
+			_, err := client.AddTransaction(context.Background(), &ledgerpb.AddTransactionRequest{
+				UserId:         userID.String(),
+				Amount:         "1",
+				IdempotencyKey: duplicateKey,
+			})
+			if err != nil {
+				t.Fatalf("failed to add transaction: %v", err)
+			}
+			manager.err = tc.managerErr
+
+			// Act
+			_, err = client.AddTransaction(context.Background(), tc.request)
+
+			// Assert
+			assert.Equal(t, tc.expectedCode, status.Code(err), "unexpected error %v", err)
+		})
+	}
+}
+
+func TestGetHistory_StreamsEveryPage(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	client := newTestClient(t, newFakeTransactionManager(userID))
+
+	const transactionCount = 7
+	for i := 0; i < transactionCount; i++ {
+		_, err := client.AddTransaction(context.Background(), &ledgerpb.AddTransactionRequest{
+			UserId:         userID.String(),
+			Amount:         decimal.NewFromInt(int64(i + 1)).String(),
+			IdempotencyKey: uuid.New().String(),
+		})
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	// Act
+	stream, err := client.GetHistory(context.Background(), &ledgerpb.GetHistoryRequest{UserId: userID.String(), PageSize: 3})
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+
+	amounts := []string{}
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to receive transaction: %v", err)
+		}
+		amounts = append(amounts, response.GetTransaction().GetAmount())
+	}
+
+	// Assert
+	assert.Equal(t, []string{"7", "6", "5", "4", "3", "2", "1"}, amounts)
+}
+
+func TestGetHistory_UnknownUser(t *testing.T) {
+	// Assign
+	client := newTestClient(t, newFakeTransactionManager())
+
+	// Act
+	stream, err := client.GetHistory(context.Background(), &ledgerpb.GetHistoryRequest{UserId: uuid.New().String()})
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	_, err = stream.Recv()
+
+	// Assert
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,207 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// Format is the format of an import file
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+const defaultBatchSize = 5000
+
+// maxExternalReferenceLength limits the size of the reference to the originating system
+const maxExternalReferenceLength = 255
+
+// namespace derives the idempotency key of an imported transaction from its external reference,
+// so that importing the same file twice does not add its transactions twice
+var namespace = uuid.MustParse("8b7f2a8e-3c1d-4e55-9a0b-6f1f0c7d2e41")
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+var ErrInvalidFormat = errors.New("invalid format")
+
+// Store adds the imported transactions to the ledger
+type Store interface {
+	ImportTransactions(ctx context.Context, transactions []storage.Transaction, createUsers bool) ([]error, error)
+}
+
+// Options configures an import
+type Options struct {
+	Format Format
+	// Offset is the number of records to skip, records are counted without the CSV header
+	// An interrupted import is resumed by passing the offset of its result
+	Offset int
+	// BatchSize is the number of records added in a single database transaction
+	BatchSize int
+	// CreateUsers creates the users that do not exist yet instead of rejecting their records
+	CreateUsers bool
+}
+
+// Result reports the progress of an import
+type Result struct {
+	// Offset is the number of records processed, every record up to it was either
+	// added or written to the rejects file
+	Offset   int
+	Imported int
+	Rejected int
+}
+
+// record is a record of the import file before validation
+type record struct {
+	raw         string
+	userID      string
+	amount      string
+	currency    string
+	timestamp   string
+	externalRef string
+}
+
+// rejection is a record that could not be imported
+type rejection struct {
+	line int
+	err  error
+	raw  string
+}
+
+// Import reads the transactions of r and adds them to store in batches
+// Every record is validated, records that are invalid or that cannot be added are
+// written to rejects with their line and the reason, the other records are still imported.
+// The created_at of the imported transactions is the timestamp of their record
+// If the import fails, the returned result holds the offset to resume from
+func Import(ctx context.Context, store Store, r io.Reader, rejects io.Writer, opts Options) (Result, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	reader, err := newReader(r, opts.Format)
+	if err != nil {
+		return Result{}, err
+	}
+
+	rejectsWriter := csv.NewWriter(rejects)
+	result := Result{Offset: opts.Offset}
+	line := 0
+	for {
+		// Read and validate a batch of records
+		transactions := []storage.Transaction{}
+		lines := []int{}
+		raws := []string{}
+		rejected := []rejection{}
+		for len(transactions)+len(rejected) < opts.BatchSize {
+			rec, err := reader.read()
+			if err == io.EOF {
+				break
+			}
+			var recErr *recordError
+			if err != nil && !errors.As(err, &recErr) {
+				return result, fmt.Errorf("read record %d: %w", line+1, err)
+			}
+			line++
+			if line <= opts.Offset {
+				continue
+			}
+			if recErr != nil {
+				rejected = append(rejected, rejection{line: line, err: recErr.err, raw: rec.raw})
+				continue
+			}
+
+			transaction, err := parse(rec)
+			if err != nil {
+				rejected = append(rejected, rejection{line: line, err: err, raw: rec.raw})
+				continue
+			}
+			transactions = append(transactions, transaction)
+			lines = append(lines, line)
+			raws = append(raws, rec.raw)
+		}
+		if len(transactions)+len(rejected) == 0 {
+			return result, nil
+		}
+
+		// Add the batch, a failed batch is imported again when the import is resumed
+		errs, err := store.ImportTransactions(ctx, transactions, opts.CreateUsers)
+		if err != nil {
+			return result, fmt.Errorf("import records %d to %d: %w", result.Offset+1, line, err)
+		}
+		for i, err := range errs {
+			if err != nil {
+				rejected = append(rejected, rejection{line: lines[i], err: err, raw: raws[i]})
+				continue
+			}
+			result.Imported++
+		}
+
+		if err := writeRejects(rejectsWriter, rejected); err != nil {
+			return result, fmt.Errorf("write rejects: %w", err)
+		}
+		result.Rejected += len(rejected)
+		result.Offset = line
+	}
+}
+
+// writeRejects writes the rejected records as CSV rows of line, error and record
+func writeRejects(w *csv.Writer, rejected []rejection) error {
+	sort.SliceStable(rejected, func(i, j int) bool { return rejected[i].line < rejected[j].line })
+	for _, r := range rejected {
+		if err := w.Write([]string{strconv.Itoa(r.line), r.err.Error(), r.raw}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parse validates a record and converts it to a transaction
+func parse(rec record) (storage.Transaction, error) {
+	userID, err := uuid.Parse(rec.userID)
+	if err != nil || userID == uuid.Nil {
+		return storage.Transaction{}, fmt.Errorf("invalid user_id %q", rec.userID)
+	}
+
+	amount, err := decimal.NewFromString(rec.amount)
+	if err != nil || !amount.IsPositive() {
+		return storage.Transaction{}, fmt.Errorf("invalid amount %q, must be a positive number", rec.amount)
+	}
+
+	if !currencyPattern.MatchString(rec.currency) {
+		return storage.Transaction{}, fmt.Errorf("invalid currency %q, must be an ISO 4217 code", rec.currency)
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, rec.timestamp)
+	if err != nil {
+		return storage.Transaction{}, fmt.Errorf("invalid timestamp %q, must be RFC 3339", rec.timestamp)
+	}
+
+	externalRef := strings.TrimSpace(rec.externalRef)
+	if externalRef == "" || len(externalRef) > maxExternalReferenceLength {
+		return storage.Transaction{}, fmt.Errorf("invalid external_ref, must be between 1 and %d characters", maxExternalReferenceLength)
+	}
+
+	return storage.Transaction{
+		ID:                uuid.New(),
+		UserID:            userID,
+		Amount:            amount,
+		CreatedAt:         createdAt.UTC(),
+		IdempotencyKey:    uuid.NewSHA1(namespace, []byte(externalRef)),
+		Currency:          rec.currency,
+		ExternalReference: externalRef,
+	}, nil
+}
@@ -0,0 +1,193 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// fakeStore records the imported transactions
+// The transactions of unknownUser are rejected and failAfter batches can be added before failing
+type fakeStore struct {
+	imported    []storage.Transaction
+	batches     int
+	unknownUser uuid.UUID
+	failAfter   int
+}
+
+func (f *fakeStore) ImportTransactions(ctx context.Context, transactions []storage.Transaction, createUsers bool) ([]error, error) {
+	if f.failAfter > 0 && f.batches == f.failAfter {
+		return nil, errors.New("database down")
+	}
+	f.batches++
+
+	errs := make([]error, len(transactions))
+	for i, transaction := range transactions {
+		if transaction.UserID == f.unknownUser && !createUsers {
+			errs[i] = storage.ErrUserNotFound
+			continue
+		}
+		f.imported = append(f.imported, transaction)
+	}
+	return errs, nil
+}
+
+var (
+	userID      = uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	unknownUser = uuid.MustParse("123e4567-e89b-12d3-a456-426614174999")
+)
+
+const csvFile = `external_ref,user_id,amount,currency,timestamp
+ref-1,123e4567-e89b-12d3-a456-426614174000,100.25,EUR,2019-03-01T10:00:00Z
+ref-2,123e4567-e89b-12d3-a456-426614174000,-5,EUR,2019-03-02T10:00:00Z
+ref-3,123e4567-e89b-12d3-a456-426614174999,10,EUR,2019-03-03T10:00:00Z
+ref-4,123e4567-e89b-12d3-a456-426614174000,20,euro,2019-03-04T10:00:00Z
+ref-5,123e4567-e89b-12d3-a456-426614174000,30
+ref-6,123e4567-e89b-12d3-a456-426614174000,40,EUR,2019-03-06T12:30:00+02:00
+`
+
+const jsonlFile = `{"user_id":"123e4567-e89b-12d3-a456-426614174000","amount":100.25,"currency":"EUR","timestamp":"2019-03-01T10:00:00Z","external_ref":"ref-1"}
+{"user_id":"123e4567-e89b-12d3-a456-426614174000","amount":"-5","currency":"EUR","timestamp":"2019-03-02T10:00:00Z","external_ref":"ref-2"}
+{"user_id":"123e4567-e89b-12d3-a456-426614174999","amount":10,"currency":"EUR","timestamp":"2019-03-03T10:00:00Z","external_ref":"ref-3"}
+{"user_id":"123e4567-e89b-12d3-a456-426614174000","amount":20,"currency":"euro","timestamp":"2019-03-04T10:00:00Z","external_ref":"ref-4"}
+{"user_id":"123e4567-e89b-12d3-a456-426614174000","amount":30,
+{"user_id":"123e4567-e89b-12d3-a456-426614174000","amount":"40","currency":"EUR","timestamp":"2019-03-06T12:30:00+02:00","external_ref":"ref-6"}
+`
+
+func TestImport_ValidatesEveryRecord(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format Format
+		file   string
+	}{
+		{
+			name:   "CSV",
+			format: FormatCSV,
+			file:   csvFile,
+		},
+		{
+			name:   "JSONL",
+			format: FormatJSONL,
+			file:   jsonlFile,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			store := &fakeStore{unknownUser: unknownUser}
+			var rejects bytes.Buffer
+
+			// Act
+			result, err := Import(context.Background(), store, strings.NewReader(tc.file), &rejects, Options{Format: tc.format, BatchSize: 4})
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, Result{Offset: 6, Imported: 2, Rejected: 4}, result)
+
+			if assert.Len(t, store.imported, 2) {
+				assert.Equal(t, userID, store.imported[0].UserID)
+				assert.Equal(t, "100.25", store.imported[0].Amount.String())
+				assert.Equal(t, "EUR", store.imported[0].Currency)
+				assert.Equal(t, "ref-1", store.imported[0].ExternalReference)
+				assert.Equal(t, time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC), store.imported[0].CreatedAt)
+				assert.Equal(t, time.Date(2019, 3, 6, 10, 30, 0, 0, time.UTC), store.imported[1].CreatedAt)
+			}
+
+			rows, err := csv.NewReader(&rejects).ReadAll()
+			if err != nil {
+				t.Fatalf("failed to read rejects: %v", err)
+			}
+			lines := []string{}
+			for _, row := range rows {
+				lines = append(lines, row[0])
+			}
+			assert.Equal(t, []string{"2", "3", "4", "5"}, lines)
+			assert.Contains(t, rows[0][1], "invalid amount")
+			assert.Equal(t, storage.ErrUserNotFound.Error(), rows[1][1])
+			assert.Contains(t, rows[2][1], "invalid currency")
+		})
+	}
+}
+
+func TestImport_IdempotencyKeyFromExternalReference(t *testing.T) {
+	// Assign
+	store := &fakeStore{}
+	file := csvFile + csvFile[strings.Index(csvFile, "\n")+1:]
+
+	// Act
+	_, err := Import(context.Background(), store, strings.NewReader(file), &bytes.Buffer{}, Options{Format: FormatCSV, CreateUsers: true})
+
+	// Assert
+	assert.NoError(t, err)
+	if assert.Len(t, store.imported, 6) {
+		assert.Equal(t, store.imported[0].IdempotencyKey, store.imported[3].IdempotencyKey)
+		assert.NotEqual(t, store.imported[0].IdempotencyKey, store.imported[1].IdempotencyKey)
+		assert.NotEqual(t, store.imported[0].ID, store.imported[3].ID)
+	}
+}
+
+func TestImport_ResumesFromOffset(t *testing.T) {
+	// Assign
+	store := &fakeStore{failAfter: 1}
+	var rejects bytes.Buffer
+
+	// Act
+	failed, failedErr := Import(context.Background(), store, strings.NewReader(csvFile), &rejects, Options{Format: FormatCSV, BatchSize: 2, CreateUsers: true})
+	store.failAfter = 0
+	resumed, resumedErr := Import(context.Background(), store, strings.NewReader(csvFile), &rejects, Options{Format: FormatCSV, BatchSize: 2, CreateUsers: true, Offset: failed.Offset})
+
+	// Assert
+	assert.Error(t, failedErr)
+	assert.Equal(t, 2, failed.Offset)
+	assert.NoError(t, resumedErr)
+	assert.Equal(t, 6, resumed.Offset)
+
+	refs := []string{}
+	for _, transaction := range store.imported {
+		refs = append(refs, transaction.ExternalReference)
+	}
+	assert.Equal(t, []string{"ref-1", "ref-3", "ref-6"}, refs, "every record should be imported exactly once")
+	assert.Equal(t, 3, strings.Count(rejects.String(), "\n"), "every record should be rejected exactly once")
+}
+
+func TestImport_InvalidFile(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format Format
+		file   string
+	}{
+		{
+			name:   "Unknown format",
+			format: Format("xml"),
+			file:   csvFile,
+		},
+		{
+			name:   "Missing CSV header",
+			format: FormatCSV,
+		},
+		{
+			name:   "Missing CSV column",
+			format: FormatCSV,
+			file:   "user_id,amount,timestamp,external_ref\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			_, err := Import(context.Background(), &fakeStore{}, strings.NewReader(tc.file), &bytes.Buffer{}, Options{Format: tc.format})
+
+			// Assert
+			assert.ErrorIs(t, err, ErrInvalidFormat)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLineSize limits the size of a JSONL record
+const maxLineSize = 1 << 20
+
+// columns are the fields every record must have
+var columns = []string{"user_id", "amount", "currency", "timestamp", "external_ref"}
+
+// recordError is returned by a reader for a record that cannot be decoded
+// The reader can still read the following records
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+type reader interface {
+	read() (record, error)
+}
+
+func newReader(r io.Reader, format Format) (reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("%w %q, must be %s or %s", ErrInvalidFormat, format, FormatCSV, FormatJSONL)
+	}
+}
+
+// csvReader reads a CSV file with a header naming the columns
+// The columns may be in any order, unknown columns are ignored
+type csvReader struct {
+	reader  *csv.Reader
+	indexes map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing CSV header", ErrInvalidFormat)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := map[string]int{}
+	for i, name := range header {
+		indexes[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, column := range columns {
+		if _, ok := indexes[column]; !ok {
+			return nil, fmt.Errorf("%w: missing CSV column %s", ErrInvalidFormat, column)
+		}
+	}
+	return &csvReader{reader: reader, indexes: indexes}, nil
+}
+
+func (c *csvReader) read() (record, error) {
+	fields, err := c.reader.Read()
+	if err == io.EOF {
+		return record{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return record{raw: encodeCSV(fields)}, &recordError{err: err}
+	}
+	if err != nil {
+		return record{}, err
+	}
+
+	return record{
+		raw:         encodeCSV(fields),
+		userID:      fields[c.indexes["user_id"]],
+		amount:      fields[c.indexes["amount"]],
+		currency:    fields[c.indexes["currency"]],
+		timestamp:   fields[c.indexes["timestamp"]],
+		externalRef: fields[c.indexes["external_ref"]],
+	}, nil
+}
+
+// encodeCSV returns the fields as a CSV line without the line break
+func encodeCSV(fields []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// jsonlReader reads a file of one JSON object per line
+type jsonlReader struct {
+	scanner *bufio.Scanner
+}
+
+func (j *jsonlReader) read() (record, error) {
+	if !j.scanner.Scan() {
+		if err := j.scanner.Err(); err != nil {
+			return record{}, err
+		}
+		return record{}, io.EOF
+	}
+
+	line := j.scanner.Text()
+	var fields struct {
+		UserID      string      `json:"user_id"`
+		Amount      json.Number `json:"amount"`
+		Currency    string      `json:"currency"`
+		Timestamp   string      `json:"timestamp"`
+		ExternalRef string      `json:"external_ref"`
+	}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return record{raw: line}, &recordError{err: err}
+	}
+
+	return record{
+		raw:         line,
+		userID:      fields.UserID,
+		amount:      fields.Amount.String(),
+		currency:    fields.Currency,
+		timestamp:   fields.Timestamp,
+		externalRef: fields.ExternalRef,
+	}, nil
+}
@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the advisory lock key held while migrations are applied so that
+// several instances starting at the same time do not race each other
+const lockID = 7283461
+
+type migration struct {
+	version int
+	name    string
+	script  string
+}
+
+// Version returns the schema version this binary expects,
+// which is the version of the newest embedded migration
+func Version() int {
+	all, err := load()
+	if err != nil || len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].version
+}
+
+// Current returns the schema version recorded in the database
+// If no migration has been applied yet, 0 is returned
+func Current(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Up applies every embedded migration newer than the version recorded in the database
+// All pending migrations are applied in a single database transaction
+func Up(ctx context.Context, db *sql.DB) error {
+	all, err := load()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range all {
+		if m.version <= current {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.script); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// load reads the embedded migrations ordered by version
+// File names must start with the version number, e.g. 0001_init.sql
+func load() ([]migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	all := []migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: missing version prefix", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version prefix: %w", name, err)
+		}
+		script, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, migration{version: version, name: name, script: string(script)})
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].version < all[j].version })
+	return all, nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_SequentialVersions(t *testing.T) {
+	// Act
+	all, err := load()
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	// Assert
+	assert.NotEmpty(t, all)
+	for i, m := range all {
+		assert.Equal(t, i+1, m.version, "migration %s should have version %d", m.name, i+1)
+		assert.NotEmpty(t, m.script, "migration %s should not be empty", m.name)
+	}
+	assert.Equal(t, len(all), Version())
+}
@@ -0,0 +1,3 @@
+-- Columns of transactions imported from the ledger of another system
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency CHAR(3);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS external_reference TEXT;
@@ -0,0 +1,49 @@
+-- Per user hash chain over transactions
+-- The hash of a transaction is sha256(previous hash || canonical serialization of the transaction),
+-- the previous hash of the first transaction of a user is 32 zero bytes.
+-- The canonical serialization is the concatenation of
+--   id, user_id (16 bytes each), amount (IEEE 754 float8, big endian),
+--   created_at (YYYY-MM-DDTHH:MM:SS.ffffff, UTF-8) and idempotency_key (16 bytes)
+-- storage.canonicalTransaction must produce the same bytes
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS chain_seq BIGINT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS hash BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS chain_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS chain_hash BYTEA NOT NULL DEFAULT '\x0000000000000000000000000000000000000000000000000000000000000000';
+
+-- Chain the existing transactions in the order they were created
+DO $$
+DECLARE
+    t RECORD;
+    current_user_id UUID;
+    seq BIGINT;
+    previous BYTEA;
+BEGIN
+    FOR t IN
+        SELECT id, user_id, amount, created_at, idempotency_key FROM transactions
+        WHERE hash IS NULL ORDER BY user_id, created_at, id
+    LOOP
+        IF current_user_id IS DISTINCT FROM t.user_id THEN
+            IF current_user_id IS NOT NULL THEN
+                UPDATE users SET chain_seq = seq, chain_hash = previous WHERE id = current_user_id;
+            END IF;
+            current_user_id := t.user_id;
+            SELECT chain_seq, chain_hash INTO seq, previous FROM users WHERE id = t.user_id;
+        END IF;
+
+        seq := seq + 1;
+        previous := sha256(previous
+            || uuid_send(t.id)
+            || uuid_send(t.user_id)
+            || float8send(t.amount)
+            || convert_to(to_char(t.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), 'UTF8')
+            || uuid_send(t.idempotency_key));
+        UPDATE transactions SET chain_seq = seq, hash = previous WHERE id = t.id;
+    END LOOP;
+
+    IF current_user_id IS NOT NULL THEN
+        UPDATE users SET chain_seq = seq, chain_hash = previous WHERE id = current_user_id;
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_user_id_chain_seq ON transactions (user_id, chain_seq);
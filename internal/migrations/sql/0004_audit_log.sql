@@ -0,0 +1,31 @@
+-- Append-only log of the mutations of the ledger, written in the database transaction of the mutation
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    request_id TEXT,
+    client_ip TEXT,
+    user_id UUID NOT NULL,
+    transaction_id UUID,
+    balance_before DOUBLE PRECISION NOT NULL,
+    balance_after DOUBLE PRECISION NOT NULL,
+    payload_hash BYTEA
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_id_created_at ON audit_log (user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_created_at ON audit_log (actor, created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_modification ON audit_log;
+CREATE TRIGGER audit_log_no_modification BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
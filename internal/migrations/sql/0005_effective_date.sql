@@ -0,0 +1,5 @@
+-- created_at is set by the database when a transaction is added, effective_date is the date
+-- the transaction takes effect, it is earlier than created_at for backdated transactions
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS effective_date TIMESTAMP;
+UPDATE transactions SET effective_date = created_at WHERE effective_date IS NULL;
+ALTER TABLE transactions ALTER COLUMN effective_date SET NOT NULL;
@@ -0,0 +1,10 @@
+-- Details describing what a transaction is for
+-- external_reference is the reference of the transaction in the system it originates from, source,
+-- and is unique per source. Imported transactions have no source, their references are
+-- deduplicated through the idempotency keys derived from them
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS category TEXT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS source TEXT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS metadata JSONB;
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_source_external_reference_key ON transactions (source, external_reference) WHERE source IS NOT NULL;
+CREATE INDEX IF NOT EXISTS transactions_user_id_external_reference_idx ON transactions (user_id, external_reference) WHERE external_reference IS NOT NULL;
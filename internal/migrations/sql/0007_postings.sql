@@ -0,0 +1,25 @@
+-- Queue of the transactions posted asynchronously, applied by the posting workers
+-- seq orders the postings, a user's postings are applied in seq order.
+-- The audit metadata of the request is kept so that the applied transaction is attributed to its caller
+CREATE TABLE IF NOT EXISTS postings (
+    id UUID PRIMARY KEY,
+    seq BIGSERIAL NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    transaction_id UUID NOT NULL,
+    amount DOUBLE PRECISION NOT NULL,
+    idempotency_key UUID NOT NULL,
+    effective_date TIMESTAMP,
+    details JSONB,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'applied', 'failed')),
+    error TEXT,
+    actor TEXT NOT NULL,
+    request_id TEXT,
+    client_ip TEXT,
+    payload_hash BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+    processed_at TIMESTAMP,
+    UNIQUE (idempotency_key, amount)
+);
+
+CREATE INDEX IF NOT EXISTS postings_pending_idx ON postings (seq) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS postings_pending_user_id_idx ON postings (user_id, seq) WHERE status = 'pending';
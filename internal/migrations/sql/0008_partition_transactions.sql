@@ -0,0 +1,103 @@
+-- Monthly range partitioning of transactions on created_at
+-- The partitions are named transactions_pYYYY_MM and created ahead of time by the partition maintenance.
+-- A partitioned table only enforces unique constraints including created_at, so the uniqueness of the IDs,
+-- of the idempotency keys with their amount and of the external references per source is enforced by
+-- transaction_keys, which gets a row with every transaction. Its created_at locates the partition of a
+-- transaction looked up by ID. chain_seq stays unique per user through the lock on the user row
+CREATE TABLE IF NOT EXISTS transaction_keys (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    idempotency_key UUID NOT NULL,
+    amount DOUBLE PRECISION NOT NULL,
+    source TEXT,
+    external_reference TEXT,
+    UNIQUE (idempotency_key, amount)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_keys_source_external_reference_key ON transaction_keys (source, external_reference) WHERE source IS NOT NULL;
+
+ALTER TABLE transactions RENAME TO transactions_unpartitioned;
+CREATE TABLE transactions (
+    id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    idempotency_key UUID NOT NULL,
+    currency CHAR(3),
+    external_reference TEXT,
+    chain_seq BIGINT,
+    hash BYTEA,
+    effective_date TIMESTAMP NOT NULL,
+    description TEXT,
+    category TEXT,
+    source TEXT,
+    metadata JSONB,
+    CONSTRAINT transactions_id_created_at_pkey PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Archived partitions are detached from transactions and attached to archive.transactions,
+-- where they are still read to verify the hash chains and to recompute the balances
+CREATE SCHEMA IF NOT EXISTS archive;
+CREATE TABLE archive.transactions (LIKE transactions INCLUDING DEFAULTS) PARTITION BY RANGE (created_at);
+CREATE INDEX archive_transactions_user_id_chain_seq_idx ON archive.transactions (user_id, chain_seq);
+
+-- create_transaction_partition creates the partition of the month of created_at unless it exists or was archived
+CREATE OR REPLACE FUNCTION create_transaction_partition(created_at TIMESTAMP) RETURNS TEXT AS $$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month', created_at);
+    partition_name TEXT := 'transactions_p' || to_char(month_start, 'YYYY_MM');
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('transaction_partitions'));
+    IF to_regclass('public.' || partition_name) IS NULL AND to_regclass('archive.' || partition_name) IS NULL THEN
+        EXECUTE format('CREATE TABLE public.%I PARTITION OF public.transactions FOR VALUES FROM (%L) TO (%L)',
+            partition_name, month_start, month_start + INTERVAL '1 month');
+    END IF;
+    RETURN partition_name;
+END
+$$ LANGUAGE plpgsql;
+
+-- archive_transaction_partitions moves the partitions of the months ending at or before archived_before
+-- to the archive schema and returns their names
+CREATE OR REPLACE FUNCTION archive_transaction_partitions(archived_before TIMESTAMP) RETURNS SETOF TEXT AS $$
+DECLARE
+    partition_name TEXT;
+    month_start TIMESTAMP;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('transaction_partitions'));
+    FOR partition_name, month_start IN
+        SELECT c.relname, to_date(substring(c.relname FROM 15), 'YYYY_MM')::timestamp
+        FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'public.transactions'::regclass AND c.relname ~ '^transactions_p\d{4}_\d{2}$'
+        ORDER BY c.relname
+    LOOP
+        CONTINUE WHEN month_start + INTERVAL '1 month' > archived_before;
+        EXECUTE format('ALTER TABLE public.transactions DETACH PARTITION public.%I', partition_name);
+        EXECUTE format('ALTER TABLE public.%I SET SCHEMA archive', partition_name);
+        EXECUTE format('ALTER TABLE archive.transactions ATTACH PARTITION archive.%I FOR VALUES FROM (%L) TO (%L)',
+            partition_name, month_start, month_start + INTERVAL '1 month');
+        RETURN NEXT partition_name;
+    END LOOP;
+END
+$$ LANGUAGE plpgsql;
+
+-- Move the existing transactions to the partitions of their months, the next months are created ahead
+SELECT create_transaction_partition(m)
+FROM (
+    SELECT DISTINCT date_trunc('month', created_at) FROM transactions_unpartitioned
+    UNION
+    SELECT generate_series(date_trunc('month', now() AT TIME ZONE 'UTC'), now() AT TIME ZONE 'UTC' + INTERVAL '3 months', INTERVAL '1 month')
+) AS months (m);
+INSERT INTO transactions (id, user_id, amount, created_at, idempotency_key, currency, external_reference,
+    chain_seq, hash, effective_date, description, category, source, metadata)
+SELECT id, user_id, amount, created_at, idempotency_key, currency, external_reference,
+    chain_seq, hash, effective_date, description, category, source, metadata
+FROM transactions_unpartitioned;
+INSERT INTO transaction_keys (id, user_id, created_at, idempotency_key, amount, source, external_reference)
+SELECT id, user_id, created_at, idempotency_key, amount, source, external_reference FROM transactions_unpartitioned;
+DROP TABLE transactions_unpartitioned;
+
+-- The indexes are created once the transactions are moved, the names are free once the old table is dropped
+CREATE INDEX transactions_user_id_created_at_idx ON transactions (user_id, created_at, chain_seq);
+CREATE INDEX transactions_user_id_chain_seq_idx ON transactions (user_id, chain_seq);
+CREATE INDEX transactions_user_id_idempotency_key_idx ON transactions (user_id, idempotency_key);
+CREATE INDEX transactions_user_id_external_reference_idx ON transactions (user_id, external_reference) WHERE external_reference IS NOT NULL;
@@ -0,0 +1,23 @@
+-- transaction_history is the full history of the transactions, the archived ones included
+-- The conditions on the view are pushed down to both tables, so that their partitions are pruned
+CREATE VIEW transaction_history AS
+SELECT id, user_id, amount, created_at, effective_date, idempotency_key, currency, external_reference,
+    source, description, category, metadata, chain_seq FROM transactions
+UNION ALL
+SELECT id, user_id, amount, created_at, effective_date, idempotency_key, currency, external_reference,
+    source, description, category, metadata, chain_seq FROM archive.transactions;
+
+-- balance_snapshots holds the closing balance of a user at the end of each UTC day with transactions,
+-- by created_at, and the last transaction of the day. Balances as of a time start from the nearest snapshot
+CREATE TABLE IF NOT EXISTS balance_snapshots (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    balance DOUBLE PRECISION NOT NULL,
+    last_transaction_id UUID NOT NULL,
+    PRIMARY KEY (user_id, day)
+);
+CREATE INDEX IF NOT EXISTS balance_snapshots_day_idx ON balance_snapshots (day);
+
+-- The snapshot job looks up the next day with transactions
+CREATE INDEX transactions_created_at_idx ON transactions (created_at);
+CREATE INDEX archive_transactions_created_at_idx ON archive.transactions (created_at);
@@ -0,0 +1,33 @@
+-- Scheduled transactions of the users, posted by the schedule runner at every occurrence of a cron expression
+-- or of an interval. next_run_at is the next occurrence to post, it only moves forward once the run of the
+-- occurrence is recorded in schedule_runs
+CREATE TABLE IF NOT EXISTS schedules (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount DOUBLE PRECISION NOT NULL,
+    cron TEXT,
+    interval_seconds BIGINT CHECK (interval_seconds > 0),
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP,
+    next_run_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'paused', 'ended')),
+    description TEXT,
+    category TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+    CHECK ((cron IS NULL) <> (interval_seconds IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS schedules_user_id_idx ON schedules (user_id, created_at);
+CREATE INDEX IF NOT EXISTS schedules_due_idx ON schedules (next_run_at) WHERE status = 'active';
+
+-- schedule_runs holds the outcome of every occurrence of the schedules, a posted occurrence has its transaction
+CREATE TABLE IF NOT EXISTS schedule_runs (
+    schedule_id UUID NOT NULL REFERENCES schedules (id) ON DELETE CASCADE,
+    scheduled_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('posted', 'failed')),
+    transaction_id UUID,
+    error TEXT,
+    ran_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (schedule_id, scheduled_at)
+);
@@ -0,0 +1,99 @@
+-- Version 2 of the canonical serialization of the hash chain covers every stored column of a transaction
+-- Version 1 is the serialization of 0003_hash_chain. Version 2 starts with the byte 2, followed by the version 1
+-- serialization and effective_date, then external_reference, source, description, category, the text of metadata
+-- as printed by JSONB and currency, each one as its length in bytes (4 bytes, big endian) and its UTF-8 bytes,
+-- a NULL column being the empty text.
+-- The chain_canonical_v<N> functions take the columns of a transaction in the same order,
+-- storage.canonicalTransaction must produce the same bytes
+CREATE OR REPLACE FUNCTION chain_canonical_v1(id UUID, user_id UUID, amount FLOAT8, created_at TIMESTAMP, effective_date TIMESTAMP,
+    idempotency_key UUID, currency TEXT, external_reference TEXT, source TEXT, description TEXT, category TEXT, metadata JSONB)
+RETURNS BYTEA AS $$
+    SELECT uuid_send(id)
+        || uuid_send(user_id)
+        || float8send(amount)
+        || convert_to(to_char(created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), 'UTF8')
+        || uuid_send(idempotency_key)
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION chain_text(value TEXT) RETURNS BYTEA AS $$
+    SELECT int4send(length(t.bytes)) || t.bytes FROM convert_to(coalesce(value, ''), 'UTF8') AS t (bytes)
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION chain_canonical_v2(id UUID, user_id UUID, amount FLOAT8, created_at TIMESTAMP, effective_date TIMESTAMP,
+    idempotency_key UUID, currency TEXT, external_reference TEXT, source TEXT, description TEXT, category TEXT, metadata JSONB)
+RETURNS BYTEA AS $$
+    SELECT '\x02'::bytea
+        || chain_canonical_v1(id, user_id, amount, created_at, effective_date, idempotency_key,
+            currency, external_reference, source, description, category, metadata)
+        || convert_to(to_char(effective_date, 'YYYY-MM-DD"T"HH24:MI:SS.US'), 'UTF8')
+        || chain_text(external_reference)
+        || chain_text(source)
+        || chain_text(description)
+        || chain_text(category)
+        || chain_text(metadata::text)
+        || chain_text(currency)
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- rehash_transaction_chains rehashes the chains of every user, archived transactions included,
+-- from the canonical serialization of from_version to the one of to_version
+-- A chain is only rehashed up to its first broken link, so that verify-chain still reports it
+CREATE OR REPLACE FUNCTION rehash_transaction_chains(from_version INT, to_version INT) RETURNS VOID AS $$
+DECLARE
+    canonical TEXT := 'SELECT chain_canonical_v%s($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)';
+    genesis BYTEA := decode(repeat('00', 32), 'hex');
+    t RECORD;
+    current_user_id UUID;
+    broken BOOLEAN;
+    seq BIGINT;
+    previous BYTEA;
+    rehashed BYTEA;
+    serialized BYTEA;
+BEGIN
+    FOR t IN
+        SELECT true AS archived, id, user_id, amount, created_at, effective_date, idempotency_key,
+            currency, external_reference, source, description, category, metadata, chain_seq, hash
+        FROM archive.transactions WHERE chain_seq IS NOT NULL
+        UNION ALL
+        SELECT false, id, user_id, amount, created_at, effective_date, idempotency_key,
+            currency, external_reference, source, description, category, metadata, chain_seq, hash
+        FROM transactions WHERE chain_seq IS NOT NULL
+        ORDER BY user_id, chain_seq
+    LOOP
+        IF current_user_id IS DISTINCT FROM t.user_id THEN
+            IF current_user_id IS NOT NULL AND NOT broken THEN
+                UPDATE users SET chain_hash = rehashed WHERE id = current_user_id AND chain_seq = seq AND chain_hash = previous;
+            END IF;
+            current_user_id := t.user_id;
+            broken := false;
+            seq := 0;
+            previous := genesis;
+            rehashed := genesis;
+        END IF;
+        CONTINUE WHEN broken;
+
+        EXECUTE format(canonical, from_version) INTO serialized USING t.id, t.user_id, t.amount, t.created_at, t.effective_date,
+            t.idempotency_key, t.currency::text, t.external_reference, t.source, t.description, t.category, t.metadata;
+        IF t.chain_seq <> seq + 1 OR t.hash IS DISTINCT FROM sha256(previous || serialized) THEN
+            broken := true;
+            CONTINUE;
+        END IF;
+        seq := t.chain_seq;
+        previous := t.hash;
+
+        EXECUTE format(canonical, to_version) INTO serialized USING t.id, t.user_id, t.amount, t.created_at, t.effective_date,
+            t.idempotency_key, t.currency::text, t.external_reference, t.source, t.description, t.category, t.metadata;
+        rehashed := sha256(rehashed || serialized);
+        IF t.archived THEN
+            UPDATE archive.transactions SET hash = rehashed WHERE id = t.id AND created_at = t.created_at;
+        ELSE
+            UPDATE transactions SET hash = rehashed WHERE id = t.id AND created_at = t.created_at;
+        END IF;
+    END LOOP;
+
+    IF current_user_id IS NOT NULL AND NOT broken THEN
+        UPDATE users SET chain_hash = rehashed WHERE id = current_user_id AND chain_seq = seq AND chain_hash = previous;
+    END IF;
+END
+$$ LANGUAGE plpgsql;
+
+SELECT rehash_transaction_chains(1, 2);
@@ -0,0 +1,2 @@
+-- The changes of the schedules are audited with the schedule they apply to
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS schedule_id UUID;
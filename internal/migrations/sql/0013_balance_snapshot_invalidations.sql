@@ -0,0 +1,6 @@
+-- balance_snapshot_invalidations holds the days from which the snapshots of a user were dropped by a transaction
+-- created on an earlier day, e.g. an imported one. The snapshot job writes the days again from the earliest one,
+-- a day is removed once it is written
+CREATE TABLE IF NOT EXISTS balance_snapshot_invalidations (
+    day DATE PRIMARY KEY
+);
@@ -0,0 +1,7 @@
+-- attempts counts the applications of a posting that failed with an unexpected error, the posting is marked
+-- failed once it reaches the maximum so that the later postings of its user are applied.
+-- A failed posting releases its idempotency key and amount, the transaction can be posted again
+ALTER TABLE postings ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+
+ALTER TABLE postings DROP CONSTRAINT IF EXISTS postings_idempotency_key_amount_key;
+CREATE UNIQUE INDEX IF NOT EXISTS postings_idempotency_key_amount_idx ON postings (idempotency_key, amount) WHERE status <> 'failed';
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// Find returns the entries of the audit log matching the filter in ascending ID order
+// The limit defaults to 100 and is capped at 1000
+func (a *AuditRepository) Find(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	conditions := []string{"id > $1"}
+	args := []interface{}{filter.AfterID}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		conditions = append(conditions, fmt.Sprintf("actor = $%d", len(args)))
+	}
+	if filter.UserID != uuid.Nil {
+		args = append(args, filter.UserID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	args = append(args, limit)
+
+	query := `SELECT id, created_at, action, actor, request_id, client_ip, user_id, transaction_id, schedule_id, balance_before, balance_after, payload_hash
+		FROM audit_log WHERE ` + strings.Join(conditions, " AND ") + fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var entry audit.Entry
+		var requestID, clientIP sql.NullString
+		var transactionID, scheduleID uuid.NullUUID
+		var payloadHash []byte
+		err := rows.Scan(&entry.ID,
+			&entry.CreatedAt,
+			&entry.Action,
+			&entry.Actor,
+			&requestID,
+			&clientIP,
+			&entry.UserID,
+			&transactionID,
+			&scheduleID,
+			&entry.BalanceBefore,
+			&entry.BalanceAfter,
+			&payloadHash)
+		if err != nil {
+			return nil, err
+		}
+		entry.RequestID = requestID.String
+		entry.ClientIP = clientIP.String
+		if transactionID.Valid {
+			entry.TransactionID = &transactionID.UUID
+		}
+		if scheduleID.Valid {
+			entry.ScheduleID = &scheduleID.UUID
+		}
+		entry.PayloadHash = hex.EncodeToString(payloadHash)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// auditTransactions records the inserted transactions in the audit log with the metadata of the context
+// The balances are the balances of the users before the transactions, they are not modified
+func auditTransactions(ctx context.Context, tx *sql.Tx, action string, transactions []Transaction, inserted map[uuid.UUID]bool, balances map[uuid.UUID]decimal.Decimal) error {
+	running := map[uuid.UUID]decimal.Decimal{}
+	for userID, balance := range balances {
+		running[userID] = balance
+	}
+
+	const columns = 9
+	values := []string{}
+	args := []interface{}{}
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		query := `INSERT INTO audit_log (action, actor, request_id, client_ip, user_id, transaction_id, balance_before, balance_after, payload_hash) VALUES ` +
+			strings.Join(values, ", ")
+		_, err := tx.ExecContext(ctx, query, args...)
+		values, args = values[:0], args[:0]
+		return err
+	}
+
+	for _, transaction := range transactions {
+		if !inserted[transaction.ID] {
+			continue
+		}
+		before := running[transaction.UserID]
+		after := before.Add(transaction.Amount)
+		running[transaction.UserID] = after
+		metadata := audit.TransactionFromContext(ctx, transaction.ID)
+
+		placeholders := make([]string, columns)
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args,
+			action,
+			metadata.Actor,
+			nullString(metadata.RequestID),
+			nullString(metadata.ClientIP),
+			transaction.UserID,
+			transaction.ID,
+			before,
+			after,
+			nullBytes(metadata.PayloadHash))
+		if len(values) == insertChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// auditSchedule records a change of a schedule in the audit log with the metadata of the context
+// The balance of the user is left unchanged by the change, it is recorded both before and after
+func auditSchedule(ctx context.Context, tx *sql.Tx, action string, schedule Schedule) error {
+	metadata := audit.FromContext(ctx)
+	_, err := tx.ExecContext(ctx, `INSERT INTO audit_log (action, actor, request_id, client_ip, user_id, schedule_id, balance_before, balance_after, payload_hash)
+		SELECT $1, $2, $3, $4, id, $6, balance, balance, $7 FROM users WHERE id = $5`,
+		action,
+		metadata.Actor,
+		nullString(metadata.RequestID),
+		nullString(metadata.ClientIP),
+		schedule.UserID,
+		schedule.ID,
+		nullBytes(metadata.PayloadHash))
+	return err
+}
+
+func nullBytes(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestAuditLog_RecordsMutations(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	transactionRepository := NewTransactionRepository(testEnv.DB)
+	userRepository := NewUserRepository(testEnv.DB)
+	auditRepository := NewAuditRepository(testEnv.DB)
+
+	user := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+	if err := userRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	payloadHash := sha256.Sum256([]byte(`{"amount":10}`))
+	ctx := audit.NewContext(testEnv.Context, audit.Metadata{
+		Actor:       audit.ActorAdmin,
+		RequestID:   "request-1",
+		ClientIP:    "192.0.2.1",
+		PayloadHash: payloadHash[:],
+	})
+	first := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(10), CreatedAt: time.Now(), IdempotencyKey: uuid.New()}
+	if _, err := transactionRepository.AddTransaction(ctx, first); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	batch := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(5), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(2.5), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+	}
+	if _, err := transactionRepository.AddTransactions(testEnv.Context, batch, true); err != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+
+	// Act
+	all, err := auditRepository.Find(testEnv.Context, audit.Filter{UserID: user.ID})
+	if err != nil {
+		t.Fatalf("failed to find audit entries: %v", err)
+	}
+	byActor, err := auditRepository.Find(testEnv.Context, audit.Filter{Actor: audit.ActorAdmin})
+	if err != nil {
+		t.Fatalf("failed to find audit entries: %v", err)
+	}
+	page, err := auditRepository.Find(testEnv.Context, audit.Filter{UserID: user.ID, AfterID: all[0].ID, Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to find audit entries: %v", err)
+	}
+
+	// Assert
+	if assert.Len(t, all, 3) {
+		assert.Equal(t, audit.ActorAdmin, all[0].Actor)
+		assert.Equal(t, "request-1", all[0].RequestID)
+		assert.Equal(t, "192.0.2.1", all[0].ClientIP)
+		assert.Equal(t, hex.EncodeToString(payloadHash[:]), all[0].PayloadHash)
+		assert.Equal(t, first.ID, *all[0].TransactionID)
+		assert.True(t, all[0].BalanceBefore.Equal(decimal.Zero))
+		assert.True(t, all[0].BalanceAfter.Equal(decimal.NewFromFloat(10)))
+
+		assert.Equal(t, audit.ActorSystem, all[2].Actor, "mutations without metadata are attributed to the system")
+		assert.Empty(t, all[2].PayloadHash)
+		assert.True(t, all[2].BalanceBefore.Equal(decimal.NewFromFloat(15)))
+		assert.True(t, all[2].BalanceAfter.Equal(decimal.NewFromFloat(17.5)))
+	}
+	assert.Len(t, byActor, 1)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, all[1].ID, page[0].ID)
+	}
+}
+
+func TestAuditLog_AppendOnly(t *testing.T) {
+	testCases := []struct {
+		name      string
+		statement string
+	}{
+		{
+			name:      "Update",
+			statement: "UPDATE audit_log SET actor = 'someone else'",
+		},
+		{
+			name:      "Delete",
+			statement: "DELETE FROM audit_log",
+		},
+		{
+			name:      "Truncate",
+			statement: "TRUNCATE audit_log",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			testEnv, err := utils.CreateTestEnv()
+			if err != nil {
+				t.Fatalf("failed to create env: %v", err)
+			}
+			defer testEnv.Cleanup()
+
+			transactionRepository := NewTransactionRepository(testEnv.DB)
+			userRepository := NewUserRepository(testEnv.DB)
+
+			user := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+			if err := userRepository.Add(testEnv.Context, user); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+			transaction := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(10), CreatedAt: time.Now(), IdempotencyKey: uuid.New()}
+			if _, err := transactionRepository.AddTransaction(testEnv.Context, transaction); err != nil {
+				t.Fatalf("failed to add transaction: %v", err)
+			}
+
+			// Act
+			_, err = testEnv.DB.ExecContext(testEnv.Context, tc.statement)
+
+			// Assert
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "audit_log is append-only")
+			}
+		})
+	}
+}
+
+func TestAuditLog_RecordsScheduleChanges(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	userRepository := NewUserRepository(testEnv.DB)
+	scheduleRepository := NewScheduleRepository(testEnv.DB)
+	auditRepository := NewAuditRepository(testEnv.DB)
+
+	user := User{ID: uuid.New(), Balance: decimal.NewFromFloat(7)}
+	if err := userRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	ctx := audit.NewContext(testEnv.Context, audit.Metadata{Actor: audit.ActorAnonymous, RequestID: "request-1"})
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := Schedule{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(10), Cron: "@daily", StartAt: now, NextRunAt: now}
+
+	// Act
+	_, err = scheduleRepository.AddSchedule(ctx, schedule)
+	if err != nil {
+		t.Fatalf("failed to add schedule: %v", err)
+	}
+	_, err = scheduleRepository.UpdateSchedule(ctx, schedule.ID, SchedulePaused, now)
+	if err != nil {
+		t.Fatalf("failed to pause schedule: %v", err)
+	}
+	_, err = scheduleRepository.UpdateSchedule(ctx, schedule.ID, ScheduleActive, now)
+	if err != nil {
+		t.Fatalf("failed to resume schedule: %v", err)
+	}
+	_, missingErr := scheduleRepository.UpdateSchedule(ctx, uuid.New(), SchedulePaused, now)
+	entries, err := auditRepository.Find(testEnv.Context, audit.Filter{UserID: user.ID})
+
+	// Assert
+	assert.ErrorIs(t, missingErr, ErrScheduleNotFound)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		for i, action := range []string{audit.ActionCreateSchedule, audit.ActionPauseSchedule, audit.ActionResumeSchedule} {
+			assert.Equal(t, action, entries[i].Action)
+			assert.Equal(t, audit.ActorAnonymous, entries[i].Actor)
+			assert.Equal(t, "request-1", entries[i].RequestID)
+			assert.Equal(t, schedule.ID, *entries[i].ScheduleID)
+			assert.Nil(t, entries[i].TransactionID)
+			assert.True(t, entries[i].BalanceBefore.Equal(decimal.NewFromFloat(7)))
+			assert.True(t, entries[i].BalanceAfter.Equal(decimal.NewFromFloat(7)))
+		}
+	}
+}
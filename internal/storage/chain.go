@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Reasons of a broken link of a hash chain
+const (
+	ChainHashMismatch   = "hash mismatch"
+	ChainMissingEntry   = "missing transaction"
+	ChainUnchainedEntry = "unchained transaction"
+	ChainHeadMismatch   = "head mismatch"
+)
+
+// genesisHash is the previous hash of the first transaction of a user
+var genesisHash = make([]byte, sha256.Size)
+
+// chainHead is the sequence number and hash of the last transaction of a user's chain
+type chainHead struct {
+	seq  int64
+	hash []byte
+}
+
+// ChainReport is the result of the verification of a user's hash chain
+// Break is nil if the chain is intact
+type ChainReport struct {
+	UserID       uuid.UUID
+	Transactions int64
+	Break        *ChainBreak
+}
+
+// ChainBreak is the first broken link of a hash chain
+// TransactionID is the zero UUID if no transaction has the broken sequence number
+type ChainBreak struct {
+	Seq           int64
+	TransactionID uuid.UUID
+	Reason        string
+	ExpectedHash  []byte
+	ActualHash    []byte
+}
+
+// chainTime is the created_at of a transaction as stored by the database
+// TIMESTAMP columns have a microsecond precision
+func chainTime(t time.Time) time.Time {
+	return t.Round(time.Microsecond)
+}
+
+// chainVersion is the version of the canonical serialization hashed by the chains
+// Changing the serialization takes a new version and a migration rehashing the chains
+const chainVersion = 2
+
+// canonicalTransaction returns the serialization of a transaction covered by its hash in the given version
+// Version 1 only covers the id, user_id, amount, created_at and idempotency_key. Version 2 starts with the byte 2
+// and covers every stored column. It must produce the same bytes as the chain_canonical_v<N> function of the migrations
+func canonicalTransaction(transaction Transaction, version int) []byte {
+	var buf bytes.Buffer
+	if version > 1 {
+		buf.WriteByte(byte(version))
+	}
+	buf.Write(transaction.ID[:])
+	buf.Write(transaction.UserID[:])
+	binary.Write(&buf, binary.BigEndian, math.Float64bits(transaction.Amount.InexactFloat64()))
+	buf.WriteString(chainTime(transaction.CreatedAt).Format("2006-01-02T15:04:05.000000"))
+	buf.Write(transaction.IdempotencyKey[:])
+	if version >= 2 {
+		buf.WriteString(chainTime(effectiveDate(transaction)).Format("2006-01-02T15:04:05.000000"))
+		writeChainText(&buf, transaction.ExternalReference)
+		writeChainText(&buf, transaction.Source)
+		writeChainText(&buf, transaction.Description)
+		writeChainText(&buf, transaction.Category)
+		writeChainText(&buf, chainMetadata(transaction.Metadata))
+		writeChainText(&buf, transaction.Currency)
+	}
+	return buf.Bytes()
+}
+
+// writeChainText writes a text field of the canonical serialization, its length in bytes (4 bytes, big endian) and its UTF-8 bytes
+// A missing field is the empty text
+func writeChainText(buf *bytes.Buffer, text string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(text)))
+	buf.WriteString(text)
+}
+
+// chainMetadata returns the metadata of a transaction as Postgres prints the JSONB column it is stored in,
+// the empty text if it has none
+// The keys of the objects are ordered by length and then by bytes, the numbers keep the digits of their JSON
+func chainMetadata(metadata map[string]interface{}) string {
+	encoded, err := encodeMetadata(metadata)
+	if err != nil || !encoded.Valid {
+		// Metadata that cannot be encoded is rejected when the transaction is stored
+		return ""
+	}
+	decoder := json.NewDecoder(strings.NewReader(encoded.String))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+	var buf strings.Builder
+	writeJSONB(&buf, value)
+	return buf.String()
+}
+
+// writeJSONB writes a decoded JSON value as the output function of the JSONB type
+func writeJSONB(buf *strings.Builder, value interface{}) {
+	switch value := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(value))
+	case json.Number:
+		// JSONB stores numbers as NUMERIC, which prints them without exponent
+		number, err := decimal.NewFromString(value.String())
+		if err != nil {
+			buf.WriteString(value.String())
+			return
+		}
+		places := int32(0)
+		if number.Exponent() < 0 {
+			places = -number.Exponent()
+		}
+		buf.WriteString(number.StringFixed(places))
+	case string:
+		writeJSONBString(buf, value)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, element := range value {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJSONB(buf, element)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJSONBString(buf, key)
+			buf.WriteString(": ")
+			writeJSONB(buf, value[key])
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// writeJSONBString writes a JSON string escaped as the output function of the JSONB type
+func writeJSONBString(buf *strings.Builder, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		default:
+			if c < ' ' {
+				fmt.Fprintf(buf, `\u%04x`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// chainHash returns the hash of a transaction following the given previous hash
+func chainHash(previous []byte, transaction Transaction) []byte {
+	return chainHashVersion(previous, transaction, chainVersion)
+}
+
+// chainHashVersion returns the hash of a transaction in the given version of the canonical serialization
+func chainHashVersion(previous []byte, transaction Transaction, version int) []byte {
+	h := sha256.New()
+	h.Write(previous)
+	h.Write(canonicalTransaction(transaction, version))
+	return h.Sum(nil)
+}
+
+// chainTransactions appends the inserted transactions to the chains of their users
+// in the order of the slice and moves the heads of the chains
+func chainTransactions(ctx context.Context, tx *sql.Tx, transactions []Transaction, inserted map[uuid.UUID]bool, heads map[uuid.UUID]chainHead) error {
+	values := []string{}
+	args := []interface{}{}
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		// created_at prunes the partitions not holding the transactions
+		query := `UPDATE transactions SET chain_seq = v.chain_seq, hash = v.hash FROM (VALUES ` +
+			strings.Join(values, ", ") +
+			`) AS v (id, created_at, chain_seq, hash) WHERE transactions.id = v.id AND transactions.created_at = v.created_at`
+		_, err := tx.ExecContext(ctx, query, args...)
+		values, args = values[:0], args[:0]
+		return err
+	}
+
+	// The sequence numbers of the heads before the transactions, to collect every moved user once
+	startSeqs := map[uuid.UUID]int64{}
+	for userID, head := range heads {
+		startSeqs[userID] = head.seq
+	}
+
+	moved := []uuid.UUID{}
+	for _, transaction := range transactions {
+		if !inserted[transaction.ID] {
+			continue
+		}
+		head := heads[transaction.UserID]
+		if head.seq == startSeqs[transaction.UserID] {
+			moved = append(moved, transaction.UserID)
+		}
+		head = chainHead{seq: head.seq + 1, hash: chainHash(head.hash, transaction)}
+		heads[transaction.UserID] = head
+
+		values = append(values, fmt.Sprintf("($%d::uuid, $%d::timestamp, $%d::bigint, $%d::bytea)", len(args)+1, len(args)+2, len(args)+3, len(args)+4))
+		args = append(args, transaction.ID, chainTime(transaction.CreatedAt), head.seq, head.hash)
+		if len(values) == insertChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(moved); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(moved) {
+			end = len(moved)
+		}
+		for _, userID := range moved[start:end] {
+			values = append(values, fmt.Sprintf("($%d::uuid, $%d::bigint, $%d::bytea)", len(args)+1, len(args)+2, len(args)+3))
+			args = append(args, userID, heads[userID].seq, heads[userID].hash)
+		}
+		query := `UPDATE users SET chain_seq = v.chain_seq, chain_hash = v.hash FROM (VALUES ` +
+			strings.Join(values, ", ") +
+			`) AS v (id, chain_seq, hash) WHERE users.id = v.id`
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		values, args = values[:0], args[:0]
+	}
+	return nil
+}
+
+// VerifyChain recomputes the hash chain of a user and reports its first broken link
+// The chain is read from a single snapshot, so that concurrent transactions cannot break it.
+// The archived transactions are the beginning of the chain, they are read from the archive schema
+// If the user is not found, ErrUserNotFound is returned
+func (t *TransactionRepository) VerifyChain(ctx context.Context, userID uuid.UUID) (ChainReport, error) {
+	tx, err := t.replica.reader(ctx, t.db).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return ChainReport{}, err
+	}
+	defer tx.Rollback()
+
+	var head chainHead
+	err = tx.QueryRowContext(ctx, "SELECT chain_seq, chain_hash FROM users WHERE id = $1", userID).Scan(&head.seq, &head.hash)
+	if err == sql.ErrNoRows {
+		return ChainReport{}, ErrUserNotFound
+	}
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+transactionColumns+`, chain_seq, hash FROM (
+			SELECT `+transactionColumns+`, chain_seq, hash FROM archive.transactions WHERE user_id = $1
+			UNION ALL
+			SELECT `+transactionColumns+`, chain_seq, hash FROM transactions WHERE user_id = $1
+		) AS chain ORDER BY chain_seq NULLS LAST, created_at, id`, userID)
+	if err != nil {
+		return ChainReport{}, err
+	}
+	defer rows.Close()
+
+	report := ChainReport{UserID: userID}
+	previous := chainHead{hash: genesisHash}
+	for rows.Next() {
+		var seq sql.NullInt64
+		var hash []byte
+		transaction, err := scanTransaction(rows, &seq, &hash)
+		if err != nil {
+			return ChainReport{}, err
+		}
+		report.Transactions++
+
+		expected := chainHash(previous.hash, transaction)
+		switch {
+		case !seq.Valid:
+			report.Break = &ChainBreak{Seq: previous.seq + 1, TransactionID: transaction.ID, Reason: ChainUnchainedEntry, ExpectedHash: expected}
+		case seq.Int64 != previous.seq+1:
+			report.Break = &ChainBreak{Seq: previous.seq + 1, Reason: ChainMissingEntry}
+		case !bytes.Equal(hash, expected):
+			report.Break = &ChainBreak{Seq: seq.Int64, TransactionID: transaction.ID, Reason: ChainHashMismatch, ExpectedHash: expected, ActualHash: hash}
+		}
+		if report.Break != nil {
+			return report, nil
+		}
+		previous = chainHead{seq: seq.Int64, hash: hash}
+	}
+	if err := rows.Err(); err != nil {
+		return ChainReport{}, err
+	}
+
+	// Transactions deleted from the end of the chain are only noticed by the head
+	if previous.seq != head.seq || !bytes.Equal(previous.hash, head.hash) {
+		report.Break = &ChainBreak{Seq: head.seq, Reason: ChainHeadMismatch, ExpectedHash: head.hash, ActualHash: previous.hash}
+	}
+	return report, nil
+}
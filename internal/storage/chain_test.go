@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestCanonicalTransaction_RoundsToMicroseconds(t *testing.T) {
+	// Assign
+	transaction := Transaction{
+		ID:             uuid.New(),
+		UserID:         uuid.New(),
+		Amount:         decimal.NewFromFloat(100.5),
+		CreatedAt:      time.Date(2022, 1, 1, 10, 0, 0, 1500, time.UTC),
+		IdempotencyKey: uuid.New(),
+	}
+	stored := transaction
+	stored.CreatedAt = time.Date(2022, 1, 1, 10, 0, 0, 2000, time.UTC)
+
+	// Act
+	canonical := canonicalTransaction(transaction, chainVersion)
+
+	// Assert
+	assert.Equal(t, byte(chainVersion), canonical[0])
+	assert.Len(t, canonical, 1+16+16+8+len("2022-01-01T10:00:00.000002")+16+len("2022-01-01T10:00:00.000002")+6*4)
+	assert.Contains(t, string(canonical), "2022-01-01T10:00:00.000002")
+	assert.Equal(t, chainHash(genesisHash, stored), chainHash(genesisHash, transaction))
+}
+
+func TestChainMetadata_PrintsAsJSONB(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metadata map[string]interface{}
+		expected string
+	}{
+		{"No metadata", nil, ""},
+		{"Keys by length", map[string]interface{}{"bb": "x", "a": true, "c": nil}, `{"a": true, "c": null, "bb": "x"}`},
+		{"Numbers", map[string]interface{}{"a": 1.5, "b": 1e21, "c": 1e-7, "d": 100}, `{"a": 1.5, "b": 1000000000000000000000, "c": 0.0000001, "d": 100}`},
+		{"Nested", map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"y": 1, "x": 2}}}, `{"a": [1, {"x": 2, "y": 1}]}`},
+		{"Escapes", map[string]interface{}{"a": "\"\\\n\x01<é"}, `{"a": "\"\\\n\u0001<é"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			printed := chainMetadata(tc.metadata)
+
+			// Assert
+			assert.Equal(t, tc.expected, printed)
+		})
+	}
+}
+
+func TestVerifyChain_ReportsFirstBrokenLink(t *testing.T) {
+	testCases := []struct {
+		name           string
+		tamper         string
+		expectedSeq    int64
+		expectedReason string
+	}{
+		{
+			name: "Intact chain",
+		},
+		{
+			name:           "Edited amount",
+			tamper:         "UPDATE transactions SET amount = amount + 1 WHERE chain_seq = 2 AND user_id = $1",
+			expectedSeq:    2,
+			expectedReason: ChainHashMismatch,
+		},
+		{
+			name:           "Edited effective date",
+			tamper:         "UPDATE transactions SET effective_date = effective_date - INTERVAL '1 day' WHERE chain_seq = 2 AND user_id = $1",
+			expectedSeq:    2,
+			expectedReason: ChainHashMismatch,
+		},
+		{
+			name:           "Edited metadata",
+			tamper:         `UPDATE transactions SET metadata = '{"order": 2}' WHERE chain_seq = 3 AND user_id = $1`,
+			expectedSeq:    3,
+			expectedReason: ChainHashMismatch,
+		},
+		{
+			name:           "Edited currency",
+			tamper:         "UPDATE transactions SET currency = 'USD' WHERE chain_seq = 4 AND user_id = $1",
+			expectedSeq:    4,
+			expectedReason: ChainHashMismatch,
+		},
+		{
+			name:           "Deleted transaction",
+			tamper:         "DELETE FROM transactions WHERE chain_seq = 2 AND user_id = $1",
+			expectedSeq:    2,
+			expectedReason: ChainMissingEntry,
+		},
+		{
+			name:           "Deleted last transaction",
+			tamper:         "DELETE FROM transactions WHERE chain_seq = 4 AND user_id = $1",
+			expectedSeq:    4,
+			expectedReason: ChainHeadMismatch,
+		},
+		{
+			name: "Transaction inserted outside of the chain",
+			tamper: `INSERT INTO transactions (id, user_id, amount, created_at, idempotency_key)
+				VALUES (gen_random_uuid(), $1, 1, now(), gen_random_uuid())`,
+			expectedSeq:    5,
+			expectedReason: ChainUnchainedEntry,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			testEnv, err := utils.CreateTestEnv()
+			if err != nil {
+				t.Fatalf("failed to create env: %v", err)
+			}
+			defer testEnv.Cleanup()
+
+			transactionRepository := NewTransactionRepository(testEnv.DB)
+			userRepository := NewUserRepository(testEnv.DB)
+
+			user := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+			if err := userRepository.Add(testEnv.Context, user); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+
+			newTransaction := func(amount float64) Transaction {
+				return Transaction{
+					UserID:         user.ID,
+					Amount:         decimal.NewFromFloat(amount),
+					ID:             uuid.New(),
+					CreatedAt:      time.Now(),
+					IdempotencyKey: uuid.New(),
+					Description:    "Order\n\"é\"",
+					Category:       "shopping",
+					Currency:       "EUR",
+					Metadata:       map[string]interface{}{"order": amount, "items": []interface{}{"a", 1e21}, "id": nil},
+				}
+			}
+
+			// Every way of adding transactions extends the same chain
+			if _, err := transactionRepository.AddTransaction(testEnv.Context, newTransaction(10.1)); err != nil {
+				t.Fatalf("failed to add transaction: %v", err)
+			}
+			if _, err := transactionRepository.AddTransactions(testEnv.Context, []Transaction{newTransaction(20.2), newTransaction(30.3)}, true); err != nil {
+				t.Fatalf("failed to add transactions: %v", err)
+			}
+			if _, err := transactionRepository.ImportTransactions(testEnv.Context, []Transaction{newTransaction(40.4)}, false); err != nil {
+				t.Fatalf("failed to import transactions: %v", err)
+			}
+
+			if tc.tamper != "" {
+				if _, err := testEnv.DB.ExecContext(testEnv.Context, tc.tamper, user.ID); err != nil {
+					t.Fatalf("failed to tamper with the transactions: %v", err)
+				}
+			}
+
+			// Act
+			report, err := transactionRepository.VerifyChain(testEnv.Context, user.ID)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, user.ID, report.UserID)
+			if tc.expectedReason == "" {
+				assert.Nil(t, report.Break)
+				assert.Equal(t, int64(4), report.Transactions)
+				return
+			}
+			if assert.NotNil(t, report.Break) {
+				assert.Equal(t, tc.expectedSeq, report.Break.Seq)
+				assert.Equal(t, tc.expectedReason, report.Break.Reason)
+			}
+		})
+	}
+}
+
+func TestVerifyChain_UnknownUser(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	transactionRepository := NewTransactionRepository(testEnv.DB)
+
+	// Act
+	_, err = transactionRepository.VerifyChain(testEnv.Context, uuid.New())
+
+	// Assert
+	assert.Equal(t, ErrUserNotFound, err)
+}
@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/storage/storagetest"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestConformance_Postgres(t *testing.T) {
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Stores {
+		return storagetest.Stores{
+			Transactions: storage.NewTransactionRepository(testEnv.DB),
+			Users:        storage.NewUserRepository(testEnv.DB),
+			Postings:     storage.NewPostingRepository(testEnv.DB),
+			Schedules:    storage.NewScheduleRepository(testEnv.DB),
+		}
+	})
+}
+
+func TestConformance_Memory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Stores {
+		store := storage.NewMemoryStore()
+		return storagetest.Stores{Transactions: store, Users: store, Postings: store, Schedules: store}
+	})
+}
+
+func TestConformance_SQLite(t *testing.T) {
+	db, err := storage.OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Stores {
+		store := storage.NewSQLiteStore(db)
+		return storagetest.Stores{Transactions: store, Users: store}
+	})
+}
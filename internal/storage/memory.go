@@ -0,0 +1,709 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// MemoryStore keeps the users and transactions in memory
+// It has the semantics of the Postgres repositories: amounts and balances are rounded to
+// double precision, created_at to microseconds, and transactions are chained per user.
+// A single mutex stands in for the row locks, so every write is serialized.
+// It keeps no audit log and is meant for tests and local use
+type MemoryStore struct {
+	mu           sync.Mutex
+	clock        Clock
+	users        map[uuid.UUID]*memoryUser
+	transactions map[uuid.UUID]Transaction
+	// keys holds the idempotency key and amount of every transaction
+	keys map[idempotencyKey]bool
+	// references holds the source and external reference of every transaction with a source
+	references map[externalReference]bool
+	// postings are the queued postings in the order they were queued
+	postings    []*Posting
+	postingKeys map[idempotencyKey]bool
+	// schedules are the schedules in the order they were added, runs the runs of every schedule
+	schedules []*Schedule
+	runs      map[uuid.UUID][]ScheduleRun
+	// scheduler is set while a schedule runner leads
+	scheduler bool
+}
+
+type memoryUser struct {
+	balance      decimal.Decimal
+	head         chainHead
+	transactions []chainedTransaction
+}
+
+type chainedTransaction struct {
+	transaction Transaction
+	hash        []byte
+}
+
+type idempotencyKey struct {
+	key    uuid.UUID
+	amount float64
+}
+
+type externalReference struct {
+	source    string
+	reference string
+}
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// MemoryStoreOption configures the memory store
+type MemoryStoreOption func(*MemoryStore)
+
+// WithMemoryClock sets the clock the memory store takes the created_at of the transactions from,
+// it stands in for the clock of the database
+func WithMemoryClock(clock Clock) MemoryStoreOption {
+	return func(m *MemoryStore) {
+		m.clock = clock
+	}
+}
+
+// NewMemoryStore returns an empty memory store
+// By default the created_at of the transactions is taken from the system clock
+func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
+	m := &MemoryStore{
+		clock:        systemClock{},
+		users:        map[uuid.UUID]*memoryUser{},
+		transactions: map[uuid.UUID]Transaction{},
+		keys:         map[idempotencyKey]bool{},
+		references:   map[externalReference]bool{},
+		postingKeys:  map[idempotencyKey]bool{},
+		runs:         map[uuid.UUID][]ScheduleRun{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Add adds a new user
+func (m *MemoryStore) Add(ctx context.Context, u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[u.ID]; ok {
+		return fmt.Errorf("user %s already exists", u.ID)
+	}
+	m.users[u.ID] = &memoryUser{
+		balance: doublePrecision(u.Balance),
+		head:    chainHead{hash: genesisHash},
+	}
+	return nil
+}
+
+// FindByID returns a user by ID
+// If the user is not found, ErrUserNotFound is returned
+func (m *MemoryStore) FindByID(ctx context.Context, id uuid.UUID) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return User{ID: id, Balance: user.balance}, nil
+}
+
+// AddTransaction adds a transaction and updates the balance of its user
+// If the ID, the idempotency key and amount or the source and external reference were already used,
+// ErrDuplicateTransaction is returned
+func (m *MemoryStore) AddTransaction(ctx context.Context, transaction Transaction) (Transaction, error) {
+	metadata, err := storedMetadata(transaction.Metadata)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Metadata = metadata
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[transaction.UserID]; !ok {
+		return Transaction{}, ErrUserNotFound
+	}
+	if m.isDuplicate(transaction) {
+		return Transaction{}, ErrDuplicateTransaction
+	}
+
+	stored := m.add(transaction, m.clock.Now())
+	return Transaction{
+		ID:                transaction.ID,
+		UserID:            transaction.UserID,
+		Amount:            transaction.Amount,
+		CreatedAt:         stored.CreatedAt,
+		EffectiveDate:     stored.EffectiveDate,
+		IdempotencyKey:    transaction.IdempotencyKey,
+		Currency:          transaction.Currency,
+		ExternalReference: transaction.ExternalReference,
+		Source:            transaction.Source,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Metadata:          transaction.Metadata,
+	}, nil
+}
+
+// AddTransactions adds a batch of transactions with the semantics of TransactionRepository.AddTransactions
+func (m *MemoryStore) AddTransactions(ctx context.Context, transactions []Transaction, atomic bool) ([]error, error) {
+	metadata := make([]map[string]interface{}, len(transactions))
+	for i, transaction := range transactions {
+		var err error
+		if metadata[i], err = storedMetadata(transaction.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]error, len(transactions))
+	missing := false
+	for i, transaction := range transactions {
+		if _, ok := m.users[transaction.UserID]; !ok {
+			results[i] = ErrUserNotFound
+			missing = true
+		}
+	}
+	if atomic && missing {
+		return abortBatch(results), nil
+	}
+
+	// Check the duplicates against the earlier transactions of the batch as well
+	batchIDs := map[uuid.UUID]bool{}
+	batchKeys := map[idempotencyKey]bool{}
+	batchReferences := map[externalReference]bool{}
+	duplicate := false
+	for i, transaction := range transactions {
+		if results[i] != nil {
+			continue
+		}
+		key := keyOf(transaction)
+		reference, hasReference := referenceOf(transaction)
+		if m.isDuplicate(transaction) || batchIDs[transaction.ID] || batchKeys[key] || (hasReference && batchReferences[reference]) {
+			results[i] = ErrDuplicateTransaction
+			duplicate = true
+			continue
+		}
+		batchIDs[transaction.ID] = true
+		batchKeys[key] = true
+		if hasReference {
+			batchReferences[reference] = true
+		}
+	}
+	if atomic && duplicate {
+		return abortBatch(results), nil
+	}
+
+	now := m.clock.Now()
+	for i, transaction := range transactions {
+		transactions[i].CreatedAt = storedTime(now)
+		transactions[i].EffectiveDate = effectiveDate(transactions[i])
+		if results[i] == nil {
+			transaction.Metadata = metadata[i]
+			m.add(transaction, now)
+		}
+	}
+	return results, nil
+}
+
+// GetUserTransactionHistory returns a page of the transactions of a user matching the filter, newest first
+func (m *MemoryStore) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter HistoryFilter) ([]Transaction, error) {
+	if page <= 0 {
+		page = 1
+	}
+
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := m.userTransactions(userID, func(transaction Transaction) bool {
+		return matches(transaction, filter)
+	})
+
+	start := (page - 1) * pageSize
+	if start >= len(transactions) {
+		return []Transaction{}, nil
+	}
+	end := start + pageSize
+	if end > len(transactions) {
+		end = len(transactions)
+	}
+	return transactions[start:end], nil
+}
+
+// FindTransactionByID returns a transaction by ID
+// If the transaction is not found, ErrTransactionNotFound is returned
+func (m *MemoryStore) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transaction, ok := m.transactions[transactionID]
+	if !ok {
+		return Transaction{}, ErrTransactionNotFound
+	}
+	return transaction, nil
+}
+
+// FindUserTransactionsByIdempotencyKey returns the transactions of a user added with an idempotency key, newest first
+func (m *MemoryStore) FindUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.userTransactions(userID, func(transaction Transaction) bool {
+		return transaction.IdempotencyKey == idempotencyKey
+	}), nil
+}
+
+// GetUserTransactionsBetween returns the transactions of a user created from from until to, oldest first
+func (m *MemoryStore) GetUserTransactionsBetween(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := m.userTransactions(userID, func(transaction Transaction) bool {
+		return !transaction.CreatedAt.Before(from) && transaction.CreatedAt.Before(to)
+	})
+	for i, j := 0, len(transactions)-1; i < j; i, j = i+1, j-1 {
+		transactions[i], transactions[j] = transactions[j], transactions[i]
+	}
+	return transactions, nil
+}
+
+// BalanceAsOf returns the balance of a user as of a time, the sum of the transactions created before it
+func (m *MemoryStore) BalanceAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (decimal.Decimal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balance := decimal.Zero
+	if user, ok := m.users[userID]; ok {
+		for _, chained := range user.transactions {
+			if chained.transaction.CreatedAt.Before(at) {
+				balance = doublePrecision(balance.Add(chained.transaction.Amount))
+			}
+		}
+	}
+	return balance, nil
+}
+
+// userTransactions returns the transactions of a user kept by keep, newest first, the caller holds the lock
+func (m *MemoryStore) userTransactions(userID uuid.UUID, keep func(Transaction) bool) []Transaction {
+	transactions := []Transaction{}
+	if user, ok := m.users[userID]; ok {
+		for _, chained := range user.transactions {
+			if keep(chained.transaction) {
+				transactions = append(transactions, chained.transaction)
+			}
+		}
+	}
+	// The chain is in insertion order, reversing it orders the transactions created at the same time
+	for i, j := 0, len(transactions)-1; i < j; i, j = i+1, j-1 {
+		transactions[i], transactions[j] = transactions[j], transactions[i]
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.After(transactions[j].CreatedAt)
+	})
+	return transactions
+}
+
+// VerifyChain recomputes the hash chain of a user and reports its first broken link
+// If the user is not found, ErrUserNotFound is returned
+func (m *MemoryStore) VerifyChain(ctx context.Context, userID uuid.UUID) (ChainReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return ChainReport{}, ErrUserNotFound
+	}
+
+	report := ChainReport{UserID: userID}
+	previous := chainHead{hash: genesisHash}
+	for _, chained := range user.transactions {
+		report.Transactions++
+		expected := chainHash(previous.hash, chained.transaction)
+		if !bytes.Equal(chained.hash, expected) {
+			report.Break = &ChainBreak{
+				Seq:           previous.seq + 1,
+				TransactionID: chained.transaction.ID,
+				Reason:        ChainHashMismatch,
+				ExpectedHash:  expected,
+				ActualHash:    chained.hash,
+			}
+			return report, nil
+		}
+		previous = chainHead{seq: previous.seq + 1, hash: chained.hash}
+	}
+	if previous.seq != user.head.seq || !bytes.Equal(previous.hash, user.head.hash) {
+		report.Break = &ChainBreak{Seq: user.head.seq, Reason: ChainHeadMismatch, ExpectedHash: user.head.hash, ActualHash: previous.hash}
+	}
+	return report, nil
+}
+
+// EnqueuePosting queues a pending posting with the semantics of PostingRepository.EnqueuePosting
+func (m *MemoryStore) EnqueuePosting(ctx context.Context, posting Posting) (Posting, error) {
+	metadata, err := storedMetadata(posting.Transaction.Metadata)
+	if err != nil {
+		return Posting{}, err
+	}
+	posting.Transaction.Metadata = metadata
+	entries := make([]Transaction, len(posting.Entries))
+	for i, entry := range posting.Entries {
+		if entry.Metadata, err = storedMetadata(entry.Metadata); err != nil {
+			return Posting{}, err
+		}
+		entries[i] = entry
+	}
+	posting.Entries = entries
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[posting.Transaction.UserID]; !ok {
+		return Posting{}, ErrUserNotFound
+	}
+	key := keyOf(posting.Transaction)
+	if m.postingKeys[key] || m.findPosting(posting.ID) != nil {
+		return Posting{}, ErrDuplicatePosting
+	}
+
+	posting.Transaction.Amount = doublePrecision(posting.Transaction.Amount)
+	if !posting.Transaction.EffectiveDate.IsZero() {
+		posting.Transaction.EffectiveDate = storedTime(posting.Transaction.EffectiveDate)
+	}
+	for i := range posting.Entries {
+		posting.Entries[i].Amount = doublePrecision(posting.Entries[i].Amount)
+		posting.Entries[i].EffectiveDate = posting.Transaction.EffectiveDate
+	}
+	posting.Status = PostingPending
+	posting.Error = ""
+	posting.CreatedAt = storedTime(m.clock.Now())
+	posting.ProcessedAt = time.Time{}
+
+	m.postingKeys[key] = true
+	m.postings = append(m.postings, &posting)
+	return posting, nil
+}
+
+// FindPosting returns a posting by ID
+// If the posting is not found, ErrPostingNotFound is returned
+func (m *MemoryStore) FindPosting(ctx context.Context, postingID uuid.UUID) (Posting, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	posting := m.findPosting(postingID)
+	if posting == nil {
+		return Posting{}, ErrPostingNotFound
+	}
+	return *posting, nil
+}
+
+// ApplyNextPosting adds the transaction of the oldest pending posting with the semantics of
+// PostingRepository.ApplyNextPosting, the mutex serializes the workers
+func (m *MemoryStore) ApplyNextPosting(ctx context.Context) (Posting, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, posting := range m.postings {
+		if posting.Status != PostingPending {
+			continue
+		}
+
+		now := m.clock.Now()
+		if err := m.postingError(posting); err != nil {
+			posting.Status, posting.Error = PostingFailed, err.Error()
+			delete(m.postingKeys, keyOf(posting.Transaction))
+		} else {
+			posting.Status, posting.Transaction = PostingApplied, m.add(posting.Transaction, now)
+			for _, entry := range posting.Entries {
+				m.add(entry, now)
+			}
+		}
+		posting.ProcessedAt = storedTime(now)
+		return *posting, true, nil
+	}
+	return Posting{}, false, nil
+}
+
+// postingError returns the error the transaction or an entry of a posting would fail with, the caller holds the lock
+func (m *MemoryStore) postingError(posting *Posting) error {
+	for i, transaction := range append([]Transaction{posting.Transaction}, posting.Entries...) {
+		var err error
+		switch {
+		case m.users[transaction.UserID] == nil:
+			err = ErrUserNotFound
+		case m.isDuplicate(transaction):
+			err = ErrDuplicateTransaction
+		}
+		if err != nil && i > 0 {
+			return fmt.Errorf("entry %s: %w", transaction.ID, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSchedule adds an active schedule with the semantics of ScheduleRepository.AddSchedule
+func (m *MemoryStore) AddSchedule(ctx context.Context, schedule Schedule) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[schedule.UserID]; !ok {
+		return Schedule{}, ErrUserNotFound
+	}
+	if m.findSchedule(schedule.ID) != nil {
+		return Schedule{}, fmt.Errorf("schedule %s already exists", schedule.ID)
+	}
+
+	now := storedTime(m.clock.Now())
+	schedule.Amount = doublePrecision(schedule.Amount)
+	schedule.Interval = schedule.Interval.Truncate(time.Second)
+	schedule.StartAt, schedule.NextRunAt = storedTime(schedule.StartAt), storedTime(schedule.NextRunAt)
+	if !schedule.EndAt.IsZero() {
+		schedule.EndAt = storedTime(schedule.EndAt)
+	}
+	schedule.Status, schedule.CreatedAt, schedule.UpdatedAt = ScheduleActive, now, now
+
+	m.schedules = append(m.schedules, &schedule)
+	return schedule, nil
+}
+
+// FindSchedule returns a schedule by ID
+// If the schedule is not found, ErrScheduleNotFound is returned
+func (m *MemoryStore) FindSchedule(ctx context.Context, scheduleID uuid.UUID) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule := m.findSchedule(scheduleID)
+	if schedule == nil {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return *schedule, nil
+}
+
+// ListUserSchedules returns the schedules of a user, oldest first
+func (m *MemoryStore) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedules := []Schedule{}
+	for _, schedule := range m.schedules {
+		if schedule.UserID == userID {
+			schedules = append(schedules, *schedule)
+		}
+	}
+	return schedules, nil
+}
+
+// UpdateSchedule sets the status and the next occurrence of a schedule and returns it as stored
+// If the schedule is not found, ErrScheduleNotFound is returned
+func (m *MemoryStore) UpdateSchedule(ctx context.Context, scheduleID uuid.UUID, status string, nextRunAt time.Time) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule := m.findSchedule(scheduleID)
+	if schedule == nil {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	schedule.Status, schedule.NextRunAt, schedule.UpdatedAt = status, storedTime(nextRunAt), storedTime(m.clock.Now())
+	return *schedule, nil
+}
+
+// DueSchedules returns up to limit active schedules whose next occurrence is at or before now, the most overdue first
+func (m *MemoryStore) DueSchedules(ctx context.Context, now time.Time, limit int) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []Schedule{}
+	for _, schedule := range m.schedules {
+		if schedule.Status == ScheduleActive && !schedule.NextRunAt.After(now) {
+			due = append(due, *schedule)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextRunAt.Before(due[j].NextRunAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// RecordScheduleRun records the run of the next occurrence of a schedule with the semantics of
+// ScheduleRepository.RecordScheduleRun
+func (m *MemoryStore) RecordScheduleRun(ctx context.Context, run ScheduleRun, nextRunAt time.Time, ended bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := storedTime(m.clock.Now())
+	run.ScheduledAt = storedTime(run.ScheduledAt)
+	recorded := false
+	for _, existing := range m.runs[run.ScheduleID] {
+		recorded = recorded || existing.ScheduledAt.Equal(run.ScheduledAt)
+	}
+	schedule := m.findSchedule(run.ScheduleID)
+	if schedule == nil {
+		return fmt.Errorf("schedule %s: %w", run.ScheduleID, ErrScheduleNotFound)
+	}
+	if !recorded {
+		run.RanAt = now
+		m.runs[run.ScheduleID] = append(m.runs[run.ScheduleID], run)
+	}
+
+	if !schedule.NextRunAt.Equal(run.ScheduledAt) {
+		return nil
+	}
+	schedule.NextRunAt, schedule.UpdatedAt = storedTime(nextRunAt), now
+	if ended {
+		schedule.Status = ScheduleEnded
+	}
+	return nil
+}
+
+// ListScheduleRuns returns up to limit runs of a schedule, the latest occurrence first
+func (m *MemoryStore) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]ScheduleRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runs := append([]ScheduleRun{}, m.runs[scheduleID]...)
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].ScheduledAt.After(runs[j].ScheduledAt)
+	})
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// LeadScheduler elects the caller as the schedule runner until release is called
+// If another runner leads, false is returned
+func (m *MemoryStore) LeadScheduler(ctx context.Context) (release func(), lead bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.scheduler {
+		return nil, false, nil
+	}
+	m.scheduler = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.scheduler = false
+	}, true, nil
+}
+
+// findSchedule returns the schedule with the ID or nil, the caller holds the lock
+func (m *MemoryStore) findSchedule(scheduleID uuid.UUID) *Schedule {
+	for _, schedule := range m.schedules {
+		if schedule.ID == scheduleID {
+			return schedule
+		}
+	}
+	return nil
+}
+
+// findPosting returns the posting with the ID or nil, the caller holds the lock
+func (m *MemoryStore) findPosting(postingID uuid.UUID) *Posting {
+	for _, posting := range m.postings {
+		if posting.ID == postingID {
+			return posting
+		}
+	}
+	return nil
+}
+
+// isDuplicate reports whether the ID, the idempotency key and amount or the source and
+// external reference of a transaction were already used
+func (m *MemoryStore) isDuplicate(transaction Transaction) bool {
+	_, ok := m.transactions[transaction.ID]
+	reference, hasReference := referenceOf(transaction)
+	return ok || m.keys[keyOf(transaction)] || (hasReference && m.references[reference])
+}
+
+// add stores a transaction created at now as Postgres would, appends it to the chain of its user
+// and updates the balance, the caller holds the lock
+func (m *MemoryStore) add(transaction Transaction, now time.Time) Transaction {
+	transaction.Amount = doublePrecision(transaction.Amount)
+	transaction.CreatedAt = storedTime(now)
+	transaction.EffectiveDate = effectiveDate(transaction)
+
+	user := m.users[transaction.UserID]
+	user.head = chainHead{seq: user.head.seq + 1, hash: chainHash(user.head.hash, transaction)}
+	user.transactions = append(user.transactions, chainedTransaction{transaction: transaction, hash: user.head.hash})
+	user.balance = doublePrecision(user.balance.Add(transaction.Amount))
+
+	m.transactions[transaction.ID] = transaction
+	m.keys[keyOf(transaction)] = true
+	if reference, ok := referenceOf(transaction); ok {
+		m.references[reference] = true
+	}
+	return transaction
+}
+
+func keyOf(transaction Transaction) idempotencyKey {
+	return idempotencyKey{key: transaction.IdempotencyKey, amount: transaction.Amount.InexactFloat64()}
+}
+
+// referenceOf returns the source and external reference of a transaction, only references
+// of transactions with a source are unique
+func referenceOf(transaction Transaction) (externalReference, bool) {
+	reference := externalReference{source: transaction.Source, reference: transaction.ExternalReference}
+	return reference, transaction.Source != "" && transaction.ExternalReference != ""
+}
+
+// matches reports whether a transaction matches a history filter
+func matches(transaction Transaction, filter HistoryFilter) bool {
+	if filter.Reference != "" && transaction.ExternalReference != filter.Reference {
+		return false
+	}
+	for key, value := range filter.Metadata {
+		text, ok := metadataText(transaction.Metadata[key])
+		if !ok || text != value {
+			return false
+		}
+	}
+	return true
+}
+
+// doublePrecision rounds a decimal as a DOUBLE PRECISION column does
+func doublePrecision(d decimal.Decimal) decimal.Decimal {
+	return decimal.NewFromFloat(d.InexactFloat64())
+}
+
+// storedMetadata returns the metadata of a transaction as read back from a JSONB column
+func storedMetadata(metadata map[string]interface{}) (map[string]interface{}, error) {
+	encoded, err := encodeMetadata(metadata)
+	if err != nil || !encoded.Valid {
+		return nil, err
+	}
+	return decodeMetadata([]byte(encoded.String))
+}
+
+// storedTime returns a time as read back from a TIMESTAMP column, the wall clock
+// rounded to microseconds in UTC
+func storedTime(t time.Time) time.Time {
+	t = chainTime(t)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
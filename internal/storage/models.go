@@ -7,11 +7,23 @@ import (
 type StorageClient struct {
 	TransactionRepository *TransactionRepository
 	UserRepository        *UserRepository
+	AuditRepository       *AuditRepository
+	PostingRepository     *PostingRepository
+	PartitionRepository   *PartitionRepository
+	SnapshotRepository    *SnapshotRepository
+	ScheduleRepository    *ScheduleRepository
 }
 
-func NewStorageClient(db *sql.DB) StorageClient {
+// NewStorageClient returns the Postgres repositories
+// The options apply to the repositories of the transactions and the users
+func NewStorageClient(db *sql.DB, opts ...RepositoryOption) StorageClient {
 	return StorageClient{
-		TransactionRepository: NewTransactionRepository(db),
-		UserRepository:        NewUserRepository(db),
+		TransactionRepository: NewTransactionRepository(db, opts...),
+		UserRepository:        NewUserRepository(db, opts...),
+		AuditRepository:       NewAuditRepository(db),
+		PostingRepository:     NewPostingRepository(db),
+		PartitionRepository:   NewPartitionRepository(db),
+		SnapshotRepository:    NewSnapshotRepository(db),
+		ScheduleRepository:    NewScheduleRepository(db),
 	}
 }
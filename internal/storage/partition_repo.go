@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PartitionRepository maintains the monthly partitions of the transactions table
+// A transaction can only be added once the partition of the month of its created_at exists
+type PartitionRepository struct {
+	db *sql.DB
+}
+
+func NewPartitionRepository(db *sql.DB) *PartitionRepository {
+	return &PartitionRepository{db: db}
+}
+
+// CreatePartitions creates the missing partitions of the months from from to to, both included
+// The partitions of archived months are not created again
+func (p *PartitionRepository) CreatePartitions(ctx context.Context, from time.Time, to time.Time) error {
+	months := []time.Time{}
+	for month := monthStart(from); !month.After(to); month = month.AddDate(0, 1, 0) {
+		months = append(months, month)
+	}
+	return createPartitions(ctx, p.db, months)
+}
+
+// ArchivePartitions moves the partitions of the months ending at or before before to the archive schema
+// and returns their names, oldest first
+// The archived transactions are no longer found by the queries, they are still part of the hash chains
+// and of the balances recomputed by ImportTransactions
+func (p *PartitionRepository) ArchivePartitions(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT archive_transaction_partitions($1::timestamp)", before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archived := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		archived = append(archived, name)
+	}
+	return archived, rows.Err()
+}
+
+// Partitions returns the names of the partitions of the transactions table, oldest first
+func (p *PartitionRepository) Partitions(ctx context.Context) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'public.transactions'::regclass ORDER BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
+// Maintain creates the partitions of the current month and of the monthsAhead next months, and archives the
+// partitions of the months before the retentionMonths months preceding the current month
+// A retentionMonths of 0 keeps every partition
+func (p *PartitionRepository) Maintain(ctx context.Context, now time.Time, monthsAhead int, retentionMonths int) error {
+	current := monthStart(now)
+	if err := p.CreatePartitions(ctx, current, current.AddDate(0, monthsAhead, 0)); err != nil {
+		return err
+	}
+	if retentionMonths == 0 {
+		return nil
+	}
+
+	archived, err := p.ArchivePartitions(ctx, current.AddDate(0, -retentionMonths, 0))
+	if err != nil {
+		return err
+	}
+	for _, name := range archived {
+		log.Printf("storage : archived partition %s", name)
+	}
+	return nil
+}
+
+// RunMaintenance maintains the partitions every interval until the context is done
+// The partitions are maintained right away, so that the partition of the current month exists
+// once the first maintenance succeeded
+func (p *PartitionRepository) RunMaintenance(ctx context.Context, interval time.Duration, monthsAhead int, retentionMonths int) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := p.Maintain(ctx, time.Now().UTC(), monthsAhead, retentionMonths); err != nil && ctx.Err() == nil {
+			log.Printf("storage : maintain partitions: %v", err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// createPartitions creates the missing partitions of the months
+func createPartitions(ctx context.Context, db *sql.DB, months []time.Time) error {
+	if len(months) == 0 {
+		return nil
+	}
+	dates := make([]string, len(months))
+	for i, month := range months {
+		dates[i] = month.Format("2006-01-02")
+	}
+	_, err := db.ExecContext(ctx, "SELECT create_transaction_partition(m) FROM unnest($1::timestamp[]) AS m", pq.Array(dates))
+	return err
+}
+
+// archivedMonths returns the months among months whose partitions were archived
+// It holds the lock of the partition maintenance shared until the database transaction ends, so that no month
+// is archived meanwhile. It must come first in the database transaction, before the transactions table is locked
+func archivedMonths(ctx context.Context, tx *sql.Tx, months []time.Time) (map[time.Time]bool, error) {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock_shared(hashtext('transaction_partitions'))"); err != nil {
+		return nil, err
+	}
+
+	dates := make([]string, len(months))
+	for i, month := range months {
+		dates[i] = month.Format("2006-01-02")
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT m FROM unnest($1::timestamp[]) AS m
+		WHERE to_regclass('archive.transactions_p' || to_char(m, 'YYYY_MM')) IS NOT NULL`, pq.Array(dates))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archived := map[time.Time]bool{}
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			return nil, err
+		}
+		archived[monthStart(month)] = true
+	}
+	return archived, rows.Err()
+}
+
+// transactionMonths returns the distinct months of the created_at of the transactions, oldest first
+func transactionMonths(transactions []Transaction) []time.Time {
+	unique := map[time.Time]bool{}
+	months := []time.Time{}
+	for _, transaction := range transactions {
+		month := monthStart(chainTime(transaction.CreatedAt))
+		if !unique[month] {
+			unique[month] = true
+			months = append(months, month)
+		}
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+	return months
+}
+
+// monthStart returns the beginning of the UTC month of t, as stored in the timestamp columns
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
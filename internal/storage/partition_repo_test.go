@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestTransactionMonths(t *testing.T) {
+	// Assign
+	transactions := []Transaction{
+		{CreatedAt: time.Date(2020, 3, 31, 23, 59, 59, 999999900, time.UTC)},
+		{CreatedAt: time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	// Act
+	months := transactionMonths(transactions)
+
+	// Assert
+	assert.Equal(t, []time.Time{
+		time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+	}, months, "created_at is rounded to microseconds as stored")
+}
+
+func TestMonthStart(t *testing.T) {
+	// Assign
+	at := time.Date(2020, 1, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+
+	// Act
+	month := monthStart(at)
+
+	// Assert
+	assert.Equal(t, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), month, "the month is the UTC month")
+}
+
+func TestPartitionRepository_Maintain(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	partitionRepository := NewPartitionRepository(testEnv.DB)
+	now := time.Date(2040, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	// Act
+	err = partitionRepository.Maintain(testEnv.Context, now, 2, 0)
+	partitions, partitionsErr := partitionRepository.Partitions(testEnv.Context)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, partitionsErr)
+	assert.Subset(t, partitions, []string{"transactions_p2040_06", "transactions_p2040_07", "transactions_p2040_08"})
+	assert.NotContains(t, partitions, "transactions_p2040_09")
+}
+
+func TestPartitionRepository_ArchivePartitions(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	old := Transaction{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Amount:         decimal.NewFromInt(10),
+		CreatedAt:      time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC),
+		IdempotencyKey: uuid.New(),
+	}
+	results, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, []Transaction{old}, false)
+	if err != nil || results[0] != nil {
+		t.Fatalf("failed to import transaction: %v %v", err, results)
+	}
+	recent, err := storageClient.TransactionRepository.AddTransaction(testEnv.Context, Transaction{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Amount:         decimal.NewFromInt(5),
+		IdempotencyKey: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	archived, archiveErr := storageClient.PartitionRepository.ArchivePartitions(testEnv.Context, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, oldErr := storageClient.TransactionRepository.FindTransactionByID(testEnv.Context, old.ID)
+	found, recentErr := storageClient.TransactionRepository.FindTransactionByID(testEnv.Context, recent.ID)
+	report, verifyErr := storageClient.TransactionRepository.VerifyChain(testEnv.Context, user.ID)
+	_, duplicateErr := storageClient.TransactionRepository.AddTransaction(testEnv.Context, Transaction{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Amount:         old.Amount,
+		IdempotencyKey: old.IdempotencyKey,
+	})
+
+	// Assert
+	assert.NoError(t, archiveErr)
+	assert.Equal(t, []string{"transactions_p2019_03"}, archived)
+	assert.Equal(t, ErrTransactionNotFound, oldErr, "archived transactions are not found")
+	assert.NoError(t, recentErr)
+	assert.Equal(t, recent.ID, found.ID)
+	assert.NoError(t, verifyErr)
+	assert.Nil(t, report.Break, "the archived transactions begin the chain")
+	assert.Equal(t, int64(2), report.Transactions)
+	assert.ErrorIs(t, duplicateErr, ErrDuplicateTransaction, "the idempotency keys of archived transactions stay used")
+}
+
+func TestImportTransactions_ArchivedMonth(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	old := Transaction{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Amount:         decimal.NewFromInt(10),
+		CreatedAt:      time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC),
+		IdempotencyKey: uuid.New(),
+	}
+	results, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, []Transaction{old}, false)
+	if err != nil || results[0] != nil {
+		t.Fatalf("failed to import transaction: %v %v", err, results)
+	}
+	if _, err := storageClient.PartitionRepository.ArchivePartitions(testEnv.Context, time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("failed to archive partitions: %v", err)
+	}
+
+	// Act
+	results, err = storageClient.TransactionRepository.ImportTransactions(testEnv.Context, []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(5), CreatedAt: time.Date(2019, 3, 31, 23, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(2), CreatedAt: time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+	}, false)
+	found, findErr := storageClient.UserRepository.FindByID(testEnv.Context, user.ID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []error{ErrArchivedMonth, nil}, results)
+	assert.NoError(t, findErr)
+	assert.True(t, found.Balance.Equal(decimal.NewFromInt(12)), "the archived transactions are still counted, got %s", found.Balance)
+}
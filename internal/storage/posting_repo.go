@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+// Statuses of a posting
+const (
+	PostingPending = "pending"
+	PostingApplied = "applied"
+	PostingFailed  = "failed"
+)
+
+var (
+	// ErrPostingNotFound is returned when a posting looked up by its ID does not exist
+	ErrPostingNotFound = errors.New("posting not found")
+	// ErrDuplicatePosting is returned for a posting whose idempotency key and amount were already queued
+	// by a posting that did not fail
+	ErrDuplicatePosting = errors.New("duplicate posting")
+)
+
+// maxPostingAttempts is the number of applications of a posting failing with an unexpected error
+// after which the posting is marked failed
+const maxPostingAttempts = 5
+
+// Posting is a transaction queued to be added asynchronously
+// Transaction is the transaction as queued, its CreatedAt is only set once it is added.
+// Entries are added in the same database transaction, e.g. the entries of the fee of the transaction,
+// they take effect at the effective date of the transaction.
+// Error is the reason a posting failed, ProcessedAt is zero while the posting is pending
+type Posting struct {
+	ID          uuid.UUID
+	Transaction Transaction
+	Entries     []Transaction
+	Status      string
+	Error       string
+	CreatedAt   time.Time
+	ProcessedAt time.Time
+}
+
+// postingDetails are the details of a queued transaction, kept in a JSONB column
+type postingDetails struct {
+	Currency          string                 `json:"currency,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Category          string                 `json:"category,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	ExternalReference string                 `json:"external_reference,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	Entries           []postingEntry         `json:"entries,omitempty"`
+}
+
+// postingEntry is an entry added with the transaction of a posting
+type postingEntry struct {
+	ID             uuid.UUID              `json:"id"`
+	UserID         uuid.UUID              `json:"user_id"`
+	Amount         decimal.Decimal        `json:"amount"`
+	IdempotencyKey uuid.UUID              `json:"idempotency_key"`
+	Currency       string                 `json:"currency,omitempty"`
+	Description    string                 `json:"description,omitempty"`
+	Category       string                 `json:"category,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// postingColumns are the columns read by scanPosting
+const postingColumns = `id, user_id, transaction_id, amount, effective_date, idempotency_key, details,
+	status, error, created_at, processed_at`
+
+// PostingRepository is the Postgres queue of the postings
+type PostingRepository struct {
+	db *sql.DB
+}
+
+func NewPostingRepository(db *sql.DB) *PostingRepository {
+	return &PostingRepository{db: db}
+}
+
+// EnqueuePosting queues a pending posting and returns it as stored
+// The audit metadata of the context is kept with the posting and written with its transaction
+// If the user is not found, ErrUserNotFound is returned. If the idempotency key and amount
+// were already queued by a posting that did not fail, ErrDuplicatePosting is returned
+func (p *PostingRepository) EnqueuePosting(ctx context.Context, posting Posting) (Posting, error) {
+	details, err := encodePostingDetails(posting.Transaction, posting.Entries)
+	if err != nil {
+		return Posting{}, err
+	}
+	metadata := audit.FromContext(ctx)
+
+	transaction := posting.Transaction
+	row := p.db.QueryRowContext(ctx, `INSERT INTO postings (id, user_id, transaction_id, amount, effective_date, idempotency_key, details,
+		actor, request_id, client_ip, payload_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING `+postingColumns,
+		posting.ID,
+		transaction.UserID,
+		transaction.ID,
+		transaction.Amount,
+		nullTime(transaction.EffectiveDate.UTC()),
+		transaction.IdempotencyKey,
+		details,
+		metadata.Actor,
+		nullString(metadata.RequestID),
+		nullString(metadata.ClientIP),
+		metadata.PayloadHash)
+	stored, err := scanPosting(row)
+	if isForeignKeyViolation(err) {
+		return Posting{}, ErrUserNotFound
+	}
+	if isUniqueViolation(err) {
+		return Posting{}, fmt.Errorf("%w: %v", ErrDuplicatePosting, err)
+	}
+	return stored, err
+}
+
+// FindPosting returns a posting by ID
+// If the posting is not found, ErrPostingNotFound is returned
+func (p *PostingRepository) FindPosting(ctx context.Context, postingID uuid.UUID) (Posting, error) {
+	posting, err := scanPosting(p.db.QueryRowContext(ctx, `SELECT `+postingColumns+` FROM postings WHERE id = $1`, postingID))
+	if err == sql.ErrNoRows {
+		return Posting{}, ErrPostingNotFound
+	}
+	return posting, err
+}
+
+// ApplyNextPosting adds the transaction of the oldest pending posting whose user has no older pending posting
+// The posting is claimed with FOR UPDATE SKIP LOCKED, so that concurrent workers apply the postings of
+// different users while the postings of a user are applied in order. The transaction is added and the
+// posting marked in the same database transaction, so that a posting is applied exactly once
+// A posting whose user was deleted or whose transaction is a duplicate is marked failed, as is a posting
+// one of whose entries cannot be added. Any other error is returned and counted as an attempt of the posting,
+// which is marked failed after maxPostingAttempts, so that a posting does not block the queue of its user.
+// If no posting can be applied, false is returned
+func (p *PostingRepository) ApplyNextPosting(ctx context.Context) (Posting, bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Posting{}, false, err
+	}
+	defer tx.Rollback()
+
+	var metadata audit.Metadata
+	var requestID, clientIP sql.NullString
+	posting, err := scanPosting(tx.QueryRowContext(ctx, `SELECT `+postingColumns+`, actor, request_id, client_ip, payload_hash
+		FROM postings p
+		WHERE status = 'pending'
+		AND NOT EXISTS (SELECT 1 FROM postings o WHERE o.user_id = p.user_id AND o.status = 'pending' AND o.seq < p.seq)
+		ORDER BY seq
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`), &metadata.Actor, &requestID, &clientIP, &metadata.PayloadHash)
+	if err == sql.ErrNoRows {
+		return Posting{}, false, nil
+	}
+	if err != nil {
+		return Posting{}, false, err
+	}
+	metadata.RequestID, metadata.ClientIP = requestID.String, clientIP.String
+
+	// The savepoint keeps the claim of the posting if adding its transaction fails
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT apply_posting"); err != nil {
+		return Posting{}, false, err
+	}
+	auditCtx := audit.NewContext(ctx, metadata)
+	added, applyErr := addTransaction(auditCtx, tx, posting.Transaction)
+	for i := 0; applyErr == nil && i < len(posting.Entries); i++ {
+		if _, err := addTransaction(auditCtx, tx, posting.Entries[i]); err != nil {
+			applyErr = fmt.Errorf("entry %s: %w", posting.Entries[i].ID, err)
+		}
+	}
+	switch {
+	case applyErr == nil:
+		posting.Status, posting.Transaction = PostingApplied, added
+	case errors.Is(applyErr, ErrUserNotFound), errors.Is(applyErr, ErrDuplicateTransaction):
+		if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT apply_posting"); err != nil {
+			return Posting{}, false, err
+		}
+		posting.Status, posting.Error = PostingFailed, applyErr.Error()
+	default:
+		if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT apply_posting"); err != nil {
+			return Posting{}, false, applyErr
+		}
+		var attempts int
+		err = tx.QueryRowContext(ctx, "UPDATE postings SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts",
+			posting.ID).Scan(&attempts)
+		if err != nil {
+			return Posting{}, false, applyErr
+		}
+		if attempts < maxPostingAttempts {
+			if err = tx.Commit(); err != nil {
+				return Posting{}, false, applyErr
+			}
+			return Posting{}, false, fmt.Errorf("posting %s, attempt %d: %w", posting.ID, attempts, applyErr)
+		}
+		posting.Status, posting.Error = PostingFailed, fmt.Sprintf("%d attempts failed, last: %v", attempts, applyErr)
+	}
+
+	var processedAt time.Time
+	err = tx.QueryRowContext(ctx, `UPDATE postings SET status = $1, error = $2, processed_at = now() AT TIME ZONE 'UTC'
+		WHERE id = $3 RETURNING processed_at`, posting.Status, nullString(posting.Error), posting.ID).Scan(&processedAt)
+	if err != nil {
+		return Posting{}, false, err
+	}
+	posting.ProcessedAt = processedAt
+
+	if err = tx.Commit(); err != nil {
+		return Posting{}, false, err
+	}
+	return posting, true, nil
+}
+
+// scanPosting reads a posting selected with postingColumns followed by the extra destinations
+func scanPosting(row rowScanner, extra ...interface{}) (Posting, error) {
+	var posting Posting
+	var effectiveDate, processedAt sql.NullTime
+	var details []byte
+	var postingError sql.NullString
+	transaction := &posting.Transaction
+	dest := append([]interface{}{
+		&posting.ID,
+		&transaction.UserID,
+		&transaction.ID,
+		&transaction.Amount,
+		&effectiveDate,
+		&transaction.IdempotencyKey,
+		&details,
+		&posting.Status,
+		&postingError,
+		&posting.CreatedAt,
+		&processedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Posting{}, err
+	}
+	transaction.EffectiveDate = effectiveDate.Time
+	posting.Error = postingError.String
+	posting.ProcessedAt = processedAt.Time
+
+	if len(details) > 0 {
+		var decoded postingDetails
+		if err := json.Unmarshal(details, &decoded); err != nil {
+			return Posting{}, fmt.Errorf("decode the posting details: %w", err)
+		}
+		transaction.Currency = decoded.Currency
+		transaction.Description = decoded.Description
+		transaction.Category = decoded.Category
+		transaction.Source = decoded.Source
+		transaction.ExternalReference = decoded.ExternalReference
+		transaction.Metadata = decoded.Metadata
+		for _, entry := range decoded.Entries {
+			posting.Entries = append(posting.Entries, Transaction{
+				ID:             entry.ID,
+				UserID:         entry.UserID,
+				Amount:         entry.Amount,
+				EffectiveDate:  transaction.EffectiveDate,
+				IdempotencyKey: entry.IdempotencyKey,
+				Currency:       entry.Currency,
+				Description:    entry.Description,
+				Category:       entry.Category,
+				Metadata:       entry.Metadata,
+			})
+		}
+	}
+	return posting, nil
+}
+
+// encodePostingDetails returns the JSON of the details of a queued transaction and of its entries, NULL if it has none
+func encodePostingDetails(transaction Transaction, entries []Transaction) (sql.NullString, error) {
+	details := postingDetails{
+		Currency:          transaction.Currency,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Source:            transaction.Source,
+		ExternalReference: transaction.ExternalReference,
+		Metadata:          transaction.Metadata,
+	}
+	for _, entry := range entries {
+		details.Entries = append(details.Entries, postingEntry{
+			ID:             entry.ID,
+			UserID:         entry.UserID,
+			Amount:         entry.Amount,
+			IdempotencyKey: entry.IdempotencyKey,
+			Currency:       entry.Currency,
+			Description:    entry.Description,
+			Category:       entry.Category,
+			Metadata:       entry.Metadata,
+		})
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode the posting details: %w", err)
+	}
+	if string(data) == "{}" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// nullTime returns NULL for the zero time
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestPostingRepository_FailsPostingAfterMaxAttempts(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	_, err = testEnv.DB.ExecContext(testEnv.Context, `CREATE FUNCTION reject_transaction() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'rejected'; END $$ LANGUAGE plpgsql;
+		CREATE TRIGGER reject_transaction BEFORE INSERT ON transactions
+		FOR EACH ROW WHEN (NEW.amount = 13) EXECUTE FUNCTION reject_transaction()`)
+	if err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+	postings := NewPostingRepository(testEnv.DB)
+	rejected := Posting{ID: uuid.New(), Transaction: Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(13), IdempotencyKey: uuid.New()}}
+	next := Posting{ID: uuid.New(), Transaction: Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(10), IdempotencyKey: uuid.New()}}
+	for _, posting := range []Posting{rejected, next} {
+		if _, err := postings.EnqueuePosting(testEnv.Context, posting); err != nil {
+			t.Fatalf("failed to enqueue posting: %v", err)
+		}
+	}
+
+	// Act
+	var attemptErrs []error
+	for i := 0; i < maxPostingAttempts-1; i++ {
+		_, _, err := postings.ApplyNextPosting(testEnv.Context)
+		attemptErrs = append(attemptErrs, err)
+	}
+	pending, pendingErr := postings.FindPosting(testEnv.Context, rejected.ID)
+	failed, failedOK, failedErr := postings.ApplyNextPosting(testEnv.Context)
+	applied, appliedOK, appliedErr := postings.ApplyNextPosting(testEnv.Context)
+	_, requeueErr := postings.EnqueuePosting(testEnv.Context, Posting{ID: uuid.New(), Transaction: rejected.Transaction})
+
+	// Assert
+	for _, err := range attemptErrs {
+		assert.ErrorContains(t, err, "rejected")
+	}
+	assert.NoError(t, pendingErr)
+	assert.Equal(t, PostingPending, pending.Status, "the posting is retried until the maximum attempts")
+	assert.NoError(t, failedErr)
+	assert.True(t, failedOK)
+	assert.Equal(t, rejected.ID, failed.ID)
+	assert.Equal(t, PostingFailed, failed.Status)
+	assert.Contains(t, failed.Error, "rejected")
+	assert.NoError(t, appliedErr)
+	assert.True(t, appliedOK)
+	assert.Equal(t, next.ID, applied.ID, "the failed posting no longer blocks the queue of its user")
+	assert.Equal(t, PostingApplied, applied.Status)
+	assert.NoError(t, requeueErr, "a failed posting releases its idempotency key")
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Consistency levels of the reads
+const (
+	// ConsistencyStrong reads are served by the primary
+	ConsistencyStrong = "strong"
+	// ConsistencyEventual reads may be served by a replica lagging behind the primary
+	ConsistencyEventual = "eventual"
+)
+
+type consistencyContextKey struct{}
+
+// NewConsistencyContext returns a context whose reads have the given consistency
+func NewConsistencyContext(ctx context.Context, consistency string) context.Context {
+	return context.WithValue(ctx, consistencyContextKey{}, consistency)
+}
+
+// ConsistencyFromContext returns the consistency of the reads of the context
+// Reads are strongly consistent by default
+func ConsistencyFromContext(ctx context.Context) string {
+	consistency, ok := ctx.Value(consistencyContextKey{}).(string)
+	if !ok || consistency == "" {
+		return ConsistencyStrong
+	}
+	return consistency
+}
+
+// ReplicaRouter routes the eventually consistent reads to a read-only replica
+// The replica is used while its replay lag is at most maxLag. The lag is checked at most once per
+// checkInterval, reads fall back to the primary while the replica lags or cannot be checked
+type ReplicaRouter struct {
+	replica       *sql.DB
+	maxLag        time.Duration
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	checking  bool
+	checkedAt time.Time
+	usable    bool
+}
+
+// NewReplicaRouter returns a router reading from the replica while it lags at most maxLag behind the primary
+func NewReplicaRouter(replica *sql.DB, maxLag time.Duration, checkInterval time.Duration) *ReplicaRouter {
+	return &ReplicaRouter{
+		replica:       replica,
+		maxLag:        maxLag,
+		checkInterval: checkInterval,
+	}
+}
+
+// RepositoryOption configures a Postgres repository
+type RepositoryOption func(*repositoryOptions)
+
+type repositoryOptions struct {
+	replica *ReplicaRouter
+}
+
+// WithReplica routes the eventually consistent reads of a repository with the router
+func WithReplica(router *ReplicaRouter) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.replica = router
+	}
+}
+
+func newRepositoryOptions(opts []RepositoryOption) repositoryOptions {
+	var options repositoryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// Lag returns how far the replica is behind the primary
+// A replica that replayed everything it received does not lag, however old its last replayed transaction
+func (r *ReplicaRouter) Lag(ctx context.Context) (time.Duration, error) {
+	var seconds float64
+	err := r.replica.QueryRowContext(ctx, `SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// reader returns the database of a read, the replica for the eventually consistent reads
+// unless it lags too far behind
+func (r *ReplicaRouter) reader(ctx context.Context, primary *sql.DB) *sql.DB {
+	if r == nil || ConsistencyFromContext(ctx) != ConsistencyEventual {
+		return primary
+	}
+	if r.replicaUsable(ctx) {
+		return r.replica
+	}
+	return primary
+}
+
+// replicaUsable reports whether the replica lagged at most maxLag when it was last checked
+// A single read checks the lag once it is due, the other reads use the last result meanwhile
+func (r *ReplicaRouter) replicaUsable(ctx context.Context) bool {
+	r.mu.Lock()
+	if r.checking || time.Since(r.checkedAt) < r.checkInterval {
+		usable := r.usable
+		r.mu.Unlock()
+		return usable
+	}
+	r.checking = true
+	r.mu.Unlock()
+
+	lag, err := r.Lag(ctx)
+	usable := err == nil && lag <= r.maxLag
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case usable == r.usable && !r.checkedAt.IsZero():
+	case err != nil:
+		log.Printf("storage : replica lag check failed, reading from the primary: %v", err)
+	case !usable:
+		log.Printf("storage : replica lags %s behind, reading from the primary", lag)
+	default:
+		log.Printf("storage : replica lags %s behind, reading from the replica", lag)
+	}
+	r.checking = false
+	r.checkedAt = time.Now()
+	r.usable = usable
+	return usable
+}
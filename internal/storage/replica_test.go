@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestReplicaRouter_Reader(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create test environment: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	primary := &sql.DB{}
+	eventual := NewConsistencyContext(testEnv.Context, ConsistencyEventual)
+	router := NewReplicaRouter(testEnv.DB, time.Second, time.Minute)
+
+	// Act
+	lag, lagErr := router.Lag(testEnv.Context)
+	strongReader := router.reader(testEnv.Context, primary)
+	eventualReader := router.reader(eventual, primary)
+	var nilRouter *ReplicaRouter
+	nilReader := nilRouter.reader(eventual, primary)
+
+	// Assert
+	assert.NoError(t, lagErr)
+	assert.Equal(t, time.Duration(0), lag, "a database that is not replaying does not lag")
+	assert.Same(t, primary, strongReader)
+	assert.Same(t, testEnv.DB, eventualReader)
+	assert.Same(t, primary, nilReader)
+}
+
+func TestReplicaRouter_FallsBackToPrimary(t *testing.T) {
+	// Assign
+	replica, err := sql.Open("postgres", "host=localhost port=1 connect_timeout=1")
+	if err != nil {
+		t.Fatalf("failed to open the replica: %v", err)
+	}
+	replica.Close()
+	primary := &sql.DB{}
+	ctx := NewConsistencyContext(context.Background(), ConsistencyEventual)
+	router := NewReplicaRouter(replica, time.Second, time.Minute)
+
+	// Act
+	reader := router.reader(ctx, primary)
+
+	// Assert
+	assert.Same(t, primary, reader, "a replica whose lag cannot be checked is not read")
+}
+
+func TestTransactionRepository_EventualReadsFromReplica(t *testing.T) {
+	// Assign
+	primaryEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create test environment: %v", err)
+	}
+	defer primaryEnv.Cleanup()
+	// A second database stands in for a replica that did not replay the transaction yet
+	replicaEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create test environment: %v", err)
+	}
+	defer replicaEnv.Cleanup()
+
+	router := NewReplicaRouter(replicaEnv.DB, time.Second, time.Minute)
+	storageClient := NewStorageClient(primaryEnv.DB, WithReplica(router))
+	ctx := primaryEnv.Context
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(ctx, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	transaction, err := storageClient.TransactionRepository.AddTransaction(ctx, Transaction{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		Amount:         decimal.NewFromInt(10),
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: uuid.New(),
+	})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	strong, strongErr := storageClient.TransactionRepository.FindTransactionByID(ctx, transaction.ID)
+	_, eventualErr := storageClient.TransactionRepository.FindTransactionByID(NewConsistencyContext(ctx, ConsistencyEventual), transaction.ID)
+
+	// Assert
+	assert.NoError(t, strongErr)
+	assert.Equal(t, transaction.ID, strong.ID)
+	assert.Equal(t, ErrTransactionNotFound, eventualErr)
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+// Statuses of a schedule
+const (
+	ScheduleActive = "active"
+	SchedulePaused = "paused"
+	ScheduleEnded  = "ended"
+)
+
+// Statuses of a run of a schedule
+const (
+	RunPosted = "posted"
+	RunFailed = "failed"
+)
+
+// ErrScheduleNotFound is returned when a schedule looked up by its ID does not exist
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// lockScheduler elects the schedule runner, only the runner holding it posts the due occurrences
+const lockScheduler = "SELECT pg_try_advisory_lock(hashtext('schedules'))"
+
+// unlockScheduler releases the election of the schedule runner
+const unlockScheduler = "SELECT pg_advisory_unlock(hashtext('schedules'))"
+
+// Schedule posts a transaction of Amount to a user at every occurrence of either a cron expression or an interval
+// from StartAt. EndAt is zero if the schedule has no end, NextRunAt is the next occurrence to post
+type Schedule struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Amount      decimal.Decimal
+	Cron        string
+	Interval    time.Duration
+	StartAt     time.Time
+	EndAt       time.Time
+	NextRunAt   time.Time
+	Status      string
+	Description string
+	Category    string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ScheduleRun is the outcome of an occurrence of a schedule
+// TransactionID is the posted transaction, Error the reason a run failed
+type ScheduleRun struct {
+	ScheduleID    uuid.UUID
+	ScheduledAt   time.Time
+	Status        string
+	TransactionID uuid.UUID
+	Error         string
+	RanAt         time.Time
+}
+
+// scheduleColumns are the columns read by scanSchedule
+const scheduleColumns = `id, user_id, amount, cron, interval_seconds, start_at, end_at, next_run_at, status,
+	description, category, created_at, updated_at`
+
+// ScheduleRepository stores the schedules and their runs in Postgres
+type ScheduleRepository struct {
+	db *sql.DB
+}
+
+func NewScheduleRepository(db *sql.DB) *ScheduleRepository {
+	return &ScheduleRepository{db: db}
+}
+
+// AddSchedule adds an active schedule, audited in the same database transaction, and returns it as stored
+// If the user is not found, ErrUserNotFound is returned
+func (s *ScheduleRepository) AddSchedule(ctx context.Context, schedule Schedule) (Schedule, error) {
+	var interval sql.NullInt64
+	if schedule.Interval > 0 {
+		interval = sql.NullInt64{Int64: int64(schedule.Interval / time.Second), Valid: true}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Schedule{}, err
+	}
+	defer tx.Rollback()
+
+	stored, err := scanSchedule(tx.QueryRowContext(ctx, `INSERT INTO schedules (id, user_id, amount, cron, interval_seconds,
+		start_at, end_at, next_run_at, description, category)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING `+scheduleColumns,
+		schedule.ID,
+		schedule.UserID,
+		schedule.Amount,
+		nullString(schedule.Cron),
+		interval,
+		schedule.StartAt.UTC(),
+		nullTime(schedule.EndAt.UTC()),
+		schedule.NextRunAt.UTC(),
+		nullString(schedule.Description),
+		nullString(schedule.Category)))
+	if isForeignKeyViolation(err) {
+		return Schedule{}, ErrUserNotFound
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+	if err := auditSchedule(ctx, tx, audit.ActionCreateSchedule, stored); err != nil {
+		return Schedule{}, err
+	}
+	return stored, tx.Commit()
+}
+
+// FindSchedule returns a schedule by ID
+// If the schedule is not found, ErrScheduleNotFound is returned
+func (s *ScheduleRepository) FindSchedule(ctx context.Context, scheduleID uuid.UUID) (Schedule, error) {
+	schedule, err := scanSchedule(s.db.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE id = $1`, scheduleID))
+	if err == sql.ErrNoRows {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return schedule, err
+}
+
+// ListUserSchedules returns the schedules of a user, oldest first
+func (s *ScheduleRepository) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]Schedule, error) {
+	return s.querySchedules(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE user_id = $1 ORDER BY created_at, id`, userID)
+}
+
+// UpdateSchedule sets the status and the next occurrence of a schedule and returns it as stored
+// The change is audited in the same database transaction, as a pause if the schedule is paused and as a resume otherwise.
+// If the schedule is not found, ErrScheduleNotFound is returned
+func (s *ScheduleRepository) UpdateSchedule(ctx context.Context, scheduleID uuid.UUID, status string, nextRunAt time.Time) (Schedule, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Schedule{}, err
+	}
+	defer tx.Rollback()
+
+	schedule, err := scanSchedule(tx.QueryRowContext(ctx, `UPDATE schedules
+		SET status = $2, next_run_at = $3, updated_at = now() AT TIME ZONE 'UTC'
+		WHERE id = $1 RETURNING `+scheduleColumns, scheduleID, status, nextRunAt.UTC()))
+	if err == sql.ErrNoRows {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	action := audit.ActionResumeSchedule
+	if status == SchedulePaused {
+		action = audit.ActionPauseSchedule
+	}
+	if err := auditSchedule(ctx, tx, action, schedule); err != nil {
+		return Schedule{}, err
+	}
+	return schedule, tx.Commit()
+}
+
+// DueSchedules returns up to limit active schedules whose next occurrence is at or before now, the most overdue first
+func (s *ScheduleRepository) DueSchedules(ctx context.Context, now time.Time, limit int) ([]Schedule, error) {
+	return s.querySchedules(ctx, `SELECT `+scheduleColumns+` FROM schedules
+		WHERE status = 'active' AND next_run_at <= $1 ORDER BY next_run_at, id LIMIT $2`, now.UTC(), limit)
+}
+
+// RecordScheduleRun records the run of the next occurrence of a schedule and moves the schedule to its following
+// occurrence, nextRunAt, ending it if ended is set. A run already recorded for the occurrence is kept
+// The schedule is only moved if its next occurrence is still the one of the run, so that concurrent runners
+// move it once, and its status is only changed when it ends, so that a schedule paused meanwhile stays paused
+func (s *ScheduleRepository) RecordScheduleRun(ctx context.Context, run ScheduleRun, nextRunAt time.Time, ended bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	transactionID := uuid.NullUUID{UUID: run.TransactionID, Valid: run.TransactionID != uuid.Nil}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schedule_runs (schedule_id, scheduled_at, status, transaction_id, error)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (schedule_id, scheduled_at) DO NOTHING`,
+		run.ScheduleID, run.ScheduledAt.UTC(), run.Status, transactionID, nullString(run.Error))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE schedules
+		SET next_run_at = $3, status = CASE WHEN $4 THEN 'ended' ELSE status END, updated_at = now() AT TIME ZONE 'UTC'
+		WHERE id = $1 AND next_run_at = $2`, run.ScheduleID, run.ScheduledAt.UTC(), nextRunAt.UTC(), ended)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListScheduleRuns returns up to limit runs of a schedule, the latest occurrence first
+func (s *ScheduleRepository) ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]ScheduleRun, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT schedule_id, scheduled_at, status, transaction_id, error, ran_at
+		FROM schedule_runs WHERE schedule_id = $1 ORDER BY scheduled_at DESC LIMIT $2`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var run ScheduleRun
+		var transactionID uuid.NullUUID
+		var runError sql.NullString
+		if err := rows.Scan(&run.ScheduleID, &run.ScheduledAt, &run.Status, &transactionID, &runError, &run.RanAt); err != nil {
+			return nil, err
+		}
+		run.TransactionID, run.Error = transactionID.UUID, runError.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// LeadScheduler elects the caller as the schedule runner until release is called
+// The election holds a session level advisory lock on a connection set aside until release, without keeping
+// a database transaction open. A runner losing its connection loses the lead with its session.
+// The connection is discarded rather than returned to the pool if the lock cannot be released.
+// If another runner leads, false is returned
+func (s *ScheduleRepository) LeadScheduler(ctx context.Context) (release func(), lead bool, err error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.QueryRowContext(ctx, lockScheduler).Scan(&lead); err != nil || !lead {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), unlockScheduler); err != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// querySchedules returns the schedules selected with scheduleColumns
+func (s *ScheduleRepository) querySchedules(ctx context.Context, query string, args ...interface{}) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// scanSchedule reads a schedule selected with scheduleColumns
+func scanSchedule(row rowScanner) (Schedule, error) {
+	var schedule Schedule
+	var cron, description, category sql.NullString
+	var interval sql.NullInt64
+	var endAt sql.NullTime
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.UserID,
+		&schedule.Amount,
+		&cron,
+		&interval,
+		&schedule.StartAt,
+		&endAt,
+		&schedule.NextRunAt,
+		&schedule.Status,
+		&description,
+		&category,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return Schedule{}, err
+	}
+	schedule.Cron, schedule.Interval = cron.String, time.Duration(interval.Int64)*time.Second
+	schedule.EndAt, schedule.Description, schedule.Category = endAt.Time, description.String, category.String
+	return schedule, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestScheduleRepository_LeadSchedulerHoldsNoTransaction(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	scheduleRepository := NewScheduleRepository(testEnv.DB)
+
+	// Act
+	release, lead, err := scheduleRepository.LeadScheduler(testEnv.Context)
+	if err != nil {
+		t.Fatalf("failed to lead the scheduler: %v", err)
+	}
+	var openTransactions, locks int
+	err = testEnv.DB.QueryRowContext(testEnv.Context, `SELECT
+		(SELECT count(*) FROM pg_stat_activity WHERE datname = current_database() AND state LIKE 'idle in transaction%'),
+		(SELECT count(*) FROM pg_locks WHERE locktype = 'advisory')`).Scan(&openTransactions, &locks)
+	if err != nil {
+		t.Fatalf("failed to query the sessions: %v", err)
+	}
+	release()
+	releaseAgain, secondLead, secondErr := scheduleRepository.LeadScheduler(testEnv.Context)
+	if secondLead {
+		releaseAgain()
+	}
+
+	// Assert
+	assert.True(t, lead)
+	assert.Equal(t, 0, openTransactions, "the leader does not keep a transaction open")
+	assert.Equal(t, 1, locks)
+	assert.NoError(t, secondErr)
+	assert.True(t, secondLead, "the lock is released")
+}
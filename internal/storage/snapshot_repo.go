@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// lockSnapshots serializes the writes of the snapshots with the transactions invalidating them
+const lockSnapshots = "SELECT pg_advisory_xact_lock(hashtext('balance_snapshots'))"
+
+// lockSnapshotsShared is taken by the transactions invalidating the snapshots, which do not wait on each other
+const lockSnapshotsShared = "SELECT pg_advisory_xact_lock_shared(hashtext('balance_snapshots'))"
+
+// SnapshotRepository writes the daily balance snapshots of the users
+// The snapshot of a user for a UTC day is its closing balance at the end of the day and the last transaction
+// of the day, by created_at. Only the days with transactions of the user get a snapshot
+type SnapshotRepository struct {
+	db *sql.DB
+}
+
+func NewSnapshotRepository(db *sql.DB) *SnapshotRepository {
+	return &SnapshotRepository{db: db}
+}
+
+// WriteSnapshots writes the snapshots of the days with transactions following the last snapshot day, or from
+// the earliest invalidated day, up to the last day ending at or before until, and returns the number of days written
+// Until is expected to lag behind the current time, transactions are not created in the past
+// once their day is snapshotted, imported transactions aside
+func (s *SnapshotRepository) WriteSnapshots(ctx context.Context, until time.Time) (int, error) {
+	var last, invalidated sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT (SELECT MAX(day) FROM balance_snapshots),
+		(SELECT MIN(day) FROM balance_snapshot_invalidations)`).Scan(&last, &invalidated)
+	if err != nil {
+		return 0, err
+	}
+	from := time.Time{}
+	if last.Valid {
+		from = last.Time.AddDate(0, 0, 1)
+	}
+	if invalidated.Valid && invalidated.Time.Before(from) {
+		from = invalidated.Time
+	}
+
+	written := 0
+	for end := dayStart(until); ; {
+		var next time.Time
+		err := s.db.QueryRowContext(ctx, `SELECT created_at FROM transaction_history WHERE created_at >= $1 AND created_at < $2
+			ORDER BY created_at LIMIT 1`, from, end).Scan(&next)
+		if err == sql.ErrNoRows {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		day := dayStart(next)
+		if err := s.writeDay(ctx, day); err != nil {
+			return written, err
+		}
+		written++
+		from = day.AddDate(0, 0, 1)
+	}
+}
+
+// writeDay writes the snapshots of the users with transactions on a day and clears its invalidation
+// The closing balance of a user is its nearest earlier snapshot plus the transactions following it
+func (s *SnapshotRepository) writeDay(ctx context.Context, day time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, lockSnapshots); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO balance_snapshots (user_id, day, balance, last_transaction_id)
+		SELECT d.user_id, $1::date, COALESCE(s.balance, 0) + h.amount, d.id
+		FROM (
+			SELECT DISTINCT ON (user_id) user_id, id FROM transaction_history
+			WHERE created_at >= $1 AND created_at < $2
+			ORDER BY user_id, created_at DESC, chain_seq DESC
+		) AS d
+		LEFT JOIN LATERAL (
+			SELECT day, balance FROM balance_snapshots WHERE user_id = d.user_id AND day < $1::date ORDER BY day DESC LIMIT 1
+		) AS s ON true
+		CROSS JOIN LATERAL (
+			SELECT SUM(amount) AS amount FROM transaction_history
+			WHERE user_id = d.user_id AND created_at >= COALESCE(s.day + 1, '-infinity'::timestamp) AND created_at < $2
+		) AS h
+		ON CONFLICT (user_id, day) DO UPDATE SET balance = EXCLUDED.balance, last_transaction_id = EXCLUDED.last_transaction_id`,
+		day.Format("2006-01-02"), day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM balance_snapshot_invalidations WHERE day = $1", day.Format("2006-01-02")); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rebuild replaces the snapshots with the ones recomputed from the full transaction history, up to the last
+// day ending at or before until, and returns the number of snapshots written
+func (s *SnapshotRepository) Rebuild(ctx context.Context, until time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, lockSnapshots); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM balance_snapshots"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM balance_snapshot_invalidations"); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `INSERT INTO balance_snapshots (user_id, day, balance, last_transaction_id)
+		SELECT user_id, day, balance, id FROM (
+			SELECT user_id, id, created_at::date AS day,
+				SUM(amount) OVER (PARTITION BY user_id ORDER BY created_at, chain_seq ROWS UNBOUNDED PRECEDING) AS balance,
+				row_number() OVER (PARTITION BY user_id, created_at::date ORDER BY created_at DESC, chain_seq DESC) AS n
+			FROM transaction_history WHERE created_at < $1::date
+		) AS closing WHERE n = 1`, dayStart(until).Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+// RunSnapshots writes the snapshots every interval until the context is done
+// The days ending less than settleTime ago are not snapshotted yet
+func (s *SnapshotRepository) RunSnapshots(ctx context.Context, interval time.Duration, settleTime time.Duration) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		written, err := s.WriteSnapshots(ctx, time.Now().UTC().Add(-settleTime))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("storage : write balance snapshots: %v", err)
+		}
+		if written > 0 {
+			log.Printf("storage : wrote the balance snapshots of %d days", written)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// invalidateSnapshots drops the snapshots of the users of the inserted transactions backdated before now, the time
+// of the database transaction, from the day of their earliest backdated transaction, and records the earliest day
+// so that the snapshot job writes the days again
+// The transactions created on the day of now are not snapshotted yet, the days are only snapshotted once they ended
+// the settle time ago. Only the transactions created on an earlier day, e.g. imported ones, invalidate snapshots
+func invalidateSnapshots(ctx context.Context, tx *sql.Tx, now time.Time, transactions []Transaction, inserted map[uuid.UUID]bool) error {
+	today := dayStart(now)
+	earliest := map[uuid.UUID]time.Time{}
+	first := today
+	for _, transaction := range transactions {
+		day := dayStart(chainTime(transaction.CreatedAt))
+		if !inserted[transaction.ID] || !day.Before(today) {
+			continue
+		}
+		if earlier, ok := earliest[transaction.UserID]; !ok || day.Before(earlier) {
+			earliest[transaction.UserID] = day
+		}
+		if day.Before(first) {
+			first = day
+		}
+	}
+	if len(earliest) == 0 {
+		return nil
+	}
+	userIDs := make([]string, 0, len(earliest))
+	days := make([]string, 0, len(earliest))
+	for userID, day := range earliest {
+		userIDs = append(userIDs, userID.String())
+		days = append(days, day.Format("2006-01-02"))
+	}
+
+	if _, err := tx.ExecContext(ctx, lockSnapshotsShared); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM balance_snapshots s
+		USING unnest($1::uuid[], $2::date[]) AS i (user_id, day)
+		WHERE s.user_id = i.user_id AND s.day >= i.day`, pq.Array(userIDs), pq.Array(days))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO balance_snapshot_invalidations (day) VALUES ($1) ON CONFLICT (day) DO NOTHING",
+		first.Format("2006-01-02"))
+	return err
+}
+
+// dayStart returns the beginning of the UTC day of t, as stored in the timestamp columns
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+)
+
+func TestDayStart(t *testing.T) {
+	// Assign
+	at := time.Date(2020, 1, 1, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+
+	// Act
+	day := dayStart(at)
+
+	// Assert
+	assert.Equal(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), day, "the day is the UTC day")
+}
+
+func TestSnapshotRepository_WriteSnapshots(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	transactions := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(10), CreatedAt: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(5), CreatedAt: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(20), CreatedAt: time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+	}
+	if _, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, transactions, false); err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+	until := time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	written, writeErr := storageClient.SnapshotRepository.WriteSnapshots(testEnv.Context, until)
+	rows, queryErr := testEnv.DB.QueryContext(testEnv.Context,
+		"SELECT balance, last_transaction_id FROM balance_snapshots WHERE user_id = $1 ORDER BY day", user.ID)
+	snapshots := map[uuid.UUID]decimal.Decimal{}
+	for queryErr == nil && rows.Next() {
+		var balance decimal.Decimal
+		var lastTransactionID uuid.UUID
+		if err := rows.Scan(&balance, &lastTransactionID); err != nil {
+			t.Fatalf("failed to scan snapshot: %v", err)
+		}
+		snapshots[lastTransactionID] = balance
+	}
+	endOfDay, endOfDayErr := storageClient.TransactionRepository.BalanceAsOf(testEnv.Context, user.ID, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	midDay, midDayErr := storageClient.TransactionRepository.BalanceAsOf(testEnv.Context, user.ID, time.Date(2020, 1, 3, 10, 0, 0, 0, time.UTC))
+	again, againErr := storageClient.SnapshotRepository.WriteSnapshots(testEnv.Context, until)
+
+	// Assert
+	assert.NoError(t, writeErr)
+	assert.Equal(t, 2, written)
+	assert.NoError(t, queryErr)
+	assert.Len(t, snapshots, 2)
+	assert.True(t, snapshots[transactions[1].ID].Equal(decimal.NewFromInt(15)), "expected the closing balance 15, got %s", snapshots[transactions[1].ID])
+	assert.True(t, snapshots[transactions[2].ID].Equal(decimal.NewFromInt(35)), "expected the closing balance 35, got %s", snapshots[transactions[2].ID])
+	assert.NoError(t, endOfDayErr)
+	assert.True(t, endOfDay.Equal(decimal.NewFromInt(15)), "expected 15, got %s", endOfDay)
+	assert.NoError(t, midDayErr)
+	assert.True(t, midDay.Equal(decimal.NewFromInt(35)), "expected 35, got %s", midDay)
+	assert.NoError(t, againErr)
+	assert.Equal(t, 0, again, "the snapshotted days are not written again")
+}
+
+func TestSnapshotRepository_ImportInvalidatesSnapshots(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	imported := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(10), CreatedAt: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(20), CreatedAt: time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+	}
+	if _, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, imported, false); err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+	until := time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)
+	if _, err := storageClient.SnapshotRepository.Rebuild(testEnv.Context, until); err != nil {
+		t.Fatalf("failed to rebuild snapshots: %v", err)
+	}
+	late := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(1), CreatedAt: time.Date(2020, 1, 2, 8, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()}
+
+	// Act
+	_, importErr := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, []Transaction{late}, false)
+	balance, balanceErr := storageClient.TransactionRepository.BalanceAsOf(testEnv.Context, user.ID, until)
+	var snapshots int
+	countErr := testEnv.DB.QueryRowContext(testEnv.Context, "SELECT COUNT(*) FROM balance_snapshots WHERE user_id = $1", user.ID).Scan(&snapshots)
+	rebuilt, rebuildErr := storageClient.SnapshotRepository.Rebuild(testEnv.Context, until)
+
+	// Assert
+	assert.NoError(t, importErr)
+	assert.NoError(t, balanceErr)
+	assert.True(t, balance.Equal(decimal.NewFromInt(31)), "expected 31, got %s", balance)
+	assert.NoError(t, countErr)
+	assert.Equal(t, 1, snapshots, "the snapshots from the day of the imported transaction are dropped")
+	assert.NoError(t, rebuildErr)
+	assert.Equal(t, int64(3), rebuilt)
+}
+
+func TestSnapshotRepository_WriteSnapshotsResumesFromInvalidatedDay(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	other := User{ID: uuid.New(), Balance: decimal.Zero}
+	for _, u := range []User{user, other} {
+		if err := storageClient.UserRepository.Add(testEnv.Context, u); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	imported := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(10), CreatedAt: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: other.ID, Amount: decimal.NewFromInt(5), CreatedAt: time.Date(2020, 1, 5, 9, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()},
+	}
+	if _, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, imported, false); err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+	until := time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)
+	if _, err := storageClient.SnapshotRepository.WriteSnapshots(testEnv.Context, until); err != nil {
+		t.Fatalf("failed to write snapshots: %v", err)
+	}
+	late := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(1), CreatedAt: time.Date(2020, 1, 2, 8, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()}
+	if _, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, []Transaction{late}, false); err != nil {
+		t.Fatalf("failed to import transaction: %v", err)
+	}
+
+	// Act
+	written, writeErr := storageClient.SnapshotRepository.WriteSnapshots(testEnv.Context, until)
+	var balance decimal.Decimal
+	queryErr := testEnv.DB.QueryRowContext(testEnv.Context,
+		"SELECT balance FROM balance_snapshots WHERE user_id = $1 AND day = '2020-01-02'", user.ID).Scan(&balance)
+	var invalidations int
+	countErr := testEnv.DB.QueryRowContext(testEnv.Context, "SELECT COUNT(*) FROM balance_snapshot_invalidations").Scan(&invalidations)
+
+	// Assert
+	assert.NoError(t, writeErr)
+	assert.Equal(t, 2, written, "the days from the imported transaction are written again")
+	assert.NoError(t, queryErr)
+	assert.True(t, balance.Equal(decimal.NewFromInt(11)), "expected the closing balance 11, got %s", balance)
+	assert.NoError(t, countErr)
+	assert.Equal(t, 0, invalidations)
+}
+
+func TestSnapshotRepository_AddTransactionsKeepsSnapshots(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	storageClient := NewStorageClient(testEnv.DB)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := storageClient.UserRepository.Add(testEnv.Context, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	today := dayStart(time.Now())
+	imported := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(10), CreatedAt: today.AddDate(0, 0, -1), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(20), CreatedAt: today, IdempotencyKey: uuid.New()},
+	}
+	if _, err := storageClient.TransactionRepository.ImportTransactions(testEnv.Context, imported, false); err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+	if _, err := storageClient.SnapshotRepository.Rebuild(testEnv.Context, today); err != nil {
+		t.Fatalf("failed to rebuild snapshots: %v", err)
+	}
+	added := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(1), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromInt(2), IdempotencyKey: uuid.New()},
+	}
+
+	// Act
+	results, addErr := storageClient.TransactionRepository.AddTransactions(testEnv.Context, added, true)
+	balance, balanceErr := storageClient.TransactionRepository.BalanceAsOf(testEnv.Context, user.ID, today.AddDate(0, 0, 1))
+	var snapshots int
+	countErr := testEnv.DB.QueryRowContext(testEnv.Context, "SELECT COUNT(*) FROM balance_snapshots WHERE user_id = $1", user.ID).Scan(&snapshots)
+	var invalidations int
+	invalidationsErr := testEnv.DB.QueryRowContext(testEnv.Context, "SELECT COUNT(*) FROM balance_snapshot_invalidations").Scan(&invalidations)
+
+	// Assert
+	assert.NoError(t, addErr)
+	assert.Equal(t, []error{nil, nil}, results)
+	assert.NoError(t, balanceErr)
+	assert.True(t, balance.Equal(decimal.NewFromInt(33)), "expected 33, got %s", balance)
+	assert.NoError(t, countErr)
+	assert.Equal(t, 1, snapshots, "the transactions added on the current day keep the snapshots")
+	assert.NoError(t, invalidationsErr)
+	assert.Equal(t, 0, invalidations)
+}
@@ -0,0 +1,718 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+// sqliteTimeFormat is the format of the timestamps stored by SQLite
+// The fixed width keeps the text order equal to the time order
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000"
+
+// sqliteSchema creates the tables of the SQLite backend
+// Amounts and balances are stored as decimal text so that they are exact
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	balance TEXT NOT NULL,
+	chain_seq INTEGER NOT NULL DEFAULT 0,
+	chain_hash BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id),
+	amount TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	chain_seq INTEGER NOT NULL,
+	hash BLOB NOT NULL,
+	UNIQUE (idempotency_key, amount),
+	UNIQUE (user_id, chain_seq)
+);
+
+CREATE INDEX IF NOT EXISTS transactions_user_id_created_at_idx ON transactions (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TEXT NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	request_id TEXT,
+	client_ip TEXT,
+	user_id TEXT NOT NULL,
+	transaction_id TEXT,
+	balance_before TEXT NOT NULL,
+	balance_after TEXT NOT NULL,
+	payload_hash BLOB
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_id_created_at_idx ON audit_log (user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_created_at_idx ON audit_log (actor, created_at);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only, UPDATE is not allowed');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only, DELETE is not allowed');
+END;
+`
+
+// sqliteMigration migrates the schema or the rows of a SQLite database in the migration transaction
+type sqliteMigration func(ctx context.Context, tx *sql.Tx) error
+
+// sqliteMigrations are applied in order to a SQLite database, PRAGMA user_version holds the number applied
+// The first one creates the schema, it is a no-op on databases created before user_version was kept
+var sqliteMigrations = []sqliteMigration{
+	sqliteScript(sqliteSchema),
+	sqliteScript(`ALTER TABLE transactions ADD COLUMN effective_date TEXT NOT NULL DEFAULT '';
+UPDATE transactions SET effective_date = created_at;`),
+	sqliteScript(`ALTER TABLE transactions ADD COLUMN external_reference TEXT;
+ALTER TABLE transactions ADD COLUMN source TEXT;
+ALTER TABLE transactions ADD COLUMN description TEXT;
+ALTER TABLE transactions ADD COLUMN category TEXT;
+ALTER TABLE transactions ADD COLUMN metadata TEXT;
+CREATE UNIQUE INDEX transactions_source_external_reference_key ON transactions (source, external_reference) WHERE source IS NOT NULL;`),
+	sqliteScript(`ALTER TABLE transactions ADD COLUMN currency TEXT;`),
+	rehashSQLiteChains(1, 2),
+}
+
+// sqliteScript is a migration running a SQL script
+func sqliteScript(script string) sqliteMigration {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, script)
+		return err
+	}
+}
+
+// rehashSQLiteChains is a migration rehashing the chains of every user from the canonical serialization
+// of fromVersion to the one of toVersion, as the rehash_transaction_chains function of the Postgres migrations.
+// A chain is only rehashed up to its first broken link, so that VerifyChain still reports it
+func rehashSQLiteChains(fromVersion, toVersion int) sqliteMigration {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT `+sqliteTransactionColumns+`, chain_seq, hash FROM transactions ORDER BY user_id, chain_seq`)
+		if err != nil {
+			return err
+		}
+		type link struct {
+			transaction Transaction
+			hash        []byte
+		}
+		chains := map[uuid.UUID][]link{}
+		userIDs := []uuid.UUID{}
+		for rows.Next() {
+			var l link
+			var seq int64
+			if l.transaction, err = scanSQLiteTransaction(rows, &seq, &l.hash); err != nil {
+				rows.Close()
+				return err
+			}
+			userID := l.transaction.UserID
+			if _, ok := chains[userID]; !ok {
+				userIDs = append(userIDs, userID)
+			}
+			// A missing entry breaks the chain
+			if seq != int64(len(chains[userID])+1) {
+				l.hash = nil
+			}
+			chains[userID] = append(chains[userID], l)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			previous, rehashed := genesisHash, genesisHash
+			broken := false
+			for seq, l := range chains[userID] {
+				if !bytes.Equal(l.hash, chainHashVersion(previous, l.transaction, fromVersion)) {
+					broken = true
+					break
+				}
+				previous = l.hash
+				rehashed = chainHashVersion(rehashed, l.transaction, toVersion)
+				if _, err := tx.ExecContext(ctx, "UPDATE transactions SET hash = $1 WHERE user_id = $2 AND chain_seq = $3", rehashed, userID, seq+1); err != nil {
+					return err
+				}
+			}
+			if broken {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, "UPDATE users SET chain_hash = $1 WHERE id = $2 AND chain_seq = $3 AND chain_hash = $4",
+				rehashed, userID, len(chains[userID]), previous)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// OpenSQLite opens the SQLite database at path and migrates its schema
+// The database is opened in WAL mode, so that reads do not wait for the writer.
+// Every transaction begins with BEGIN IMMEDIATE and takes the write lock of the database,
+// which stands in for the row locks of Postgres across processes
+func OpenSQLite(ctx context.Context, path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_txlock", "immediate")
+	params.Set("_foreign_keys", "on")
+	params.Set("_synchronous", "FULL")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate the sqlite schema: %w", err)
+	}
+	return db, nil
+}
+
+// migrateSQLite applies the pending migrations in a single transaction
+// The transaction holds the write lock, so that processes opening the database at the same time
+// do not apply the same migration twice
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version is %d, this binary only knows %d migrations", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		if err := sqliteMigrations[i](ctx, tx); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	// PRAGMA does not take parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(sqliteMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQLiteStore keeps the users and transactions in a SQLite database opened with OpenSQLite
+// It has the semantics of the Postgres repositories, except that amounts and balances are exact decimals.
+// The writes of the store are serialized by a mutex, so that they never wait on each other
+// for the write lock of the database
+type SQLiteStore struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// Add adds a new user
+func (s *SQLiteStore) Add(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users (id, balance, chain_hash) VALUES ($1, $2, $3)", u.ID, u.Balance, genesisHash)
+	return err
+}
+
+// FindByID returns a user by ID
+// If the user is not found, ErrUserNotFound is returned
+func (s *SQLiteStore) FindByID(ctx context.Context, id uuid.UUID) (User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx, "SELECT id, balance FROM users WHERE id = $1", id).Scan(&user.ID, &user.Balance)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// ListIDs returns the IDs of every user in ascending order
+func (s *SQLiteStore) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddTransaction adds a transaction and updates the balance of its user
+// If the ID, the idempotency key and amount or the source and external reference were already used,
+// ErrDuplicateTransaction is returned
+func (s *SQLiteStore) AddTransaction(ctx context.Context, transaction Transaction) (Transaction, error) {
+	batch := []Transaction{transaction}
+	errs, err := s.AddTransactions(ctx, batch, true)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if errs[0] != nil {
+		return Transaction{}, errs[0]
+	}
+	transaction = batch[0]
+
+	return Transaction{
+		ID:                transaction.ID,
+		UserID:            transaction.UserID,
+		Amount:            transaction.Amount,
+		CreatedAt:         transaction.CreatedAt,
+		EffectiveDate:     transaction.EffectiveDate,
+		IdempotencyKey:    transaction.IdempotencyKey,
+		Currency:          transaction.Currency,
+		ExternalReference: transaction.ExternalReference,
+		Source:            transaction.Source,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Metadata:          transaction.Metadata,
+	}, nil
+}
+
+// AddTransactions adds a batch of transactions with the semantics of TransactionRepository.AddTransactions
+// The created_at of the transactions is the time the write lock was taken
+func (s *SQLiteStore) AddTransactions(ctx context.Context, transactions []Transaction, atomic bool) ([]error, error) {
+	results := make([]error, len(transactions))
+	if len(transactions) == 0 {
+		return results, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Read the balances and heads of the users of the batch
+	type account struct {
+		balance decimal.Decimal
+		head    chainHead
+	}
+	accounts := map[uuid.UUID]*account{}
+	missing := false
+	for i, transaction := range transactions {
+		if _, ok := accounts[transaction.UserID]; !ok {
+			var a account
+			err := tx.QueryRowContext(ctx, "SELECT balance, chain_seq, chain_hash FROM users WHERE id = $1", transaction.UserID).
+				Scan(&a.balance, &a.head.seq, &a.head.hash)
+			if err == sql.ErrNoRows {
+				accounts[transaction.UserID] = nil
+			} else if err != nil {
+				return nil, err
+			} else {
+				accounts[transaction.UserID] = &a
+			}
+		}
+		if accounts[transaction.UserID] == nil {
+			results[i] = ErrUserNotFound
+			missing = true
+		}
+	}
+	if atomic && missing {
+		return abortBatch(results), nil
+	}
+
+	now := storedTime(time.Now())
+	for i := range transactions {
+		transactions[i].CreatedAt = now
+		transactions[i].EffectiveDate = effectiveDate(transactions[i])
+	}
+
+	// Insert the transactions as the next links of the chains, a failed insert only aborts its own statement
+	failed := missing
+	for i, transaction := range transactions {
+		if results[i] != nil {
+			continue
+		}
+		a := accounts[transaction.UserID]
+		head := chainHead{seq: a.head.seq + 1, hash: chainHash(a.head.hash, transaction)}
+		encoded, err := encodeMetadata(transaction.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO transactions (id, user_id, amount, created_at, effective_date, idempotency_key, chain_seq, hash,
+			currency, external_reference, source, description, category, metadata)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			transaction.ID,
+			transaction.UserID,
+			transaction.Amount,
+			transaction.CreatedAt.Format(sqliteTimeFormat),
+			transaction.EffectiveDate.Format(sqliteTimeFormat),
+			transaction.IdempotencyKey,
+			head.seq,
+			head.hash,
+			nullString(transaction.Currency),
+			nullString(transaction.ExternalReference),
+			nullString(transaction.Source),
+			nullString(transaction.Description),
+			nullString(transaction.Category),
+			encoded)
+		if isSQLiteUniqueViolation(err) {
+			results[i] = ErrDuplicateTransaction
+			failed = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		before := a.balance
+		a.balance = a.balance.Add(transaction.Amount)
+		a.head = head
+		metadata := audit.TransactionFromContext(ctx, transaction.ID)
+		_, err = tx.ExecContext(ctx, `INSERT INTO audit_log (created_at, action, actor, request_id, client_ip, user_id, transaction_id, balance_before, balance_after, payload_hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			time.Now().UTC().Format(sqliteTimeFormat),
+			audit.ActionAddTransaction,
+			metadata.Actor,
+			nullString(metadata.RequestID),
+			nullString(metadata.ClientIP),
+			transaction.UserID,
+			transaction.ID,
+			before,
+			a.balance,
+			nullBytes(metadata.PayloadHash))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if atomic && failed {
+		return abortBatch(results), nil
+	}
+
+	// Update the balances and the heads of the chains
+	for userID, a := range accounts {
+		if a == nil {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE users SET balance = $1, chain_seq = $2, chain_hash = $3 WHERE id = $4", a.balance, a.head.seq, a.head.hash, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetUserTransactionHistory returns a page of the transactions of a user matching the filter, newest first
+func (s *SQLiteStore) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter HistoryFilter) ([]Transaction, error) {
+	if page <= 0 {
+		page = 1
+	}
+
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	if filter.Reference != "" {
+		args = append(args, filter.Reference)
+		conditions = append(conditions, fmt.Sprintf("external_reference = $%d", len(args)))
+	}
+	for _, key := range sortedKeys(filter.Metadata) {
+		// Strings compare by their value and the other values by their JSON text, as ->> does in Postgres
+		args = append(args, `$."`+key+`"`, filter.Metadata[key])
+		path, value := len(args)-1, len(args)
+		conditions = append(conditions, fmt.Sprintf(`CASE json_type(metadata, $%d) WHEN 'null' THEN NULL WHEN 'text' THEN metadata ->> $%d ELSE metadata -> $%d END = $%d`,
+			path, path, path, value))
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	query := `SELECT ` + sqliteTransactionColumns + ` FROM transactions WHERE ` + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY created_at DESC, chain_seq DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	return s.queryTransactions(ctx, query, args...)
+}
+
+// FindTransactionByID returns a transaction by ID
+// If the transaction is not found, ErrTransactionNotFound is returned
+func (s *SQLiteStore) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (Transaction, error) {
+	transaction, err := scanSQLiteTransaction(s.db.QueryRowContext(ctx, `SELECT `+sqliteTransactionColumns+` FROM transactions WHERE id = ?`, transactionID))
+	if err == sql.ErrNoRows {
+		return Transaction{}, ErrTransactionNotFound
+	}
+	return transaction, err
+}
+
+// FindUserTransactionsByIdempotencyKey returns the transactions of a user added with an idempotency key, newest first
+func (s *SQLiteStore) FindUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]Transaction, error) {
+	return s.queryTransactions(ctx, `SELECT `+sqliteTransactionColumns+` FROM transactions WHERE user_id = ? AND idempotency_key = ?
+		ORDER BY created_at DESC, chain_seq DESC`, userID, idempotencyKey)
+}
+
+// GetUserTransactionsBetween returns the transactions of a user created from from until to, oldest first
+func (s *SQLiteStore) GetUserTransactionsBetween(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]Transaction, error) {
+	return s.queryTransactions(ctx, `SELECT `+sqliteTransactionColumns+` FROM transactions WHERE user_id = ? AND created_at >= ? AND created_at < ?
+		ORDER BY created_at, chain_seq`, userID, from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+}
+
+// BalanceAsOf returns the balance of a user as of a time, the sum of the transactions created before it
+// The amounts are summed as decimals, SQLite would sum the decimal text as floats
+func (s *SQLiteStore) BalanceAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (decimal.Decimal, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT amount FROM transactions WHERE user_id = ? AND created_at < ?",
+		userID, at.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer rows.Close()
+
+	balance := decimal.Zero
+	for rows.Next() {
+		var amount decimal.Decimal
+		if err := rows.Scan(&amount); err != nil {
+			return decimal.Zero, err
+		}
+		balance = balance.Add(amount)
+	}
+	return balance, rows.Err()
+}
+
+// sqliteTransactionColumns are the columns read by scanSQLiteTransaction
+const sqliteTransactionColumns = `id, user_id, amount, created_at, effective_date, idempotency_key,
+	currency, external_reference, source, description, category, metadata`
+
+// queryTransactions returns the transactions selected with sqliteTransactionColumns by a query
+func (s *SQLiteStore) queryTransactions(ctx context.Context, query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		transaction, err := scanSQLiteTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
+// scanSQLiteTransaction reads a transaction selected with sqliteTransactionColumns followed by the extra destinations
+func scanSQLiteTransaction(row rowScanner, extra ...interface{}) (Transaction, error) {
+	var transaction Transaction
+	var createdAt, effectiveDate string
+	var currency, externalReference, source, description, category, metadata sql.NullString
+	dest := append([]interface{}{
+		&transaction.ID,
+		&transaction.UserID,
+		&transaction.Amount,
+		&createdAt,
+		&effectiveDate,
+		&transaction.IdempotencyKey,
+		&currency,
+		&externalReference,
+		&source,
+		&description,
+		&category,
+		&metadata,
+	}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Currency = currency.String
+	transaction.ExternalReference = externalReference.String
+	transaction.Source = source.String
+	transaction.Description = description.String
+	transaction.Category = category.String
+	if transaction.Metadata, err = decodeMetadata([]byte(metadata.String)); err != nil {
+		return Transaction{}, err
+	}
+	if transaction.CreatedAt, err = time.Parse(sqliteTimeFormat, createdAt); err != nil {
+		return Transaction{}, err
+	}
+	if transaction.EffectiveDate, err = time.Parse(sqliteTimeFormat, effectiveDate); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// VerifyChain recomputes the hash chain of a user and reports its first broken link
+// The chain is read in a single transaction, so that concurrent writes cannot break it
+// If the user is not found, ErrUserNotFound is returned
+func (s *SQLiteStore) VerifyChain(ctx context.Context, userID uuid.UUID) (ChainReport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ChainReport{}, err
+	}
+	defer tx.Rollback()
+
+	var head chainHead
+	err = tx.QueryRowContext(ctx, "SELECT chain_seq, chain_hash FROM users WHERE id = $1", userID).Scan(&head.seq, &head.hash)
+	if err == sql.ErrNoRows {
+		return ChainReport{}, ErrUserNotFound
+	}
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+sqliteTransactionColumns+`, chain_seq, hash
+		FROM transactions WHERE user_id = $1 ORDER BY chain_seq`, userID)
+	if err != nil {
+		return ChainReport{}, err
+	}
+	defer rows.Close()
+
+	report := ChainReport{UserID: userID}
+	previous := chainHead{hash: genesisHash}
+	for rows.Next() {
+		var seq int64
+		var hash []byte
+		transaction, err := scanSQLiteTransaction(rows, &seq, &hash)
+		if err != nil {
+			return ChainReport{}, err
+		}
+		report.Transactions++
+
+		expected := chainHash(previous.hash, transaction)
+		switch {
+		case seq != previous.seq+1:
+			report.Break = &ChainBreak{Seq: previous.seq + 1, Reason: ChainMissingEntry}
+		case !bytes.Equal(hash, expected):
+			report.Break = &ChainBreak{Seq: seq, TransactionID: transaction.ID, Reason: ChainHashMismatch, ExpectedHash: expected, ActualHash: hash}
+		}
+		if report.Break != nil {
+			return report, nil
+		}
+		previous = chainHead{seq: seq, hash: hash}
+	}
+	if err := rows.Err(); err != nil {
+		return ChainReport{}, err
+	}
+
+	// Transactions deleted from the end of the chain are only noticed by the head
+	if previous.seq != head.seq || !bytes.Equal(previous.hash, head.hash) {
+		report.Break = &ChainBreak{Seq: head.seq, Reason: ChainHeadMismatch, ExpectedHash: head.hash, ActualHash: previous.hash}
+	}
+	return report, nil
+}
+
+// SQLiteAuditRepository reads the audit log of a SQLite database opened with OpenSQLite
+type SQLiteAuditRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteAuditRepository(db *sql.DB) *SQLiteAuditRepository {
+	return &SQLiteAuditRepository{db: db}
+}
+
+// Find returns the entries of the audit log matching the filter with the semantics of AuditRepository.Find
+func (a *SQLiteAuditRepository) Find(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	conditions := []string{"id > ?"}
+	args := []interface{}{filter.AfterID}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.UserID != uuid.Nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(sqliteTimeFormat))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC().Format(sqliteTimeFormat))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	args = append(args, limit)
+
+	query := `SELECT id, created_at, action, actor, request_id, client_ip, user_id, transaction_id, balance_before, balance_after, payload_hash
+		FROM audit_log WHERE ` + strings.Join(conditions, " AND ") + " ORDER BY id LIMIT ?"
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var entry audit.Entry
+		var createdAt string
+		var requestID, clientIP sql.NullString
+		var transactionID uuid.NullUUID
+		var payloadHash []byte
+		err := rows.Scan(&entry.ID,
+			&createdAt,
+			&entry.Action,
+			&entry.Actor,
+			&requestID,
+			&clientIP,
+			&entry.UserID,
+			&transactionID,
+			&entry.BalanceBefore,
+			&entry.BalanceAfter,
+			&payloadHash)
+		if err != nil {
+			return nil, err
+		}
+		if entry.CreatedAt, err = time.Parse(sqliteTimeFormat, createdAt); err != nil {
+			return nil, err
+		}
+		entry.RequestID = requestID.String
+		entry.ClientIP = clientIP.String
+		if transactionID.Valid {
+			entry.TransactionID = &transactionID.UUID
+		}
+		entry.PayloadHash = hex.EncodeToString(payloadHash)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+)
+
+func openTestSQLite(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	db, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewSQLiteStore(db)
+}
+
+func TestSQLiteStore_ExactDecimals(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := openTestSQLite(t)
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := store.Add(ctx, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Act
+	for _, amount := range []string{"0.1", "0.2", "12345678901234567.89"} {
+		transaction := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.RequireFromString(amount), CreatedAt: time.Now(), IdempotencyKey: uuid.New()}
+		if _, err := store.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	found, err := store.FindByID(ctx, user.ID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234568.19", found.Balance.String())
+}
+
+func TestSQLiteAuditLog(t *testing.T) {
+	// Assign
+	store := openTestSQLite(t)
+	auditRepository := NewSQLiteAuditRepository(store.db)
+	ctx := audit.NewContext(context.Background(), audit.Metadata{Actor: audit.ActorAdmin, RequestID: "request-1"})
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := store.Add(ctx, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	transaction := Transaction{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(10), CreatedAt: time.Now(), IdempotencyKey: uuid.New()}
+	if _, err := store.AddTransaction(ctx, transaction); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	entries, err := auditRepository.Find(ctx, audit.Filter{UserID: user.ID, Since: time.Now().Add(-time.Minute)})
+	_, updateErr := store.db.ExecContext(ctx, "UPDATE audit_log SET actor = 'someone else'")
+	_, deleteErr := store.db.ExecContext(ctx, "DELETE FROM audit_log")
+
+	// Assert
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActorAdmin, entries[0].Actor)
+		assert.Equal(t, "request-1", entries[0].RequestID)
+		assert.Equal(t, transaction.ID, *entries[0].TransactionID)
+		assert.True(t, entries[0].BalanceAfter.Equal(decimal.NewFromFloat(10)))
+	}
+	if assert.Error(t, updateErr) {
+		assert.Contains(t, updateErr.Error(), "audit_log is append-only")
+	}
+	if assert.Error(t, deleteErr) {
+		assert.Contains(t, deleteErr.Error(), "audit_log is append-only")
+	}
+}
+
+func TestSQLiteAuditLog_BatchContext(t *testing.T) {
+	// Assign
+	store := openTestSQLite(t)
+	auditRepository := NewSQLiteAuditRepository(store.db)
+	ctx := audit.NewContext(context.Background(), audit.Metadata{Actor: audit.ActorAdmin, RequestID: "batch"})
+	user := User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := store.Add(ctx, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	transactions := []Transaction{
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(10), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(20), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), UserID: user.ID, Amount: decimal.NewFromFloat(30), IdempotencyKey: uuid.New()},
+	}
+	ctx = audit.NewBatchContext(ctx, map[uuid.UUID]audit.Metadata{
+		transactions[0].ID: {Actor: audit.ActorAnonymous, RequestID: "request-1"},
+		transactions[1].ID: {Actor: audit.ActorAnonymous, RequestID: "request-2"},
+	})
+
+	// Act
+	_, err := store.AddTransactions(ctx, transactions, false)
+	entries, findErr := auditRepository.Find(ctx, audit.Filter{UserID: user.ID, Since: time.Now().Add(-time.Minute)})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, findErr)
+	requestIDs := map[uuid.UUID]string{}
+	for _, entry := range entries {
+		requestIDs[*entry.TransactionID] = entry.Actor + "/" + entry.RequestID
+	}
+	assert.Equal(t, map[uuid.UUID]string{
+		transactions[0].ID: "anonymous/request-1",
+		transactions[1].ID: "anonymous/request-2",
+		transactions[2].ID: "admin/batch",
+	}, requestIDs)
+}
+
+func TestOpenSQLite_MigratesExistingDatabase(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	// A database created before user_version was kept
+	_, err = db.ExecContext(ctx, sqliteSchema)
+	if err == nil {
+		_, err = db.ExecContext(ctx, `INSERT INTO users (id, balance, chain_hash) VALUES ('123e4567-e89b-12d3-a456-426614174000', '10', x'00');
+			INSERT INTO transactions (id, user_id, amount, created_at, idempotency_key, chain_seq, hash)
+			VALUES ('223e4567-e89b-12d3-a456-426614174000', '123e4567-e89b-12d3-a456-426614174000', '10', '2022-01-01T00:00:00.000000', '323e4567-e89b-12d3-a456-426614174000', 1, x'00')`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to create the database: %v", err)
+	}
+
+	// Act
+	db, err = OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Close()
+	db, err = OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	defer db.Close()
+	var version int
+	versionErr := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	transactions, err := NewSQLiteStore(db).GetUserTransactionHistory(ctx, uuid.MustParse("123e4567-e89b-12d3-a456-426614174000"), 1, 10, HistoryFilter{})
+
+	// Assert
+	assert.NoError(t, versionErr)
+	assert.Equal(t, len(sqliteMigrations), version)
+	assert.NoError(t, err)
+	if assert.Len(t, transactions, 1) {
+		assert.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), transactions[0].EffectiveDate)
+	}
+}
+
+func TestOpenSQLite_RehashesChains(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	// A database whose chains hash the first version of the canonical serialization
+	intact, tampered := uuid.New(), uuid.New()
+	tx, err := db.BeginTx(ctx, nil)
+	for _, migration := range sqliteMigrations[:4] {
+		if err == nil {
+			err = migration(ctx, tx)
+		}
+	}
+	for _, userID := range []uuid.UUID{intact, tampered} {
+		previous := genesisHash
+		for seq := 1; seq <= 3 && err == nil; seq++ {
+			transaction := Transaction{ID: uuid.New(), UserID: userID, Amount: decimal.NewFromInt(int64(seq)), CreatedAt: time.Date(2022, 1, seq, 0, 0, 0, 0, time.UTC), IdempotencyKey: uuid.New()}
+			previous = chainHashVersion(previous, transaction, 1)
+			amount := transaction.Amount
+			if userID == tampered && seq == 2 {
+				amount = amount.Add(decimal.NewFromInt(100))
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO transactions (id, user_id, amount, created_at, effective_date, idempotency_key, chain_seq, hash)
+				VALUES ($1, $2, $3, $4, $4, $5, $6, $7)`,
+				transaction.ID, userID, amount, transaction.CreatedAt.Format(sqliteTimeFormat), transaction.IdempotencyKey, seq, previous)
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO users (id, balance, chain_seq, chain_hash) VALUES ($1, '6', 3, $2)", userID, previous)
+		}
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "PRAGMA user_version = 4")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to create the database: %v", err)
+	}
+
+	// Act
+	db, err = OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	defer db.Close()
+	store := NewSQLiteStore(db)
+	intactReport, intactErr := store.VerifyChain(ctx, intact)
+	tamperedReport, tamperedErr := store.VerifyChain(ctx, tampered)
+
+	// Assert
+	assert.NoError(t, intactErr)
+	assert.Nil(t, intactReport.Break)
+	assert.NoError(t, tamperedErr)
+	if assert.NotNil(t, tamperedReport.Break) {
+		assert.Equal(t, int64(2), tamperedReport.Break.Seq)
+		assert.Equal(t, ChainHashMismatch, tamperedReport.Break.Reason)
+	}
+}
@@ -0,0 +1,928 @@
+// Package storagetest is the conformance suite of the storage backends
+// Every backend must pass it, so that the transaction manager behaves the same on all of them
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+)
+
+// Stores are the stores of the backend under test
+// Postings is nil for the backends without a queue of asynchronous postings, their posting tests are skipped.
+// Schedules is nil for the backends without scheduled transactions, their schedule tests are skipped
+type Stores struct {
+	Transactions transactionmanager.TransactionStore
+	Users        transactionmanager.UserStore
+	Postings     transactionmanager.PostingStore
+	Schedules    transactionmanager.ScheduleStore
+}
+
+// Run runs the conformance suite against the stores returned by newStores
+// newStores is called once per test, the tests only use users they created so the stores may be shared
+func Run(t *testing.T, newStores func(t *testing.T) Stores) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, stores Stores)
+	}{
+		{"AddTransaction updates the balance", testAddTransaction},
+		{"AddTransaction of a backdated transaction", testAddTransactionBackdated},
+		{"AddTransaction of an unknown user", testAddTransactionUnknownUser},
+		{"AddTransaction is idempotent", testAddTransactionIdempotent},
+		{"AddTransaction locks the user", testAddTransactionConcurrent},
+		{"AddTransaction of the same key concurrently", testAddTransactionSameKeyConcurrent},
+		{"AddTransactions all or nothing", testAddTransactionsAtomic},
+		{"AddTransactions best effort", testAddTransactionsBestEffort},
+		{"AddTransaction stores the details", testAddTransactionDetails},
+		{"AddTransaction of an already used external reference", testAddTransactionReferenceUnique},
+		{"GetUserTransactionHistory pages newest first", testHistory},
+		{"GetUserTransactionHistory filters", testHistoryFilter},
+		{"FindByID of an unknown user", testFindUnknownUser},
+		{"FindTransactionByID", testFindTransactionByID},
+		{"FindUserTransactionsByIdempotencyKey", testFindUserTransactionsByIdempotencyKey},
+		{"VerifyChain", testVerifyChain},
+		{"GetUserTransactionsBetween oldest first", testTransactionsBetween},
+		{"BalanceAsOf", testBalanceAsOf},
+		{"EnqueuePosting", testEnqueuePosting},
+		{"ApplyNextPosting in order per user", testApplyNextPosting},
+		{"ApplyNextPosting of a duplicate transaction", testApplyNextPostingDuplicate},
+		{"ApplyNextPosting adds the entries", testApplyNextPostingEntries},
+		{"AddSchedule", testAddSchedule},
+		{"RecordScheduleRun moves the schedule once", testRecordScheduleRun},
+		{"LeadScheduler elects a single runner", testLeadScheduler},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newStores(t))
+		})
+	}
+}
+
+func newUser(t *testing.T, stores Stores) uuid.UUID {
+	t.Helper()
+
+	user := storage.User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := stores.Users.Add(context.Background(), user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	return user.ID
+}
+
+func newTransaction(userID uuid.UUID, amount float64) storage.Transaction {
+	return storage.Transaction{
+		ID:             uuid.New(),
+		UserID:         userID,
+		Amount:         decimal.NewFromFloat(amount),
+		IdempotencyKey: uuid.New(),
+	}
+}
+
+// assertCreatedNow asserts that a created_at was set by the store at about the current time
+// The clock of the database may be a little off the clock of the test
+func assertCreatedNow(t *testing.T, createdAt time.Time) {
+	t.Helper()
+
+	assert.WithinDuration(t, time.Now(), createdAt, time.Minute, "created_at should be set by the store")
+}
+
+func balance(t *testing.T, stores Stores, userID uuid.UUID) decimal.Decimal {
+	t.Helper()
+
+	user, err := stores.Users.FindByID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("failed to find user: %v", err)
+	}
+	return user.Balance
+}
+
+func testAddTransaction(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 100.5)
+	transaction.CreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	added, err := stores.Transactions.AddTransaction(ctx, transaction)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, transaction.ID, added.ID)
+	assertCreatedNow(t, added.CreatedAt)
+	assert.True(t, added.EffectiveDate.Equal(added.CreatedAt), "effective_date should default to created_at, got %s", added.EffectiveDate)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromFloat(100.5)))
+}
+
+func testAddTransactionBackdated(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 10)
+	transaction.EffectiveDate = time.Date(2020, 1, 1, 1, 0, 0, 1500, time.FixedZone("CET", 3600))
+
+	// Act
+	added, err := stores.Transactions.AddTransaction(ctx, transaction)
+	history, historyErr := stores.Transactions.GetUserTransactionHistory(ctx, userID, 1, 10, storage.HistoryFilter{})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, historyErr)
+	assertCreatedNow(t, added.CreatedAt)
+	effectiveDate := time.Date(2020, 1, 1, 0, 0, 0, 2000, time.UTC)
+	assert.True(t, added.EffectiveDate.Equal(effectiveDate), "effective_date should be rounded to microseconds, got %s", added.EffectiveDate)
+	if assert.Len(t, history, 1) {
+		assert.True(t, history[0].EffectiveDate.Equal(effectiveDate), "got %s", history[0].EffectiveDate)
+		assert.True(t, history[0].CreatedAt.Equal(added.CreatedAt), "got %s", history[0].CreatedAt)
+	}
+}
+
+func testAddTransactionUnknownUser(t *testing.T, stores Stores) {
+	// Act
+	_, err := stores.Transactions.AddTransaction(context.Background(), newTransaction(uuid.New(), 10))
+
+	// Assert
+	assert.Equal(t, storage.ErrUserNotFound, err)
+}
+
+func testAddTransactionIdempotent(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	first := newTransaction(userID, 10)
+	if _, err := stores.Transactions.AddTransaction(ctx, first); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	sameAmount := newTransaction(userID, 10)
+	sameAmount.IdempotencyKey = first.IdempotencyKey
+	otherAmount := newTransaction(userID, 20)
+	otherAmount.IdempotencyKey = first.IdempotencyKey
+
+	// Act
+	_, sameAmountErr := stores.Transactions.AddTransaction(ctx, sameAmount)
+	_, otherAmountErr := stores.Transactions.AddTransaction(ctx, otherAmount)
+
+	// Assert
+	assert.True(t, errors.Is(sameAmountErr, storage.ErrDuplicateTransaction), "got %v", sameAmountErr)
+	assert.NoError(t, otherAmountErr)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromFloat(30)))
+}
+
+func testAddTransactionConcurrent(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	const concurrentRequests = 50
+
+	// Act
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := stores.Transactions.AddTransaction(ctx, newTransaction(userID, 1)); err != nil {
+				t.Errorf("failed to add transaction: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(concurrentRequests)))
+}
+
+func testAddTransactionSameKeyConcurrent(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	idempotencyKey := uuid.New()
+	const concurrentRequests = 20
+
+	// Act
+	var wg sync.WaitGroup
+	var added int32
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transaction := newTransaction(userID, 5)
+			transaction.IdempotencyKey = idempotencyKey
+			if _, err := stores.Transactions.AddTransaction(ctx, transaction); err == nil {
+				atomic.AddInt32(&added, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, int32(1), added)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(5)))
+}
+
+func testAddTransactionsAtomic(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transactions := []storage.Transaction{
+		newTransaction(userID, 10),
+		newTransaction(uuid.New(), 20),
+	}
+
+	// Act
+	errs, err := stores.Transactions.AddTransactions(ctx, transactions, true)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []error{storage.ErrBatchAborted, storage.ErrUserNotFound}, errs)
+	assert.True(t, balance(t, stores, userID).IsZero())
+}
+
+func testAddTransactionsBestEffort(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	existing := newTransaction(userID, 10)
+	if _, err := stores.Transactions.AddTransaction(ctx, existing); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	duplicate := newTransaction(userID, 10)
+	duplicate.IdempotencyKey = existing.IdempotencyKey
+	transactions := []storage.Transaction{
+		newTransaction(userID, 1),
+		duplicate,
+		newTransaction(uuid.New(), 20),
+		newTransaction(userID, 2),
+	}
+
+	// Act
+	errs, err := stores.Transactions.AddTransactions(ctx, transactions, false)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, storage.ErrDuplicateTransaction, storage.ErrUserNotFound, nil}, errs)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(13)))
+	assertCreatedNow(t, transactions[0].CreatedAt)
+	assert.True(t, transactions[3].EffectiveDate.Equal(transactions[3].CreatedAt), "effective_date should default to created_at, got %s", transactions[3].EffectiveDate)
+}
+
+func testHistory(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	for i := 0; i < 5; i++ {
+		transaction := newTransaction(userID, float64(i+1))
+		if _, err := stores.Transactions.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	// Act
+	firstPage, firstErr := stores.Transactions.GetUserTransactionHistory(ctx, userID, 1, 2, storage.HistoryFilter{})
+	lastPage, lastErr := stores.Transactions.GetUserTransactionHistory(ctx, userID, 3, 2, storage.HistoryFilter{})
+	pastEnd, pastEndErr := stores.Transactions.GetUserTransactionHistory(ctx, userID, 4, 2, storage.HistoryFilter{})
+	unknown, unknownErr := stores.Transactions.GetUserTransactionHistory(ctx, uuid.New(), 1, 2, storage.HistoryFilter{})
+
+	// Assert
+	assert.NoError(t, firstErr)
+	assert.NoError(t, lastErr)
+	assert.NoError(t, pastEndErr)
+	assert.NoError(t, unknownErr)
+	if assert.Len(t, firstPage, 2) {
+		assert.True(t, firstPage[0].Amount.Equal(decimal.NewFromInt(5)))
+		assert.False(t, firstPage[0].CreatedAt.Before(firstPage[1].CreatedAt))
+		assert.True(t, firstPage[1].Amount.Equal(decimal.NewFromInt(4)))
+	}
+	if assert.Len(t, lastPage, 1) {
+		assert.True(t, lastPage[0].Amount.Equal(decimal.NewFromInt(1)))
+	}
+	assert.Empty(t, pastEnd)
+	assert.Empty(t, unknown)
+}
+
+func testAddTransactionDetails(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 10)
+	transaction.Description = "Refund of order A-1"
+	transaction.Category = "refund"
+	transaction.Currency = "EUR"
+	transaction.Source = "shop-" + uuid.NewString()
+	transaction.ExternalReference = "A-1"
+	transaction.Metadata = map[string]interface{}{"order_id": "A-1", "attempt": 2, "flags": map[string]interface{}{"manual": true}}
+	plain := newTransaction(userID, 20)
+
+	// Act
+	added, err := stores.Transactions.AddTransaction(ctx, transaction)
+	_, plainErr := stores.Transactions.AddTransaction(ctx, plain)
+	history, historyErr := stores.Transactions.GetUserTransactionHistory(ctx, userID, 1, 10, storage.HistoryFilter{})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, plainErr)
+	assert.NoError(t, historyErr)
+	assert.Equal(t, transaction.ExternalReference, added.ExternalReference)
+	metadata := map[string]interface{}{"order_id": "A-1", "attempt": float64(2), "flags": map[string]interface{}{"manual": true}}
+	if assert.Len(t, history, 2) {
+		assert.Empty(t, history[0].Description)
+		assert.Empty(t, history[0].Currency)
+		assert.Nil(t, history[0].Metadata)
+		assert.Equal(t, transaction.Description, history[1].Description)
+		assert.Equal(t, transaction.Category, history[1].Category)
+		assert.Equal(t, transaction.Currency, history[1].Currency)
+		assert.Equal(t, transaction.Source, history[1].Source)
+		assert.Equal(t, transaction.ExternalReference, history[1].ExternalReference)
+		assert.Equal(t, metadata, history[1].Metadata)
+	}
+}
+
+func testAddTransactionReferenceUnique(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	source := "shop-" + uuid.NewString()
+	withReference := func(source string, reference string) storage.Transaction {
+		transaction := newTransaction(userID, 10)
+		transaction.Source = source
+		transaction.ExternalReference = reference
+		return transaction
+	}
+	if _, err := stores.Transactions.AddTransaction(ctx, withReference(source, "A-1")); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	_, sameErr := stores.Transactions.AddTransaction(ctx, withReference(source, "A-1"))
+	_, otherSourceErr := stores.Transactions.AddTransaction(ctx, withReference("other-"+source, "A-1"))
+	errs, batchErr := stores.Transactions.AddTransactions(ctx, []storage.Transaction{
+		withReference(source, "A-2"),
+		withReference(source, "A-2"),
+		withReference(source, "A-1"),
+	}, false)
+
+	// Assert
+	assert.True(t, errors.Is(sameErr, storage.ErrDuplicateTransaction), "got %v", sameErr)
+	assert.NoError(t, otherSourceErr)
+	assert.NoError(t, batchErr)
+	assert.Equal(t, []error{nil, storage.ErrDuplicateTransaction, storage.ErrDuplicateTransaction}, errs)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(30)))
+}
+
+func testHistoryFilter(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	details := []struct {
+		reference string
+		metadata  map[string]interface{}
+	}{
+		{"A-1", map[string]interface{}{"channel": "web", "attempt": 1}},
+		{"A-2", map[string]interface{}{"channel": "web", "attempt": 2}},
+		{"A-3", map[string]interface{}{"channel": "app", "attempt": 2, "note": nil}},
+		{"", nil},
+	}
+	for i, detail := range details {
+		transaction := newTransaction(userID, float64(i+1))
+		transaction.ExternalReference = detail.reference
+		transaction.Metadata = detail.metadata
+		if _, err := stores.Transactions.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		filter          storage.HistoryFilter
+		expectedAmounts []int64
+	}{
+		{"No filter", storage.HistoryFilter{}, []int64{4, 3, 2, 1}},
+		{"Reference", storage.HistoryFilter{Reference: "A-2"}, []int64{2}},
+		{"Unknown reference", storage.HistoryFilter{Reference: "A-4"}, []int64{}},
+		{"Metadata string", storage.HistoryFilter{Metadata: map[string]string{"channel": "web"}}, []int64{2, 1}},
+		{"Metadata number", storage.HistoryFilter{Metadata: map[string]string{"attempt": "2"}}, []int64{3, 2}},
+		{"Metadata keys", storage.HistoryFilter{Metadata: map[string]string{"channel": "web", "attempt": "2"}}, []int64{2}},
+		{"Metadata null", storage.HistoryFilter{Metadata: map[string]string{"note": "null"}}, []int64{}},
+		{"Reference and metadata", storage.HistoryFilter{Reference: "A-1", Metadata: map[string]string{"channel": "app"}}, []int64{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			history, err := stores.Transactions.GetUserTransactionHistory(ctx, userID, 1, 10, tc.filter)
+
+			// Assert
+			assert.NoError(t, err)
+			amounts := []int64{}
+			for _, transaction := range history {
+				amounts = append(amounts, transaction.Amount.IntPart())
+			}
+			assert.Equal(t, tc.expectedAmounts, amounts)
+		})
+	}
+}
+
+func testFindUnknownUser(t *testing.T, stores Stores) {
+	// Act
+	_, err := stores.Users.FindByID(context.Background(), uuid.New())
+
+	// Assert
+	assert.Equal(t, storage.ErrUserNotFound, err)
+}
+
+func testFindTransactionByID(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 10)
+	transaction.Description = "Invoice 1"
+	transaction.Metadata = map[string]interface{}{"order_id": "1"}
+	added, err := stores.Transactions.AddTransaction(ctx, transaction)
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	found, err := stores.Transactions.FindTransactionByID(ctx, transaction.ID)
+	_, unknownErr := stores.Transactions.FindTransactionByID(ctx, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, added.ID, found.ID)
+	assert.Equal(t, userID, found.UserID)
+	assert.True(t, found.Amount.Equal(added.Amount), "got %s", found.Amount)
+	assert.True(t, found.CreatedAt.Equal(added.CreatedAt), "got %s", found.CreatedAt)
+	assert.True(t, found.EffectiveDate.Equal(added.EffectiveDate), "got %s", found.EffectiveDate)
+	assert.Equal(t, transaction.IdempotencyKey, found.IdempotencyKey)
+	assert.Equal(t, "Invoice 1", found.Description)
+	assert.Equal(t, map[string]interface{}{"order_id": "1"}, found.Metadata)
+	assert.Equal(t, storage.ErrTransactionNotFound, unknownErr)
+}
+
+func testFindUserTransactionsByIdempotencyKey(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	otherUserID := newUser(t, stores)
+	first := newTransaction(userID, 10)
+	otherAmount := newTransaction(userID, 20)
+	otherAmount.IdempotencyKey = first.IdempotencyKey
+	otherUser := newTransaction(otherUserID, 30)
+	otherUser.IdempotencyKey = first.IdempotencyKey
+	for _, transaction := range []storage.Transaction{first, otherAmount, otherUser, newTransaction(userID, 40)} {
+		if _, err := stores.Transactions.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	// Act
+	found, err := stores.Transactions.FindUserTransactionsByIdempotencyKey(ctx, userID, first.IdempotencyKey)
+	unknown, unknownErr := stores.Transactions.FindUserTransactionsByIdempotencyKey(ctx, userID, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	ids := []uuid.UUID{}
+	for _, transaction := range found {
+		ids = append(ids, transaction.ID)
+	}
+	assert.ElementsMatch(t, []uuid.UUID{first.ID, otherAmount.ID}, ids)
+	assert.NoError(t, unknownErr)
+	assert.Empty(t, unknown)
+}
+
+func testVerifyChain(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	if _, err := stores.Transactions.AddTransaction(ctx, newTransaction(userID, 10.1)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	transactions := []storage.Transaction{newTransaction(userID, 20.2), newTransaction(userID, 30.3)}
+	if _, err := stores.Transactions.AddTransactions(ctx, transactions, true); err != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+
+	// Act
+	report, err := stores.Transactions.VerifyChain(ctx, userID)
+	_, unknownErr := stores.Transactions.VerifyChain(ctx, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Nil(t, report.Break)
+	assert.Equal(t, int64(3), report.Transactions)
+	assert.Equal(t, storage.ErrUserNotFound, unknownErr)
+}
+
+// addTransactions adds transactions of the amounts one after the other and returns them as added
+func addTransactions(t *testing.T, stores Stores, userID uuid.UUID, amounts ...float64) []storage.Transaction {
+	t.Helper()
+
+	added := []storage.Transaction{}
+	for _, amount := range amounts {
+		transaction, err := stores.Transactions.AddTransaction(context.Background(), newTransaction(userID, amount))
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+		added = append(added, transaction)
+	}
+	return added
+}
+
+func testTransactionsBetween(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	added := addTransactions(t, stores, userID, 10, 20, 30)
+	from, to := added[0].CreatedAt, added[2].CreatedAt.Add(time.Microsecond)
+
+	// Act
+	transactions, err := stores.Transactions.GetUserTransactionsBetween(ctx, userID, from, to)
+	empty, emptyErr := stores.Transactions.GetUserTransactionsBetween(ctx, userID, from, from)
+	unknown, unknownErr := stores.Transactions.GetUserTransactionsBetween(ctx, uuid.New(), from, to)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, emptyErr)
+	assert.NoError(t, unknownErr)
+	if assert.Len(t, transactions, 3) {
+		for i := range added {
+			assert.Equal(t, added[i].ID, transactions[i].ID, "the transactions should be oldest first")
+		}
+	}
+	assert.Empty(t, empty, "to is excluded")
+	assert.Empty(t, unknown)
+}
+
+func testBalanceAsOf(t *testing.T, stores Stores) {
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	added := addTransactions(t, stores, userID, 10.1, 20.2)
+
+	// Act
+	before, beforeErr := stores.Transactions.BalanceAsOf(ctx, userID, added[0].CreatedAt)
+	after, afterErr := stores.Transactions.BalanceAsOf(ctx, userID, added[1].CreatedAt.Add(time.Microsecond))
+	unknown, unknownErr := stores.Transactions.BalanceAsOf(ctx, uuid.New(), time.Now())
+
+	// Assert
+	assert.NoError(t, beforeErr)
+	assert.NoError(t, afterErr)
+	assert.NoError(t, unknownErr)
+	assert.True(t, before.IsZero(), "the transactions created at the time are excluded, got %s", before)
+	assert.True(t, after.Equal(balance(t, stores, userID)), "expected the balance %s, got %s", balance(t, stores, userID), after)
+	assert.True(t, unknown.IsZero())
+}
+
+// applyPostings applies the pending postings until none is left and returns them in the order they were applied
+func applyPostings(t *testing.T, stores Stores) []storage.Posting {
+	t.Helper()
+
+	var applied []storage.Posting
+	for {
+		posting, ok, err := stores.Postings.ApplyNextPosting(context.Background())
+		if err != nil {
+			t.Fatalf("failed to apply posting: %v", err)
+		}
+		if !ok {
+			return applied
+		}
+		applied = append(applied, posting)
+	}
+}
+
+func newPosting(transaction storage.Transaction) storage.Posting {
+	return storage.Posting{ID: uuid.New(), Transaction: transaction}
+}
+
+func testEnqueuePosting(t *testing.T, stores Stores) {
+	if stores.Postings == nil {
+		t.Skip("the backend has no posting queue")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 10)
+	transaction.Description = "Invoice 1"
+	transaction.Metadata = map[string]interface{}{"order_id": "1"}
+	duplicate := newTransaction(userID, 10)
+	duplicate.IdempotencyKey = transaction.IdempotencyKey
+
+	// Act
+	queued, err := stores.Postings.EnqueuePosting(ctx, newPosting(transaction))
+	found, findErr := stores.Postings.FindPosting(ctx, queued.ID)
+	_, duplicateErr := stores.Postings.EnqueuePosting(ctx, newPosting(duplicate))
+	_, unknownUserErr := stores.Postings.EnqueuePosting(ctx, newPosting(newTransaction(uuid.New(), 10)))
+	_, unknownErr := stores.Postings.FindPosting(ctx, uuid.New())
+	applyPostings(t, stores)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PostingPending, queued.Status)
+	assertCreatedNow(t, queued.CreatedAt)
+	assert.True(t, queued.ProcessedAt.IsZero())
+	assert.NoError(t, findErr)
+	assert.Equal(t, queued.ID, found.ID)
+	assert.Equal(t, transaction.ID, found.Transaction.ID)
+	assert.Equal(t, userID, found.Transaction.UserID)
+	assert.True(t, found.Transaction.Amount.Equal(transaction.Amount), "got %s", found.Transaction.Amount)
+	assert.Equal(t, transaction.IdempotencyKey, found.Transaction.IdempotencyKey)
+	assert.Equal(t, "Invoice 1", found.Transaction.Description)
+	assert.Equal(t, map[string]interface{}{"order_id": "1"}, found.Transaction.Metadata)
+	assert.True(t, errors.Is(duplicateErr, storage.ErrDuplicatePosting), "got %v", duplicateErr)
+	assert.Equal(t, storage.ErrUserNotFound, unknownUserErr)
+	assert.Equal(t, storage.ErrPostingNotFound, unknownErr)
+}
+
+func testApplyNextPosting(t *testing.T, stores Stores) {
+	if stores.Postings == nil {
+		t.Skip("the backend has no posting queue")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	otherUserID := newUser(t, stores)
+	var queued []storage.Posting
+	for _, transaction := range []storage.Transaction{
+		newTransaction(userID, 10),
+		newTransaction(otherUserID, 5),
+		newTransaction(userID, -4),
+	} {
+		posting, err := stores.Postings.EnqueuePosting(ctx, newPosting(transaction))
+		if err != nil {
+			t.Fatalf("failed to enqueue posting: %v", err)
+		}
+		queued = append(queued, posting)
+	}
+
+	// Act
+	applied := applyPostings(t, stores)
+	found, err := stores.Postings.FindPosting(ctx, queued[0].ID)
+
+	// Assert
+	var userPostings []uuid.UUID
+	for _, posting := range applied {
+		assert.Equal(t, storage.PostingApplied, posting.Status)
+		if posting.Transaction.UserID == userID {
+			userPostings = append(userPostings, posting.ID)
+		}
+	}
+	assert.Equal(t, []uuid.UUID{queued[0].ID, queued[2].ID}, userPostings)
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(6)), "got %s", balance(t, stores, userID))
+	assert.True(t, balance(t, stores, otherUserID).Equal(decimal.NewFromInt(5)), "got %s", balance(t, stores, otherUserID))
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PostingApplied, found.Status)
+	assert.Empty(t, found.Error)
+	assert.False(t, found.ProcessedAt.IsZero())
+
+	stored, err := stores.Transactions.FindTransactionByID(ctx, queued[0].Transaction.ID)
+	assert.NoError(t, err)
+	assertCreatedNow(t, stored.CreatedAt)
+}
+
+func testApplyNextPostingDuplicate(t *testing.T, stores Stores) {
+	if stores.Postings == nil {
+		t.Skip("the backend has no posting queue")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	transaction := newTransaction(userID, 10)
+	if _, err := stores.Transactions.AddTransaction(ctx, transaction); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	duplicate := newTransaction(userID, 10)
+	duplicate.IdempotencyKey = transaction.IdempotencyKey
+	queued, err := stores.Postings.EnqueuePosting(ctx, newPosting(duplicate))
+	if err != nil {
+		t.Fatalf("failed to enqueue posting: %v", err)
+	}
+
+	// Act
+	applyPostings(t, stores)
+	found, err := stores.Postings.FindPosting(ctx, queued.ID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, storage.PostingFailed, found.Status)
+	assert.Contains(t, found.Error, storage.ErrDuplicateTransaction.Error())
+	assert.False(t, found.ProcessedAt.IsZero())
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(10)), "got %s", balance(t, stores, userID))
+	_, err = stores.Transactions.FindTransactionByID(ctx, duplicate.ID)
+	assert.Equal(t, storage.ErrTransactionNotFound, err)
+}
+
+func testApplyNextPostingEntries(t *testing.T, stores Stores) {
+	if stores.Postings == nil {
+		t.Skip("the backend has no posting queue")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID, revenueAccount := newUser(t, stores), newUser(t, stores)
+	withEntries := newPosting(newTransaction(userID, 10))
+	withEntries.Entries = []storage.Transaction{newTransaction(userID, -1), newTransaction(revenueAccount, 1)}
+	withEntries.Entries[0].Category = "fee"
+	withEntries.Entries[0].Metadata = map[string]interface{}{"fee_of": withEntries.Transaction.ID.String()}
+	unknownEntry := newPosting(newTransaction(userID, 20))
+	unknownEntry.Entries = []storage.Transaction{newTransaction(userID, -1), newTransaction(uuid.New(), 1)}
+	for _, posting := range []storage.Posting{withEntries, unknownEntry} {
+		if _, err := stores.Postings.EnqueuePosting(ctx, posting); err != nil {
+			t.Fatalf("failed to enqueue posting: %v", err)
+		}
+	}
+
+	// Act
+	applied := applyPostings(t, stores)
+	entry, entryErr := stores.Transactions.FindTransactionByID(ctx, withEntries.Entries[0].ID)
+	_, requeueErr := stores.Postings.EnqueuePosting(ctx, newPosting(unknownEntry.Transaction))
+	reapplied := applyPostings(t, stores)
+
+	// Assert
+	if assert.Len(t, applied, 2) {
+		assert.Equal(t, storage.PostingApplied, applied[0].Status)
+		assert.Equal(t, storage.PostingFailed, applied[1].Status)
+		assert.Contains(t, applied[1].Error, storage.ErrUserNotFound.Error())
+	}
+	assert.True(t, balance(t, stores, userID).Equal(decimal.NewFromInt(29)), "got %s", balance(t, stores, userID))
+	assert.True(t, balance(t, stores, revenueAccount).Equal(decimal.NewFromInt(1)), "got %s", balance(t, stores, revenueAccount))
+	assert.NoError(t, entryErr)
+	assert.Equal(t, "fee", entry.Category)
+	assert.Equal(t, withEntries.Transaction.ID.String(), entry.Metadata["fee_of"])
+	_, err := stores.Transactions.FindTransactionByID(ctx, unknownEntry.Entries[0].ID)
+	assert.Equal(t, storage.ErrTransactionNotFound, err, "no entry of a failed posting is added")
+	assert.NoError(t, requeueErr, "a failed posting releases its idempotency key")
+	if assert.Len(t, reapplied, 1) {
+		assert.Equal(t, storage.PostingApplied, reapplied[0].Status)
+	}
+}
+
+func newSchedule(userID uuid.UUID, nextRunAt time.Time) storage.Schedule {
+	return storage.Schedule{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Amount:    decimal.NewFromInt(10),
+		Interval:  time.Hour,
+		StartAt:   nextRunAt,
+		NextRunAt: nextRunAt,
+	}
+}
+
+// dueSchedule reports whether a schedule is among the due schedules
+func dueSchedule(t *testing.T, stores Stores, scheduleID uuid.UUID, now time.Time) bool {
+	t.Helper()
+
+	due, err := stores.Schedules.DueSchedules(context.Background(), now, 1000)
+	if err != nil {
+		t.Fatalf("failed to read the due schedules: %v", err)
+	}
+	for _, schedule := range due {
+		if schedule.ID == scheduleID {
+			return true
+		}
+	}
+	return false
+}
+
+func testAddSchedule(t *testing.T, stores Stores) {
+	if stores.Schedules == nil {
+		t.Skip("the backend has no schedules")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	start := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	schedule := newSchedule(userID, start)
+	schedule.EndAt = start.AddDate(1, 0, 0)
+	schedule.Description = "subscription"
+	cron := newSchedule(userID, start)
+	cron.Interval, cron.Cron = 0, "0 9 * * *"
+
+	// Act
+	added, err := stores.Schedules.AddSchedule(ctx, schedule)
+	_, cronErr := stores.Schedules.AddSchedule(ctx, cron)
+	found, findErr := stores.Schedules.FindSchedule(ctx, schedule.ID)
+	listed, listErr := stores.Schedules.ListUserSchedules(ctx, userID)
+	_, unknownUserErr := stores.Schedules.AddSchedule(ctx, newSchedule(uuid.New(), start))
+	_, unknownErr := stores.Schedules.FindSchedule(ctx, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ScheduleActive, added.Status)
+	assertCreatedNow(t, added.CreatedAt)
+	assert.NoError(t, cronErr)
+	assert.NoError(t, findErr)
+	assert.Equal(t, userID, found.UserID)
+	assert.True(t, found.Amount.Equal(decimal.NewFromInt(10)), "got %s", found.Amount)
+	assert.Equal(t, time.Hour, found.Interval)
+	assert.Equal(t, start, found.StartAt)
+	assert.Equal(t, start.AddDate(1, 0, 0), found.EndAt)
+	assert.Equal(t, start, found.NextRunAt)
+	assert.Equal(t, "subscription", found.Description)
+	assert.NoError(t, listErr)
+	if assert.Len(t, listed, 2) {
+		assert.Equal(t, schedule.ID, listed[0].ID)
+		assert.Equal(t, "0 9 * * *", listed[1].Cron)
+		assert.True(t, listed[1].EndAt.IsZero())
+	}
+	assert.Equal(t, storage.ErrUserNotFound, unknownUserErr)
+	assert.Equal(t, storage.ErrScheduleNotFound, unknownErr)
+}
+
+func testRecordScheduleRun(t *testing.T, stores Stores) {
+	if stores.Schedules == nil {
+		t.Skip("the backend has no schedules")
+	}
+
+	// Assign
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	occurrence := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	schedule, err := stores.Schedules.AddSchedule(ctx, newSchedule(userID, occurrence))
+	if err != nil {
+		t.Fatalf("failed to add schedule: %v", err)
+	}
+	transactionID := uuid.New()
+	run := storage.ScheduleRun{ScheduleID: schedule.ID, ScheduledAt: occurrence, Status: storage.RunPosted, TransactionID: transactionID}
+	next := occurrence.Add(time.Hour)
+
+	// Act
+	due := dueSchedule(t, stores, schedule.ID, occurrence)
+	notDue := dueSchedule(t, stores, schedule.ID, occurrence.Add(-time.Second))
+	recordErr := stores.Schedules.RecordScheduleRun(ctx, run, next, false)
+	// A second runner recording the same occurrence neither overwrites the run nor moves the schedule
+	againErr := stores.Schedules.RecordScheduleRun(ctx, storage.ScheduleRun{ScheduleID: schedule.ID, ScheduledAt: occurrence, Status: storage.RunFailed}, next.Add(time.Hour), false)
+	moved, movedErr := stores.Schedules.FindSchedule(ctx, schedule.ID)
+	_, pauseErr := stores.Schedules.UpdateSchedule(ctx, schedule.ID, storage.SchedulePaused, next)
+	pausedDue := dueSchedule(t, stores, schedule.ID, next)
+	endErr := stores.Schedules.RecordScheduleRun(ctx, storage.ScheduleRun{ScheduleID: schedule.ID, ScheduledAt: next, Status: storage.RunFailed, Error: "failed"}, next, true)
+	ended, endedErr := stores.Schedules.FindSchedule(ctx, schedule.ID)
+	runs, runsErr := stores.Schedules.ListScheduleRuns(ctx, schedule.ID, 10)
+	limited, limitedErr := stores.Schedules.ListScheduleRuns(ctx, schedule.ID, 1)
+
+	// Assert
+	assert.True(t, due)
+	assert.False(t, notDue)
+	assert.NoError(t, recordErr)
+	assert.NoError(t, againErr)
+	assert.NoError(t, movedErr)
+	assert.Equal(t, next, moved.NextRunAt)
+	assert.Equal(t, storage.ScheduleActive, moved.Status)
+	assert.NoError(t, pauseErr)
+	assert.False(t, pausedDue, "a paused schedule is not due")
+	assert.NoError(t, endErr)
+	assert.NoError(t, endedErr)
+	assert.Equal(t, storage.ScheduleEnded, ended.Status)
+	assert.NoError(t, runsErr)
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, next, runs[0].ScheduledAt)
+		assert.Equal(t, storage.RunFailed, runs[0].Status)
+		assert.Equal(t, "failed", runs[0].Error)
+		assert.Equal(t, uuid.Nil, runs[0].TransactionID)
+		assert.Equal(t, storage.RunPosted, runs[1].Status)
+		assert.Equal(t, transactionID, runs[1].TransactionID)
+		assertCreatedNow(t, runs[1].RanAt)
+	}
+	assert.NoError(t, limitedErr)
+	assert.Len(t, limited, 1)
+}
+
+func testLeadScheduler(t *testing.T, stores Stores) {
+	if stores.Schedules == nil {
+		t.Skip("the backend has no schedules")
+	}
+
+	// Assign
+	ctx := context.Background()
+
+	// Act
+	release, lead, err := stores.Schedules.LeadScheduler(ctx)
+	_, secondLead, secondErr := stores.Schedules.LeadScheduler(ctx)
+	if lead {
+		release()
+	}
+	releaseAgain, leadAgain, againErr := stores.Schedules.LeadScheduler(ctx)
+	if leadAgain {
+		releaseAgain()
+	}
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, lead)
+	assert.NoError(t, secondErr)
+	assert.False(t, secondLead, "a single runner leads")
+	assert.NoError(t, againErr)
+	assert.True(t, leadAgain, "the lead is released")
+}
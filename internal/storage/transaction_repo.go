@@ -3,40 +3,96 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
 )
 
+var (
+	// ErrDuplicateTransaction is returned for a transaction whose idempotency key
+	// and amount, or source and external reference, were already used
+	ErrDuplicateTransaction = errors.New("duplicate transaction")
+	// ErrBatchAborted is returned for a transaction of an all-or-nothing batch
+	// which was not added because another transaction of the batch failed
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrTransactionNotFound is returned when a transaction looked up by its ID does not exist
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrArchivedMonth is returned for an imported transaction created in a month whose partition was archived
+	ErrArchivedMonth = errors.New("transaction month is archived")
+)
+
+// insertChunkSize is the number of rows inserted by a single statement
+// It keeps the number of parameters below the Postgres limit of 65535
+const insertChunkSize = 1000
+
 type Transaction struct {
-	ID             uuid.UUID
-	UserID         uuid.UUID
-	Amount         decimal.Decimal
-	CreatedAt      time.Time
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Amount decimal.Decimal
+	// CreatedAt is set by the database when the transaction is added, only imported
+	// transactions keep their own
+	CreatedAt time.Time
+	// EffectiveDate is the date the transaction takes effect, it defaults to CreatedAt
+	// and is earlier for backdated transactions
+	EffectiveDate  time.Time
 	IdempotencyKey uuid.UUID
+	// Currency is the ISO 4217 code of the amount, it is optional
+	Currency string
+	// ExternalReference is the reference of the transaction in the system it originates from,
+	// it is unique per Source. Imported transactions have a reference but no source
+	ExternalReference string
+	Source            string
+	Description       string
+	Category          string
+	Metadata          map[string]interface{}
+}
+
+// HistoryFilter selects the transactions of a history
+// Reference matches the external reference, every key of Metadata matches the transactions
+// whose metadata value for the key is the given text. Numbers and booleans match their JSON text
+type HistoryFilter struct {
+	Reference string
+	Metadata  map[string]string
 }
 
+// transactionColumns are the columns read by scanTransaction
+const transactionColumns = `id, user_id, amount, created_at, effective_date, idempotency_key,
+	currency, external_reference, source, description, category, metadata`
+
 type TransactionRepository struct {
 	db *sql.DB
+	// replica serves the eventually consistent reads, nil without a replica
+	replica *ReplicaRouter
 }
 
-func NewTransactionRepository(db *sql.DB) *TransactionRepository {
-	return &TransactionRepository{db: db}
+func NewTransactionRepository(db *sql.DB, opts ...RepositoryOption) *TransactionRepository {
+	return &TransactionRepository{db: db, replica: newRepositoryOptions(opts).replica}
 }
 
+// FindTransactionByID returns a transaction by ID
+// If the transaction is not found, ErrTransactionNotFound is returned
 func (t *TransactionRepository) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (Transaction, error) {
-	var transaction Transaction
-	err := t.db.QueryRowContext(ctx, `SELECT id, user_id, amount, created_at,idempotency_key FROM transactions WHERE id = $1`, transactionID).
-		Scan(&transaction.ID,
-			&transaction.UserID,
-			&transaction.Amount,
-			&transaction.CreatedAt,
-			&transaction.IdempotencyKey)
-
+	// created_at is read from transaction_keys first, so that only the partition of the transaction is scanned
+	transaction, err := scanTransaction(t.replica.reader(ctx, t.db).QueryRowContext(ctx, `SELECT `+transactionColumns+` FROM transactions
+		WHERE id = $1 AND created_at = (SELECT created_at FROM transaction_keys WHERE id = $1)`, transactionID))
+	if err == sql.ErrNoRows {
+		return Transaction{}, ErrTransactionNotFound
+	}
 	return transaction, err
 }
 
+// AddTransaction adds a transaction and updates the balance of its user
+// The created_at of the transaction is the time of the database transaction
+// If the ID, the idempotency key and amount or the source and external reference were already used,
+// ErrDuplicateTransaction is returned
 func (t *TransactionRepository) AddTransaction(ctx context.Context, transaction Transaction) (Transaction, error) {
 	// Begin a new transaction
 	tx, err := t.db.BeginTx(ctx, nil)
@@ -44,57 +100,119 @@ func (t *TransactionRepository) AddTransaction(ctx context.Context, transaction
 		return Transaction{}, err
 	}
 
+	added, err := addTransaction(ctx, tx, transaction)
+	if err != nil {
+		tx.Rollback()
+		return Transaction{}, err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return added, nil
+}
+
+// addTransaction adds a transaction and updates the balance of its user in the database transaction tx
+// The caller rolls tx back if an error is returned
+func addTransaction(ctx context.Context, tx *sql.Tx, transaction Transaction) (Transaction, error) {
 	// Lock the user row using SELECT FOR UPDATE
 	var currentBalance decimal.Decimal
-	err = tx.QueryRowContext(ctx, "SELECT balance FROM users WHERE id = $1 FOR UPDATE", transaction.UserID).Scan(&currentBalance)
+	var head chainHead
+	err := tx.QueryRowContext(ctx, "SELECT balance, chain_seq, chain_hash, now() AT TIME ZONE 'UTC' FROM users WHERE id = $1 FOR UPDATE", transaction.UserID).
+		Scan(&currentBalance, &head.seq, &head.hash, &transaction.CreatedAt)
 	if err == sql.ErrNoRows {
-		tx.Rollback()
 		return Transaction{}, ErrUserNotFound
 	}
 
 	if err != nil {
-		tx.Rollback()
 		return Transaction{}, err
 	}
 
-	// Insert the transaction
-	err = tx.QueryRowContext(ctx, `INSERT INTO transactions (id, user_id, amount, created_at,idempotency_key) VALUES ($1, $2, $3, $4,$5) RETURNING id, created_at`,
+	// Insert the transaction as the next link of the user's hash chain
+	transaction.EffectiveDate = effectiveDate(transaction)
+	head = chainHead{seq: head.seq + 1, hash: chainHash(head.hash, transaction)}
+	metadata, err := encodeMetadata(transaction.Metadata)
+	if err != nil {
+		return Transaction{}, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO transaction_keys (id, user_id, created_at, idempotency_key, amount, source, external_reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		transaction.ID,
+		transaction.UserID,
+		transaction.CreatedAt,
+		transaction.IdempotencyKey,
+		transaction.Amount,
+		nullString(transaction.Source),
+		nullString(transaction.ExternalReference))
+	if isUniqueViolation(err) {
+		return Transaction{}, fmt.Errorf("%w: %v", ErrDuplicateTransaction, err)
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	err = tx.QueryRowContext(ctx, `INSERT INTO transactions (id, user_id, amount, created_at, effective_date, idempotency_key, chain_seq, hash,
+		currency, external_reference, source, description, category, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id, created_at`,
 		transaction.ID,
 		transaction.UserID,
 		transaction.Amount,
 		transaction.CreatedAt,
-		transaction.IdempotencyKey).
+		transaction.EffectiveDate,
+		transaction.IdempotencyKey,
+		head.seq,
+		head.hash,
+		nullString(transaction.Currency),
+		nullString(transaction.ExternalReference),
+		nullString(transaction.Source),
+		nullString(transaction.Description),
+		nullString(transaction.Category),
+		metadata).
 		Scan(&transaction.ID,
 			&transaction.CreatedAt)
 	if err != nil {
-		tx.Rollback()
 		return Transaction{}, err
 	}
 
-	// Update the user's balance
+	// Update the user's balance and the head of the chain
 	newBalance := currentBalance.Add(transaction.Amount)
-	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = $1 WHERE id = $2", newBalance, transaction.UserID)
+	_, err = tx.ExecContext(ctx, "UPDATE users SET balance = $1, chain_seq = $2, chain_hash = $3 WHERE id = $4", newBalance, head.seq, head.hash, transaction.UserID)
 	if err != nil {
-		tx.Rollback()
 		return Transaction{}, err
 	}
 
-	// Commit the transaction
-	err = tx.Commit()
+	// Record the transaction in the audit log
+	err = auditTransactions(ctx, tx, audit.ActionAddTransaction, []Transaction{transaction},
+		map[uuid.UUID]bool{transaction.ID: true}, map[uuid.UUID]decimal.Decimal{transaction.UserID: currentBalance})
+	if err != nil {
+		return Transaction{}, err
+	}
+	err = invalidateSnapshots(ctx, tx, transaction.CreatedAt, []Transaction{transaction}, map[uuid.UUID]bool{transaction.ID: true})
 	if err != nil {
 		return Transaction{}, err
 	}
 
 	return Transaction{
-		ID:             transaction.ID,
-		UserID:         transaction.UserID,
-		Amount:         transaction.Amount,
-		CreatedAt:      transaction.CreatedAt,
-		IdempotencyKey: transaction.IdempotencyKey,
+		ID:                transaction.ID,
+		UserID:            transaction.UserID,
+		Amount:            transaction.Amount,
+		CreatedAt:         transaction.CreatedAt,
+		EffectiveDate:     transaction.EffectiveDate,
+		IdempotencyKey:    transaction.IdempotencyKey,
+		Currency:          transaction.Currency,
+		ExternalReference: transaction.ExternalReference,
+		Source:            transaction.Source,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Metadata:          transaction.Metadata,
 	}, nil
 }
 
-func (t *TransactionRepository) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int) ([]Transaction, error) {
+// GetUserTransactionHistory returns a page of the transactions of a user matching the filter, newest first
+// The order matches the partitions, they are scanned newest first until the page is read
+func (t *TransactionRepository) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter HistoryFilter) ([]Transaction, error) {
 	if page <= 0 {
 		page = 1
 	}
@@ -103,21 +221,81 @@ func (t *TransactionRepository) GetUserTransactionHistory(ctx context.Context, u
 		pageSize = 10
 	}
 
-	rows, err := t.db.QueryContext(ctx, `SELECT id,user_id, amount, created_at,idempotency_key FROM transactions WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`, userID, pageSize, (page-1)*pageSize)
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	if filter.Reference != "" {
+		args = append(args, filter.Reference)
+		conditions = append(conditions, fmt.Sprintf("external_reference = $%d", len(args)))
+	}
+	for _, key := range sortedKeys(filter.Metadata) {
+		args = append(args, key, filter.Metadata[key])
+		conditions = append(conditions, fmt.Sprintf("metadata ->> $%d = $%d", len(args)-1, len(args)))
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE ` + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY created_at DESC, chain_seq DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	return t.queryTransactions(ctx, query, args...)
+}
+
+// FindUserTransactionsByIdempotencyKey returns the transactions of a user added with an idempotency key, newest first
+// A key is only unique together with the amount, so that a key may have been used for several transactions
+func (t *TransactionRepository) FindUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]Transaction, error) {
+	return t.queryTransactions(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE user_id = $1 AND idempotency_key = $2
+		ORDER BY created_at DESC, chain_seq DESC`, userID, idempotencyKey)
+}
+
+// GetUserTransactionsBetween returns the transactions of a user created from from until to, oldest first
+// The archived transactions are included
+func (t *TransactionRepository) GetUserTransactionsBetween(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]Transaction, error) {
+	return t.queryTransactions(ctx, `SELECT `+transactionColumns+` FROM transaction_history
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3 ORDER BY created_at, chain_seq`, userID, from.UTC(), to.UTC())
+}
+
+// BalanceAsOf returns the balance of a user as of a time, the sum of the transactions created before it
+// The sum starts from the nearest snapshot of a day ending at or before the time, the archived transactions
+// are included. The snapshot and the transactions are read from a single snapshot of the database
+func (t *TransactionRepository) BalanceAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (decimal.Decimal, error) {
+	tx, err := t.replica.reader(ctx, t.db).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer tx.Rollback()
+
+	at = at.UTC()
+	balance := decimal.Zero
+	from := time.Time{}
+	var day time.Time
+	err = tx.QueryRowContext(ctx, "SELECT day, balance FROM balance_snapshots WHERE user_id = $1 AND day < $2::date ORDER BY day DESC LIMIT 1",
+		userID, at.Format("2006-01-02")).Scan(&day, &balance)
+	if err != nil && err != sql.ErrNoRows {
+		return decimal.Zero, err
+	}
+	if err == nil {
+		from = day.AddDate(0, 0, 1)
+	}
+
+	var sum decimal.NullDecimal
+	err = tx.QueryRowContext(ctx, "SELECT SUM(amount) FROM transaction_history WHERE user_id = $1 AND created_at >= $2 AND created_at < $3",
+		userID, from, at).Scan(&sum)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return balance.Add(sum.Decimal), nil
+}
+
+// queryTransactions returns the transactions selected with transactionColumns by a query
+func (t *TransactionRepository) queryTransactions(ctx context.Context, query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := t.replica.reader(ctx, t.db).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	transactions := []Transaction{}
 	for rows.Next() {
-		var transaction Transaction
-		err = rows.Scan(&transaction.ID,
-			&transaction.UserID,
-			&transaction.Amount,
-			&transaction.CreatedAt,
-			&transaction.IdempotencyKey,
-		)
+		transaction, err := scanTransaction(rows)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
 		transactions = append(transactions, transaction)
@@ -131,13 +309,517 @@ func (t *TransactionRepository) GetUserTransactionHistory(ctx context.Context, u
 	return transactions, nil
 }
 
-func (t *TransactionRepository) FindTransactionByIdempotencyKey(ctx context.Context, idempotencyKey uuid.UUID) (Transaction, error) {
+// AddTransactions adds a batch of transactions in a single database transaction
+// The returned errors are aligned with the transactions, a nil error means the transaction was added
+// The users of the batch are locked in sorted order so that concurrent batches cannot deadlock
+// If atomic is true, either every transaction is added or none is. The transactions that did not
+// fail themselves get ErrBatchAborted
+// The created_at and effective_date of the transactions are set in place, created_at is the time
+// of the database transaction
+// If the database fails, the error is returned and no transaction is added
+func (t *TransactionRepository) AddTransactions(ctx context.Context, transactions []Transaction, atomic bool) ([]error, error) {
+	results := make([]error, len(transactions))
+	if len(transactions) == 0 {
+		return results, nil
+	}
+
+	// Begin a new transaction
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user rows in sorted order using SELECT FOR UPDATE
+	balances, heads, err := lockUsers(ctx, tx, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	var now time.Time
+	if err := tx.QueryRowContext(ctx, "SELECT now() AT TIME ZONE 'UTC'").Scan(&now); err != nil {
+		return nil, err
+	}
+	for i := range transactions {
+		transactions[i].CreatedAt = now
+		transactions[i].EffectiveDate = effectiveDate(transactions[i])
+	}
+
+	pending := []int{}
+	for i, transaction := range transactions {
+		if _, ok := balances[transaction.UserID]; !ok {
+			results[i] = ErrUserNotFound
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if atomic && len(pending) < len(transactions) {
+		return abortBatch(results), nil
+	}
+
+	// Insert the transactions, skipping the ones with an already used idempotency key
+	inserted := map[uuid.UUID]bool{}
+	for start := 0; start < len(pending); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		ids, err := insertTransactions(ctx, tx, transactions, pending[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			inserted[id] = true
+		}
+	}
+
+	// Record the transactions in the audit log before their amounts are added to the balances
+	if err := auditTransactions(ctx, tx, audit.ActionAddTransaction, transactions, inserted, balances); err != nil {
+		return nil, err
+	}
+
+	for _, i := range pending {
+		if !inserted[transactions[i].ID] {
+			results[i] = ErrDuplicateTransaction
+			continue
+		}
+		balances[transactions[i].UserID] = balances[transactions[i].UserID].Add(transactions[i].Amount)
+	}
+	if atomic && len(inserted) < len(transactions) {
+		return abortBatch(results), nil
+	}
+
+	// Update the users' balances and append the transactions to their hash chains
+	if len(inserted) > 0 {
+		if err := updateBalances(ctx, tx, transactions, results, balances); err != nil {
+			return nil, err
+		}
+		if err := chainTransactions(ctx, tx, transactions, inserted, heads); err != nil {
+			return nil, err
+		}
+		if err := invalidateSnapshots(ctx, tx, now, transactions, inserted); err != nil {
+			return nil, err
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ImportTransactions loads historical transactions with COPY and recomputes the balances
+// of their users from the full transaction history
+// The returned errors are aligned with the transactions, a nil error means the transaction was added.
+// Transactions of unknown users get ErrUserNotFound unless createUsers is true, in which case the
+// users are created. Transactions created in an archived month get ErrArchivedMonth.
+// Transactions with an already used idempotency key and amount get ErrDuplicateTransaction
+// If the database fails, the error is returned and no transaction is added
+func (t *TransactionRepository) ImportTransactions(ctx context.Context, transactions []Transaction, createUsers bool) ([]error, error) {
+	results := make([]error, len(transactions))
+	if len(transactions) == 0 {
+		return results, nil
+	}
+
+	// Create the partitions of the months of the transactions before the import, creating a partition
+	// locks the transactions table until the database transaction ends
+	months := transactionMonths(transactions)
+	if err := createPartitions(ctx, t.db, months); err != nil {
+		return nil, err
+	}
+
+	// Begin a new transaction
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The partitions of archived months are not created again, their transactions are rejected
+	archived, err := archivedMonths(ctx, tx, months)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the transactions into a staging table, COPY cannot skip conflicting rows itself
+	_, err = tx.ExecContext(ctx, "CREATE TEMPORARY TABLE import_transactions (LIKE transactions) ON COMMIT DROP")
+	if err != nil {
+		return nil, err
+	}
+	if err := copyTransactions(ctx, tx, transactions); err != nil {
+		return nil, err
+	}
+
+	if createUsers {
+		_, err = tx.ExecContext(ctx, "INSERT INTO users (id, balance) SELECT DISTINCT user_id, 0 FROM import_transactions ON CONFLICT (id) DO NOTHING")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Lock the user rows in sorted order using SELECT FOR UPDATE
+	balances, heads, err := lockUsers(ctx, tx, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := []string{}
+	archivedDates := []string{}
+	for month := range archived {
+		archivedDates = append(archivedDates, month.Format("2006-01-02"))
+	}
+	for i, transaction := range transactions {
+		if _, ok := balances[transaction.UserID]; !ok {
+			results[i] = ErrUserNotFound
+		} else if archived[monthStart(chainTime(transaction.CreatedAt))] {
+			results[i] = ErrArchivedMonth
+		}
+	}
+	for userID := range balances {
+		userIDs = append(userIDs, userID.String())
+	}
+
+	// Insert the transactions of existing users, skipping the ones with an already used idempotency key
+	rows, err := tx.QueryContext(ctx, `WITH keys AS (
+			INSERT INTO transaction_keys (id, user_id, created_at, idempotency_key, amount, source, external_reference)
+			SELECT id, user_id, created_at, idempotency_key, amount, source, external_reference FROM import_transactions
+			WHERE user_id = ANY($1::uuid[]) AND date_trunc('month', created_at) <> ALL($2::timestamp[])
+			ON CONFLICT (idempotency_key, amount) DO NOTHING RETURNING id)
+		INSERT INTO transactions (id, user_id, amount, created_at, effective_date, idempotency_key, currency, external_reference,
+			source, description, category, metadata)
+		SELECT id, user_id, amount, created_at, effective_date, idempotency_key, currency, external_reference,
+			source, description, category, metadata FROM import_transactions
+		WHERE id IN (SELECT id FROM keys) RETURNING id`, pq.Array(userIDs), pq.Array(archivedDates))
+	if err != nil {
+		return nil, err
+	}
+	inserted := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inserted[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, transaction := range transactions {
+		if results[i] == nil && !inserted[transaction.ID] {
+			results[i] = ErrDuplicateTransaction
+		}
+	}
+
+	if err := auditTransactions(ctx, tx, audit.ActionImportTransaction, transactions, inserted, balances); err != nil {
+		return nil, err
+	}
+
+	// Recompute the balances from the transaction history, archived transactions included, the users are still locked
+	if len(inserted) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET balance = t.balance
+			FROM (SELECT user_id, SUM(amount) AS balance FROM (
+				SELECT user_id, amount FROM transactions WHERE user_id = ANY($1::uuid[])
+				UNION ALL
+				SELECT user_id, amount FROM archive.transactions WHERE user_id = ANY($1::uuid[])
+			) AS history GROUP BY user_id) AS t
+			WHERE users.id = t.user_id`, pq.Array(userIDs))
+		if err != nil {
+			return nil, err
+		}
+		if err := chainTransactions(ctx, tx, transactions, inserted, heads); err != nil {
+			return nil, err
+		}
+		var now time.Time
+		if err := tx.QueryRowContext(ctx, "SELECT now() AT TIME ZONE 'UTC'").Scan(&now); err != nil {
+			return nil, err
+		}
+		if err := invalidateSnapshots(ctx, tx, now, transactions, inserted); err != nil {
+			return nil, err
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// copyTransactions copies the transactions into the import_transactions staging table
+func copyTransactions(ctx context.Context, tx *sql.Tx, transactions []Transaction) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_transactions",
+		"id", "user_id", "amount", "created_at", "effective_date", "idempotency_key", "currency", "external_reference",
+		"source", "description", "category", "metadata"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, transaction := range transactions {
+		metadata, err := encodeMetadata(transaction.Metadata)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx,
+			transaction.ID,
+			transaction.UserID,
+			transaction.Amount,
+			chainTime(transaction.CreatedAt),
+			chainTime(effectiveDate(transaction)),
+			transaction.IdempotencyKey,
+			nullString(transaction.Currency),
+			nullString(transaction.ExternalReference),
+			nullString(transaction.Source),
+			nullString(transaction.Description),
+			nullString(transaction.Category),
+			metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Flush the buffered rows
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// isUniqueViolation reports whether err is a violation of a unique constraint
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// lockUsers locks the users of the transactions in sorted order and returns their balances
+// and the heads of their hash chains
+// Users that do not exist are missing from the result
+func lockUsers(ctx context.Context, tx *sql.Tx, transactions []Transaction) (map[uuid.UUID]decimal.Decimal, map[uuid.UUID]chainHead, error) {
+	unique := map[uuid.UUID]bool{}
+	userIDs := []string{}
+	for _, transaction := range transactions {
+		if !unique[transaction.UserID] {
+			unique[transaction.UserID] = true
+			userIDs = append(userIDs, transaction.UserID.String())
+		}
+	}
+	sort.Strings(userIDs)
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, balance, chain_seq, chain_hash FROM users WHERE id = ANY($1::uuid[]) ORDER BY id FOR UPDATE", pq.Array(userIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	balances := map[uuid.UUID]decimal.Decimal{}
+	heads := map[uuid.UUID]chainHead{}
+	for rows.Next() {
+		var id uuid.UUID
+		var balance decimal.Decimal
+		var head chainHead
+		if err := rows.Scan(&id, &balance, &head.seq, &head.hash); err != nil {
+			return nil, nil, err
+		}
+		balances[id] = balance
+		heads[id] = head
+	}
+	return balances, heads, rows.Err()
+}
+
+// insertColumnTypes are the types of the columns of insertTransactions, the values of a CTE are not typed by their columns
+var insertColumnTypes = []string{"uuid", "uuid", "double precision", "timestamp", "timestamp", "uuid", "text", "text", "text", "text", "text", "jsonb"}
+
+// insertTransactions inserts the transactions at the given indexes with a multi-row insert
+// and returns the IDs of the inserted rows
+// Transactions with an already used ID, idempotency key and amount or source and external reference are skipped,
+// their keys are inserted into transaction_keys first
+func insertTransactions(ctx context.Context, tx *sql.Tx, transactions []Transaction, indexes []int) ([]uuid.UUID, error) {
+	columns := len(insertColumnTypes)
+	values := make([]string, 0, len(indexes))
+	args := make([]interface{}, 0, len(indexes)*columns)
+	for n, i := range indexes {
+		metadata, err := encodeMetadata(transactions[i].Metadata)
+		if err != nil {
+			return nil, err
+		}
+		placeholders := make([]string, columns)
+		for c := range placeholders {
+			placeholders[c] = fmt.Sprintf("$%d::%s", n*columns+c+1, insertColumnTypes[c])
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args,
+			transactions[i].ID,
+			transactions[i].UserID,
+			transactions[i].Amount,
+			chainTime(transactions[i].CreatedAt),
+			transactions[i].EffectiveDate,
+			transactions[i].IdempotencyKey,
+			nullString(transactions[i].Currency),
+			nullString(transactions[i].ExternalReference),
+			nullString(transactions[i].Source),
+			nullString(transactions[i].Description),
+			nullString(transactions[i].Category),
+			metadata)
+	}
+
+	query := `WITH v (id, user_id, amount, created_at, effective_date, idempotency_key,
+		currency, external_reference, source, description, category, metadata) AS (VALUES ` +
+		strings.Join(values, ", ") + `),
+		keys AS (INSERT INTO transaction_keys (id, user_id, created_at, idempotency_key, amount, source, external_reference)
+			SELECT id, user_id, created_at, idempotency_key, amount, source, external_reference FROM v
+			ON CONFLICT DO NOTHING RETURNING id)
+		INSERT INTO transactions (id, user_id, amount, created_at, effective_date, idempotency_key,
+			currency, external_reference, source, description, category, metadata)
+		SELECT DISTINCT ON (v.id) v.id, v.user_id, v.amount, v.created_at, v.effective_date, v.idempotency_key,
+			v.currency, v.external_reference, v.source, v.description, v.category, v.metadata
+		FROM v JOIN keys ON keys.id = v.id RETURNING id`
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// updateBalances sets the new balance of every user with an added transaction in a single statement
+func updateBalances(ctx context.Context, tx *sql.Tx, transactions []Transaction, results []error, balances map[uuid.UUID]decimal.Decimal) error {
+	updated := map[uuid.UUID]bool{}
+	values := []string{}
+	args := []interface{}{}
+	for i, transaction := range transactions {
+		if results[i] != nil || updated[transaction.UserID] {
+			continue
+		}
+		updated[transaction.UserID] = true
+		values = append(values, fmt.Sprintf("($%d::uuid, $%d::double precision)", len(args)+1, len(args)+2))
+		args = append(args, transaction.UserID, balances[transaction.UserID])
+	}
+
+	query := `UPDATE users SET balance = v.balance FROM (VALUES ` +
+		strings.Join(values, ", ") +
+		`) AS v (id, balance) WHERE users.id = v.id`
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// effectiveDate returns the effective date of a transaction in UTC rounded to microseconds
+// as stored by the database, it defaults to the created_at of the transaction
+func effectiveDate(transaction Transaction) time.Time {
+	if transaction.EffectiveDate.IsZero() {
+		return transaction.CreatedAt
+	}
+	return chainTime(transaction.EffectiveDate).UTC()
+}
+
+// abortBatch marks every transaction that did not fail itself as aborted
+func abortBatch(results []error) []error {
+	for i := range results {
+		if results[i] == nil {
+			results[i] = ErrBatchAborted
+		}
+	}
+	return results
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTransaction reads a transaction selected with transactionColumns followed by the extra destinations
+func scanTransaction(row rowScanner, extra ...interface{}) (Transaction, error) {
 	var transaction Transaction
-	err := t.db.QueryRowContext(ctx, `SELECT id, user_id, amount, created_at,idempotency_key FROM transactions WHERE idempotency_key = $1`, idempotencyKey).
-		Scan(&transaction.ID,
-			&transaction.UserID,
-			&transaction.Amount,
-			&transaction.CreatedAt,
-			&transaction.IdempotencyKey)
-	return transaction, err
+	var currency, externalReference, source, description, category sql.NullString
+	var metadata []byte
+	dest := append([]interface{}{
+		&transaction.ID,
+		&transaction.UserID,
+		&transaction.Amount,
+		&transaction.CreatedAt,
+		&transaction.EffectiveDate,
+		&transaction.IdempotencyKey,
+		&currency,
+		&externalReference,
+		&source,
+		&description,
+		&category,
+		&metadata,
+	}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Currency = strings.TrimSpace(currency.String)
+	transaction.ExternalReference = externalReference.String
+	transaction.Source = source.String
+	transaction.Description = description.String
+	transaction.Category = category.String
+	if transaction.Metadata, err = decodeMetadata(metadata); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// encodeMetadata returns the JSON of the metadata of a transaction, NULL if it has none
+func encodeMetadata(metadata map[string]interface{}) (sql.NullString, error) {
+	if len(metadata) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode the metadata: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeMetadata decodes the JSON metadata of a transaction, NULL is no metadata
+func decodeMetadata(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("decode the metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// metadataText returns the text a history filter compares a metadata value with,
+// as the ->> operator of Postgres. Null values have no text
+func metadataText(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	default:
+		data, err := json.Marshal(value)
+		return string(data), err == nil
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
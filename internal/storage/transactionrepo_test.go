@@ -347,7 +347,7 @@ func TestGetUserTransactionHistory_MultipleTransaction_Success(t *testing.T) {
 		},
 	})
 
-	actualTransactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId, 1, 10)
+	actualTransactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId, 1, 10, HistoryFilter{})
 	if err != nil {
 		t.Fatalf("failed to get user transaction history: %v", err)
 	}
@@ -471,12 +471,12 @@ func TestGetUserTransactionHistory_MultipleTransactionAndMultipleUser_Success(t
 		},
 	})
 
-	actualTransactions1, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId1, 1, 10)
+	actualTransactions1, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId1, 1, 10, HistoryFilter{})
 	if err != nil {
 		t.Fatalf("failed to get user transaction history: %v", err)
 	}
 
-	actualTransactions2, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId2, 1, 10)
+	actualTransactions2, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId2, 1, 10, HistoryFilter{})
 	if err != nil {
 		t.Fatalf("failed to get user transaction history: %v", err)
 	}
@@ -534,7 +534,6 @@ func TestGetUserTransactionHistory_MultiplePages(t *testing.T) {
 			ID:             uuid.New(),
 			UserID:         user.ID,
 			Amount:         decimal.NewFromFloat(float64(i + 1)),
-			CreatedAt:      time.Now().Add(time.Duration(i) * time.Minute),
 			IdempotencyKey: uuid.New(),
 		}
 		_, err = transactionRepository.AddTransaction(testEnv.Context, transaction)
@@ -545,7 +544,7 @@ func TestGetUserTransactionHistory_MultiplePages(t *testing.T) {
 
 	// Act and Assert
 	for pageNum := 1; pageNum <= (numTransactions / pageSize); pageNum++ {
-		transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, user.ID, pageNum, pageSize)
+		transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, user.ID, pageNum, pageSize, HistoryFilter{})
 		assert.NoError(t, err)
 		assert.Len(t, transactions, pageSize)
 
@@ -567,7 +566,7 @@ func TestGetUserTransactionHistory_EmptyResult_Success(t *testing.T) {
 	transactionRepository := NewTransactionRepository(testEnv.DB)
 
 	// Act
-	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, uuid.New(), 1, 10)
+	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, uuid.New(), 1, 10, HistoryFilter{})
 
 	// Assert
 	assert.NoError(t, err)
@@ -585,7 +584,7 @@ func TestGetUserTransactionHistory_InvalidUserID_Success(t *testing.T) {
 	transactionRepository := NewTransactionRepository(testEnv.DB)
 
 	// Act
-	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, uuid.New(), 1, 10)
+	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, uuid.New(), 1, 10, HistoryFilter{})
 
 	// Assert
 	assert.NoError(t, err)
@@ -629,7 +628,7 @@ func TestGetUserTransactionHistory_InvalidPageAndInvalidPageSize_Error(t *testin
 	}
 
 	// Act and Assert
-	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, user.ID, -1, -1)
+	transactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, user.ID, -1, -1, HistoryFilter{})
 	assert.NoError(t, err)
 	assert.Equal(t, 10, len(transactions))
 
@@ -676,7 +675,7 @@ func TestGetUserTransactionHistory_SingleTransaction_Success(t *testing.T) {
 		},
 	})
 
-	actualTransactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId, 1, 10)
+	actualTransactions, err := transactionRepository.GetUserTransactionHistory(testEnv.Context, userId, 1, 10, HistoryFilter{})
 	if err != nil {
 		t.Fatalf("failed to get user transaction history: %v", err)
 	}
@@ -839,6 +838,167 @@ func TestGetUserBalance_InvalidUserID_Error(t *testing.T) {
 	assert.Equal(t, ErrUserNotFound, err)
 }
 
+func TestAddTransactions_Batch(t *testing.T) {
+	testCases := []struct {
+		name             string
+		atomic           bool
+		expectedErrors   []error
+		expectedBalance1 float64
+		expectedBalance2 float64
+	}{
+		{
+			name:             "All or nothing",
+			atomic:           true,
+			expectedErrors:   []error{ErrBatchAborted, ErrBatchAborted, ErrUserNotFound, ErrBatchAborted, ErrBatchAborted},
+			expectedBalance1: 10,
+			expectedBalance2: 0,
+		},
+		{
+			name:             "Best effort",
+			atomic:           false,
+			expectedErrors:   []error{nil, nil, ErrUserNotFound, ErrDuplicateTransaction, nil},
+			expectedBalance1: 110,
+			expectedBalance2: 75.5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			testEnv, err := utils.CreateTestEnv()
+			if err != nil {
+				t.Fatalf("failed to create env: %v", err)
+			}
+			defer testEnv.Cleanup()
+
+			transactionRepository := NewTransactionRepository(testEnv.DB)
+			userRepository := NewUserRepository(testEnv.DB)
+
+			user1 := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+			user2 := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+			for _, user := range []User{user1, user2} {
+				err = userRepository.Add(testEnv.Context, user)
+				if err != nil {
+					t.Fatalf("failed to add user: %v", err)
+				}
+			}
+
+			existing := Transaction{
+				UserID:         user1.ID,
+				Amount:         decimal.NewFromFloat(10),
+				ID:             uuid.New(),
+				CreatedAt:      time.Now(),
+				IdempotencyKey: uuid.New(),
+			}
+			err = createTransactions(testEnv, transactionRepository, []Transaction{existing})
+			if err != nil {
+				t.Fatalf("failed to add transaction: %v", err)
+			}
+
+			duplicate := existing
+			duplicate.ID = uuid.New()
+			transactions := []Transaction{
+				{UserID: user2.ID, Amount: decimal.NewFromFloat(50), ID: uuid.New(), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+				{UserID: user1.ID, Amount: decimal.NewFromFloat(100), ID: uuid.New(), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+				{UserID: uuid.New(), Amount: decimal.NewFromFloat(1), ID: uuid.New(), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+				duplicate,
+				{UserID: user2.ID, Amount: decimal.NewFromFloat(25.5), ID: uuid.New(), CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+			}
+
+			// Act
+			errs, err := transactionRepository.AddTransactions(testEnv.Context, transactions, tc.atomic)
+			if err != nil {
+				t.Fatalf("failed to add transactions: %v", err)
+			}
+
+			actualUser1, err := userRepository.FindByID(testEnv.Context, user1.ID)
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			actualUser2, err := userRepository.FindByID(testEnv.Context, user2.ID)
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+
+			// Assert
+			assert.Equal(t, tc.expectedErrors, errs)
+
+			actualUserBalance1, _ := actualUser1.Balance.Float64()
+			assert.Equal(t, tc.expectedBalance1, actualUserBalance1)
+			actualUserBalance2, _ := actualUser2.Balance.Float64()
+			assert.Equal(t, tc.expectedBalance2, actualUserBalance2)
+		})
+	}
+}
+
+func TestImportTransactions_RecomputesBalances(t *testing.T) {
+	// Assign
+	testEnv, err := utils.CreateTestEnv()
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer testEnv.Cleanup()
+
+	transactionRepository := NewTransactionRepository(testEnv.DB)
+	userRepository := NewUserRepository(testEnv.DB)
+
+	user := User{ID: uuid.New(), Balance: decimal.NewFromFloat(0)}
+	err = userRepository.Add(testEnv.Context, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	newUserID := uuid.New()
+
+	existing := Transaction{
+		UserID:         user.ID,
+		Amount:         decimal.NewFromFloat(10),
+		ID:             uuid.New(),
+		CreatedAt:      time.Now(),
+		IdempotencyKey: uuid.New(),
+	}
+	err = createTransactions(testEnv, transactionRepository, []Transaction{existing})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	createdAt := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	duplicate := existing
+	duplicate.ID = uuid.New()
+	transactions := []Transaction{
+		{UserID: user.ID, Amount: decimal.NewFromFloat(100), ID: uuid.New(), CreatedAt: createdAt, IdempotencyKey: uuid.New(), Currency: "EUR", ExternalReference: "ref-1"},
+		{UserID: newUserID, Amount: decimal.NewFromFloat(25.5), ID: uuid.New(), CreatedAt: createdAt, IdempotencyKey: uuid.New(), Currency: "EUR", ExternalReference: "ref-2"},
+		duplicate,
+	}
+
+	// Act
+	errs, err := transactionRepository.ImportTransactions(testEnv.Context, transactions, true)
+	if err != nil {
+		t.Fatalf("failed to import transactions: %v", err)
+	}
+
+	actualUser, err := userRepository.FindByID(testEnv.Context, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	newUser, err := userRepository.FindByID(testEnv.Context, newUserID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	imported, err := transactionRepository.FindTransactionByID(testEnv.Context, transactions[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get transaction: %v", err)
+	}
+
+	// Assert
+	assert.Equal(t, []error{nil, nil, ErrDuplicateTransaction}, errs)
+
+	actualUserBalance, _ := actualUser.Balance.Float64()
+	assert.Equal(t, 110.0, actualUserBalance)
+	newUserBalance, _ := newUser.Balance.Float64()
+	assert.Equal(t, 25.5, newUserBalance)
+	assert.True(t, createdAt.Equal(imported.CreatedAt), "created_at should be preserved, got %s", imported.CreatedAt)
+}
+
 func createTransactions(testEnv utils.TestEnv, transactionRepository *TransactionRepository, transactions []Transaction) error {
 	for i := range transactions {
 		_, err := transactionRepository.AddTransaction(testEnv.Context, transactions[i])
@@ -849,10 +1009,11 @@ func createTransactions(testEnv utils.TestEnv, transactionRepository *Transactio
 	return nil
 }
 
+// transactionsEqual compares the fields of the transactions set by the caller
+// created_at is set by the database
 func transactionsEqual(a, b Transaction) bool {
 	return a.ID == b.ID &&
 		a.Amount.Equal(b.Amount) &&
 		a.UserID == b.UserID &&
-		a.CreatedAt.Equal(b.CreatedAt) &&
 		a.IdempotencyKey == b.IdempotencyKey
 }
@@ -16,10 +16,12 @@ type User struct {
 
 type UserRepository struct {
 	db *sql.DB
+	// replica serves the eventually consistent reads, nil without a replica
+	replica *ReplicaRouter
 }
 
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db}
+func NewUserRepository(db *sql.DB, opts ...RepositoryOption) *UserRepository {
+	return &UserRepository{db: db, replica: newRepositoryOptions(opts).replica}
 }
 
 var ErrUserNotFound = errors.New("user not found")
@@ -28,7 +30,7 @@ var ErrUserNotFound = errors.New("user not found")
 // If the user is not found, ErrUserNotFound is returned
 func (r *UserRepository) FindByID(ctx context.Context, id uuid.UUID) (User, error) {
 	var user User
-	err := r.db.QueryRowContext(ctx, "SELECT id, balance FROM users WHERE id = $1", id).Scan(&user.ID, &user.Balance)
+	err := r.replica.reader(ctx, r.db).QueryRowContext(ctx, "SELECT id, balance FROM users WHERE id = $1", id).Scan(&user.ID, &user.Balance)
 
 	if err == sql.ErrNoRows {
 		return User{}, ErrUserNotFound
@@ -49,3 +51,22 @@ func (r *UserRepository) Add(ctx context.Context, u User) error {
 	}
 	return nil
 }
+
+// ListIDs returns the IDs of every user in ascending order
+func (r *UserRepository) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
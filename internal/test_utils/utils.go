@@ -3,25 +3,71 @@ package utils
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
+	"github.com/tebrizetayi/ledgerservice/internal/migrations"
 
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
 )
 
+// testDatabaseURLEnv names the environment variable of an external Postgres used instead of a container
+// Its user must be allowed to create databases
+const testDatabaseURLEnv = "TEST_DATABASE_URL"
+
 type TestEnv struct {
 	Context context.Context
 	DB      *sql.DB
 	Cleanup func()
 }
 
-func CreateTestEnv() (TestEnv, error) {
+// harness is the Postgres server shared by the tests of a package
+// The migrations are applied once to a template database, every test gets a clone of it
+type harness struct {
+	// connString connects to the maintenance database of the server
+	connString string
+	admin      *sql.DB
+	template   string
+	cleanup    func()
+}
 
+var (
+	shared   *harness
+	startErr = errors.New("utils: the test harness is not started, call utils.Main from TestMain")
+)
+
+// Main starts the shared Postgres of a package, runs its tests and removes the Postgres
+// It is called from the TestMain of the packages using CreateTestEnv:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(utils.Main(m))
+//	}
+//
+// If TEST_DATABASE_URL is set, that server is used instead of a container.
+// If the Postgres cannot be started the tests still run, CreateTestEnv returns the error
+func Main(m *testing.M) int {
+	ctx := context.Background()
+	shared, startErr = startHarness(ctx)
+	if startErr != nil {
+		log.Printf("utils: failed to start the test harness: %v", startErr)
+	} else {
+		defer shared.close(ctx)
+	}
+	return m.Run()
+}
+
+// CreateTestEnv returns a migrated database of its own to a test
+// Cleanup closes and drops the database
+func CreateTestEnv() (TestEnv, error) {
 	ctx := context.Background()
 	testDb, cleanup, err := CreateTestDB(ctx)
 	if err != nil {
@@ -37,6 +83,113 @@ func CreateTestEnv() (TestEnv, error) {
 	return testEnv, nil
 }
 
+// CreateTestDB clones the template database of the shared Postgres
+func CreateTestDB(ctx context.Context) (*sql.DB, func(), error) {
+	if startErr != nil {
+		return nil, nil, startErr
+	}
+	return shared.clone(ctx)
+}
+
+func startHarness(ctx context.Context) (*harness, error) {
+	h := &harness{
+		template: "ledger_template_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		cleanup:  func() {},
+	}
+
+	h.connString = os.Getenv(testDatabaseURLEnv)
+	if h.connString == "" {
+		pool, err := dockertest.NewPool("")
+		if err != nil {
+			return nil, err
+		}
+		if h.connString, h.cleanup, err = startContainer(pool); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if h.admin, err = sql.Open("postgres", h.connString); err != nil {
+		h.cleanup()
+		return nil, err
+	}
+	if err := h.createTemplate(ctx); err != nil {
+		h.close(ctx)
+		return nil, fmt.Errorf("create the template database: %w", err)
+	}
+	return h, nil
+}
+
+// createTemplate creates the template database and applies the migrations to it
+// The connections to the template are closed, Postgres only copies a database nobody is connected to
+func (h *harness) createTemplate(ctx context.Context) error {
+	if _, err := h.admin.ExecContext(ctx, "CREATE DATABASE "+h.template); err != nil {
+		return err
+	}
+
+	templateDb, err := sql.Open("postgres", withDatabase(h.connString, h.template))
+	if err != nil {
+		return err
+	}
+	defer templateDb.Close()
+
+	return migrations.Up(ctx, templateDb)
+}
+
+func (h *harness) clone(ctx context.Context) (*sql.DB, func(), error) {
+	name := "ledger_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+
+	_, err := h.admin.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", name, h.template))
+	if err != nil {
+		return nil, nil, fmt.Errorf("clone the template database: %w", err)
+	}
+
+	testDb, err := sql.Open("postgres", withDatabase(h.connString, name))
+	if err != nil {
+		h.drop(ctx, name)
+		return nil, nil, err
+	}
+
+	testDb.SetMaxOpenConns(50)                  // Maximum number of open connections to the database
+	testDb.SetMaxIdleConns(10)                  // Maximum number of connections in the idle connection pool
+	testDb.SetConnMaxLifetime(30 * time.Minute) // Maximum amount of time a connection may be reused
+
+	cleanup := func() {
+		testDb.Close()
+		h.drop(context.Background(), name)
+	}
+	return testDb, cleanup, nil
+}
+
+// drop drops a database, the connections left open by the test are terminated
+func (h *harness) drop(ctx context.Context, name string) {
+	if _, err := h.admin.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name)); err != nil {
+		log.Printf("Could not drop database %s: %s", name, err)
+	}
+}
+
+func (h *harness) close(ctx context.Context) {
+	if h.admin != nil {
+		h.drop(ctx, h.template)
+		h.admin.Close()
+	}
+	h.cleanup()
+}
+
+// withDatabase returns the connection string connecting to another database of the same server
+// Both the URL and the key/value forms of lib/pq are supported
+func withDatabase(connString string, name string) string {
+	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		u, err := url.Parse(connString)
+		if err == nil {
+			u.Path = "/" + name
+			return u.String()
+		}
+	}
+	// The last occurrence of a key wins
+	return connString + " dbname=" + name
+}
+
 func startContainer(pool *dockertest.Pool) (string, func(), error) {
 	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
 		Repository: "postgres",
@@ -73,6 +226,7 @@ func startContainer(pool *dockertest.Pool) (string, func(), error) {
 		if err != nil {
 			return err
 		}
+		defer db.Close()
 		return db.Ping()
 	})
 
@@ -83,48 +237,3 @@ func startContainer(pool *dockertest.Pool) (string, func(), error) {
 
 	return connString, cleanup, nil
 }
-
-func CreateTestDB(ctx context.Context) (*sql.DB, func(), error) {
-	pool, err := dockertest.NewPool("")
-	if err != nil {
-		return nil, nil, err
-	}
-
-	connString, cleanup, err := startContainer(pool)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	testDb, err := sql.Open("postgres", connString)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	testDb.SetMaxOpenConns(50)                  // Maximum number of open connections to the database
-	testDb.SetMaxIdleConns(10)                  // Maximum number of connections in the idle connection pool
-	testDb.SetConnMaxLifetime(30 * time.Minute) // Maximum amount of time a connection may be reused
-
-	// Load and execute the SQL script to create the required tables
-	script := `CREATE TABLE IF NOT EXISTS  users (
-		id UUID PRIMARY KEY,
-		balance DOUBLE PRECISION NOT NULL
-	);
-	
-	CREATE TABLE IF NOT EXISTS  transactions (
-		id UUID PRIMARY KEY,
-		user_id UUID NOT NULL,
-		amount DOUBLE PRECISION NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		idempotency_key UUID NOT NULL,
-		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
-		UNIQUE (idempotency_key, amount)
-	);`
-
-	_, err = testDb.Exec(script)
-	if err != nil {
-		log.Fatalf("Could not execute SQL script: %s", err)
-	}
-
-	return testDb, cleanup, nil
-
-}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDatabase(t *testing.T) {
+	testCases := []struct {
+		name       string
+		connString string
+		expected   string
+	}{
+		{
+			name:       "URL",
+			connString: "postgres://postgres:secret@db:5432/ledger?sslmode=disable",
+			expected:   "postgres://postgres:secret@db:5432/ledger_test?sslmode=disable",
+		},
+		{
+			name:       "URL without a database",
+			connString: "postgresql://postgres@db",
+			expected:   "postgresql://postgres@db/ledger_test",
+		},
+		{
+			name:       "Key value",
+			connString: "host=db user=postgres dbname=ledger sslmode=disable",
+			expected:   "host=db user=postgres dbname=ledger sslmode=disable dbname=ledger_test",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			connString := withDatabase(tc.connString, "ledger_test")
+
+			// Assert
+			assert.Equal(t, tc.expected, connString)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package transactionmanager
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// cacheStripes is the number of locks the invalidations of the users are spread over
+const cacheStripes = 256
+
+// Cache caches the balances of the users
+// It is implemented by LRUCache, a cache shared by several instances can implement it as well.
+// Errors of the cache are not reported, a failed Get is a miss
+type Cache interface {
+	Get(ctx context.Context, userID uuid.UUID) (decimal.Decimal, bool)
+	Set(ctx context.Context, userID uuid.UUID, balance decimal.Decimal)
+	Delete(ctx context.Context, userID uuid.UUID)
+}
+
+// CacheStats are the hits and misses of the balance cache since the manager was created
+type CacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// WithCache caches the balances of the users, which also saves looking the user up for its history
+// The balance of a user is invalidated when this manager adds a transaction of the user, so that
+// a caller reads its own writes. Transactions added by other instances are seen once the entry expires
+func WithCache(cache Cache) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.cache = &balanceCache{cache: cache}
+	}
+}
+
+// CacheStats returns the hits and misses of the balance cache, zero without WithCache
+func (tm *TransactionManagerClient) CacheStats() CacheStats {
+	if tm.cache == nil {
+		return CacheStats{}
+	}
+	stats := CacheStats{
+		Hits:   atomic.LoadInt64(&tm.cache.hits),
+		Misses: atomic.LoadInt64(&tm.cache.misses),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// balanceCache counts the hits of a cache and keeps stale balances out of it
+// A balance read from the store is only cached if no transaction of its user was added since the read began
+type balanceCache struct {
+	cache        Cache
+	hits, misses int64
+	stripes      [cacheStripes]cacheStripe
+}
+
+type cacheStripe struct {
+	mu sync.Mutex
+	// writes counts the invalidations of the users of the stripe
+	writes uint64
+}
+
+func (c *balanceCache) stripe(userID uuid.UUID) *cacheStripe {
+	return &c.stripes[binary.BigEndian.Uint64(userID[8:])%cacheStripes]
+}
+
+// balance returns the balance of a user from the cache or reads it with find and caches it
+func (c *balanceCache) balance(ctx context.Context, userID uuid.UUID, find func() (decimal.Decimal, error)) (decimal.Decimal, error) {
+	if balance, ok := c.cache.Get(ctx, userID); ok {
+		atomic.AddInt64(&c.hits, 1)
+		return balance, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	stripe := c.stripe(userID)
+	stripe.mu.Lock()
+	writes := stripe.writes
+	stripe.mu.Unlock()
+
+	balance, err := find()
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	if stripe.writes == writes {
+		c.cache.Set(ctx, userID, balance)
+	}
+	return balance, nil
+}
+
+// invalidate drops the balance of a user once a transaction of the user was added
+func (c *balanceCache) invalidate(ctx context.Context, userID uuid.UUID) {
+	stripe := c.stripe(userID)
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	stripe.writes++
+	c.cache.Delete(ctx, userID)
+}
+
+// LRUCache is an in-process Cache keeping the most recently used balances for a limited time
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	clock Clock
+	order *list.List
+	items map[uuid.UUID]*list.Element
+}
+
+type lruEntry struct {
+	userID    uuid.UUID
+	balance   decimal.Decimal
+	expiresAt time.Time
+}
+
+// NewLRUCache returns a cache of at most size balances, each kept for ttl
+// The least recently used balance is evicted when the cache is full
+func NewLRUCache(size int, ttl time.Duration, clock Clock) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		ttl:   ttl,
+		clock: clock,
+		order: list.New(),
+		items: map[uuid.UUID]*list.Element{},
+	}
+}
+
+// Get returns the balance of a user unless it is missing or expired
+func (c *LRUCache) Get(ctx context.Context, userID uuid.UUID) (decimal.Decimal, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[userID]
+	if !ok {
+		return decimal.Decimal{}, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.clock.Now().Before(entry.expiresAt) {
+		c.remove(element)
+		return decimal.Decimal{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.balance, true
+}
+
+// Set caches the balance of a user
+func (c *LRUCache) Set(ctx context.Context, userID uuid.UUID, balance decimal.Decimal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.clock.Now().Add(c.ttl)
+	if element, ok := c.items[userID]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.balance, entry.expiresAt = balance, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[userID] = c.order.PushFront(&lruEntry{userID: userID, balance: balance, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete drops the balance of a user
+func (c *LRUCache) Delete(ctx context.Context, userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[userID]; ok {
+		c.remove(element)
+	}
+}
+
+// Len returns the number of cached balances, expired ones included
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops an entry, the caller holds the lock
+func (c *LRUCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*lruEntry).userID)
+}
@@ -0,0 +1,119 @@
+package transactionmanager
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager/transactionmanagertest"
+)
+
+// findCountingStore counts the users looked up in a MemoryStore
+type findCountingStore struct {
+	*storage.MemoryStore
+	finds int64
+}
+
+func (s *findCountingStore) FindByID(ctx context.Context, id uuid.UUID) (storage.User, error) {
+	atomic.AddInt64(&s.finds, 1)
+	return s.MemoryStore.FindByID(ctx, id)
+}
+
+func TestLRUCache(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	clock := transactionmanagertest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewLRUCache(2, time.Minute, clock)
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+
+	// Act
+	cache.Set(ctx, first, decimal.NewFromInt(1))
+	cache.Set(ctx, second, decimal.NewFromInt(2))
+	_, firstCached := cache.Get(ctx, first)
+	cache.Set(ctx, third, decimal.NewFromInt(3))
+	_, secondCached := cache.Get(ctx, second)
+	thirdBalance, thirdCached := cache.Get(ctx, third)
+	cache.Delete(ctx, first)
+	_, deletedCached := cache.Get(ctx, first)
+	clock.Advance(time.Minute)
+	_, expiredCached := cache.Get(ctx, third)
+
+	// Assert
+	assert.True(t, firstCached)
+	assert.False(t, secondCached, "the least recently used balance is evicted")
+	assert.True(t, thirdCached)
+	assert.True(t, thirdBalance.Equal(decimal.NewFromInt(3)), "got %s", thirdBalance)
+	assert.False(t, deletedCached)
+	assert.False(t, expiredCached)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestGetUserBalance_Cache(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := &findCountingStore{MemoryStore: storage.NewMemoryStore()}
+	cache := NewLRUCache(10, time.Minute, SystemClock{})
+	transactionManager := NewTransactionManagerClient(store, store, WithCache(cache))
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Act
+	before, beforeErr := transactionManager.GetUserBalance(ctx, user.ID)
+	_, cachedErr := transactionManager.GetUserBalance(ctx, user.ID)
+	_, historyErr := transactionManager.GetUserTransactionHistory(ctx, user.ID, 1, 10, HistoryFilter{})
+	findsBeforeAdd := atomic.LoadInt64(&store.finds)
+	_, addErr := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromFloat(10),
+		UserID:         user.ID,
+		IdempotencyKey: uuid.New(),
+	})
+	after, afterErr := transactionManager.GetUserBalance(ctx, user.ID)
+	_, unknownErr := transactionManager.GetUserBalance(ctx, uuid.New())
+
+	// Assert
+	assert.NoError(t, beforeErr)
+	assert.NoError(t, cachedErr)
+	assert.NoError(t, historyErr)
+	assert.NoError(t, addErr)
+	assert.NoError(t, afterErr)
+	assert.True(t, before.Equal(decimal.Zero), "got %s", before)
+	assert.Equal(t, int64(1), findsBeforeAdd, "the cached balance also validates the user of the history")
+	assert.True(t, after.Equal(decimal.NewFromFloat(10)), "the balance is read after the transaction is added, got %s", after)
+	assert.Equal(t, ErrUserNotFound, unknownErr)
+	assert.Equal(t, 1, cache.Len(), "unknown users are not cached")
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 3, HitRate: 0.4}, transactionManager.CacheStats())
+}
+
+func TestGetUserBalance_CacheSkipsBalanceReadBeforeInvalidation(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	cache := NewLRUCache(10, time.Minute, SystemClock{})
+	transactionManager := NewTransactionManagerClient(store, store, WithCache(cache))
+	userID := uuid.New()
+
+	// Act
+	balance, err := transactionManager.cache.balance(ctx, userID, func() (decimal.Decimal, error) {
+		// A transaction of the user is added while its stale balance is read
+		transactionManager.invalidate(ctx, userID)
+		return decimal.NewFromInt(5), nil
+	})
+	_, cached := cache.Get(ctx, userID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(5)), "got %s", balance)
+	assert.False(t, cached)
+}
@@ -0,0 +1,48 @@
+package transactionmanager
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator generates the IDs of new transactions
+type IDGenerator interface {
+	NewID() uuid.UUID
+}
+
+// SystemClock is the clock of the system
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// RandomIDGenerator generates random (version 4) UUIDs
+type RandomIDGenerator struct{}
+
+func (RandomIDGenerator) NewID() uuid.UUID {
+	return uuid.New()
+}
+
+// Option configures the transaction manager
+type Option func(*TransactionManagerClient)
+
+// WithClock sets the clock the effective dates of the transactions are checked against
+func WithClock(clock Clock) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.clock = clock
+	}
+}
+
+// WithIDGenerator sets the generator of the IDs of the transactions added without one
+func WithIDGenerator(ids IDGenerator) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.ids = ids
+	}
+}
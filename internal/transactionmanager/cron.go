@@ -0,0 +1,145 @@
+package transactionmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands of the common cron expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSearchLimit bounds the search of the next time of an expression which never matches, e.g. 0 0 30 2 *
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// cronExpression is a parsed standard cron expression: minute, hour, day of month, month and day of week,
+// evaluated in UTC. Every field is a bit set of its matching values
+type cronExpression struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday are set when the day of month or the day of week starts with *. As in cron,
+	// a time matches either its day of month or its day of week when both are restricted
+	anyDay, anyWeekday bool
+}
+
+// parseCron parses a cron expression of five fields or one of the macros such as @daily
+// A field is *, a value, a range a-b, either followed by a step /n, or a comma separated list of those.
+// The days of the week are 0 to 7, Sunday being 0 or 7
+func parseCron(expression string) (cronExpression, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expression)]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return cronExpression{}, fmt.Errorf("cron expression %q must have 5 fields, got %d", expression, len(fields))
+	}
+
+	var cron cronExpression
+	var err error
+	bounds := []struct {
+		name     string
+		min, max int
+		set      *uint64
+	}{
+		{"minute", 0, 59, &cron.minutes},
+		{"hour", 0, 23, &cron.hours},
+		{"day of month", 1, 31, &cron.days},
+		{"month", 1, 12, &cron.months},
+		{"day of week", 0, 7, &cron.weekdays},
+	}
+	for i, field := range fields {
+		if *bounds[i].set, err = parseCronField(field, bounds[i].min, bounds[i].max); err != nil {
+			return cronExpression{}, fmt.Errorf("cron %s %q: %w", bounds[i].name, field, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if cron.weekdays&(1<<7) != 0 {
+		cron.weekdays |= 1
+	}
+	cron.anyDay, cron.anyWeekday = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return cron, nil
+}
+
+// parseCronField returns the bit set of the values of a field between min and max
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			rangePart = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var lowErr, highErr error
+			low, lowErr = strconv.Atoi(bounds[0])
+			high, highErr = strconv.Atoi(bounds[1])
+			if lowErr != nil || highErr != nil || low > high {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rangePart)
+			}
+			low, high = value, value
+			// A single value with a step runs from the value to the end of the range, as in cron
+			if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", rangePart, min, max)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time after after matching the expression, to the minute
+// The zero time is returned if the expression does not match within five years
+func (c cronExpression) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(cronSearchLimit); t.Before(limit); {
+		switch {
+		case c.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hours&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay tells whether the day of t matches the day of month and day of week fields
+func (c cronExpression) matchesDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
@@ -0,0 +1,125 @@
+package transactionmanager
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronExpression_Next(t *testing.T) {
+	// Wednesday
+	after := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		name       string
+		expression string
+		after      time.Time
+		next       time.Time
+	}{
+		{"Every minute", "* * * * *", after, time.Date(2020, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"Seconds are truncated", "* * * * *", after.Add(30 * time.Second), time.Date(2020, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"Step", "*/15 * * * *", after, time.Date(2020, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"Range and step", "10-40/15 * * * *", after, time.Date(2020, 1, 15, 10, 40, 0, 0, time.UTC)},
+		{"Value and step", "5/20 * * * *", after, time.Date(2020, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"Step of days", "0 0 */10 * *", after, time.Date(2020, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"List of ranges", "0 0 1-3,25-27 * *", after, time.Date(2020, 1, 25, 0, 0, 0, 0, time.UTC)},
+		{"List of months", "0 0 1 3,6 *", after, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"End of day", "59 23 * * *", after, time.Date(2020, 1, 15, 23, 59, 0, 0, time.UTC)},
+		{"Next year", "@yearly", after, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"Hourly", "@hourly", after, time.Date(2020, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"Weekly", "@weekly", after, time.Date(2020, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"Daily", "@daily", after, time.Date(2020, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"Monthly", "0 9 1 * *", after, time.Date(2020, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"Range and list", "0 8-9,17 * * *", after, time.Date(2020, 1, 15, 17, 0, 0, 0, time.UTC)},
+		{"Weekdays", "0 9 * * 1-5", time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 20, 9, 0, 0, 0, time.UTC)},
+		{"Sunday as 7", "0 0 * * 7", after, time.Date(2020, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"Day of month or day of week", "0 0 20 * 5", after, time.Date(2020, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"Day of week or day of month", "0 0 15 * 1", after, time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"Day of month with any day of week", "0 0 20 * *", after, time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"Day of week with any day of month", "0 0 * * 5", after, time.Date(2020, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"Stepped day of month and day of week", "0 0 */2 * 1", after, time.Date(2020, 1, 27, 0, 0, 0, 0, time.UTC)},
+		{"Leap day", "0 0 29 2 *", after, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"Leap day skips the common years", "0 0 29 2 *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"31st skips the shorter months", "0 0 31 * *", time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"Never", "0 0 30 2 *", after, time.Time{}},
+		{"Never on the 31st", "0 0 31 2,4,6,9,11 *", after, time.Time{}},
+		{"Other time zone", "0 12 * * *", time.Date(2020, 1, 15, 13, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)), time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			cron, err := parseCron(tc.expression)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", tc.expression, err)
+			}
+
+			// Act
+			next := cron.next(tc.after)
+
+			// Assert
+			assert.Equal(t, tc.next, next)
+		})
+	}
+}
+
+func TestCronExpression_NextAcrossDST(t *testing.T) {
+	// Assign
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load the location: %v", err)
+	}
+	cron, err := parseCron("0 7 * * *")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	// The clocks of New York move from 02:00 EST to 03:00 EDT on 2020-03-08
+	after := time.Date(2020, 3, 7, 12, 0, 0, 0, newYork)
+
+	// Act
+	first := cron.next(after)
+	second := cron.next(first)
+
+	// Assert
+	assert.Equal(t, time.Date(2020, 3, 8, 7, 0, 0, 0, time.UTC), first, "the expression is evaluated in UTC")
+	assert.Equal(t, 24*time.Hour, second.Sub(first), "the occurrences do not move with the local time")
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expression string
+		err        string
+	}{
+		{"Empty", "", "5 fields"},
+		{"Too few fields", "* * * *", "5 fields"},
+		{"Too many fields", "* * * * * *", "5 fields"},
+		{"Unknown macro", "@reboot", "5 fields"},
+		{"Minute out of range", "60 * * * *", "minute"},
+		{"Negative minute", "-1 * * * *", "minute"},
+		{"Hour out of range", "0 24 * * *", "hour"},
+		{"Day of month 0", "0 0 0 * *", "day of month"},
+		{"Day of month out of range", "0 0 32 * *", "day of month"},
+		{"Month 0", "0 0 * 0 *", "month"},
+		{"Month out of range", "0 0 * 13 *", "month"},
+		{"Day of week out of range", "0 0 * * 8", "day of week"},
+		{"Range out of range", "0-60/5 * * * *", "minute"},
+		{"Reversed range", "0 10-8 * * *", "hour"},
+		{"Range of three values", "0 1-2-3 * * *", "hour"},
+		{"Zero step", "*/0 * * * *", "minute"},
+		{"Step not a number", "*/a * * * *", "minute"},
+		{"Empty list item", "0 0 1, * *", "day of month"},
+		{"Not a number", "a * * * *", "minute"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			_, err := parseCron(tc.expression)
+
+			// Assert
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
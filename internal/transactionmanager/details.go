@@ -0,0 +1,82 @@
+package transactionmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Limits of the details of a transaction, lengths are in characters
+const (
+	MaxDescriptionLength       = 500
+	MaxCategoryLength          = 64
+	MaxSourceLength            = 64
+	MaxExternalReferenceLength = 255
+	// MaxMetadataSize is the size of the JSON encoded metadata in bytes
+	MaxMetadataSize = 4096
+)
+
+// currencyPattern is the pattern of the ISO 4217 currency codes
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// metadataKeyPattern restricts the top-level metadata keys, so that they can be used in history filters
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// validateDetails checks the details of a transaction against their limits
+// The returned error wraps ErrInvalidTransaction
+func validateDetails(transaction Transaction) error {
+	if (transaction.Source == "") != (transaction.ExternalReference == "") {
+		return fmt.Errorf("%w: source and external_reference must be given together", ErrInvalidTransaction)
+	}
+	if transaction.Currency != "" && !currencyPattern.MatchString(transaction.Currency) {
+		return fmt.Errorf("%w: currency must be an ISO 4217 code of 3 upper case letters", ErrInvalidTransaction)
+	}
+
+	lengths := []struct {
+		field string
+		value string
+		max   int
+	}{
+		{"description", transaction.Description, MaxDescriptionLength},
+		{"category", transaction.Category, MaxCategoryLength},
+		{"source", transaction.Source, MaxSourceLength},
+		{"external_reference", transaction.ExternalReference, MaxExternalReferenceLength},
+	}
+	for _, l := range lengths {
+		if utf8.RuneCountInString(l.value) > l.max {
+			return fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidTransaction, l.field, l.max)
+		}
+	}
+
+	if len(transaction.Metadata) == 0 {
+		return nil
+	}
+	for key := range transaction.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: metadata key %q must be 1 to 64 letters, digits, '_' or '-'", ErrInvalidTransaction, key)
+		}
+	}
+	encoded, err := json.Marshal(transaction.Metadata)
+	if err != nil {
+		return fmt.Errorf("%w: metadata must be JSON: %v", ErrInvalidTransaction, err)
+	}
+	if len(encoded) > MaxMetadataSize {
+		return fmt.Errorf("%w: metadata must be at most %d bytes of JSON", ErrInvalidTransaction, MaxMetadataSize)
+	}
+	return nil
+}
+
+// validateFilter checks a history filter
+// The returned error wraps ErrInvalidHistoryFilter
+func validateFilter(filter HistoryFilter) error {
+	if utf8.RuneCountInString(filter.Reference) > MaxExternalReferenceLength {
+		return fmt.Errorf("%w: reference must be at most %d characters", ErrInvalidHistoryFilter, MaxExternalReferenceLength)
+	}
+	for key := range filter.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: metadata key %q must be 1 to 64 letters, digits, '_' or '-'", ErrInvalidHistoryFilter, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package transactionmanager
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// FeeCategory is the category of the entries of the fees
+const FeeCategory = "fee"
+
+// feePlaces is the number of decimal places the fees are rounded to
+const feePlaces = 2
+
+// ErrFeeAccountNotFound is returned when the fee revenue account is not a user of the ledger
+var ErrFeeAccountNotFound = errors.New("fee revenue account not found, fees.revenue_account must be a user of the ledger")
+
+// FeeRule charges a fee on the transactions of a category and currency, an empty Category or Currency matches every transaction
+// The fee is Flat plus Percentage percent of the amount, at least Min and at most Max unless Max is zero.
+// It never exceeds the amount of the transaction
+type FeeRule struct {
+	Name       string
+	Category   string
+	Currency   string
+	Flat       decimal.Decimal
+	Percentage decimal.Decimal
+	Min        decimal.Decimal
+	Max        decimal.Decimal
+}
+
+// Fee is the breakdown of the fee charged on a transaction
+// Amount is the fee charged, Flat plus PercentageAmount bounded by the Min and Max of the rule.
+// TransactionID is the entry debiting the fee from the user, RevenueTransactionID the entry crediting it to RevenueAccount
+type Fee struct {
+	Rule                 string          `json:"rule"`
+	Flat                 decimal.Decimal `json:"flat"`
+	Percentage           decimal.Decimal `json:"percentage"`
+	PercentageAmount     decimal.Decimal `json:"percentage_amount"`
+	Amount               decimal.Decimal `json:"amount"`
+	NetAmount            decimal.Decimal `json:"net_amount"`
+	TransactionID        uuid.UUID       `json:"transaction_id"`
+	RevenueTransactionID uuid.UUID       `json:"revenue_transaction_id"`
+	RevenueAccount       uuid.UUID       `json:"revenue_account"`
+}
+
+// feeEngine charges the fees of the first matching rule
+type feeEngine struct {
+	revenueAccount uuid.UUID
+	rules          []FeeRule
+}
+
+// WithFees charges the fees of the rules on the transactions added with AddTransaction, AddTransactions and
+// EnqueueTransaction, the first matching rule applies
+// The fee is credited to the revenue account, a user of the ledger
+func WithFees(revenueAccount uuid.UUID, rules []FeeRule) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.fees = &feeEngine{revenueAccount: revenueAccount, rules: rules}
+	}
+}
+
+// charge returns the fee of a transaction, false if no rule matches or the fee is zero
+func (e *feeEngine) charge(transaction Transaction) (Fee, bool) {
+	if e == nil {
+		return Fee{}, false
+	}
+	for _, rule := range e.rules {
+		if rule.Category != "" && rule.Category != transaction.Category {
+			continue
+		}
+		if rule.Currency != "" && rule.Currency != transaction.Currency {
+			continue
+		}
+
+		fee := Fee{
+			Rule:             rule.Name,
+			Flat:             rule.Flat,
+			Percentage:       rule.Percentage,
+			PercentageAmount: transaction.Amount.Mul(rule.Percentage).Div(decimal.NewFromInt(100)).Round(feePlaces),
+			RevenueAccount:   e.revenueAccount,
+		}
+		fee.Amount = decimal.Max(fee.Flat.Add(fee.PercentageAmount), rule.Min)
+		if rule.Max.IsPositive() {
+			fee.Amount = decimal.Min(fee.Amount, rule.Max)
+		}
+		fee.Amount = decimal.Min(fee.Amount, transaction.Amount)
+		fee.NetAmount = transaction.Amount.Sub(fee.Amount)
+		return fee, fee.Amount.IsPositive()
+	}
+	return Fee{}, false
+}
+
+// addWithFee adds a transaction together with the entries of its fee in a single database transaction
+func (tm *TransactionManagerClient) addWithFee(ctx context.Context, transactionEntity Transaction, fee Fee) (Transaction, error) {
+	results := make([]BatchResult, 1)
+	entries := batchEntries{}
+	entries.add(0, feeEntries(tm.toStorage(transactionEntity), &fee)...)
+	if err := tm.addEntries(ctx, entries, true, map[int]*Fee{0: &fee}, results); err != nil {
+		return Transaction{}, err
+	}
+	if results[0].Err != nil {
+		return Transaction{}, results[0].Err
+	}
+	return results[0].Transaction, nil
+}
+
+// feeEntries returns a transaction followed by the entries of its fee and sets their IDs on the fee
+// The fee is debited from the user and credited to the revenue account, both entries link to the transaction
+// with the fee_of metadata. Their IDs are derived from the ID of the transaction
+func feeEntries(transaction storage.Transaction, fee *Fee) []storage.Transaction {
+	fee.TransactionID = uuid.NewSHA1(transaction.ID, []byte("fee"))
+	fee.RevenueTransactionID = uuid.NewSHA1(transaction.ID, []byte("fee_revenue"))
+	entry := func(id uuid.UUID, userID uuid.UUID, amount decimal.Decimal) storage.Transaction {
+		return storage.Transaction{
+			ID:             id,
+			UserID:         userID,
+			Amount:         amount,
+			EffectiveDate:  transaction.EffectiveDate,
+			IdempotencyKey: id,
+			Currency:       transaction.Currency,
+			Description:    "Fee " + fee.Rule,
+			Category:       FeeCategory,
+			Metadata:       map[string]interface{}{"fee_of": transaction.ID.String(), "fee_rule": fee.Rule},
+		}
+	}
+	return []storage.Transaction{
+		transaction,
+		entry(fee.TransactionID, transaction.UserID, fee.Amount.Neg()),
+		entry(fee.RevenueTransactionID, fee.RevenueAccount, fee.Amount),
+	}
+}
+
+// entryError returns the error of a transaction one of whose entries failed, fee is nil if it was not charged
+func entryError(entry storage.Transaction, fee *Fee, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrDuplicateTransaction):
+		return ErrTransactionAlreadyExist
+	case errors.Is(err, storage.ErrUserNotFound) && fee != nil && entry.ID == fee.RevenueTransactionID:
+		return ErrFeeAccountNotFound
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package transactionmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+func TestFeeEngine_Charge(t *testing.T) {
+	rules := []FeeRule{
+		{Name: "card-eur", Category: "card", Currency: "EUR", Flat: decimal.NewFromFloat(0.25), Percentage: decimal.NewFromFloat(1.5), Min: decimal.NewFromInt(1), Max: decimal.NewFromInt(10)},
+		{Name: "card", Category: "card", Percentage: decimal.NewFromInt(2)},
+		{Name: "wire", Category: "wire", Flat: decimal.NewFromInt(5)},
+	}
+	testCases := []struct {
+		name        string
+		transaction Transaction
+		rule        string
+		amount      decimal.Decimal
+		charged     bool
+	}{
+		{"Flat and percentage", Transaction{Amount: decimal.NewFromInt(100), Category: "card", Currency: "EUR"}, "card-eur", decimal.NewFromFloat(1.75), true},
+		{"Minimum", Transaction{Amount: decimal.NewFromInt(10), Category: "card", Currency: "EUR"}, "card-eur", decimal.NewFromInt(1), true},
+		{"Maximum", Transaction{Amount: decimal.NewFromInt(1000), Category: "card", Currency: "EUR"}, "card-eur", decimal.NewFromInt(10), true},
+		{"Other currency", Transaction{Amount: decimal.NewFromInt(100), Category: "card", Currency: "USD"}, "card", decimal.NewFromInt(2), true},
+		{"Rounded", Transaction{Amount: decimal.NewFromFloat(10.33), Category: "card"}, "card", decimal.NewFromFloat(0.21), true},
+		{"At most the amount", Transaction{Amount: decimal.NewFromInt(3), Category: "wire"}, "wire", decimal.NewFromInt(3), true},
+		{"No rule", Transaction{Amount: decimal.NewFromInt(100), Category: "refund"}, "", decimal.Zero, false},
+		{"Zero fee", Transaction{Amount: decimal.NewFromFloat(0.1), Category: "card"}, "card", decimal.Zero, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			engine := &feeEngine{revenueAccount: uuid.New(), rules: rules}
+
+			// Act
+			fee, charged := engine.charge(tc.transaction)
+
+			// Assert
+			assert.Equal(t, tc.charged, charged)
+			if tc.charged {
+				assert.Equal(t, tc.rule, fee.Rule)
+				assert.True(t, tc.amount.Equal(fee.Amount), "fee %s, expected %s", fee.Amount, tc.amount)
+				assert.True(t, tc.transaction.Amount.Sub(tc.amount).Equal(fee.NetAmount))
+			}
+		})
+	}
+}
+
+// newFeeManager returns a manager charging a flat fee of 2 on the card transactions, with a user and the revenue account
+// The store also queues the postings of the manager
+func newFeeManager(t *testing.T) (*TransactionManagerClient, uuid.UUID, uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	userID, revenueAccount := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{userID, revenueAccount} {
+		if err := store.Add(ctx, storage.User{ID: id, Balance: decimal.Zero}); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	rules := []FeeRule{{Name: "card", Category: "card", Flat: decimal.NewFromInt(2)}}
+	return NewTransactionManagerClient(store, store, WithFees(revenueAccount, rules), WithPostingStore(store)), userID, revenueAccount
+}
+
+func TestAddTransaction_Fee(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	transactionManager, userID, revenueAccount := newFeeManager(t)
+
+	// Act
+	added, err := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromInt(100),
+		UserID:         userID,
+		IdempotencyKey: uuid.New(),
+		Category:       "card",
+	})
+	plain, plainErr := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromInt(10),
+		UserID:         userID,
+		IdempotencyKey: uuid.New(),
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, plainErr)
+	assert.Nil(t, plain.Fee)
+	if !assert.NotNil(t, added.Fee) {
+		return
+	}
+	assert.True(t, added.Amount.Equal(decimal.NewFromInt(100)))
+	assert.True(t, added.Fee.Amount.Equal(decimal.NewFromInt(2)))
+	assert.Equal(t, revenueAccount, added.Fee.RevenueAccount)
+
+	balance, _ := transactionManager.GetUserBalance(ctx, userID)
+	revenue, _ := transactionManager.GetUserBalance(ctx, revenueAccount)
+	assert.True(t, balance.Equal(decimal.NewFromInt(108)), "balance %s", balance)
+	assert.True(t, revenue.Equal(decimal.NewFromInt(2)), "revenue %s", revenue)
+
+	linked := HistoryFilter{Metadata: map[string]string{"fee_of": added.ID.String()}}
+	debits, _ := transactionManager.GetUserTransactionHistory(ctx, userID, 1, 10, linked)
+	credits, _ := transactionManager.GetUserTransactionHistory(ctx, revenueAccount, 1, 10, linked)
+	if assert.Len(t, debits, 1) && assert.Len(t, credits, 1) {
+		assert.Equal(t, added.Fee.TransactionID, debits[0].ID)
+		assert.True(t, debits[0].Amount.Equal(decimal.NewFromInt(-2)))
+		assert.Equal(t, FeeCategory, debits[0].Category)
+		assert.Equal(t, added.Fee.RevenueTransactionID, credits[0].ID)
+		assert.True(t, credits[0].Amount.Equal(decimal.NewFromInt(2)))
+	}
+}
+
+func TestAddTransaction_FeeErrors(t *testing.T) {
+	t.Run("Duplicate", func(t *testing.T) {
+		// Assign
+		ctx := context.Background()
+		transactionManager, userID, revenueAccount := newFeeManager(t)
+		transaction := Transaction{Amount: decimal.NewFromInt(100), UserID: userID, IdempotencyKey: uuid.New(), Category: "card"}
+		if _, err := transactionManager.AddTransaction(ctx, transaction); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+
+		// Act
+		_, err := transactionManager.AddTransaction(ctx, transaction)
+
+		// Assert
+		assert.ErrorIs(t, err, ErrTransactionAlreadyExist)
+		revenue, _ := transactionManager.GetUserBalance(ctx, revenueAccount)
+		assert.True(t, revenue.Equal(decimal.NewFromInt(2)), "revenue %s", revenue)
+	})
+
+	t.Run("Revenue account not found", func(t *testing.T) {
+		// Assign
+		ctx := context.Background()
+		store := storage.NewMemoryStore()
+		userID := uuid.New()
+		if err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.Zero}); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+		rules := []FeeRule{{Name: "all", Flat: decimal.NewFromInt(1)}}
+		transactionManager := NewTransactionManagerClient(store, store, WithFees(uuid.New(), rules))
+
+		// Act
+		_, err := transactionManager.AddTransaction(ctx, Transaction{Amount: decimal.NewFromInt(100), UserID: userID, IdempotencyKey: uuid.New()})
+
+		// Assert
+		assert.ErrorIs(t, err, ErrFeeAccountNotFound)
+		balance, _ := transactionManager.GetUserBalance(ctx, userID)
+		assert.True(t, balance.IsZero(), "balance %s", balance)
+	})
+
+	t.Run("User not found", func(t *testing.T) {
+		// Assign
+		ctx := context.Background()
+		transactionManager, _, _ := newFeeManager(t)
+
+		// Act
+		_, err := transactionManager.AddTransaction(ctx, Transaction{Amount: decimal.NewFromInt(100), UserID: uuid.New(), IdempotencyKey: uuid.New(), Category: "card"})
+
+		// Assert
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	})
+}
+
+func TestAddTransactions_Fee(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode BatchMode
+	}{
+		{"All or nothing", BatchModeAllOrNothing},
+		{"Best effort", BatchModeBestEffort},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			transactionManager, userID, revenueAccount := newFeeManager(t)
+			transactions := []Transaction{
+				{Amount: decimal.NewFromInt(100), UserID: userID, IdempotencyKey: uuid.New(), Category: "card"},
+				{Amount: decimal.NewFromInt(10), UserID: userID, IdempotencyKey: uuid.New()},
+			}
+
+			// Act
+			results, err := transactionManager.AddTransactions(ctx, transactions, tc.mode)
+
+			// Assert
+			assert.NoError(t, err)
+			if assert.Len(t, results, 2) {
+				assert.NoError(t, results[0].Err)
+				assert.NoError(t, results[1].Err)
+				if assert.NotNil(t, results[0].Transaction.Fee) {
+					assert.True(t, results[0].Transaction.Fee.Amount.Equal(decimal.NewFromInt(2)))
+				}
+				assert.Nil(t, results[1].Transaction.Fee)
+			}
+			balance, _ := transactionManager.GetUserBalance(ctx, userID)
+			revenue, _ := transactionManager.GetUserBalance(ctx, revenueAccount)
+			assert.True(t, balance.Equal(decimal.NewFromInt(108)), "balance %s", balance)
+			assert.True(t, revenue.Equal(decimal.NewFromInt(2)), "revenue %s", revenue)
+		})
+	}
+}
+
+func TestAddTransactions_FeeAccountNotFound(t *testing.T) {
+	testCases := []struct {
+		name            string
+		mode            BatchMode
+		expectedErrs    []error
+		expectedBalance decimal.Decimal
+	}{
+		{"All or nothing", BatchModeAllOrNothing, []error{ErrFeeAccountNotFound, ErrBatchAborted}, decimal.Zero},
+		{"Best effort", BatchModeBestEffort, []error{ErrFeeAccountNotFound, nil}, decimal.NewFromInt(10)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			store := storage.NewMemoryStore()
+			userID := uuid.New()
+			if err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.Zero}); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+			rules := []FeeRule{{Name: "card", Category: "card", Flat: decimal.NewFromInt(1)}}
+			transactionManager := NewTransactionManagerClient(store, store, WithFees(uuid.New(), rules))
+			transactions := []Transaction{
+				{Amount: decimal.NewFromInt(100), UserID: userID, IdempotencyKey: uuid.New(), Category: "card"},
+				{Amount: decimal.NewFromInt(10), UserID: userID, IdempotencyKey: uuid.New()},
+			}
+
+			// Act
+			results, err := transactionManager.AddTransactions(ctx, transactions, tc.mode)
+
+			// Assert
+			assert.NoError(t, err)
+			if assert.Len(t, results, 2) {
+				assert.ErrorIs(t, results[0].Err, tc.expectedErrs[0])
+				if tc.expectedErrs[1] == nil {
+					assert.NoError(t, results[1].Err)
+				} else {
+					assert.ErrorIs(t, results[1].Err, tc.expectedErrs[1])
+				}
+			}
+			balance, _ := transactionManager.GetUserBalance(ctx, userID)
+			assert.True(t, balance.Equal(tc.expectedBalance), "balance %s", balance)
+		})
+	}
+}
+
+func TestEnqueueTransaction_Fee(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	transactionManager, userID, revenueAccount := newFeeManager(t)
+	queued, err := transactionManager.EnqueueTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromInt(100),
+		UserID:         userID,
+		IdempotencyKey: uuid.New(),
+		Category:       "card",
+	})
+	if err != nil {
+		t.Fatalf("failed to enqueue transaction: %v", err)
+	}
+
+	// Act
+	_, applied, applyErr := transactionManager.postings.ApplyNextPosting(ctx)
+	posting, postingErr := transactionManager.GetPosting(ctx, queued.ID, userID)
+
+	// Assert
+	assert.NoError(t, applyErr)
+	assert.True(t, applied)
+	assert.NoError(t, postingErr)
+	assert.Equal(t, PostingApplied, posting.Status)
+	balance, _ := transactionManager.GetUserBalance(ctx, userID)
+	revenue, _ := transactionManager.GetUserBalance(ctx, revenueAccount)
+	assert.True(t, balance.Equal(decimal.NewFromInt(98)), "balance %s", balance)
+	assert.True(t, revenue.Equal(decimal.NewFromInt(2)), "revenue %s", revenue)
+	if assert.NotNil(t, posting.Transaction) {
+		linked := HistoryFilter{Metadata: map[string]string{"fee_of": posting.Transaction.ID.String()}}
+		credits, _ := transactionManager.GetUserTransactionHistory(ctx, revenueAccount, 1, 10, linked)
+		assert.Len(t, credits, 1)
+	}
+}
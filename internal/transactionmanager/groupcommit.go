@@ -0,0 +1,127 @@
+package transactionmanager
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tebrizetayi/ledgerservice/internal/audit"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// groupCommitShards is the number of shards the users are spread over
+// The transactions of a user always go to the same shard
+const groupCommitShards = 64
+
+// WithGroupCommit coalesces the concurrent AddTransaction calls of the users of a shard into batches
+// added in a single database transaction, so that the writers of a hot user do not queue up on its row lock
+// A batch is added once it holds maxBatch transactions or maxLatency passed since its first transaction,
+// and only after the previous batch of its shard was added. Every call still gets its own result
+func WithGroupCommit(maxBatch int, maxLatency time.Duration) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.groupCommit = &groupCommitter{
+			store:      tm.transactions,
+			maxBatch:   maxBatch,
+			maxLatency: maxLatency,
+		}
+	}
+}
+
+// groupCommitter adds the transactions of each shard in batches
+type groupCommitter struct {
+	store      TransactionStore
+	maxBatch   int
+	maxLatency time.Duration
+	shards     [groupCommitShards]groupShard
+}
+
+// groupShard collects the next batch of a shard while the previous one is added
+type groupShard struct {
+	// commit serializes the batches of the shard, they would otherwise wait for each other's row locks
+	commit sync.Mutex
+	mu     sync.Mutex
+	// pending is the batch collecting transactions, nil if there is none
+	pending *groupBatch
+}
+
+// groupBatch is a batch of transactions added in a single database transaction
+// The first caller of a batch adds it for every caller. results and err are set before done is closed
+type groupBatch struct {
+	transactions []storage.Transaction
+	metadata     map[uuid.UUID]audit.Metadata
+	full         chan struct{}
+	done         chan struct{}
+	results      []error
+	err          error
+}
+
+// add adds a transaction with the next batch of its shard and returns it as stored
+// If the context is done before the batch is added, the context error is returned and
+// the transaction may still be added
+func (g *groupCommitter) add(ctx context.Context, transaction storage.Transaction) (storage.Transaction, error) {
+	shard := &g.shards[binary.BigEndian.Uint64(transaction.UserID[8:])%groupCommitShards]
+
+	shard.mu.Lock()
+	batch := shard.pending
+	leader := batch == nil
+	if leader {
+		batch = &groupBatch{
+			metadata: map[uuid.UUID]audit.Metadata{},
+			full:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		shard.pending = batch
+	}
+	i := len(batch.transactions)
+	batch.transactions = append(batch.transactions, transaction)
+	batch.metadata[transaction.ID] = audit.FromContext(ctx)
+	if len(batch.transactions) >= g.maxBatch {
+		shard.pending = nil
+		close(batch.full)
+	}
+	shard.mu.Unlock()
+
+	if leader {
+		g.commit(shard, batch)
+	}
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return storage.Transaction{}, ctx.Err()
+	}
+	if batch.err != nil {
+		return storage.Transaction{}, batch.err
+	}
+	if batch.results[i] != nil {
+		return storage.Transaction{}, batch.results[i]
+	}
+	return batch.transactions[i], nil
+}
+
+// commit waits until the batch is full or its latency passed and adds it once the previous batch of the shard was added
+// The batch keeps collecting transactions until it is added
+func (g *groupCommitter) commit(shard *groupShard, batch *groupBatch) {
+	timer := time.NewTimer(g.maxLatency)
+	select {
+	case <-timer.C:
+	case <-batch.full:
+		timer.Stop()
+	}
+
+	shard.commit.Lock()
+	defer shard.commit.Unlock()
+
+	shard.mu.Lock()
+	if shard.pending == batch {
+		shard.pending = nil
+	}
+	shard.mu.Unlock()
+
+	// The batch is added for all its callers, so it is not canceled with the context of its first caller
+	ctx := audit.NewBatchContext(context.Background(), batch.metadata)
+	batch.results, batch.err = g.store.AddTransactions(ctx, batch.transactions, false)
+	close(batch.done)
+}
@@ -1,6 +1,7 @@
 package transactionmanager
 
 import (
+	"context"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,23 +9,129 @@ import (
 	"github.com/tebrizetayi/ledgerservice/internal/storage"
 )
 
-type Storage interface {
-	storage.StorageClient
+// TransactionStore stores the transactions and the balances they change
+// It is implemented by storage.TransactionRepository and storage.MemoryStore
+type TransactionStore interface {
+	AddTransaction(ctx context.Context, transaction storage.Transaction) (storage.Transaction, error)
+	AddTransactions(ctx context.Context, transactions []storage.Transaction, atomic bool) ([]error, error)
+	GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter storage.HistoryFilter) ([]storage.Transaction, error)
+	FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (storage.Transaction, error)
+	FindUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]storage.Transaction, error)
+	VerifyChain(ctx context.Context, userID uuid.UUID) (storage.ChainReport, error)
+	GetUserTransactionsBetween(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]storage.Transaction, error)
+	BalanceAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (decimal.Decimal, error)
+}
+
+// PostingStore queues the transactions posted asynchronously
+// It is implemented by storage.PostingRepository and storage.MemoryStore
+type PostingStore interface {
+	EnqueuePosting(ctx context.Context, posting storage.Posting) (storage.Posting, error)
+	FindPosting(ctx context.Context, postingID uuid.UUID) (storage.Posting, error)
+	ApplyNextPosting(ctx context.Context) (storage.Posting, bool, error)
+}
+
+// ScheduleStore stores the schedules and their runs
+// It is implemented by storage.ScheduleRepository and storage.MemoryStore
+type ScheduleStore interface {
+	AddSchedule(ctx context.Context, schedule storage.Schedule) (storage.Schedule, error)
+	FindSchedule(ctx context.Context, scheduleID uuid.UUID) (storage.Schedule, error)
+	ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]storage.Schedule, error)
+	UpdateSchedule(ctx context.Context, scheduleID uuid.UUID, status string, nextRunAt time.Time) (storage.Schedule, error)
+	DueSchedules(ctx context.Context, now time.Time, limit int) ([]storage.Schedule, error)
+	RecordScheduleRun(ctx context.Context, run storage.ScheduleRun, nextRunAt time.Time, ended bool) error
+	ListScheduleRuns(ctx context.Context, scheduleID uuid.UUID, limit int) ([]storage.ScheduleRun, error)
+	LeadScheduler(ctx context.Context) (release func(), lead bool, err error)
+}
+
+// UserStore stores the users
+// It is implemented by storage.UserRepository and storage.MemoryStore
+type UserStore interface {
+	FindByID(ctx context.Context, id uuid.UUID) (storage.User, error)
+	Add(ctx context.Context, u storage.User) error
 }
 
 type TransactionManagerClient struct {
-	storageClient storage.StorageClient
+	transactions TransactionStore
+	users        UserStore
+	postings     PostingStore
+	schedules    ScheduleStore
+	clock        Clock
+	ids          IDGenerator
+	// queued wakes up a posting worker when a transaction is queued
+	queued chan struct{}
+	// groupCommit is nil unless AddTransaction coalesces concurrent transactions into batches
+	groupCommit *groupCommitter
+	// cache is nil unless the balances are cached
+	cache *balanceCache
+	// fees is nil unless fees are charged on the transactions
+	fees *feeEngine
 }
 
+// Transaction is a transaction of the ledger
+// The ID is generated if it is not set, CreatedAt is set by the store when the transaction is added.
+// EffectiveDate backdates the transaction, it defaults to CreatedAt.
+// ExternalReference is the reference of the transaction in the system it originates from, Source,
+// it is unique per source. Imported transactions have a reference but no source.
+// Fee is the fee charged on the transaction, it is only set by AddTransaction
 type Transaction struct {
-	ID             uuid.UUID       `json:"id"`
-	Amount         decimal.Decimal `json:"amount"`
-	UserID         uuid.UUID       `json:"user_id"`
-	CreatedAt      time.Time       `json:"created_at"`
-	IdempotencyKey uuid.UUID       `json:"idempotency_key"` // Add idempotency key to the transaction struct
+	ID                uuid.UUID              `json:"id"`
+	Amount            decimal.Decimal        `json:"amount"`
+	UserID            uuid.UUID              `json:"user_id"`
+	CreatedAt         time.Time              `json:"created_at"`
+	EffectiveDate     time.Time              `json:"effective_date"`
+	IdempotencyKey    uuid.UUID              `json:"idempotency_key"` // Add idempotency key to the transaction struct
+	Currency          string                 `json:"currency,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Category          string                 `json:"category,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	ExternalReference string                 `json:"external_reference,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	Fee               *Fee                   `json:"fee,omitempty"`
+}
+
+// HistoryFilter selects the transactions of a history
+// Reference matches the external reference, every key of Metadata matches the transactions
+// whose metadata value for the key is the given text. Numbers and booleans match their JSON text
+type HistoryFilter struct {
+	Reference string
+	Metadata  map[string]string
 }
 
 type User struct {
 	ID      uuid.UUID
 	Balance decimal.Decimal
 }
+
+// BatchMode decides what happens to a batch when some of its transactions fail
+type BatchMode string
+
+const (
+	BatchModeAllOrNothing BatchMode = "all_or_nothing"
+	BatchModeBestEffort   BatchMode = "best_effort"
+)
+
+// BatchResult is the outcome of a single transaction of a batch
+// Err is nil if the transaction was added
+type BatchResult struct {
+	Transaction Transaction
+	Err         error
+}
+
+// ChainReport is the result of the verification of a user's hash chain
+// Break is the first broken link, it is nil if the chain is intact
+type ChainReport struct {
+	UserID       uuid.UUID   `json:"user_id"`
+	Intact       bool        `json:"intact"`
+	Transactions int64       `json:"transactions"`
+	Break        *ChainBreak `json:"break,omitempty"`
+}
+
+// ChainBreak is the first broken link of a hash chain, the hashes are hex encoded
+// TransactionID is nil if no transaction has the broken sequence number
+type ChainBreak struct {
+	Seq           int64      `json:"seq"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	Reason        string     `json:"reason"`
+	ExpectedHash  string     `json:"expected_hash,omitempty"`
+	ActualHash    string     `json:"actual_hash,omitempty"`
+}
@@ -0,0 +1,171 @@
+package transactionmanager
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// Statuses of a posting
+const (
+	PostingPending = storage.PostingPending
+	PostingApplied = storage.PostingApplied
+	PostingFailed  = storage.PostingFailed
+)
+
+var (
+	ErrPostingNotFound  = storage.ErrPostingNotFound
+	ErrAsyncUnavailable = errors.New("asynchronous posting is not available")
+)
+
+// Posting is a transaction posted asynchronously
+// Transaction is the added transaction once the posting is applied, Error the reason a posting failed
+type Posting struct {
+	ID          uuid.UUID    `json:"id"`
+	UserID      uuid.UUID    `json:"user_id"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ProcessedAt *time.Time   `json:"processed_at,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
+// WithPostingStore sets the queue of the transactions posted asynchronously
+// Without it EnqueueTransaction, GetPosting and RunPostingWorker return ErrAsyncUnavailable
+func WithPostingStore(postings PostingStore) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.postings = postings
+	}
+}
+
+// EnqueueTransaction validates a transaction and queues it to be added by a posting worker
+// The transaction is checked as by AddTransaction, the pending posting is returned once it is durably queued.
+// With WithFees the fee of the rules at the time the transaction is queued is added with it when the posting
+// is applied, the posting fails if the fee cannot be added.
+// If the idempotency key and amount were already queued, ErrTransactionAlreadyExist is returned
+func (tm *TransactionManagerClient) EnqueueTransaction(ctx context.Context, transactionEntity Transaction) (Posting, error) {
+	if tm.postings == nil {
+		return Posting{}, ErrAsyncUnavailable
+	}
+	if err := tm.validate(ctx, transactionEntity); err != nil {
+		return Posting{}, err
+	}
+
+	posting := storage.Posting{
+		ID:          tm.ids.NewID(),
+		Transaction: tm.toStorage(transactionEntity),
+	}
+	if fee, ok := tm.fees.charge(transactionEntity); ok {
+		entries := feeEntries(posting.Transaction, &fee)
+		posting.Entries = entries[1:]
+	}
+	queued, err := tm.postings.EnqueuePosting(ctx, posting)
+	if errors.Is(err, storage.ErrDuplicatePosting) {
+		return Posting{}, ErrTransactionAlreadyExist
+	}
+	if err != nil {
+		return Posting{}, err
+	}
+
+	// Wake up a local worker rather than waiting for its next poll
+	select {
+	case tm.queued <- struct{}{}:
+	default:
+	}
+	return postingFromStorage(queued), nil
+}
+
+// GetPosting returns a posting by ID
+// If owner is not uuid.Nil, the postings of other users are not found either, owner is a filter given by the
+// caller rather than an authenticated identity.
+// If the posting is not found, ErrPostingNotFound is returned
+func (tm *TransactionManagerClient) GetPosting(ctx context.Context, postingID uuid.UUID, owner uuid.UUID) (Posting, error) {
+	if tm.postings == nil {
+		return Posting{}, ErrAsyncUnavailable
+	}
+
+	posting, err := tm.postings.FindPosting(ctx, postingID)
+	if err != nil {
+		return Posting{}, err
+	}
+	if owner != uuid.Nil && posting.Transaction.UserID != owner {
+		return Posting{}, ErrPostingNotFound
+	}
+
+	if posting.Status == PostingApplied {
+		posting.Transaction, err = tm.transactions.FindTransactionByID(ctx, posting.Transaction.ID)
+		if err != nil {
+			return Posting{}, err
+		}
+	}
+	return postingFromStorage(posting), nil
+}
+
+// RunPostingWorker applies the queued postings until the context is done
+// When no posting is pending or the store fails, the worker waits for pollInterval or until
+// a transaction is queued by this manager. Several workers may run at the same time
+func (tm *TransactionManagerClient) RunPostingWorker(ctx context.Context, pollInterval time.Duration) error {
+	if tm.postings == nil {
+		return ErrAsyncUnavailable
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		case <-tm.queued:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		for ctx.Err() == nil {
+			posting, ok, err := tm.postings.ApplyNextPosting(ctx)
+			if ok {
+				tm.invalidate(ctx, posting.Transaction.UserID)
+				for _, entry := range posting.Entries {
+					tm.invalidate(ctx, entry.UserID)
+				}
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("transactionmanager : apply posting: %v", err)
+				}
+				break
+			}
+			if !ok {
+				break
+			}
+			if posting.Status == PostingFailed {
+				log.Printf("transactionmanager : posting %s failed: %s", posting.ID, posting.Error)
+			}
+		}
+		timer.Reset(pollInterval)
+	}
+}
+
+func postingFromStorage(posting storage.Posting) Posting {
+	result := Posting{
+		ID:        posting.ID,
+		UserID:    posting.Transaction.UserID,
+		Status:    posting.Status,
+		Error:     posting.Error,
+		CreatedAt: posting.CreatedAt,
+	}
+	if !posting.ProcessedAt.IsZero() {
+		processedAt := posting.ProcessedAt
+		result.ProcessedAt = &processedAt
+	}
+	if posting.Status == PostingApplied {
+		transaction := fromStorage(posting.Transaction)
+		result.Transaction = &transaction
+	}
+	return result
+}
@@ -0,0 +1,462 @@
+package transactionmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// Statuses of a schedule
+const (
+	ScheduleActive = storage.ScheduleActive
+	SchedulePaused = storage.SchedulePaused
+	ScheduleEnded  = storage.ScheduleEnded
+)
+
+// Statuses of a run of a schedule
+const (
+	RunPosted = storage.RunPosted
+	RunFailed = storage.RunFailed
+)
+
+// Limits of the runs listed by ListScheduleRuns
+const (
+	DefaultScheduleRunsLimit = 100
+	MaxScheduleRunsLimit     = 1000
+)
+
+// MinScheduleInterval is the shortest interval of a schedule
+const MinScheduleInterval = time.Minute
+
+// dueSchedulesBatch is the number of due schedules read at once by the runner
+const dueSchedulesBatch = 100
+
+var (
+	ErrInvalidSchedule      = errors.New("invalid schedule")
+	ErrScheduleNotFound     = storage.ErrScheduleNotFound
+	ErrScheduleEnded        = errors.New("schedule has ended")
+	ErrSchedulesUnavailable = errors.New("schedules are not available")
+)
+
+// Duration is a duration encoded in JSON as a Go duration string such as "24h"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"24h\": %w", err)
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Schedule posts a transaction of Amount to a user at every occurrence of either a cron expression,
+// evaluated in UTC, or an interval from StartAt, until EndAt if it is set
+// NextRunAt is the next occurrence to post, the last posted one once the schedule has ended
+type Schedule struct {
+	ID          uuid.UUID       `json:"id"`
+	UserID      uuid.UUID       `json:"user_id"`
+	Amount      decimal.Decimal `json:"amount"`
+	Cron        string          `json:"cron,omitempty"`
+	Interval    Duration        `json:"interval,omitempty"`
+	StartAt     time.Time       `json:"start_at"`
+	EndAt       *time.Time      `json:"end_at,omitempty"`
+	NextRunAt   time.Time       `json:"next_run_at"`
+	Status      string          `json:"status"`
+	Description string          `json:"description,omitempty"`
+	Category    string          `json:"category,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// ScheduleRun is the outcome of an occurrence of a schedule
+// TransactionID is the posted transaction, Error the reason a run failed
+type ScheduleRun struct {
+	ScheduledAt   time.Time  `json:"scheduled_at"`
+	Status        string     `json:"status"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	RanAt         time.Time  `json:"ran_at"`
+}
+
+// WithScheduleStore sets the store of the scheduled transactions
+// Without it the schedule methods and RunScheduler return ErrSchedulesUnavailable
+func WithScheduleStore(schedules ScheduleStore) Option {
+	return func(tm *TransactionManagerClient) {
+		tm.schedules = schedules
+	}
+}
+
+// CreateSchedule validates a schedule and adds it active, its first occurrence being the first one at or after StartAt
+// StartAt defaults to now and a schedule without an ID gets a generated one.
+// If the schedule is invalid or has no occurrence before EndAt, an error wrapping ErrInvalidSchedule is returned
+func (tm *TransactionManagerClient) CreateSchedule(ctx context.Context, schedule Schedule) (Schedule, error) {
+	if tm.schedules == nil {
+		return Schedule{}, ErrSchedulesUnavailable
+	}
+	if schedule.ID == uuid.Nil {
+		schedule.ID = tm.ids.NewID()
+	}
+	if schedule.StartAt.IsZero() {
+		schedule.StartAt = tm.clock.Now()
+	}
+	stored := storage.Schedule{
+		ID:          schedule.ID,
+		UserID:      schedule.UserID,
+		Amount:      schedule.Amount,
+		Cron:        schedule.Cron,
+		Interval:    time.Duration(schedule.Interval),
+		StartAt:     schedule.StartAt,
+		EndAt:       endAt(schedule.EndAt),
+		Description: schedule.Description,
+		Category:    schedule.Category,
+	}
+	if err := validateSchedule(stored); err != nil {
+		return Schedule{}, err
+	}
+
+	first, ok := firstOccurrence(stored, stored.StartAt)
+	if !ok {
+		return Schedule{}, fmt.Errorf("%w: the schedule has no occurrence before end_at", ErrInvalidSchedule)
+	}
+	stored.NextRunAt = first
+
+	added, err := tm.schedules.AddSchedule(ctx, stored)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return scheduleFromStorage(added), nil
+}
+
+// ListUserSchedules returns the schedules of a user, oldest first
+// If the user is not found, ErrUserNotFound is returned
+func (tm *TransactionManagerClient) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]Schedule, error) {
+	if tm.schedules == nil {
+		return []Schedule{}, ErrSchedulesUnavailable
+	}
+	if _, err := tm.users.FindByID(ctx, userID); err != nil {
+		return []Schedule{}, err
+	}
+
+	found, err := tm.schedules.ListUserSchedules(ctx, userID)
+	if err != nil {
+		return []Schedule{}, err
+	}
+	schedules := make([]Schedule, 0, len(found))
+	for _, schedule := range found {
+		schedules = append(schedules, scheduleFromStorage(schedule))
+	}
+	return schedules, nil
+}
+
+// PauseSchedule stops posting the occurrences of a schedule of a user until it is resumed
+// Pausing a paused schedule does nothing. If the schedule is not found, ErrScheduleNotFound is returned,
+// if it has ended, ErrScheduleEnded
+func (tm *TransactionManagerClient) PauseSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (Schedule, error) {
+	schedule, err := tm.userSchedule(ctx, userID, scheduleID)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if schedule.Status == ScheduleEnded {
+		return Schedule{}, ErrScheduleEnded
+	}
+	if schedule.Status == SchedulePaused {
+		return scheduleFromStorage(schedule), nil
+	}
+
+	paused, err := tm.schedules.UpdateSchedule(ctx, scheduleID, SchedulePaused, schedule.NextRunAt)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return scheduleFromStorage(paused), nil
+}
+
+// ResumeSchedule posts the occurrences of a paused schedule of a user again, from the first one at or after now
+// The occurrences missed while the schedule was paused are not posted, a schedule without a further occurrence
+// ends. Resuming an active schedule does nothing. If the schedule is not found, ErrScheduleNotFound is returned,
+// if it has ended, ErrScheduleEnded
+func (tm *TransactionManagerClient) ResumeSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (Schedule, error) {
+	schedule, err := tm.userSchedule(ctx, userID, scheduleID)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if schedule.Status == ScheduleEnded {
+		return Schedule{}, ErrScheduleEnded
+	}
+	if schedule.Status == ScheduleActive {
+		return scheduleFromStorage(schedule), nil
+	}
+
+	status, nextRunAt := ScheduleActive, schedule.NextRunAt
+	if next, ok := firstOccurrence(schedule, tm.clock.Now()); ok {
+		nextRunAt = next
+	} else {
+		status = ScheduleEnded
+	}
+	resumed, err := tm.schedules.UpdateSchedule(ctx, scheduleID, status, nextRunAt)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return scheduleFromStorage(resumed), nil
+}
+
+// ListScheduleRuns returns up to limit runs of a schedule of a user, the latest occurrence first
+// The limit defaults to DefaultScheduleRunsLimit and is capped at MaxScheduleRunsLimit.
+// If the schedule is not found, ErrScheduleNotFound is returned
+func (tm *TransactionManagerClient) ListScheduleRuns(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID, limit int) ([]ScheduleRun, error) {
+	if _, err := tm.userSchedule(ctx, userID, scheduleID); err != nil {
+		return []ScheduleRun{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultScheduleRunsLimit
+	}
+	if limit > MaxScheduleRunsLimit {
+		limit = MaxScheduleRunsLimit
+	}
+
+	found, err := tm.schedules.ListScheduleRuns(ctx, scheduleID, limit)
+	if err != nil {
+		return []ScheduleRun{}, err
+	}
+	runs := make([]ScheduleRun, 0, len(found))
+	for _, run := range found {
+		runs = append(runs, scheduleRunFromStorage(run))
+	}
+	return runs, nil
+}
+
+// RunScheduler posts the due occurrences of the schedules every pollInterval until the context is done
+// Several runners may run at the same time, only the one leading the schedule store posts. The transaction of an
+// occurrence has an ID and idempotency key derived from the schedule and the occurrence, so that an occurrence
+// posted by a runner stopped before recording its run is recorded rather than posted again
+func (tm *TransactionManagerClient) RunScheduler(ctx context.Context, pollInterval time.Duration) error {
+	if tm.schedules == nil {
+		return ErrSchedulesUnavailable
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		if _, err := tm.postDueSchedules(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("transactionmanager : post due schedules: %v", err)
+		}
+		timer.Reset(pollInterval)
+	}
+}
+
+// postDueSchedules posts the due occurrences of the schedules if this runner leads and returns the number of runs
+// An occurrence whose transaction is rejected is recorded as a failed run. A schedule failing with any other error
+// is skipped until the next poll, the occurrence being posted again then, while the other schedules are posted.
+// The errors of the skipped schedules are returned joined
+func (tm *TransactionManagerClient) postDueSchedules(ctx context.Context) (int, error) {
+	release, lead, err := tm.schedules.LeadScheduler(ctx)
+	if err != nil || !lead {
+		return 0, err
+	}
+	defer release()
+
+	runs := 0
+	skipped := map[uuid.UUID]bool{}
+	var errs []error
+	for ctx.Err() == nil {
+		// The skipped schedules are due again, the batch is widened so that it still holds others
+		due, err := tm.schedules.DueSchedules(ctx, tm.clock.Now(), dueSchedulesBatch+len(skipped))
+		if err != nil {
+			return runs, errors.Join(append(errs, err)...)
+		}
+		progress := false
+		for _, schedule := range due {
+			if skipped[schedule.ID] {
+				continue
+			}
+			if err := tm.postOccurrence(ctx, schedule); err != nil {
+				skipped[schedule.ID] = true
+				errs = append(errs, fmt.Errorf("schedule %s: %w", schedule.ID, err))
+				continue
+			}
+			runs++
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+	return runs, errors.Join(errs...)
+}
+
+// postOccurrence posts the transaction of the next occurrence of a schedule and records its run
+func (tm *TransactionManagerClient) postOccurrence(ctx context.Context, schedule storage.Schedule) error {
+	occurrence := schedule.NextRunAt
+	key := uuid.NewSHA1(schedule.ID, []byte(occurrence.UTC().Format(time.RFC3339Nano)))
+	transaction := Transaction{
+		ID:             uuid.NewSHA1(key, []byte("transaction")),
+		UserID:         schedule.UserID,
+		Amount:         schedule.Amount,
+		EffectiveDate:  occurrence,
+		IdempotencyKey: key,
+		Description:    schedule.Description,
+		Category:       schedule.Category,
+		Metadata:       map[string]interface{}{"schedule_id": schedule.ID.String()},
+	}
+
+	run := storage.ScheduleRun{ScheduleID: schedule.ID, ScheduledAt: occurrence, Status: RunPosted, TransactionID: transaction.ID}
+	_, err := tm.AddTransaction(ctx, transaction)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTransactionAlreadyExist):
+		// The occurrence was posted before its run was recorded
+		if _, err := tm.transactions.FindTransactionByID(ctx, transaction.ID); errors.Is(err, ErrTransactionNotFound) {
+			run.Status, run.TransactionID, run.Error = RunFailed, uuid.Nil, ErrTransactionAlreadyExist.Error()
+		} else if err != nil {
+			return err
+		}
+	case errors.Is(err, ErrInvalidTransaction), errors.Is(err, ErrInvalidEffectiveDate), errors.Is(err, ErrUserNotFound):
+		run.Status, run.TransactionID, run.Error = RunFailed, uuid.Nil, err.Error()
+	default:
+		return err
+	}
+	if run.Status == RunFailed {
+		log.Printf("transactionmanager : schedule %s occurrence %s failed: %s", schedule.ID, occurrence.Format(time.RFC3339), run.Error)
+	}
+
+	next, ended := nextOccurrence(schedule, occurrence)
+	return tm.schedules.RecordScheduleRun(ctx, run, next, ended)
+}
+
+// userSchedule returns a schedule of a user, the schedules of other users are not found
+func (tm *TransactionManagerClient) userSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (storage.Schedule, error) {
+	if tm.schedules == nil {
+		return storage.Schedule{}, ErrSchedulesUnavailable
+	}
+	schedule, err := tm.schedules.FindSchedule(ctx, scheduleID)
+	if err != nil {
+		return storage.Schedule{}, err
+	}
+	if schedule.UserID != userID {
+		return storage.Schedule{}, ErrScheduleNotFound
+	}
+	return schedule, nil
+}
+
+// validateSchedule checks a new schedule
+// The returned error wraps ErrInvalidSchedule or ErrInvalidTransaction
+func validateSchedule(schedule storage.Schedule) error {
+	if !schedule.Amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidSchedule)
+	}
+	switch {
+	case (schedule.Cron == "") == (schedule.Interval == 0):
+		return fmt.Errorf("%w: either cron or interval must be given", ErrInvalidSchedule)
+	case schedule.Cron != "":
+		if _, err := parseCron(schedule.Cron); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	case schedule.Interval < MinScheduleInterval || schedule.Interval%time.Second != 0:
+		return fmt.Errorf("%w: interval must be whole seconds and at least %s", ErrInvalidSchedule, MinScheduleInterval)
+	}
+	if !schedule.EndAt.IsZero() && !schedule.EndAt.After(schedule.StartAt) {
+		return fmt.Errorf("%w: end_at must be after start_at", ErrInvalidSchedule)
+	}
+	return validateDetails(Transaction{Description: schedule.Description, Category: schedule.Category})
+}
+
+// firstOccurrence returns the first occurrence of a schedule at or after from
+// If the schedule has no occurrence from then until its end, false is returned
+func firstOccurrence(schedule storage.Schedule, from time.Time) (time.Time, bool) {
+	var first time.Time
+	if schedule.Interval > 0 {
+		first = schedule.StartAt
+		if from.After(first) {
+			periods := (from.Sub(first) + schedule.Interval - 1) / schedule.Interval
+			first = first.Add(periods * schedule.Interval)
+		}
+	} else {
+		if from.Before(schedule.StartAt) {
+			from = schedule.StartAt
+		}
+		cron, err := parseCron(schedule.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		first = cron.next(from.Add(-time.Nanosecond))
+	}
+	return first, !first.IsZero() && (schedule.EndAt.IsZero() || !first.After(schedule.EndAt))
+}
+
+// nextOccurrence returns the occurrence of a schedule following after
+// If the schedule has no further occurrence, after and true are returned
+func nextOccurrence(schedule storage.Schedule, after time.Time) (time.Time, bool) {
+	var next time.Time
+	if schedule.Interval > 0 {
+		next = after.Add(schedule.Interval)
+	} else if cron, err := parseCron(schedule.Cron); err == nil {
+		next = cron.next(after)
+	}
+	if next.IsZero() || (!schedule.EndAt.IsZero() && next.After(schedule.EndAt)) {
+		return after, true
+	}
+	return next, false
+}
+
+// endAt returns the end of a schedule, the zero time if it has none
+func endAt(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func scheduleFromStorage(schedule storage.Schedule) Schedule {
+	result := Schedule{
+		ID:          schedule.ID,
+		UserID:      schedule.UserID,
+		Amount:      schedule.Amount,
+		Cron:        schedule.Cron,
+		Interval:    Duration(schedule.Interval),
+		StartAt:     schedule.StartAt,
+		NextRunAt:   schedule.NextRunAt,
+		Status:      schedule.Status,
+		Description: schedule.Description,
+		Category:    schedule.Category,
+		CreatedAt:   schedule.CreatedAt,
+	}
+	if !schedule.EndAt.IsZero() {
+		end := schedule.EndAt
+		result.EndAt = &end
+	}
+	return result
+}
+
+func scheduleRunFromStorage(run storage.ScheduleRun) ScheduleRun {
+	result := ScheduleRun{
+		ScheduledAt: run.ScheduledAt,
+		Status:      run.Status,
+		Error:       run.Error,
+		RanAt:       run.RanAt,
+	}
+	if run.TransactionID != uuid.Nil {
+		transactionID := run.TransactionID
+		result.TransactionID = &transactionID
+	}
+	return result
+}
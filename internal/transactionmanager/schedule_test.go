@@ -0,0 +1,301 @@
+package transactionmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager/transactionmanagertest"
+)
+
+// newScheduleManager returns a manager with a schedule store and a user, at now
+func newScheduleManager(t *testing.T, now time.Time) (*TransactionManagerClient, *storage.MemoryStore, *transactionmanagertest.Clock, uuid.UUID) {
+	t.Helper()
+
+	clock := transactionmanagertest.NewClock(now)
+	store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+	transactionManager := NewTransactionManagerClient(store, store, WithClock(clock), WithScheduleStore(store))
+	user := storage.User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := store.Add(context.Background(), user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	return transactionManager, store, clock, user.ID
+}
+
+func TestCreateSchedule(t *testing.T) {
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	end := now.Add(time.Hour)
+	testCases := []struct {
+		name      string
+		schedule  Schedule
+		nextRunAt time.Time
+		err       error
+	}{
+		{"Cron", Schedule{Amount: decimal.NewFromInt(10), Cron: "@daily"}, time.Date(2020, 1, 16, 0, 0, 0, 0, time.UTC), nil},
+		{"Interval from now", Schedule{Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour)}, now, nil},
+		{"Interval from start", Schedule{Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour), StartAt: now.Add(-time.Hour)}, now.Add(-time.Hour), nil},
+		{"Negative amount", Schedule{Amount: decimal.NewFromInt(-10), Cron: "@daily"}, time.Time{}, ErrInvalidSchedule},
+		{"Neither cron nor interval", Schedule{Amount: decimal.NewFromInt(10)}, time.Time{}, ErrInvalidSchedule},
+		{"Both cron and interval", Schedule{Amount: decimal.NewFromInt(10), Cron: "@daily", Interval: Duration(time.Hour)}, time.Time{}, ErrInvalidSchedule},
+		{"Invalid cron", Schedule{Amount: decimal.NewFromInt(10), Cron: "0 0 * *"}, time.Time{}, ErrInvalidSchedule},
+		{"Short interval", Schedule{Amount: decimal.NewFromInt(10), Interval: Duration(time.Second)}, time.Time{}, ErrInvalidSchedule},
+		{"End before start", Schedule{Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour), EndAt: &now}, time.Time{}, ErrInvalidSchedule},
+		{"No occurrence before the end", Schedule{Amount: decimal.NewFromInt(10), Cron: "@daily", EndAt: &end}, time.Time{}, ErrInvalidSchedule},
+		{"Long description", Schedule{Amount: decimal.NewFromInt(10), Cron: "@daily", Description: string(make([]byte, MaxDescriptionLength+1))}, time.Time{}, ErrInvalidTransaction},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			transactionManager, _, _, userID := newScheduleManager(t, now)
+			tc.schedule.UserID = userID
+
+			// Act
+			schedule, err := transactionManager.CreateSchedule(context.Background(), tc.schedule)
+
+			// Assert
+			assert.ErrorIs(t, err, tc.err)
+			if tc.err == nil {
+				assert.NotEqual(t, uuid.Nil, schedule.ID)
+				assert.Equal(t, ScheduleActive, schedule.Status)
+				assert.Equal(t, tc.nextRunAt, schedule.NextRunAt)
+			}
+		})
+	}
+}
+
+func TestCreateSchedule_Errors(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, _, _, _ := newScheduleManager(t, now)
+	withoutStore := NewTransactionManagerClient(storage.NewMemoryStore(), storage.NewMemoryStore())
+	schedule := Schedule{UserID: uuid.New(), Amount: decimal.NewFromInt(10), Cron: "@daily"}
+
+	// Act
+	_, unknownErr := transactionManager.CreateSchedule(ctx, schedule)
+	_, unavailableErr := withoutStore.CreateSchedule(ctx, schedule)
+	unavailableRunErr := withoutStore.RunScheduler(ctx, time.Second)
+
+	// Assert
+	assert.Equal(t, ErrUserNotFound, unknownErr)
+	assert.Equal(t, ErrSchedulesUnavailable, unavailableErr)
+	assert.Equal(t, ErrSchedulesUnavailable, unavailableRunErr)
+}
+
+func TestPostDueSchedules(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, _, clock, userID := newScheduleManager(t, now)
+	end := now.Add(2 * time.Hour)
+	schedule, err := transactionManager.CreateSchedule(ctx, Schedule{
+		UserID:      userID,
+		Amount:      decimal.NewFromInt(10),
+		Interval:    Duration(time.Hour),
+		EndAt:       &end,
+		Description: "subscription",
+	})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	clock.Advance(3 * time.Hour)
+
+	// Act
+	runs, err := transactionManager.postDueSchedules(ctx)
+	again, againErr := transactionManager.postDueSchedules(ctx)
+	balance, balanceErr := transactionManager.GetUserBalance(ctx, userID)
+	schedules, listErr := transactionManager.ListUserSchedules(ctx, userID)
+	scheduleRuns, runsErr := transactionManager.ListScheduleRuns(ctx, userID, schedule.ID, 0)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, runs)
+	assert.NoError(t, againErr)
+	assert.Equal(t, 0, again)
+	assert.NoError(t, balanceErr)
+	assert.True(t, balance.Equal(decimal.NewFromInt(30)), "expected 30, got %s", balance)
+	assert.NoError(t, listErr)
+	if assert.Len(t, schedules, 1) {
+		assert.Equal(t, ScheduleEnded, schedules[0].Status)
+	}
+	assert.NoError(t, runsErr)
+	if assert.Len(t, scheduleRuns, 3) {
+		assert.Equal(t, end, scheduleRuns[0].ScheduledAt, "the latest occurrence comes first")
+		assert.Equal(t, now, scheduleRuns[2].ScheduledAt)
+		for _, run := range scheduleRuns {
+			if assert.Equal(t, RunPosted, run.Status) && assert.NotNil(t, run.TransactionID) {
+				transaction, err := transactionManager.GetTransaction(ctx, *run.TransactionID, userID)
+				assert.NoError(t, err)
+				assert.Equal(t, run.ScheduledAt, transaction.EffectiveDate)
+				assert.Equal(t, "subscription", transaction.Description)
+			}
+		}
+	}
+}
+
+func TestPostDueSchedules_Restart(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, store, _, userID := newScheduleManager(t, now)
+	schedule, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	if _, err := transactionManager.postDueSchedules(ctx); err != nil {
+		t.Fatalf("failed to post the due schedules: %v", err)
+	}
+	// A runner stopped after posting the occurrence finds it due again
+	if _, err := store.UpdateSchedule(ctx, schedule.ID, ScheduleActive, now); err != nil {
+		t.Fatalf("failed to update schedule: %v", err)
+	}
+
+	// Act
+	runs, err := transactionManager.postDueSchedules(ctx)
+	balance, balanceErr := transactionManager.GetUserBalance(ctx, userID)
+	scheduleRuns, runsErr := transactionManager.ListScheduleRuns(ctx, userID, schedule.ID, 0)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, runs)
+	assert.NoError(t, balanceErr)
+	assert.True(t, balance.Equal(decimal.NewFromInt(10)), "the occurrence is not posted twice, got %s", balance)
+	assert.NoError(t, runsErr)
+	if assert.Len(t, scheduleRuns, 1) {
+		assert.Equal(t, RunPosted, scheduleRuns[0].Status)
+	}
+}
+
+// failingScheduleStore fails to record the runs of a schedule
+type failingScheduleStore struct {
+	*storage.MemoryStore
+	scheduleID uuid.UUID
+}
+
+func (s failingScheduleStore) RecordScheduleRun(ctx context.Context, run storage.ScheduleRun, nextRunAt time.Time, ended bool) error {
+	if run.ScheduleID == s.scheduleID {
+		return errors.New("record failed")
+	}
+	return s.MemoryStore.RecordScheduleRun(ctx, run, nextRunAt, ended)
+}
+
+func TestPostDueSchedules_SkipsFailingSchedule(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, store, clock, userID := newScheduleManager(t, now)
+	start := now.Add(-time.Hour)
+	failing, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour), StartAt: start})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	other, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(1), Interval: Duration(time.Hour)})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	clock.Advance(time.Hour)
+	failingManager := NewTransactionManagerClient(store, store, WithClock(clock),
+		WithScheduleStore(failingScheduleStore{MemoryStore: store, scheduleID: failing.ID}))
+
+	// Act
+	runs, err := failingManager.postDueSchedules(ctx)
+	otherRuns, otherRunsErr := transactionManager.ListScheduleRuns(ctx, userID, other.ID, 0)
+	failingRuns, failingRunsErr := transactionManager.ListScheduleRuns(ctx, userID, failing.ID, 0)
+
+	// Assert
+	assert.ErrorContains(t, err, "record failed")
+	assert.ErrorContains(t, err, failing.ID.String())
+	assert.Equal(t, 2, runs, "the other schedule is posted")
+	assert.NoError(t, otherRunsErr)
+	assert.Len(t, otherRuns, 2)
+	assert.NoError(t, failingRunsErr)
+	assert.Empty(t, failingRuns)
+}
+
+func TestPostDueSchedules_Leader(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, store, _, userID := newScheduleManager(t, now)
+	if _, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour)}); err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	release, lead, err := store.LeadScheduler(ctx)
+	if err != nil || !lead {
+		t.Fatalf("failed to lead the scheduler: %v", err)
+	}
+
+	// Act
+	follower, followerErr := transactionManager.postDueSchedules(ctx)
+	release()
+	leader, leaderErr := transactionManager.postDueSchedules(ctx)
+
+	// Assert
+	assert.NoError(t, followerErr)
+	assert.Equal(t, 0, follower, "only the leading runner posts")
+	assert.NoError(t, leaderErr)
+	assert.Equal(t, 1, leader)
+}
+
+func TestPauseResumeSchedule(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, _, clock, userID := newScheduleManager(t, now)
+	schedule, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(10), Cron: "0 * * * *"})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+
+	// Act
+	paused, pauseErr := transactionManager.PauseSchedule(ctx, userID, schedule.ID)
+	clock.Advance(3 * time.Hour)
+	pausedRuns, pausedRunsErr := transactionManager.postDueSchedules(ctx)
+	resumed, resumeErr := transactionManager.ResumeSchedule(ctx, userID, schedule.ID)
+	_, otherUserErr := transactionManager.PauseSchedule(ctx, uuid.New(), schedule.ID)
+	_, unknownErr := transactionManager.ResumeSchedule(ctx, userID, uuid.New())
+
+	// Assert
+	assert.NoError(t, pauseErr)
+	assert.Equal(t, SchedulePaused, paused.Status)
+	assert.NoError(t, pausedRunsErr)
+	assert.Equal(t, 0, pausedRuns, "a paused schedule is not posted")
+	assert.NoError(t, resumeErr)
+	assert.Equal(t, ScheduleActive, resumed.Status)
+	assert.Equal(t, time.Date(2020, 1, 15, 14, 0, 0, 0, time.UTC), resumed.NextRunAt, "the occurrences missed while paused are skipped")
+	assert.Equal(t, ErrScheduleNotFound, otherUserErr)
+	assert.Equal(t, ErrScheduleNotFound, unknownErr)
+}
+
+func TestPauseSchedule_Ended(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.UTC)
+	transactionManager, _, clock, userID := newScheduleManager(t, now)
+	end := now.Add(time.Minute)
+	schedule, err := transactionManager.CreateSchedule(ctx, Schedule{UserID: userID, Amount: decimal.NewFromInt(10), Interval: Duration(time.Hour), EndAt: &end})
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := transactionManager.postDueSchedules(ctx); err != nil {
+		t.Fatalf("failed to post the due schedules: %v", err)
+	}
+
+	// Act
+	_, pauseErr := transactionManager.PauseSchedule(ctx, userID, schedule.ID)
+	_, resumeErr := transactionManager.ResumeSchedule(ctx, userID, schedule.ID)
+	runs, runsErr := transactionManager.ListScheduleRuns(ctx, userID, schedule.ID, 0)
+
+	// Assert
+	assert.Equal(t, ErrScheduleEnded, pauseErr)
+	assert.Equal(t, ErrScheduleEnded, resumeErr)
+	assert.NoError(t, runsErr)
+	assert.Len(t, runs, 1, "the runs of an ended schedule are listed")
+}
@@ -0,0 +1,85 @@
+package transactionmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+)
+
+// MaxStatementPeriod is the longest period of a statement
+const MaxStatementPeriod = 366 * 24 * time.Hour
+
+var ErrInvalidPeriod = errors.New("invalid period, from must be before to and the period at most 366 days")
+
+// Statement is the statement of the transactions of a user created in a period, from From until To
+// OpeningBalance is the balance as of From, ClosingBalance the balance as of To
+type Statement struct {
+	UserID         uuid.UUID       `json:"user_id"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance decimal.Decimal `json:"opening_balance"`
+	ClosingBalance decimal.Decimal `json:"closing_balance"`
+	Lines          []StatementLine `json:"lines"`
+}
+
+// StatementLine is a transaction of a statement with the balance following it
+type StatementLine struct {
+	Transaction Transaction     `json:"transaction"`
+	Balance     decimal.Decimal `json:"balance"`
+}
+
+// GetUserBalanceAsOf returns the balance of a user as of a time, including the transactions created before it
+// The balance is eventually consistent, it may be read from a replica.
+// If the user is not found, ErrUserNotFound is returned
+func (tm *TransactionManagerClient) GetUserBalanceAsOf(ctx context.Context, userID uuid.UUID, asOf time.Time) (decimal.Decimal, error) {
+	ctx = storage.NewConsistencyContext(ctx, storage.ConsistencyEventual)
+
+	// Validate the user
+	if _, err := tm.userBalance(ctx, userID); err != nil {
+		return decimal.Zero, err
+	}
+
+	return tm.transactions.BalanceAsOf(ctx, userID, asOf)
+}
+
+// GetUserStatement returns the statement of the transactions of a user created from from until to, oldest first
+// The statement is eventually consistent, it may be read from a replica.
+// If the period is empty or longer than MaxStatementPeriod, ErrInvalidPeriod is returned
+func (tm *TransactionManagerClient) GetUserStatement(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) (Statement, error) {
+	if !from.Before(to) || to.Sub(from) > MaxStatementPeriod {
+		return Statement{}, ErrInvalidPeriod
+	}
+	ctx = storage.NewConsistencyContext(ctx, storage.ConsistencyEventual)
+
+	// Validate the user
+	if _, err := tm.userBalance(ctx, userID); err != nil {
+		return Statement{}, err
+	}
+
+	opening, err := tm.transactions.BalanceAsOf(ctx, userID, from)
+	if err != nil {
+		return Statement{}, err
+	}
+	transactions, err := tm.transactions.GetUserTransactionsBetween(ctx, userID, from, to)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	statement := Statement{
+		UserID:         userID,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		ClosingBalance: opening,
+		Lines:          make([]StatementLine, 0, len(transactions)),
+	}
+	for _, transaction := range transactions {
+		statement.ClosingBalance = statement.ClosingBalance.Add(transaction.Amount)
+		statement.Lines = append(statement.Lines, StatementLine{Transaction: fromStorage(transaction), Balance: statement.ClosingBalance})
+	}
+	return statement, nil
+}
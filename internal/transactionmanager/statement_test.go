@@ -0,0 +1,103 @@
+package transactionmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/storage"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager/transactionmanagertest"
+)
+
+// newStatementManager returns a manager whose user got a transaction of each amount, one day apart from day
+func newStatementManager(t *testing.T, day time.Time, amounts ...float64) (*TransactionManagerClient, uuid.UUID) {
+	t.Helper()
+
+	ctx := context.Background()
+	clock := transactionmanagertest.NewClock(day)
+	store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+	transactionManager := NewTransactionManagerClient(store, store, WithClock(clock))
+	user := storage.User{ID: uuid.New(), Balance: decimal.Zero}
+	if err := store.Add(ctx, user); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	for _, amount := range amounts {
+		_, err := transactionManager.AddTransaction(ctx, Transaction{
+			Amount:         decimal.NewFromFloat(amount),
+			UserID:         user.ID,
+			IdempotencyKey: uuid.New(),
+		})
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+		clock.Advance(24 * time.Hour)
+	}
+	return transactionManager, user.ID
+}
+
+func TestGetUserBalanceAsOf(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	transactionManager, userID := newStatementManager(t, day, 100, 30, 5)
+
+	// Act
+	balance, err := transactionManager.GetUserBalanceAsOf(ctx, userID, day.AddDate(0, 0, 2))
+	_, unknownErr := transactionManager.GetUserBalanceAsOf(ctx, uuid.New(), day)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(130)), "expected 130, got %s", balance)
+	assert.Equal(t, ErrUserNotFound, unknownErr)
+}
+
+func TestGetUserStatement(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	transactionManager, userID := newStatementManager(t, day, 100, 30, 5, 20)
+	from, to := day.AddDate(0, 0, 1), day.AddDate(0, 0, 3)
+
+	// Act
+	statement, err := transactionManager.GetUserStatement(ctx, userID, from, to)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, userID, statement.UserID)
+	assert.True(t, statement.OpeningBalance.Equal(decimal.NewFromInt(100)), "expected the opening balance 100, got %s", statement.OpeningBalance)
+	assert.True(t, statement.ClosingBalance.Equal(decimal.NewFromInt(135)), "expected the closing balance 135, got %s", statement.ClosingBalance)
+	if assert.Len(t, statement.Lines, 2) {
+		assert.True(t, statement.Lines[0].Transaction.Amount.Equal(decimal.NewFromInt(30)))
+		assert.True(t, statement.Lines[0].Balance.Equal(decimal.NewFromInt(130)))
+		assert.True(t, statement.Lines[1].Transaction.Amount.Equal(decimal.NewFromInt(5)))
+		assert.True(t, statement.Lines[1].Balance.Equal(decimal.NewFromInt(135)))
+	}
+}
+
+func TestGetUserStatement_InvalidPeriod(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		from, to time.Time
+	}{
+		{"Empty", day, day},
+		{"Reversed", day.AddDate(0, 0, 1), day},
+		{"Too long", day, day.Add(MaxStatementPeriod + time.Second)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			transactionManager, userID := newStatementManager(t, day)
+
+			// Act
+			_, err := transactionManager.GetUserStatement(context.Background(), userID, tc.from, tc.to)
+
+			// Assert
+			assert.Equal(t, ErrInvalidPeriod, err)
+		})
+	}
+}
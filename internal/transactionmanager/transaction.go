@@ -1,8 +1,8 @@
 package transactionmanager
 
 import (
+	"encoding/hex"
 	"errors"
-	"strings"
 
 	"github.com/shopspring/decimal"
 	"github.com/tebrizetayi/ledgerservice/internal/storage"
@@ -15,35 +15,70 @@ import (
 var (
 	ErrInvalidTransaction      = errors.New("invalid transaction")
 	ErrTransactionAlreadyExist = errors.New("transaction already exist")
+	ErrUserNotFound            = storage.ErrUserNotFound
+	ErrBatchAborted            = storage.ErrBatchAborted
+	ErrInvalidBatchMode        = errors.New("invalid batch mode")
+	ErrInvalidEffectiveDate    = errors.New("effective date must not be in the future")
+	ErrInvalidHistoryFilter    = errors.New("invalid history filter")
+	ErrTransactionNotFound     = storage.ErrTransactionNotFound
 )
 
-func NewTransactionManagerClient(storage storage.StorageClient) *TransactionManagerClient {
-	return &TransactionManagerClient{
-		storageClient: storage,
+// NewTransactionManagerClient returns a transaction manager backed by the given stores
+// By default the system clock is used and random IDs are generated
+func NewTransactionManagerClient(transactions TransactionStore, users UserStore, opts ...Option) *TransactionManagerClient {
+	tm := &TransactionManagerClient{
+		transactions: transactions,
+		users:        users,
+		queued:       make(chan struct{}, 1),
+		clock:        SystemClock{},
+		ids:          RandomIDGenerator{},
 	}
+	for _, opt := range opts {
+		opt(tm)
+	}
+	return tm
 }
 
+// AddTransaction adds a transaction and returns it as stored
+// A transaction without an ID gets a generated one
+// With WithGroupCommit the transaction is added in a batch with the concurrent transactions of its shard.
+// With WithFees the fee of a transaction matching a rule is added with it, the transaction is then not added in a batch
 func (tm *TransactionManagerClient) AddTransaction(ctx context.Context, transactionEntity Transaction) (Transaction, error) {
-	if !tm.ValidateTransaction(ctx, transactionEntity) {
-		return Transaction{}, ErrInvalidTransaction
+	if err := tm.validate(ctx, transactionEntity); err != nil {
+		return Transaction{}, err
+	}
+	if fee, ok := tm.fees.charge(transactionEntity); ok {
+		return tm.addWithFee(ctx, transactionEntity, fee)
 	}
 
-	_, err := tm.storageClient.TransactionRepository.AddTransaction(ctx, storage.Transaction{
-		ID:             transactionEntity.ID,
-		Amount:         transactionEntity.Amount,
-		UserID:         transactionEntity.UserID,
-		CreatedAt:      transactionEntity.CreatedAt,
-		IdempotencyKey: transactionEntity.IdempotencyKey,
-	})
-
-	if err != nil && strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+	var added storage.Transaction
+	var err error
+	if tm.groupCommit != nil {
+		added, err = tm.groupCommit.add(ctx, tm.toStorage(transactionEntity))
+	} else {
+		added, err = tm.transactions.AddTransaction(ctx, tm.toStorage(transactionEntity))
+	}
+	// The transaction may have been added even if the store failed
+	tm.invalidate(ctx, transactionEntity.UserID)
+	if errors.Is(err, storage.ErrDuplicateTransaction) {
 		return Transaction{}, ErrTransactionAlreadyExist
 	}
 	if err != nil {
 		return Transaction{}, err
 	}
 
-	return transactionEntity, nil
+	return fromStorage(added), nil
+}
+
+// validate checks a new transaction
+func (tm *TransactionManagerClient) validate(ctx context.Context, transaction Transaction) error {
+	if !tm.ValidateTransaction(ctx, transaction) {
+		return ErrInvalidTransaction
+	}
+	if transaction.EffectiveDate.After(tm.clock.Now()) {
+		return ErrInvalidEffectiveDate
+	}
+	return validateDetails(transaction)
 }
 
 func (tm *TransactionManagerClient) ValidateTransaction(ctx context.Context, transaction Transaction) bool {
@@ -51,36 +86,280 @@ func (tm *TransactionManagerClient) ValidateTransaction(ctx context.Context, tra
 	return transaction.Amount.IsPositive()
 }
 
+// toStorage returns the storage transaction of a new transaction, generating its ID if it has none
+func (tm *TransactionManagerClient) toStorage(transaction Transaction) storage.Transaction {
+	if transaction.ID == uuid.Nil {
+		transaction.ID = tm.ids.NewID()
+	}
+	return storage.Transaction{
+		ID:                transaction.ID,
+		Amount:            transaction.Amount,
+		UserID:            transaction.UserID,
+		EffectiveDate:     transaction.EffectiveDate,
+		IdempotencyKey:    transaction.IdempotencyKey,
+		Currency:          transaction.Currency,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Source:            transaction.Source,
+		ExternalReference: transaction.ExternalReference,
+		Metadata:          transaction.Metadata,
+	}
+}
+
+func fromStorage(transaction storage.Transaction) Transaction {
+	return Transaction{
+		ID:                transaction.ID,
+		Amount:            transaction.Amount,
+		UserID:            transaction.UserID,
+		CreatedAt:         transaction.CreatedAt,
+		EffectiveDate:     transaction.EffectiveDate,
+		IdempotencyKey:    transaction.IdempotencyKey,
+		Currency:          transaction.Currency,
+		Description:       transaction.Description,
+		Category:          transaction.Category,
+		Source:            transaction.Source,
+		ExternalReference: transaction.ExternalReference,
+		Metadata:          transaction.Metadata,
+	}
+}
+
+// GetUserBalance returns the balance of a user
+// It is read from the primary unless the context asks for eventually consistent reads
 func (tm *TransactionManagerClient) GetUserBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
-	user, err := tm.storageClient.UserRepository.FindByID(ctx, userID)
+	balance, err := tm.userBalance(ctx, userID)
 	if err != nil {
 		return decimal.NewFromFloat(0), err
 	}
 
-	return user.Balance, nil
+	return balance, nil
+}
+
+// userBalance returns the balance of a user, from the cache with WithCache
+// The balances missing from the cache are read from the primary, so that the cache is not filled from a lagging replica
+// If the user is not found, ErrUserNotFound is returned
+func (tm *TransactionManagerClient) userBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if tm.cache == nil {
+		user, err := tm.users.FindByID(ctx, userID)
+		return user.Balance, err
+	}
+	return tm.cache.balance(ctx, userID, func() (decimal.Decimal, error) {
+		user, err := tm.users.FindByID(storage.NewConsistencyContext(ctx, storage.ConsistencyStrong), userID)
+		return user.Balance, err
+	})
+}
+
+// invalidate drops the cached balance of a user once a transaction of the user may have been added
+func (tm *TransactionManagerClient) invalidate(ctx context.Context, userID uuid.UUID) {
+	if tm.cache != nil {
+		tm.cache.invalidate(ctx, userID)
+	}
 }
 
-func (tm *TransactionManagerClient) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int) ([]Transaction, error) {
+// GetUserTransactionHistory returns a page of the transactions of a user matching the filter, newest first
+// The history is eventually consistent, it may be read from a replica
+func (tm *TransactionManagerClient) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter HistoryFilter) ([]Transaction, error) {
+	if err := validateFilter(filter); err != nil {
+		return []Transaction{}, err
+	}
+	ctx = storage.NewConsistencyContext(ctx, storage.ConsistencyEventual)
+
 	// Validate the user
-	_, err := tm.storageClient.UserRepository.FindByID(ctx, userID)
+	_, err := tm.userBalance(ctx, userID)
 	if err != nil {
 		return []Transaction{}, err
 	}
 
-	transactionResult, err := tm.storageClient.TransactionRepository.GetUserTransactionHistory(ctx, userID, page, pageSize)
+	transactionResult, err := tm.transactions.GetUserTransactionHistory(ctx, userID, page, pageSize, storage.HistoryFilter(filter))
 	if err != nil {
 		return []Transaction{}, err
 	}
 
 	transactions := []Transaction{}
 	for _, transaction := range transactionResult {
-		transactions = append(transactions, Transaction{
-			ID:             transaction.ID,
-			Amount:         transaction.Amount,
-			UserID:         transaction.UserID,
-			CreatedAt:      transaction.CreatedAt,
-			IdempotencyKey: transaction.IdempotencyKey,
-		})
+		transactions = append(transactions, fromStorage(transaction))
+	}
+	return transactions, nil
+}
+
+// GetTransaction returns a transaction by ID
+// If owner is not uuid.Nil, the transactions of other users are not found either, owner is a filter given by the
+// caller rather than an authenticated identity. If the transaction is not found, ErrTransactionNotFound is returned.
+// The transaction is read from the primary, a caller looking up the transaction it just added must not miss it
+func (tm *TransactionManagerClient) GetTransaction(ctx context.Context, transactionID uuid.UUID, owner uuid.UUID) (Transaction, error) {
+	transaction, err := tm.transactions.FindTransactionByID(ctx, transactionID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if owner != uuid.Nil && transaction.UserID != owner {
+		return Transaction{}, ErrTransactionNotFound
+	}
+	return fromStorage(transaction), nil
+}
+
+// GetUserTransactionsByIdempotencyKey returns the transactions of a user added with an idempotency key, newest first
+// A key only identifies a transaction together with its amount, so that several transactions may be returned
+// If the user has no transaction with the key, ErrTransactionNotFound is returned.
+// The transactions are read from the primary, a caller checking whether its transaction was added must not miss it
+func (tm *TransactionManagerClient) GetUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]Transaction, error) {
+	// Validate the user
+	_, err := tm.userBalance(ctx, userID)
+	if err != nil {
+		return []Transaction{}, err
+	}
+
+	found, err := tm.transactions.FindUserTransactionsByIdempotencyKey(ctx, userID, idempotencyKey)
+	if err != nil {
+		return []Transaction{}, err
+	}
+	if len(found) == 0 {
+		return []Transaction{}, ErrTransactionNotFound
+	}
+
+	transactions := make([]Transaction, 0, len(found))
+	for _, transaction := range found {
+		transactions = append(transactions, fromStorage(transaction))
 	}
 	return transactions, nil
 }
+
+// AddTransactions adds a batch of transactions in a single database transaction
+// The results are aligned with the transactions
+// In BatchModeAllOrNothing no transaction is added if any of them fails, the transactions
+// that did not fail themselves get ErrBatchAborted
+// In BatchModeBestEffort every valid transaction is added
+// With WithFees the fee of a transaction matching a rule is added with it. In BatchModeBestEffort such a
+// transaction is added with its fee in a database transaction of its own, so that it is never added without it
+// The transactions of the results are as stored
+func (tm *TransactionManagerClient) AddTransactions(ctx context.Context, transactionEntities []Transaction, mode BatchMode) ([]BatchResult, error) {
+	if mode != BatchModeAllOrNothing && mode != BatchModeBestEffort {
+		return nil, ErrInvalidBatchMode
+	}
+
+	results := make([]BatchResult, len(transactionEntities))
+	fees := map[int]*Fee{}
+	batch := batchEntries{}
+	charged := []batchEntries{}
+	failed := false
+	for i, transactionEntity := range transactionEntities {
+		results[i].Transaction = transactionEntity
+		if err := tm.validate(ctx, transactionEntity); err != nil {
+			results[i].Err = err
+			failed = true
+			continue
+		}
+
+		transaction := tm.toStorage(transactionEntity)
+		fee, ok := tm.fees.charge(transactionEntity)
+		switch {
+		case !ok:
+			batch.add(i, transaction)
+		case mode == BatchModeAllOrNothing:
+			fees[i] = &fee
+			batch.add(i, feeEntries(transaction, &fee)...)
+		default:
+			fees[i] = &fee
+			entries := batchEntries{}
+			entries.add(i, feeEntries(transaction, &fee)...)
+			charged = append(charged, entries)
+		}
+	}
+
+	if mode == BatchModeAllOrNothing && failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBatchAborted
+			}
+		}
+		return results, nil
+	}
+
+	if err := tm.addEntries(ctx, batch, mode == BatchModeAllOrNothing, fees, results); err != nil {
+		return nil, err
+	}
+	for _, entries := range charged {
+		if err := tm.addEntries(ctx, entries, true, fees, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// batchEntries are the entries added for the transactions of a batch
+// owners holds the index of the result of the transaction each entry belongs to, its first entry is the transaction
+type batchEntries struct {
+	entries []storage.Transaction
+	owners  []int
+}
+
+func (b *batchEntries) add(owner int, entries ...storage.Transaction) {
+	for _, entry := range entries {
+		b.entries = append(b.entries, entry)
+		b.owners = append(b.owners, owner)
+	}
+}
+
+// addEntries adds the entries of a batch and sets the results of the transactions they belong to
+// A transaction fails with the first error of its entries that is not ErrBatchAborted
+func (tm *TransactionManagerClient) addEntries(ctx context.Context, b batchEntries, atomic bool, fees map[int]*Fee, results []BatchResult) error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	errs, err := tm.transactions.AddTransactions(ctx, b.entries, atomic)
+	// The transactions may have been added even if the store failed
+	for _, entry := range b.entries {
+		tm.invalidate(ctx, entry.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	added := map[int]storage.Transaction{}
+	for n, i := range b.owners {
+		if _, ok := added[i]; !ok {
+			added[i] = b.entries[n]
+		}
+		if errs[n] == nil {
+			continue
+		}
+		if results[i].Err == nil || errors.Is(results[i].Err, ErrBatchAborted) {
+			results[i].Err = entryError(b.entries[n], fees[i], errs[n])
+		}
+	}
+	for i, transaction := range added {
+		if results[i].Err == nil {
+			results[i].Transaction = fromStorage(transaction)
+			results[i].Transaction.Fee = fees[i]
+		}
+	}
+	return nil
+}
+
+// VerifyUserChain recomputes the hash chain of a user's transactions and reports its first broken link
+// The chain may be read from a replica
+func (tm *TransactionManagerClient) VerifyUserChain(ctx context.Context, userID uuid.UUID) (ChainReport, error) {
+	ctx = storage.NewConsistencyContext(ctx, storage.ConsistencyEventual)
+	report, err := tm.transactions.VerifyChain(ctx, userID)
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	chainReport := ChainReport{
+		UserID:       report.UserID,
+		Intact:       report.Break == nil,
+		Transactions: report.Transactions,
+	}
+	if report.Break != nil {
+		chainReport.Break = &ChainBreak{
+			Seq:          report.Break.Seq,
+			Reason:       report.Break.Reason,
+			ExpectedHash: hex.EncodeToString(report.Break.ExpectedHash),
+			ActualHash:   hex.EncodeToString(report.Break.ActualHash),
+		}
+		if report.Break.TransactionID != uuid.Nil {
+			transactionID := report.Break.TransactionID
+			chainReport.Break.TransactionID = &transactionID
+		}
+	}
+	return chainReport, nil
+}
@@ -1,6 +1,8 @@
 package transactionmanager
 
 import (
+	"context"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -8,7 +10,7 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/tebrizetayi/ledgerservice/internal/storage"
-	utils "github.com/tebrizetayi/ledgerservice/internal/test_utils"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager/transactionmanagertest"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -17,26 +19,21 @@ import (
 func TestAddTransaction_NotValidAmount(t *testing.T) {
 
 	// Assign
-	testEnv, err := utils.CreateTestEnv()
-	if err != nil {
-		t.Fatalf("failed to create test env: %v", err)
-	}
-	defer testEnv.Cleanup()
-
-	storageClient := storage.NewStorageClient(testEnv.DB)
-	transactionManager := NewTransactionManagerClient(storageClient)
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
 
 	user := storage.User{
 		ID:      uuid.New(),
 		Balance: decimal.NewFromFloat(0),
 	}
-	err = transactionManager.storageClient.UserRepository.Add(testEnv.Context, user)
+	err := store.Add(ctx, user)
 	if err != nil {
 		t.Fatalf("failed to add user: %v", err)
 	}
 
 	// Act
-	_, err = transactionManager.AddTransaction(testEnv.Context, Transaction{
+	_, err = transactionManager.AddTransaction(ctx, Transaction{
 		ID:             uuid.New(),
 		Amount:         decimal.NewFromFloat(0),
 		UserID:         user.ID,
@@ -50,26 +47,21 @@ func TestAddTransaction_NotValidAmount(t *testing.T) {
 
 func TestAddTransaction_Success(t *testing.T) {
 	// Assign
-	testEnv, err := utils.CreateTestEnv()
-	if err != nil {
-		t.Fatalf("failed to create test env: %v", err)
-	}
-	defer testEnv.Cleanup()
-
-	storageClient := storage.NewStorageClient(testEnv.DB)
-	transactionManager := NewTransactionManagerClient(storageClient)
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
 
 	user := storage.User{
 		ID:      uuid.New(),
 		Balance: decimal.NewFromFloat(0),
 	}
-	err = transactionManager.storageClient.UserRepository.Add(testEnv.Context, user)
+	err := store.Add(ctx, user)
 	if err != nil {
 		t.Fatalf("failed to add user: %v", err)
 	}
 
 	// Act
-	transaction, err := transactionManager.AddTransaction(testEnv.Context, Transaction{
+	transaction, err := transactionManager.AddTransaction(ctx, Transaction{
 		ID:             uuid.New(),
 		Amount:         decimal.NewFromFloat(100),
 		UserID:         user.ID,
@@ -85,22 +77,243 @@ func TestAddTransaction_Success(t *testing.T) {
 	assert.Equal(t, user.ID, transaction.UserID)
 }
 
-func TestAddTransaction_IdempotencySameAmount_Concurrency(t *testing.T) {
+func TestAddTransaction_ClockAndIDGenerator(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := transactionmanagertest.NewClock(now)
+	store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+	transactionManager := NewTransactionManagerClient(store, store,
+		WithClock(clock), WithIDGenerator(transactionmanagertest.NewIDGenerator()))
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Act
+	transaction, err := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromFloat(100),
+		UserID:         user.ID,
+		IdempotencyKey: uuid.New(),
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, transactionmanagertest.ID(1), transaction.ID)
+	assert.Equal(t, now, transaction.CreatedAt)
+	assert.Equal(t, now, transaction.EffectiveDate)
+}
+
+func TestAddTransaction_EffectiveDate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name          string
+		effectiveDate time.Time
+		expectedError error
+	}{
+		{
+			name:          "Backdated",
+			effectiveDate: now.Add(-24 * time.Hour),
+		},
+		{
+			name:          "Now",
+			effectiveDate: now,
+		},
+		{
+			name:          "In the future",
+			effectiveDate: now.Add(time.Second),
+			expectedError: ErrInvalidEffectiveDate,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			clock := transactionmanagertest.NewClock(now)
+			store := storage.NewMemoryStore(storage.WithMemoryClock(clock))
+			transactionManager := NewTransactionManagerClient(store, store, WithClock(clock))
+
+			user := storage.User{
+				ID:      uuid.New(),
+				Balance: decimal.NewFromFloat(0),
+			}
+			err := store.Add(ctx, user)
+			if err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+
+			// Act
+			transaction, err := transactionManager.AddTransaction(ctx, Transaction{
+				Amount:         decimal.NewFromFloat(100),
+				UserID:         user.ID,
+				EffectiveDate:  tc.effectiveDate,
+				IdempotencyKey: uuid.New(),
+			})
+
+			// Assert
+			assert.Equal(t, tc.expectedError, err)
+			if tc.expectedError == nil {
+				assert.Equal(t, now, transaction.CreatedAt)
+				assert.Equal(t, tc.effectiveDate, transaction.EffectiveDate)
+			}
+		})
+	}
+}
+
+func TestAddTransaction_Details(t *testing.T) {
+	testCases := []struct {
+		name        string
+		transaction Transaction
+		expectedErr bool
+	}{
+		{
+			name: "All details",
+			transaction: Transaction{
+				Description:       "Invoice 42",
+				Category:          "payments",
+				Currency:          "EUR",
+				Source:            "stripe",
+				ExternalReference: "ch_42",
+				Metadata:          map[string]interface{}{"order_id": "42", "attempt": float64(2)},
+			},
+		},
+		{
+			name:        "Reference without source",
+			transaction: Transaction{ExternalReference: "ch_42"},
+			expectedErr: true,
+		},
+		{
+			name:        "Source without reference",
+			transaction: Transaction{Source: "stripe"},
+			expectedErr: true,
+		},
+		{
+			name:        "Description too long",
+			transaction: Transaction{Description: strings.Repeat("a", MaxDescriptionLength+1)},
+			expectedErr: true,
+		},
+		{
+			name:        "Category too long",
+			transaction: Transaction{Category: strings.Repeat("a", MaxCategoryLength+1)},
+			expectedErr: true,
+		},
+		{
+			name:        "Lower case currency",
+			transaction: Transaction{Currency: "eur"},
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid metadata key",
+			transaction: Transaction{Metadata: map[string]interface{}{"order id": "42"}},
+			expectedErr: true,
+		},
+		{
+			name:        "Metadata too large",
+			transaction: Transaction{Metadata: map[string]interface{}{"note": strings.Repeat("a", MaxMetadataSize)}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			store := storage.NewMemoryStore()
+			transactionManager := NewTransactionManagerClient(store, store)
+
+			user := storage.User{
+				ID:      uuid.New(),
+				Balance: decimal.NewFromFloat(0),
+			}
+			err := store.Add(ctx, user)
+			if err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+			tc.transaction.Amount = decimal.NewFromFloat(100)
+			tc.transaction.UserID = user.ID
+			tc.transaction.IdempotencyKey = uuid.New()
+
+			// Act
+			transaction, err := transactionManager.AddTransaction(ctx, tc.transaction)
+
+			// Assert
+			if tc.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidTransaction)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.transaction.Description, transaction.Description)
+			assert.Equal(t, tc.transaction.Category, transaction.Category)
+			assert.Equal(t, tc.transaction.Currency, transaction.Currency)
+			assert.Equal(t, tc.transaction.Source, transaction.Source)
+			assert.Equal(t, tc.transaction.ExternalReference, transaction.ExternalReference)
+			assert.Equal(t, tc.transaction.Metadata, transaction.Metadata)
+		})
+	}
+}
+
+func TestGetUserTransactionHistory_Filter(t *testing.T) {
 	// Assign
-	testEnv, err := utils.CreateTestEnv()
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
 	if err != nil {
-		t.Fatalf("failed to create test env: %v", err)
+		t.Fatalf("failed to add user: %v", err)
+	}
+	for _, reference := range []string{"ch_1", "ch_2"} {
+		_, err := transactionManager.AddTransaction(ctx, Transaction{
+			Amount:            decimal.NewFromFloat(10),
+			UserID:            user.ID,
+			IdempotencyKey:    uuid.New(),
+			Source:            "stripe",
+			ExternalReference: reference,
+			Metadata:          map[string]interface{}{"reference": reference},
+		})
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
 	}
-	defer testEnv.Cleanup()
 
-	storageClient := storage.NewStorageClient(testEnv.DB)
-	transactionManager := NewTransactionManagerClient(storageClient)
+	// Act
+	byReference, referenceErr := transactionManager.GetUserTransactionHistory(ctx, user.ID, 1, 10, HistoryFilter{Reference: "ch_2"})
+	byMetadata, metadataErr := transactionManager.GetUserTransactionHistory(ctx, user.ID, 1, 10, HistoryFilter{Metadata: map[string]string{"reference": "ch_1"}})
+	_, invalidErr := transactionManager.GetUserTransactionHistory(ctx, user.ID, 1, 10, HistoryFilter{Metadata: map[string]string{"": "ch_1"}})
+
+	// Assert
+	assert.NoError(t, referenceErr)
+	if assert.Len(t, byReference, 1) {
+		assert.Equal(t, "ch_2", byReference[0].ExternalReference)
+	}
+	assert.NoError(t, metadataErr)
+	if assert.Len(t, byMetadata, 1) {
+		assert.Equal(t, "ch_1", byMetadata[0].ExternalReference)
+	}
+	assert.ErrorIs(t, invalidErr, ErrInvalidHistoryFilter)
+}
+
+func TestAddTransaction_IdempotencySameAmount_Concurrency(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
 
 	user := storage.User{
 		ID:      uuid.New(),
 		Balance: decimal.NewFromFloat(0),
 	}
-	err = transactionManager.storageClient.UserRepository.Add(testEnv.Context, user)
+	err := store.Add(ctx, user)
 	if err != nil {
 		t.Fatalf("failed to add user: %v", err)
 	}
@@ -117,7 +330,7 @@ func TestAddTransaction_IdempotencySameAmount_Concurrency(t *testing.T) {
 	for i := 0; i < concurrentRequests; i++ {
 		go func() {
 			<-startCh
-			_, err := transactionManager.AddTransaction(testEnv.Context, Transaction{
+			_, err := transactionManager.AddTransaction(ctx, Transaction{
 				ID:             uuid.New(),
 				Amount:         decimal.NewFromFloat(100),
 				UserID:         user.ID,
@@ -141,20 +354,15 @@ func TestAddTransaction_IdempotencySameAmount_Concurrency(t *testing.T) {
 
 func TestAddTransaction_IdempotencyDifferentAmount_Concurrency(t *testing.T) {
 	// Assign
-	testEnv, err := utils.CreateTestEnv()
-	if err != nil {
-		t.Fatalf("failed to create test env: %v", err)
-	}
-	defer testEnv.Cleanup()
-
-	storageClient := storage.NewStorageClient(testEnv.DB)
-	transactionManager := NewTransactionManagerClient(storageClient)
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
 
 	user := storage.User{
 		ID:      uuid.New(),
 		Balance: decimal.NewFromFloat(0),
 	}
-	err = transactionManager.storageClient.UserRepository.Add(testEnv.Context, user)
+	err := store.Add(ctx, user)
 	if err != nil {
 		t.Fatalf("failed to add user: %v", err)
 	}
@@ -171,7 +379,7 @@ func TestAddTransaction_IdempotencyDifferentAmount_Concurrency(t *testing.T) {
 	for i := 0; i < concurrentRequests; i++ {
 		go func(i int) {
 			<-startCh
-			_, err := transactionManager.AddTransaction(testEnv.Context, Transaction{
+			_, err := transactionManager.AddTransaction(ctx, Transaction{
 				ID:             uuid.New(),
 				Amount:         decimal.NewFromFloat(float64(i + 1)),
 				UserID:         user.ID,
@@ -192,3 +400,353 @@ func TestAddTransaction_IdempotencyDifferentAmount_Concurrency(t *testing.T) {
 	// Assert
 	assert.Equal(t, int32(concurrentRequests), successCount, "only one transaction should be added")
 }
+
+func TestAddTransactions_InvalidTransactionAbortsBatch(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store)
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	transactions := []Transaction{
+		{ID: uuid.New(), Amount: decimal.NewFromFloat(100), UserID: user.ID, CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+		{ID: uuid.New(), Amount: decimal.NewFromFloat(-1), UserID: user.ID, CreatedAt: time.Now(), IdempotencyKey: uuid.New()},
+	}
+
+	// Act
+	results, err := transactionManager.AddTransactions(ctx, transactions, BatchModeAllOrNothing)
+	if err != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+	balance, err := transactionManager.GetUserBalance(ctx, user.ID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, ErrBatchAborted, results[0].Err)
+	assert.Equal(t, ErrInvalidTransaction, results[1].Err)
+	assert.True(t, balance.IsZero(), "no transaction should be added, balance is %s", balance)
+}
+
+func TestAddTransactions_InvalidMode(t *testing.T) {
+	// Assign
+	transactionManager := NewTransactionManagerClient(storage.NewMemoryStore(), storage.NewMemoryStore())
+
+	// Act
+	_, err := transactionManager.AddTransactions(context.Background(), []Transaction{}, BatchMode("sometimes"))
+
+	// Assert
+	assert.Equal(t, ErrInvalidBatchMode, err)
+}
+
+func TestEnqueueTransaction_Worker(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	transactionManager := NewTransactionManagerClient(store, store,
+		WithPostingStore(store), WithIDGenerator(transactionmanagertest.NewIDGenerator()))
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Act
+	queued, err := transactionManager.EnqueueTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromFloat(100),
+		UserID:         user.ID,
+		IdempotencyKey: uuid.New(),
+		Description:    "Invoice 1",
+	})
+	pending, pendingErr := transactionManager.GetPosting(ctx, queued.ID, user.ID)
+
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	workerErr := make(chan error, 1)
+	go func() {
+		workerErr <- transactionManager.RunPostingWorker(workerCtx, 10*time.Millisecond)
+	}()
+	var applied Posting
+	deadline := time.Now().Add(5 * time.Second)
+	for applied.Status != PostingApplied && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		applied, _ = transactionManager.GetPosting(ctx, queued.ID, uuid.Nil)
+	}
+	stopWorker()
+	_, otherOwnerErr := transactionManager.GetPosting(ctx, queued.ID, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, transactionmanagertest.ID(1), queued.ID)
+	assert.Equal(t, user.ID, queued.UserID)
+	assert.Equal(t, PostingPending, queued.Status)
+	assert.Nil(t, queued.Transaction)
+	assert.NoError(t, pendingErr)
+	assert.Equal(t, PostingPending, pending.Status)
+
+	assert.Equal(t, PostingApplied, applied.Status)
+	assert.NotNil(t, applied.ProcessedAt)
+	if assert.NotNil(t, applied.Transaction) {
+		assert.Equal(t, transactionmanagertest.ID(2), applied.Transaction.ID)
+		assert.Equal(t, "Invoice 1", applied.Transaction.Description)
+		assert.False(t, applied.Transaction.CreatedAt.IsZero())
+	}
+	balance, err := transactionManager.GetUserBalance(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromFloat(100)), "got %s", balance)
+	assert.Equal(t, ErrPostingNotFound, otherOwnerErr)
+	assert.NoError(t, <-workerErr)
+}
+
+func TestEnqueueTransaction_Errors(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	idempotencyKey := uuid.New()
+
+	testCases := []struct {
+		name        string
+		options     func(store *storage.MemoryStore) []Option
+		transaction Transaction
+		expectedErr error
+	}{
+		{
+			name:        "without a posting store",
+			options:     func(store *storage.MemoryStore) []Option { return nil },
+			transaction: Transaction{Amount: decimal.NewFromFloat(10), UserID: userID, IdempotencyKey: uuid.New()},
+			expectedErr: ErrAsyncUnavailable,
+		},
+		{
+			name:        "invalid amount",
+			options:     func(store *storage.MemoryStore) []Option { return []Option{WithPostingStore(store)} },
+			transaction: Transaction{Amount: decimal.NewFromFloat(0), UserID: userID, IdempotencyKey: uuid.New()},
+			expectedErr: ErrInvalidTransaction,
+		},
+		{
+			name:        "unknown user",
+			options:     func(store *storage.MemoryStore) []Option { return []Option{WithPostingStore(store)} },
+			transaction: Transaction{Amount: decimal.NewFromFloat(10), UserID: uuid.New(), IdempotencyKey: uuid.New()},
+			expectedErr: ErrUserNotFound,
+		},
+		{
+			name:        "already queued",
+			options:     func(store *storage.MemoryStore) []Option { return []Option{WithPostingStore(store)} },
+			transaction: Transaction{Amount: decimal.NewFromFloat(10), UserID: userID, IdempotencyKey: idempotencyKey},
+			expectedErr: ErrTransactionAlreadyExist,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			store := storage.NewMemoryStore()
+			if err := store.Add(ctx, storage.User{ID: userID, Balance: decimal.Zero}); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+			transactionManager := NewTransactionManagerClient(store, store, tc.options(store)...)
+			if tc.expectedErr == ErrTransactionAlreadyExist {
+				if _, err := transactionManager.EnqueueTransaction(ctx, tc.transaction); err != nil {
+					t.Fatalf("failed to enqueue transaction: %v", err)
+				}
+			}
+
+			// Act
+			_, err := transactionManager.EnqueueTransaction(ctx, tc.transaction)
+
+			// Assert
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+// batchCountingStore records the sizes of the batches added to a MemoryStore
+type batchCountingStore struct {
+	*storage.MemoryStore
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *batchCountingStore) AddTransactions(ctx context.Context, transactions []storage.Transaction, atomic bool) ([]error, error) {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(transactions))
+	s.mu.Unlock()
+	return s.MemoryStore.AddTransactions(ctx, transactions, atomic)
+}
+
+func TestAddTransaction_GroupCommit(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := &batchCountingStore{MemoryStore: storage.NewMemoryStore()}
+	const requests = 10
+	transactionManager := NewTransactionManagerClient(store, store, WithGroupCommit(requests, time.Minute))
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	transactions := make([]Transaction, requests)
+	for i := range transactions {
+		transactions[i] = Transaction{
+			Amount:         decimal.NewFromInt(int64(i + 1)),
+			UserID:         user.ID,
+			IdempotencyKey: uuid.New(),
+		}
+	}
+	// The last request retries the first one
+	transactions[requests-1].Amount = transactions[0].Amount
+	transactions[requests-1].IdempotencyKey = transactions[0].IdempotencyKey
+
+	// Act
+	added := make([]Transaction, requests)
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := range transactions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			added[i], errs[i] = transactionManager.AddTransaction(ctx, transactions[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, []int{requests}, store.batches)
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			assert.Equal(t, ErrTransactionAlreadyExist, err)
+			assert.True(t, transactions[i].IdempotencyKey == transactions[0].IdempotencyKey)
+			failed++
+			continue
+		}
+		assert.True(t, added[i].Amount.Equal(transactions[i].Amount), "got %s", added[i].Amount)
+		assert.NotEqual(t, uuid.Nil, added[i].ID)
+		assert.False(t, added[i].CreatedAt.IsZero())
+	}
+	assert.Equal(t, 1, failed)
+	balance, err := transactionManager.GetUserBalance(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(45)), "got %s", balance)
+}
+
+func TestAddTransaction_GroupCommitMaxLatency(t *testing.T) {
+	// Assign
+	ctx := context.Background()
+	store := &batchCountingStore{MemoryStore: storage.NewMemoryStore()}
+	transactionManager := NewTransactionManagerClient(store, store, WithGroupCommit(100, 5*time.Millisecond))
+
+	user := storage.User{
+		ID:      uuid.New(),
+		Balance: decimal.NewFromFloat(0),
+	}
+	err := store.Add(ctx, user)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	// Act
+	added, err := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromFloat(100),
+		UserID:         user.ID,
+		IdempotencyKey: uuid.New(),
+	})
+	_, unknownUserErr := transactionManager.AddTransaction(ctx, Transaction{
+		Amount:         decimal.NewFromFloat(100),
+		UserID:         uuid.New(),
+		IdempotencyKey: uuid.New(),
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, added.Amount.Equal(decimal.NewFromFloat(100)), "got %s", added.Amount)
+	assert.Equal(t, ErrUserNotFound, unknownUserErr)
+	assert.Equal(t, []int{1, 1}, store.batches)
+}
+
+// consistencyRecordingStore records the consistency of the reads of a MemoryStore
+type consistencyRecordingStore struct {
+	*storage.MemoryStore
+	users, histories, idempotencyKeys, transactions []string
+}
+
+func (s *consistencyRecordingStore) FindByID(ctx context.Context, id uuid.UUID) (storage.User, error) {
+	s.users = append(s.users, storage.ConsistencyFromContext(ctx))
+	return s.MemoryStore.FindByID(ctx, id)
+}
+
+func (s *consistencyRecordingStore) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter storage.HistoryFilter) ([]storage.Transaction, error) {
+	s.histories = append(s.histories, storage.ConsistencyFromContext(ctx))
+	return s.MemoryStore.GetUserTransactionHistory(ctx, userID, page, pageSize, filter)
+}
+
+func (s *consistencyRecordingStore) FindUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]storage.Transaction, error) {
+	s.idempotencyKeys = append(s.idempotencyKeys, storage.ConsistencyFromContext(ctx))
+	return s.MemoryStore.FindUserTransactionsByIdempotencyKey(ctx, userID, idempotencyKey)
+}
+
+func (s *consistencyRecordingStore) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (storage.Transaction, error) {
+	s.transactions = append(s.transactions, storage.ConsistencyFromContext(ctx))
+	return s.MemoryStore.FindTransactionByID(ctx, transactionID)
+}
+
+func TestReadConsistency(t *testing.T) {
+	testCases := []struct {
+		name          string
+		opts          []Option
+		expectedUsers []string
+	}{
+		{
+			name:          "Without cache",
+			expectedUsers: []string{storage.ConsistencyStrong, storage.ConsistencyEventual, storage.ConsistencyEventual, storage.ConsistencyStrong},
+		},
+		{
+			name:          "Cache filled from the primary",
+			opts:          []Option{WithCache(NewLRUCache(10, time.Minute, SystemClock{}))},
+			expectedUsers: []string{storage.ConsistencyStrong},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			ctx := context.Background()
+			store := &consistencyRecordingStore{MemoryStore: storage.NewMemoryStore()}
+			transactionManager := NewTransactionManagerClient(store, store, tc.opts...)
+			userID := uuid.New()
+			if err := store.MemoryStore.Add(ctx, storage.User{ID: userID, Balance: decimal.Zero}); err != nil {
+				t.Fatalf("failed to add user: %v", err)
+			}
+
+			// Act
+			_, balanceErr := transactionManager.GetUserBalance(ctx, userID)
+			_, eventualErr := transactionManager.GetUserBalance(storage.NewConsistencyContext(ctx, storage.ConsistencyEventual), userID)
+			_, historyErr := transactionManager.GetUserTransactionHistory(ctx, userID, 1, 10, HistoryFilter{})
+			_, idempotencyErr := transactionManager.GetUserTransactionsByIdempotencyKey(ctx, userID, uuid.New())
+			_, transactionErr := transactionManager.GetTransaction(ctx, uuid.New(), userID)
+
+			// Assert
+			assert.NoError(t, balanceErr)
+			assert.NoError(t, eventualErr)
+			assert.NoError(t, historyErr)
+			assert.Equal(t, ErrTransactionNotFound, idempotencyErr)
+			assert.Equal(t, tc.expectedUsers, store.users)
+			assert.Equal(t, []string{storage.ConsistencyEventual}, store.histories)
+			assert.Equal(t, []string{storage.ConsistencyStrong}, store.idempotencyKeys)
+			assert.Equal(t, ErrTransactionNotFound, transactionErr)
+			assert.Equal(t, []string{storage.ConsistencyStrong}, store.transactions)
+		})
+	}
+}
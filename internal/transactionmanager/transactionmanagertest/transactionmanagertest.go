@@ -0,0 +1,71 @@
+// Package transactionmanagertest provides deterministic fakes of the clock and the ID generator
+// of the transaction manager, the controller and the memory store
+package transactionmanagertest
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock is a clock that stands still until it is set or advanced
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set sets the time of the clock
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// IDGenerator generates sequential IDs, the first one is 00000000-0000-0000-0000-000000000001
+type IDGenerator struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func NewIDGenerator() *IDGenerator {
+	return &IDGenerator{}
+}
+
+// NewID returns the next ID
+func (g *IDGenerator) NewID() uuid.UUID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.last++
+	return ID(g.last)
+}
+
+// ID returns the n-th ID generated by an IDGenerator
+func ID(n uint64) uuid.UUID {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[8:], n)
+	return id
+}
@@ -0,0 +1,370 @@
+// Package client is a Go client for the ledger API
+//
+// Requests failing with 429 Too Many Requests or a 5xx status are retried
+// with exponential backoff, honoring the Retry-After header of the response.
+// Errors returned by the API are decoded into *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	defaultPageSize   = 10
+)
+
+// Client calls the ledger API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	newKey     func() uuid.UUID
+	token      string
+}
+
+// Option configures the client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to send requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried
+func WithRetries(maxRetries int) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the bounds of the exponential backoff between retries
+func WithBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithToken sets the bearer token sent with every request
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client calling the API at baseURL, e.g. http://localhost:8080
+// By default a request is retried 3 times with a backoff between 100ms and 5s
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		newKey:     uuid.New,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Transaction is a ledger entry of a user
+// Fee is the fee charged on the transaction, it is only returned when the transaction is added
+type Transaction struct {
+	ID                uuid.UUID              `json:"id"`
+	Amount            decimal.Decimal        `json:"amount"`
+	UserID            uuid.UUID              `json:"user_id"`
+	CreatedAt         time.Time              `json:"created_at"`
+	EffectiveDate     time.Time              `json:"effective_date"`
+	IdempotencyKey    uuid.UUID              `json:"idempotency_key"`
+	Currency          string                 `json:"currency,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Category          string                 `json:"category,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	ExternalReference string                 `json:"external_reference,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	Fee               *Fee                   `json:"fee,omitempty"`
+}
+
+// Fee is the breakdown of the fee charged on a transaction
+// TransactionID is the entry debiting the fee from the user, RevenueTransactionID the entry crediting it to RevenueAccount
+type Fee struct {
+	Rule                 string          `json:"rule"`
+	Flat                 decimal.Decimal `json:"flat"`
+	Percentage           decimal.Decimal `json:"percentage"`
+	PercentageAmount     decimal.Decimal `json:"percentage_amount"`
+	Amount               decimal.Decimal `json:"amount"`
+	NetAmount            decimal.Decimal `json:"net_amount"`
+	TransactionID        uuid.UUID       `json:"transaction_id"`
+	RevenueTransactionID uuid.UUID       `json:"revenue_transaction_id"`
+	RevenueAccount       uuid.UUID       `json:"revenue_account"`
+}
+
+// AddTransactionRequest is the transaction to add
+// If IdempotencyKey is not set, a key is generated and reused for every retry.
+// EffectiveDate backdates the transaction, it requires a client created WithToken with the admin token.
+// Currency is an ISO 4217 code, ExternalReference is unique per Source, both must be given together
+type AddTransactionRequest struct {
+	Amount            float64                `json:"amount"`
+	IdempotencyKey    uuid.UUID              `json:"idempotency_key"`
+	EffectiveDate     *time.Time             `json:"effective_date,omitempty"`
+	Currency          string                 `json:"currency,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	Category          string                 `json:"category,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	ExternalReference string                 `json:"external_reference,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// AddTransactionResponse is the result of adding a transaction
+// IdempotencyKey is the key the transaction was sent with
+type AddTransactionResponse struct {
+	Message        string      `json:"message"`
+	Transaction    Transaction `json:"transaction"`
+	IdempotencyKey uuid.UUID   `json:"-"`
+}
+
+// AddTransaction adds a transaction to the ledger of the user
+func (c *Client) AddTransaction(ctx context.Context, userID uuid.UUID, request AddTransactionRequest) (AddTransactionResponse, error) {
+	if request.IdempotencyKey == uuid.Nil {
+		request.IdempotencyKey = c.newKey()
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return AddTransactionResponse{}, err
+	}
+
+	var response AddTransactionResponse
+	err = c.do(ctx, http.MethodPost, fmt.Sprintf("/users/%s/add", userID), nil, body, &response)
+	if err != nil {
+		return AddTransactionResponse{}, err
+	}
+
+	response.IdempotencyKey = request.IdempotencyKey
+	return response, nil
+}
+
+// GetBalance returns the balance of the user
+func (c *Client) GetBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	var response struct {
+		Balance decimal.Decimal `json:"balance"`
+	}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%s/balance", userID), nil, nil, &response)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return response.Balance, nil
+}
+
+// GetTransaction returns a transaction of the user by ID
+// If the user has no such transaction, an error for which IsNotFound is true is returned
+func (c *Client) GetTransaction(ctx context.Context, userID uuid.UUID, transactionID uuid.UUID) (Transaction, error) {
+	query := url.Values{}
+	query.Set("user_id", userID.String())
+
+	var transaction Transaction
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/transactions/%s", transactionID), query, nil, &transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// GetTransactionsByIdempotencyKey returns the transactions of the user added with an idempotency key, newest first
+// It tells whether a transaction whose request failed was added after all, which requires setting
+// the IdempotencyKey of the AddTransactionRequest. If none was added, an error for which IsNotFound is true is returned
+func (c *Client) GetTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]Transaction, error) {
+	query := url.Values{}
+	query.Set("idempotency_key", idempotencyKey.String())
+
+	transactions := []Transaction{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%s/transactions", userID), query, nil, &transactions)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// GetHistoryPage returns a single page of the transaction history of the user, newest first
+// Pages start at 1
+func (c *Client) GetHistoryPage(ctx context.Context, userID uuid.UUID, page int, pageSize int) ([]Transaction, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("pageSize", strconv.Itoa(pageSize))
+
+	transactions := []Transaction{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%s/history", userID), query, nil, &transactions)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// History returns an iterator over the whole transaction history of the user, newest first
+// Pages of pageSize transactions are fetched as the iterator advances
+// If pageSize is not positive, 10 is used
+func (c *Client) History(ctx context.Context, userID uuid.UUID, pageSize int) *HistoryIterator {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	return &HistoryIterator{
+		ctx:      ctx,
+		client:   c,
+		userID:   userID,
+		pageSize: pageSize,
+	}
+}
+
+// HistoryIterator iterates over the transaction history of a user
+//
+//	it := client.History(ctx, userID, 100)
+//	for it.Next() {
+//		transaction := it.Transaction()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type HistoryIterator struct {
+	ctx      context.Context
+	client   *Client
+	userID   uuid.UUID
+	pageSize int
+
+	page    int
+	buffer  []Transaction
+	current Transaction
+	done    bool
+	err     error
+}
+
+// Next advances the iterator to the next transaction
+// It returns false when the history is exhausted or an error occurred
+func (it *HistoryIterator) Next() bool {
+	if len(it.buffer) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		it.page++
+		transactions, err := it.client.GetHistoryPage(it.ctx, it.userID, it.page, it.pageSize)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if len(transactions) < it.pageSize {
+			it.done = true
+		}
+		if len(transactions) == 0 {
+			return false
+		}
+		it.buffer = transactions
+	}
+
+	it.current, it.buffer = it.buffer[0], it.buffer[1:]
+	return true
+}
+
+// Transaction returns the current transaction
+func (it *HistoryIterator) Transaction() Transaction {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *HistoryIterator) Err() error {
+	return it.err
+}
+
+// do sends the request and decodes the response body into v
+// Requests are retried on 429 and 5xx responses
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body []byte, v interface{}) error {
+	requestURL := c.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, requestURL, bodyReader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			return json.NewDecoder(resp.Body).Decode(v)
+		}
+
+		apiErr := decodeError(resp)
+		if !apiErr.Temporary() || attempt >= c.maxRetries {
+			return apiErr
+		}
+
+		if err := sleep(ctx, c.backoff(attempt, apiErr.RetryAfter)); err != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns how long to wait before the next attempt
+// The delay requested by the server wins over the exponential backoff
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	backoff := c.minBackoff << attempt
+	if backoff <= 0 || backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	// Add jitter so that clients failing together do not retry together
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
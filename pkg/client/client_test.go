@@ -0,0 +1,454 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/tebrizetayi/ledgerservice/internal/api"
+	"github.com/tebrizetayi/ledgerservice/internal/transactionmanager"
+	"github.com/tebrizetayi/ledgerservice/pkg/client"
+)
+
+// fakeTransactionManager keeps the ledger of a set of users in memory
+type fakeTransactionManager struct {
+	mu           sync.Mutex
+	users        map[uuid.UUID][]transactionmanager.Transaction
+	addedKeys    []uuid.UUID
+	failAttempts int32
+}
+
+func newFakeTransactionManager(userIDs ...uuid.UUID) *fakeTransactionManager {
+	users := map[uuid.UUID][]transactionmanager.Transaction{}
+	for _, userID := range userIDs {
+		users[userID] = []transactionmanager.Transaction{}
+	}
+	return &fakeTransactionManager{users: users}
+}
+
+func (f *fakeTransactionManager) AddTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addedKeys = append(f.addedKeys, transaction.IdempotencyKey)
+	transactions, ok := f.users[transaction.UserID]
+	if !ok {
+		return transactionmanager.Transaction{}, transactionmanager.ErrUserNotFound
+	}
+	for _, existing := range transactions {
+		if existing.IdempotencyKey == transaction.IdempotencyKey && existing.Amount.Equal(transaction.Amount) {
+			return transactionmanager.Transaction{}, transactionmanager.ErrTransactionAlreadyExist
+		}
+	}
+	// Prepend so that the history is newest first
+	f.users[transaction.UserID] = append([]transactionmanager.Transaction{transaction}, transactions...)
+	return transaction, nil
+}
+
+func (f *fakeTransactionManager) AddTransactions(ctx context.Context, transactions []transactionmanager.Transaction, mode transactionmanager.BatchMode) ([]transactionmanager.BatchResult, error) {
+	results := make([]transactionmanager.BatchResult, len(transactions))
+	for i, transaction := range transactions {
+		results[i].Transaction, results[i].Err = f.AddTransaction(ctx, transaction)
+	}
+	return results, nil
+}
+
+func (f *fakeTransactionManager) GetUserBalance(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return decimal.Zero, transactionmanager.ErrUserNotFound
+	}
+	balance := decimal.Zero
+	for _, transaction := range transactions {
+		balance = balance.Add(transaction.Amount)
+	}
+	return balance, nil
+}
+
+func (f *fakeTransactionManager) GetUserTransactionHistory(ctx context.Context, userID uuid.UUID, page int, pageSize int, filter transactionmanager.HistoryFilter) ([]transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return nil, transactionmanager.ErrUserNotFound
+	}
+	start := (page - 1) * pageSize
+	if start >= len(transactions) {
+		return []transactionmanager.Transaction{}, nil
+	}
+	end := start + pageSize
+	if end > len(transactions) {
+		end = len(transactions)
+	}
+	return transactions[start:end], nil
+}
+
+func (f *fakeTransactionManager) GetTransaction(ctx context.Context, transactionID uuid.UUID, owner uuid.UUID) (transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for userID, transactions := range f.users {
+		for _, transaction := range transactions {
+			if transaction.ID == transactionID && (owner == uuid.Nil || owner == userID) {
+				return transaction, nil
+			}
+		}
+	}
+	return transactionmanager.Transaction{}, transactionmanager.ErrTransactionNotFound
+}
+
+func (f *fakeTransactionManager) GetUserTransactionsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey uuid.UUID) ([]transactionmanager.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return nil, transactionmanager.ErrUserNotFound
+	}
+	found := []transactionmanager.Transaction{}
+	for _, transaction := range transactions {
+		if transaction.IdempotencyKey == idempotencyKey {
+			found = append(found, transaction)
+		}
+	}
+	if len(found) == 0 {
+		return nil, transactionmanager.ErrTransactionNotFound
+	}
+	return found, nil
+}
+
+func (f *fakeTransactionManager) EnqueueTransaction(ctx context.Context, transaction transactionmanager.Transaction) (transactionmanager.Posting, error) {
+	return transactionmanager.Posting{}, transactionmanager.ErrAsyncUnavailable
+}
+
+func (f *fakeTransactionManager) GetPosting(ctx context.Context, postingID uuid.UUID, owner uuid.UUID) (transactionmanager.Posting, error) {
+	return transactionmanager.Posting{}, transactionmanager.ErrAsyncUnavailable
+}
+
+func (f *fakeTransactionManager) GetUserBalanceAsOf(ctx context.Context, userID uuid.UUID, asOf time.Time) (decimal.Decimal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return decimal.Zero, transactionmanager.ErrUserNotFound
+	}
+	balance := decimal.Zero
+	for _, transaction := range transactions {
+		if transaction.CreatedAt.Before(asOf) {
+			balance = balance.Add(transaction.Amount)
+		}
+	}
+	return balance, nil
+}
+
+func (f *fakeTransactionManager) GetUserStatement(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) (transactionmanager.Statement, error) {
+	return transactionmanager.Statement{}, transactionmanager.ErrInvalidPeriod
+}
+
+func (f *fakeTransactionManager) CreateSchedule(ctx context.Context, schedule transactionmanager.Schedule) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ListUserSchedules(ctx context.Context, userID uuid.UUID) ([]transactionmanager.Schedule, error) {
+	return nil, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) PauseSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ResumeSchedule(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (transactionmanager.Schedule, error) {
+	return transactionmanager.Schedule{}, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) ListScheduleRuns(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID, limit int) ([]transactionmanager.ScheduleRun, error) {
+	return nil, transactionmanager.ErrSchedulesUnavailable
+}
+
+func (f *fakeTransactionManager) VerifyUserChain(ctx context.Context, userID uuid.UUID) (transactionmanager.ChainReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions, ok := f.users[userID]
+	if !ok {
+		return transactionmanager.ChainReport{}, transactionmanager.ErrUserNotFound
+	}
+	return transactionmanager.ChainReport{UserID: userID, Intact: true, Transactions: int64(len(transactions))}, nil
+}
+
+func newTestServer(t *testing.T, manager *fakeTransactionManager) *httptest.Server {
+	t.Helper()
+
+	handler := api.NewAPI(api.NewController(manager), api.WithRateLimit(1000, 1000))
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_AddTransactionAndGetBalance(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	manager := newFakeTransactionManager(userID)
+	server := newTestServer(t, manager)
+	c := client.New(server.URL)
+
+	// Act
+	first, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 100})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	second, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 50.5})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	balance, err := c.GetBalance(context.Background(), userID)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromFloat(150.5)), "balance should be 150.5, got %s", balance)
+	assert.NotEqual(t, uuid.Nil, first.IdempotencyKey, "an idempotency key should be generated")
+	assert.NotEqual(t, first.IdempotencyKey, second.IdempotencyKey)
+}
+
+func TestClient_EffectiveDate(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	manager := newFakeTransactionManager(userID)
+	handler := api.NewAPI(api.NewController(manager), api.WithAdmin("admin-token", nil))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	effectiveDate := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := client.AddTransactionRequest{Amount: 100, EffectiveDate: &effectiveDate}
+
+	// Act
+	response, err := client.New(server.URL, client.WithToken("admin-token")).AddTransaction(context.Background(), userID, request)
+	_, forbiddenErr := client.New(server.URL).AddTransaction(context.Background(), userID, request)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, userID, response.Transaction.UserID)
+	assert.True(t, effectiveDate.Equal(response.Transaction.EffectiveDate), "effective date is %s", response.Transaction.EffectiveDate)
+	apiErr, ok := forbiddenErr.(*client.Error)
+	if assert.True(t, ok, "expected an API error, got %v", forbiddenErr) {
+		assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	}
+}
+
+func TestClient_GetTransaction(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	otherUserID := uuid.New()
+	manager := newFakeTransactionManager(userID, otherUserID)
+	server := newTestServer(t, manager)
+	c := client.New(server.URL)
+
+	key := uuid.New()
+	added, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 100, IdempotencyKey: key})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	transaction, err := c.GetTransaction(context.Background(), userID, added.Transaction.ID)
+	_, otherUserErr := c.GetTransaction(context.Background(), otherUserID, added.Transaction.ID)
+	byKey, byKeyErr := c.GetTransactionsByIdempotencyKey(context.Background(), userID, key)
+	_, unknownKeyErr := c.GetTransactionsByIdempotencyKey(context.Background(), userID, uuid.New())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, added.Transaction.ID, transaction.ID)
+	assert.True(t, client.IsNotFound(otherUserErr), "expected not found, got %v", otherUserErr)
+	assert.NoError(t, byKeyErr)
+	if assert.Len(t, byKey, 1) {
+		assert.Equal(t, added.Transaction.ID, byKey[0].ID)
+	}
+	assert.True(t, client.IsNotFound(unknownKeyErr), "expected not found, got %v", unknownKeyErr)
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	manager := newFakeTransactionManager(userID)
+	server := newTestServer(t, manager)
+	c := client.New(server.URL)
+
+	key := uuid.New()
+	_, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 100, IdempotencyKey: key})
+	if err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+
+	// Act
+	_, conflictErr := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 100, IdempotencyKey: key})
+	_, notFoundErr := c.GetBalance(context.Background(), uuid.New())
+
+	// Assert
+	assert.True(t, client.IsConflict(conflictErr), "expected conflict, got %v", conflictErr)
+	assert.True(t, client.IsNotFound(notFoundErr), "expected not found, got %v", notFoundErr)
+
+	apiErr, ok := notFoundErr.(*client.Error)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, "Not Found", apiErr.Status)
+		assert.Contains(t, apiErr.Message, "user not found")
+	}
+}
+
+func TestClient_History(t *testing.T) {
+	// Assign
+	userID := uuid.New()
+	manager := newFakeTransactionManager(userID)
+	server := newTestServer(t, manager)
+	c := client.New(server.URL)
+
+	const transactionCount = 7
+	for i := 0; i < transactionCount; i++ {
+		_, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: float64(i + 1)})
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+
+	// Act
+	amounts := []decimal.Decimal{}
+	it := c.History(context.Background(), userID, 3)
+	for it.Next() {
+		amounts = append(amounts, it.Transaction().Amount)
+	}
+
+	// Assert
+	assert.NoError(t, it.Err())
+	if assert.Len(t, amounts, transactionCount) {
+		for i, amount := range amounts {
+			expected := decimal.NewFromInt(int64(transactionCount - i))
+			assert.True(t, amount.Equal(expected), "transaction %d should be %s, got %s", i, expected, amount)
+		}
+	}
+}
+
+func TestClient_History_Error(t *testing.T) {
+	// Assign
+	server := newTestServer(t, newFakeTransactionManager())
+	c := client.New(server.URL)
+
+	// Act
+	it := c.History(context.Background(), uuid.New(), 3)
+	hasNext := it.Next()
+
+	// Assert
+	assert.False(t, hasNext)
+	assert.True(t, client.IsNotFound(it.Err()), "expected not found, got %v", it.Err())
+}
+
+func TestClient_RetriesWithSameIdempotencyKey(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		retryAfter string
+	}{
+		{
+			name:       "Rate limited",
+			statusCode: http.StatusTooManyRequests,
+			retryAfter: "1",
+		},
+		{
+			name:       "Server error",
+			statusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Assign
+			userID := uuid.New()
+			manager := newFakeTransactionManager(userID)
+			handler := api.NewAPI(api.NewController(manager), api.WithRateLimit(1000, 1000))
+
+			// Fail the first two attempts before reaching the API
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&attempts, 1) <= 2 {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(tc.statusCode)
+					w.Write([]byte(`{"error":"` + http.StatusText(tc.statusCode) + `","message":"try again"}`))
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			c := client.New(server.URL, client.WithBackoff(time.Millisecond, 5*time.Millisecond))
+
+			// Act
+			start := time.Now()
+			response, err := c.AddTransaction(context.Background(), userID, client.AddTransactionRequest{Amount: 100})
+			elapsed := time.Since(start)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+			assert.Equal(t, []uuid.UUID{response.IdempotencyKey}, manager.addedKeys)
+			if tc.retryAfter != "" {
+				assert.GreaterOrEqual(t, elapsed, 2*time.Second, "Retry-After should be honored")
+			}
+		})
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	// Assign
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream unavailable"))
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL, client.WithRetries(2), client.WithBackoff(time.Millisecond, 5*time.Millisecond))
+
+	// Act
+	_, err := c.GetBalance(context.Background(), uuid.New())
+
+	// Assert
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	apiErr, ok := err.(*client.Error)
+	if assert.True(t, ok, "expected *client.Error, got %v", err) {
+		assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+		assert.Equal(t, "upstream unavailable", apiErr.Message)
+	}
+}
+
+func TestClient_NoRetryOnClientError(t *testing.T) {
+	// Assign
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL, client.WithBackoff(time.Millisecond, 5*time.Millisecond))
+
+	// Act
+	_, err := c.GetBalance(context.Background(), uuid.New())
+
+	// Assert
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorBody limits how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	// Status is the HTTP status text returned in the error body
+	Status string
+	// Message describes the problem
+	Message string
+	// RetryAfter is the delay requested by the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ledger API: %d %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsNotFound reports whether err is an API error for a missing user or transaction
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether err is an API error for a transaction
+// that was already added with the same idempotency key and amount
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsRateLimited reports whether err is an API error for a rate limited request
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+func hasStatus(err error, statusCode int) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == statusCode
+}
+
+// decodeError reads the error body of the response and closes it
+// Bodies which are not in the API error format are used as the message
+func decodeError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Status:     http.StatusText(resp.StatusCode),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		apiErr.Message = err.Error()
+		return apiErr
+	}
+
+	var response struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Error == "" {
+		apiErr.Message = string(body)
+		return apiErr
+	}
+
+	apiErr.Status = response.Error
+	apiErr.Message = response.Message
+	return apiErr
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
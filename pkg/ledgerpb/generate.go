@@ -0,0 +1,5 @@
+// Package ledgerpb contains the protobuf messages and the gRPC service of the ledger API
+// generated from proto/ledger/v1/ledger.proto
+package ledgerpb
+
+//go:generate sh -c "cd ../../proto && buf generate"